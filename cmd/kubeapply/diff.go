@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+)
+
+const LAST_APPLIED = "kubectl.kubernetes.io/last-applied-configuration"
+
+// ignored lists the fields that are populated by the server, as JSON
+// Pointers. They are never reported as different, even if the
+// manifests mention them.
+var ignored = []string{
+	"/status",
+	"/metadata/creationTimestamp",
+	"/metadata/generation",
+	"/metadata/managedFields",
+	"/metadata/resourceVersion",
+	"/metadata/selfLink",
+	"/metadata/uid",
+	"/metadata/annotations/" + strings.Replace(LAST_APPLIED, "/", "~1", -1),
+}
+
+func isIgnored(path string) bool {
+	for _, prefix := range ignored {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// differ looks up the live version of the resources in the manifests.
+type differ struct {
+	client    *k8s.Client
+	namespace string
+}
+
+func newDiffer() (*differ, error) {
+	info, err := k8s.NewKubeInfo("", "", "")
+	if err != nil {
+		return nil, err
+	}
+	return &differ{
+		client:    k8s.NewClient(info),
+		namespace: info.Namespace,
+	}, nil
+}
+
+// diff prints how the live versions of the resources in the given
+// files differ from the manifests and returns the number of resources
+// that differ.
+func (d *differ) diff(names []string) (int, error) {
+	count := 0
+	for _, n := range names {
+		resources, err := k8s.LoadResources(n)
+		if err != nil {
+			return count, err
+		}
+		for _, desired := range resources {
+			rt, err := d.client.LookupResourceType(desired.Kind())
+			if k8s.IsUnrecognized(err) {
+				// e.g. a custom resource whose definition
+				// isn't installed yet
+				fmt.Printf("+ %s/%s\n", desired.Kind(), desired.QName())
+				count++
+				continue
+			}
+			if err != nil {
+				return count, err
+			}
+			name := fmt.Sprintf("%s/%s", rt.Name, desired.QName())
+			live, err := d.live(rt, desired)
+			if err != nil {
+				return count, err
+			}
+			if live == nil {
+				fmt.Printf("+ %s\n", name)
+				count++
+				continue
+			}
+			lines := diffResource(desired, live)
+			if len(lines) > 0 {
+				fmt.Printf("~ %s\n", name)
+				for _, line := range lines {
+					fmt.Printf("    %s\n", line)
+				}
+				count++
+			}
+		}
+	}
+	return count, nil
+}
+
+// live returns the live version of the given resource, or nil if it
+// does not exist.
+func (d *differ) live(rt k8s.ResourceType, desired k8s.Resource) (k8s.Resource, error) {
+	var namespace string
+	if rt.Namespaced {
+		namespace = desired.Namespace()
+		if namespace == "" {
+			namespace = d.namespace
+		}
+	}
+	resources, err := d.client.SelectiveList(namespace, rt.Name, "metadata.name="+desired.Name(), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, nil
+	}
+	return resources[0], nil
+}
+
+// diffResource returns a line for every field that the manifest sets
+// to a different value than the live resource has, and for every
+// field that was removed from the manifest since it was last applied,
+// see k8s.Operation.String. Fields that are only present in the live
+// resource are left alone, just like `kubectl apply` does.
+func diffResource(desired, live k8s.Resource) []string {
+	var applied interface{}
+	if last, ok := live.Metadata().Annotations()[LAST_APPLIED].(string); ok {
+		if json.Unmarshal([]byte(last), &applied) != nil {
+			applied = nil
+		}
+	}
+
+	var lines []string
+	for _, op := range k8s.Diff(live, desired) {
+		if isIgnored(op.Path) {
+			continue
+		}
+		switch op.Op {
+		case "add":
+			// a null in the manifest leaves the field unset
+			if op.Value == nil {
+				continue
+			}
+		case "remove":
+			if !contains(applied, op.Path) {
+				continue
+			}
+		}
+		lines = append(lines, op.String())
+	}
+	return lines
+}
+
+// contains returns whether the value has something at the JSON
+// Pointer.
+func contains(value interface{}, pointer string) bool {
+	if pointer == "" {
+		return true
+	}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[token]; !ok {
+				return false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return false
+			}
+			value = v[i]
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+)
+
+func TestDiffResource(t *testing.T) {
+	desired := k8s.Resource{
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{
+			"name":   "foo",
+			"labels": map[string]interface{}{"app": "foo"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 3,
+			"paused":   nil,
+			"template": map[string]interface{}{"image": "foo:2"},
+		},
+	}
+	live := k8s.Resource{
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{
+			"name":            "foo",
+			"uid":             "1234",
+			"resourceVersion": "42",
+			"labels":          map[string]interface{}{"app": "foo", "old": "yes", "added-by": "someone"},
+			"annotations": map[string]interface{}{
+				LAST_APPLIED: `{"metadata": {"name": "foo", "labels": {"app": "foo", "old": "yes"}}}`,
+			},
+		},
+		"spec": map[string]interface{}{
+			// decoded from json
+			"replicas":         float64(1),
+			"template":         map[string]interface{}{"image": "foo:2"},
+			"progressDeadline": float64(600),
+		},
+		"status": map[string]interface{}{"replicas": float64(1)},
+	}
+
+	expected := []string{
+		// removed from the manifest since it was last applied
+		`- /metadata/labels/old: "yes"`,
+		"~ /spec/replicas: 1 -> 3",
+	}
+	if lines := diffResource(desired, live); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+
+	desired["spec"].(map[string]interface{})["replicas"] = 1
+	desired["metadata"].(map[string]interface{})["labels"].(map[string]interface{})["old"] = "yes"
+	if lines := diffResource(desired, live); len(lines) != 0 {
+		t.Errorf("expected no differences, got %q", lines)
+	}
+
+	// without the last applied configuration nothing counts as
+	// removed
+	delete(live["metadata"].(map[string]interface{}), "annotations")
+	delete(desired["metadata"].(map[string]interface{})["labels"].(map[string]interface{}), "old")
+	if lines := diffResource(desired, live); len(lines) != 0 {
+		t.Errorf("expected no differences, got %q", lines)
+	}
+}
@@ -42,10 +42,28 @@ var Version = "(unknown version)"
 var show_version = flag.Bool("version", false, "output version information and exit")
 var debug = flag.Bool("debug", envBool("KUBEAPPLY_DEBUG"), "enable debug mode, expanded files will be preserved")
 var timeout = flag.Int("t", 60, "timeout in seconds")
+var totalTimeout = flag.Int("total-timeout", 0, "timeout in seconds for all phases together (default: none)")
+var pruneSet = flag.String("prune", "", "name of the set of resources being applied, resources labeled as "+
+	"belonging to the set that are no longer in the manifests are deleted once all phases are ready")
+var showDiff = flag.Bool("diff", false, "show how the live resources differ from the manifests instead of "+
+	"applying them, exits non-zero if any resource differs")
+var serverSide = flag.Bool("server-side", false, "apply with server side apply instead of kubectl apply, so that "+
+	"fields set by other controllers are left alone")
+var forceConflicts = flag.Bool("force-conflicts", false, "with -server-side, take over fields that other "+
+	"controllers set instead of failing")
+var validate = flag.Bool("validate", false, "check the resources against the schema of the cluster before "+
+	"applying anything, and fail if any of them is invalid")
 var files tpu.ArrayFlags
+var readyWhen tpu.ArrayFlags
+var values tpu.ArrayFlags
 
 func _main() int {
 	flag.Var(&files, "f", "path to yaml file")
+	flag.Var(&readyWhen, "ready", "readiness condition for a kind, e.g. 'Pod={.status.phase}=Running' or "+
+		"'Deployment=status.readyReplicas == spec.replicas' "+
+		"(may be repeated, see also the "+k8s.READY_WHEN+" annotation)")
+	flag.Var(&values, "values", "path to yaml file with values for templates, available as .Values "+
+		"(may be repeated, later files override earlier ones)")
 	flag.Parse()
 
 	if *show_version {
@@ -58,6 +76,35 @@ func _main() int {
 		return 1
 	}
 
+	for _, spec := range readyWhen {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			fmt.Printf("expecting KIND=CONDITION, got %s\n", spec)
+			return 1
+		}
+		ready, err := k8s.ReadyCondition(parts[1])
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		k8s.READY[parts[0]] = ready
+	}
+
+	data, err := templateData(values)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	var deadline time.Time
+	if *totalTimeout > 0 {
+		deadline = time.Now().Add(time.Duration(*totalTimeout) * time.Second)
+	}
+
+	if *pruneSet != "" {
+		applied = nil
+	}
+
 	p := NewPhaser()
 
 	for _, file := range files {
@@ -68,20 +115,96 @@ func _main() int {
 		}
 	}
 
+	if *showDiff {
+		return diffPhases(p.phases(), data)
+	}
+
 	for _, names := range p.phases() {
-		rc := phase(names, nil)
+		wait := time.Duration(*timeout) * time.Second
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				fmt.Printf("not done after %d seconds\n", *totalTimeout)
+				return 1
+			}
+			if left < wait {
+				wait = left
+			}
+		}
+		rc := phase(names, data, wait)
 		if rc != 0 {
 			return rc
 		}
 	}
 
+	if *pruneSet != "" {
+		err := prune(*pruneSet)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
 	return 0
 }
 
+// templateData returns the data that templates are expanded with:
+// the merged values files, if any, as .Values and the environment as
+// .Env.
+func templateData(paths []string) (interface{}, error) {
+	var vals map[string]interface{}
+	if len(paths) > 0 {
+		var err error
+		vals, err = k8s.LoadValues(paths...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return map[string]interface{}{
+		"Values": vals,
+		"Env":    env,
+	}, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "resources" {
+		os.Exit(resources(os.Args[2:]))
+	}
 	os.Exit(_main())
 }
 
+// resources implements `kubeapply resources`, which prints what the
+// resource type names of the cluster resolve to, e.g. to find out why
+// a kind in a manifest ends up in the wrong API group.
+func resources(args []string) int {
+	flags := flag.NewFlagSet("kubeapply resources", flag.ExitOnError)
+	ambiguous := flags.Bool("ambiguous", false, "only print the names that more than one resource type goes by")
+	flags.Parse(args)
+
+	client, err := k8s.NewClientWithOptions(k8s.ClientOptions{})
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	resolutions := client.ResolveNames()
+	if *ambiguous {
+		resolutions = client.ListAmbiguities()
+	}
+	err = k8s.PrintNameResolutions(os.Stdout, resolutions)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
 type Phaser struct {
 	prefixes map[string][]string
 	last     []string
@@ -149,13 +272,40 @@ func (p *Phaser) phases() (result [][]string) {
 	return
 }
 
-func phase(names []string, data interface{}) int {
-	expanded, err := expand(names, data)
+// phase applies the given files, one step at a time, see ORDER, and
+// waits for each step to become ready before moving on to the next.
+func phase(names []string, data interface{}, timeout time.Duration) int {
+	steps, err := expand(names, data)
 	if err != nil {
 		fmt.Println(err)
 		return 1
 	}
 
+	deadline := time.Now().Add(timeout)
+	for idx, expanded := range steps {
+		rc := step(expanded, time.Until(deadline))
+		if rc != 0 {
+			if !*debug {
+				for _, rest := range steps[idx+1:] {
+					remove(rest)
+				}
+			}
+			if rc == 2 {
+				fmt.Printf("not ready after %v\n", timeout)
+				return 1
+			}
+			return rc
+		}
+	}
+
+	return 0
+}
+
+// step applies the given files and waits for them to become ready. It
+// returns 2 if they aren't ready within the timeout.
+func step(expanded []string, timeout time.Duration) int {
+	// A new waiter is needed for every step, so that resources
+	// defined in earlier steps are known.
 	waiter := k8s.NewWaiter(nil)
 
 	valid := make(map[string]bool)
@@ -171,8 +321,24 @@ func phase(names []string, data interface{}) int {
 		}
 	}
 
+	if !abort && *validate {
+		err := validateResources(expanded)
+		if err != nil {
+			fmt.Println(err)
+			abort = true
+		}
+	}
+
 	if !abort {
-		apply(expanded)
+		if *serverSide {
+			err := serverSideApply(expanded)
+			if err != nil {
+				fmt.Println(err)
+				abort = true
+			}
+		} else {
+			apply(expanded)
+		}
 	}
 
 	if !*debug {
@@ -190,28 +356,122 @@ func phase(names []string, data interface{}) int {
 		return 1
 	}
 
-	if !waiter.Wait(time.Duration(*timeout) * time.Second) {
-		fmt.Printf("not ready after %d seconds\n", *timeout)
+	if !waiter.Wait(timeout) {
+		return 2
+	}
+
+	return 0
+}
+
+func remove(names []string) {
+	for _, n := range names {
+		err := os.Remove(n)
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// diffPhases expands the manifests of all phases and shows how they
+// differ from the live resources, nothing is applied.
+func diffPhases(phases [][]string, data interface{}) int {
+	d, err := newDiffer()
+	if err != nil {
+		fmt.Println(err)
 		return 1
 	}
 
+	differences := 0
+	for _, names := range phases {
+		steps, err := expand(names, data)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+
+		var expanded []string
+		for _, s := range steps {
+			expanded = append(expanded, s...)
+		}
+
+		count, err := d.diff(expanded)
+
+		if !*debug {
+			remove(expanded)
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		differences += count
+	}
+
+	if differences > 0 {
+		fmt.Printf("%d resources differ\n", differences)
+		return 1
+	}
 	return 0
 }
 
-func expand(names []string, data interface{}) ([]string, error) {
+// ORDER lists the kinds that are applied, and waited for, ahead of
+// everything else in a phase, so that e.g. a custom resource can be
+// defined and used in a single phase.
+var ORDER = []string{"CustomResourceDefinition", "Namespace"}
+
+func order(kind string) int {
+	for idx, k := range ORDER {
+		if k == kind {
+			return idx
+		}
+	}
+	return len(ORDER)
+}
+
+// expand expands the given files and splits the resulting resources
+// into steps according to ORDER. Steps without resources are left out.
+func expand(names []string, data interface{}) ([][]string, error) {
 	fmt.Printf("expanding %s\n", strings.Join(names, " "))
-	var result []string
+	steps := make([][]string, len(ORDER)+1)
 	for _, n := range names {
-		resources, err := k8s.LoadResources(n)
+		// missing keys are only an error for templates that
+		// are written against values files
+		resources, err := k8s.LoadResourcesWithData(n, data, len(values) > 0)
 		if err != nil {
 			return nil, err
 		}
-		out := n + ".o"
-		err = k8s.SaveResources(out, resources)
-		if err != nil {
-			return nil, err
+		if *pruneSet != "" {
+			for _, res := range resources {
+				setLabel(res, SET_LABEL, *pruneSet)
+				applied = append(applied, res)
+			}
+		}
+		split := make([][]k8s.Resource, len(steps))
+		for _, res := range resources {
+			idx := order(res.Kind())
+			split[idx] = append(split[idx], res)
+		}
+		for idx, resources := range split {
+			if len(resources) == 0 {
+				continue
+			}
+			out := n + ".o"
+			if idx < len(ORDER) {
+				out = fmt.Sprintf("%s.%s.o", n, strings.ToLower(ORDER[idx]))
+			}
+			err = k8s.SaveResources(out, resources)
+			if err != nil {
+				return nil, err
+			}
+			steps[idx] = append(steps[idx], out)
+		}
+	}
+
+	var result [][]string
+	for _, s := range steps {
+		if len(s) > 0 {
+			result = append(result, s)
 		}
-		result = append(result, out)
 	}
 	return result, nil
 }
@@ -230,3 +490,170 @@ func apply(names []string) {
 		panic(err)
 	}
 }
+
+// FIELD_MANAGER is who kubeapply applies as with -server-side.
+const FIELD_MANAGER = "kubeapply"
+
+// serverSideApply applies the resources in the given files with
+// server side apply. Resources without a namespace go to the
+// namespace of the current context, like with kubectl apply.
+func serverSideApply(names []string) error {
+	info, err := k8s.NewKubeInfo("", "", "")
+	if err != nil {
+		return err
+	}
+	client := k8s.NewClient(info)
+	recorder := client.Recorder(FIELD_MANAGER)
+	for _, n := range names {
+		resources, err := k8s.LoadResources(n)
+		if err != nil {
+			return err
+		}
+		for _, res := range resources {
+			ri, err := client.LookupResourceTypeOf(res)
+			if err != nil {
+				return err
+			}
+			if ri.Namespaced && res.Namespace() == "" {
+				res.Metadata()["namespace"] = info.Namespace
+			}
+			fmt.Printf("server side apply %s/%s\n", ri.Name, res.QName())
+			result, err := client.Apply(res, FIELD_MANAGER, *forceConflicts)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %v", ri.Name, res.QName(), err)
+			}
+			// the event is only for the record, so failing to
+			// post it doesn't fail the apply
+			err = recorder.Event(result, k8s.NORMAL_EVENT, "Applied", "applied by kubeapply")
+			if err != nil {
+				fmt.Printf("posting event for %s/%s: %v\n", ri.Name, res.QName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateResources checks the resources in the given files against
+// the schema of the cluster, and returns an error listing all of the
+// invalid ones.
+func validateResources(names []string) error {
+	client := k8s.NewClient(nil)
+	var problems []string
+	for _, n := range names {
+		resources, err := k8s.LoadResources(n)
+		if err != nil {
+			return err
+		}
+		for _, res := range resources {
+			err := client.ValidateAgainstSchema(res)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s %s: %v", n, res.Kind(), res.QName(), err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid resources:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// SET_LABEL is the label that marks the resources applied with -prune
+// as belonging to a set.
+const SET_LABEL = "kubeapply.datawire.io/set"
+
+// applied holds every resource in the manifests when -prune is in
+// effect.
+var applied []k8s.Resource
+
+func setLabel(res k8s.Resource, key, value string) {
+	md, ok := res["metadata"].(map[string]interface{})
+	if !ok {
+		md = make(map[string]interface{})
+		res["metadata"] = md
+	}
+	labels, ok := md["labels"].(map[string]interface{})
+	if !ok {
+		labels = make(map[string]interface{})
+		md["labels"] = labels
+	}
+	labels[key] = value
+}
+
+// pruneName is how prune tells the resources apart, TYPE/NAME for
+// cluster scoped ones and TYPE/NAME.NAMESPACE for namespaced ones.
+func pruneName(rt k8s.ResourceType, name, namespace string) string {
+	if rt.Namespaced {
+		return fmt.Sprintf("%s/%s.%s", rt.Name, name, namespace)
+	}
+	return fmt.Sprintf("%s/%s", rt.Name, name)
+}
+
+// keepSet returns the pruneName of every applied resource. Namespaced
+// resources without a namespace were applied to the namespace of the
+// context, like kubectl does.
+func keepSet(resources []k8s.Resource, namespace string,
+	lookup func(string) (k8s.ResourceType, error)) (map[string]bool, error) {
+	keep := make(map[string]bool)
+	for _, res := range resources {
+		rt, err := lookup(res.Kind())
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", res.Kind(), res.QName(), err)
+		}
+		ns := res.Namespace()
+		if ns == "" {
+			ns = namespace
+		}
+		keep[pruneName(rt, res.Name(), ns)] = true
+	}
+	return keep, nil
+}
+
+// prune deletes the resources in the cluster that are labeled as
+// belonging to the given set but are no longer in the manifests.
+func prune(set string) error {
+	info, err := k8s.NewKubeInfo("", "", "")
+	if err != nil {
+		return err
+	}
+	client := k8s.NewClient(info)
+
+	keep, err := keepSet(applied, info.Namespace, client.LookupResourceType)
+	if err != nil {
+		return err
+	}
+
+	selector := fmt.Sprintf("%s=%s", SET_LABEL, set)
+	listed := make(map[string]bool)
+	deleted := make(map[string]bool)
+	for _, rt := range client.ResourceTypes("list", "delete") {
+		if listed[rt.Name] {
+			continue
+		}
+		listed[rt.Name] = true
+
+		resources, err := client.SelectiveList("", rt.Name, "", selector)
+		if err != nil {
+			return err
+		}
+		for _, res := range resources {
+			name := pruneName(rt, res.Name(), res.Namespace())
+			if keep[name] || deleted[name] {
+				continue
+			}
+			args := []string{"delete", res.Kind() + "/" + res.Name()}
+			if res.Namespace() != "" {
+				args = append(args, "--namespace", res.Namespace())
+			}
+			fmt.Printf("kubectl %s\n", strings.Join(args, " "))
+			cmd := exec.Command("kubectl", args...)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			err = cmd.Run()
+			if err != nil {
+				return err
+			}
+			deleted[name] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+)
+
+func lookup(kind string) (k8s.ResourceType, error) {
+	switch kind {
+	case "Service":
+		return k8s.ResourceType{Version: "v1", Name: "services", Kind: "Service", Namespaced: true}, nil
+	case "Namespace":
+		return k8s.ResourceType{Version: "v1", Name: "namespaces", Kind: "Namespace"}, nil
+	}
+	return k8s.ResourceType{}, fmt.Errorf("unknown kind %s", kind)
+}
+
+func resource(kind, name, namespace string) k8s.Resource {
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	return k8s.Resource{"kind": kind, "metadata": metadata}
+}
+
+func TestKeepSet(t *testing.T) {
+	keep, err := keepSet([]k8s.Resource{
+		resource("Service", "foo", ""),
+		resource("Service", "bar", "default"),
+		resource("Namespace", "staging", ""),
+	}, "staging", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{
+		// applied to the namespace of the context
+		"services/foo.staging": true,
+		"services/bar.default": true,
+		"namespaces/staging":   true,
+	}
+	if !reflect.DeepEqual(keep, expected) {
+		t.Errorf("expected %v, got %v", expected, keep)
+	}
+
+	// the resources listed from the cluster are named the same way
+	services, _ := lookup("Service")
+	if name := pruneName(services, "foo", "staging"); !keep[name] {
+		t.Errorf("%s would be pruned", name)
+	}
+
+	if _, err := keepSet([]k8s.Resource{resource("Bogus", "foo", "")}, "staging", lookup); err == nil {
+		t.Errorf("expected an error for an unknown kind")
+	}
+}
@@ -128,7 +128,13 @@ func (s *Syncer) Run() {
 		// value from the current iteration instead of the
 		// value from the last iteration
 		kind := k
-		err := s.Watcher.WatchNamespace(NAMESPACE, kind, func(_ *k8s.Watcher) {
+		query := k8s.Query{
+			Kind:          kind,
+			Namespace:     NAMESPACE,
+			FieldSelector: FIELD_SELECTOR,
+			LabelSelector: LABEL_SELECTOR,
+		}
+		err := s.Watcher.WatchQuery(query, func(_ *k8s.Watcher) {
 			s.Mux.Lock()
 			defer s.Mux.Unlock()
 			s.Dirty = true
@@ -139,7 +145,10 @@ func (s *Syncer) Run() {
 			panic(fmt.Sprintf("kubewatch: %v", err))
 		}
 	}
-	s.Watcher.Start()
+	err := s.Watcher.Start()
+	if err != nil {
+		panic(fmt.Sprintf("kubewatch: %v", err))
+	}
 	s.serve()
 }
 
@@ -233,16 +242,20 @@ func init() {
 	KUBEWATCH.Flags().StringVarP(&PORT, "port", "p", "0", "port for kubewatch api")
 	KUBEWATCH.Flags().StringVarP(&SYNC_COMMAND, "sync", "s", "curl", "sync command")
 	KUBEWATCH.Flags().StringVarP(&NAMESPACE, "namespace", "n", "", "namespace to watch (defaults to all)")
+	KUBEWATCH.Flags().StringVar(&FIELD_SELECTOR, "fields", "", "only watch resources matching the field selector")
+	KUBEWATCH.Flags().StringVarP(&LABEL_SELECTOR, "labels", "l", "", "only watch resources matching the label selector")
 	KUBEWATCH.Flags().DurationVarP(&MIN_INTERVAL, "min-interval", "m", 250*time.Millisecond, "min sync interval")
 	KUBEWATCH.Flags().DurationVarP(&WARMUP_DELAY, "warmup-delay", "w", 0, "warmup delay")
 }
 
 var (
-	PORT         string
-	SYNC_COMMAND string
-	NAMESPACE    string
-	MIN_INTERVAL time.Duration
-	WARMUP_DELAY time.Duration
+	PORT           string
+	SYNC_COMMAND   string
+	NAMESPACE      string
+	FIELD_SELECTOR string
+	LABEL_SELECTOR string
+	MIN_INTERVAL   time.Duration
+	WARMUP_DELAY   time.Duration
 )
 
 func kubewatch(cmd *cobra.Command, args []string) {
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+)
+
+// A checkResult is one line of the report produced by `teleproxy
+// check`.
+type checkResult struct {
+	Name   string
+	Err    error
+	Detail string
+}
+
+func (r checkResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("  [FAIL] %-12s %v", r.Name, r.Err)
+	}
+	return fmt.Sprintf("  [PASS] %-12s %s", r.Name, r.Detail)
+}
+
+// This is the service that the end-to-end check connects to. Every
+// cluster has it, and it is reachable from every pod.
+const CHECK_SERVICE = "kubernetes.default.svc." + CLUSTER_DOMAIN
+
+// check verifies that the environment is able to run teleproxy and,
+// if a teleproxy session is already running, that the session is
+// healthy. It prints a pass/fail report suitable for pasting into a
+// support ticket and returns the exit code.
+func check(args Args) int {
+	var results []checkResult
+	run := func(name string, f func() (string, error)) {
+		detail, err := f()
+		results = append(results, checkResult{Name: name, Err: err, Detail: detail})
+	}
+
+	run("privileges", func() (string, error) {
+		return checkPrivileges(args)
+	})
+	run("firewall", checkFirewall)
+	run("kubectl", func() (string, error) {
+		return "", checkKubectlVersion()
+	})
+
+	kubeinfo, err := args.kubeInfo(args.context, args.namespace)
+	if err != nil {
+		results = append(results, checkResult{Name: "kubeconfig", Err: err})
+	} else {
+		run("kubeconfig", func() (string, error) {
+			return fmt.Sprintf("context=%s namespace=%s", kubeinfo.Context, kubeinfo.Namespace), nil
+		})
+		run("api-server", func() (string, error) {
+			return kubectlOutput(kubeinfo, "get", "--raw", "/version")
+		})
+		run("cluster-proxy", func() (string, error) {
+			phase, err := kubectlOutput(kubeinfo, "get", "pod/teleproxy", "-o", "jsonpath={.status.phase}")
+			if err != nil {
+				return "", err
+			}
+			if phase != "Running" {
+				return "", errors.Errorf("pod/teleproxy is %s", phase)
+			}
+			return "pod/teleproxy is Running", nil
+		})
+	}
+
+	run("resolver", checkResolver)
+	run("end-to-end", checkEndToEnd)
+
+	fmt.Printf("teleproxy check (version %s, %s/%s)\n", Version, runtime.GOOS, runtime.GOARCH)
+	failed := 0
+	for _, r := range results {
+		fmt.Println(r)
+		if r.Err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(results))
+		return 1
+	}
+	fmt.Printf("all %d checks passed\n", len(results))
+	return 0
+}
+
+func checkPrivileges(args Args) (string, error) {
+	if os.Geteuid() == 0 {
+		return "running as root", nil
+	}
+	if args.helper != "" {
+		if _, err := os.Stat(args.helper); err != nil {
+			return "", errors.Wrap(err, "helper socket")
+		}
+		return fmt.Sprintf("using helper at %s", args.helper), nil
+	}
+	return "", errors.New("teleproxy must be run as root or suid root, or with -helper")
+}
+
+func checkFirewall() (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		cmd = exec.Command("iptables", "-t", "nat", "-L", "-n")
+	case "darwin":
+		cmd = exec.Command("pfctl", "-s", "info")
+	default:
+		return "", errors.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "%s: %s", strings.Join(cmd.Args, " "), strings.TrimSpace(string(output)))
+	}
+	return fmt.Sprintf("%s is usable", cmd.Args[0]), nil
+}
+
+func checkKubectlVersion() error {
+	output, err := exec.Command("kubectl", "version", "--client", "-o", "json").Output()
+	if err != nil {
+		return errors.Wrap(err, KUBECTL_ERR)
+	}
+	return parseKubectlVersion(output)
+}
+
+func kubectlOutput(kubeinfo *k8s.KubeInfo, args ...string) (string, error) {
+	cmd := exec.Command("kubectl", kubeinfo.GetKubectlArray(args...)...)
+	output, err := cmd.CombinedOutput()
+	result := strings.Join(strings.Fields(string(output)), " ")
+	if err != nil {
+		return "", errors.Wrap(err, result)
+	}
+	return result, nil
+}
+
+func checkResolver() (string, error) {
+	var err error
+	// when teleproxy is registered with a resolver manager, only
+	// the alias is guaranteed to reach us
+	for _, name := range []string{"teleproxy", MAGIC_ALIAS} {
+		var ips []net.IP
+		ips, err = net.LookupIP(name)
+		if err != nil {
+			continue
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.ParseIP(MAGIC_IP)) {
+			return "", errors.Errorf("%s resolves to %v instead of %s", name, ips, MAGIC_IP)
+		}
+		return fmt.Sprintf("%s resolves to %s", name, MAGIC_IP), nil
+	}
+	return "", errors.Wrap(err, "teleproxy is not resolving names (is a session running?)")
+}
+
+func checkEndToEnd() (string, error) {
+	ips, err := net.LookupIP(CHECK_SERVICE)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", errors.Errorf("no addresses for %s", CHECK_SERVICE)
+	}
+	addr := net.JoinHostPort(ips[0].String(), "443")
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return fmt.Sprintf("connected to %s (%s)", CHECK_SERVICE, addr), nil
+}
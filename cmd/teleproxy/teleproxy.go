@@ -11,26 +11,50 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"git.lukeshu.com/go/libsystemd/sd_daemon"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/datawire/teleproxy/pkg/k8s"
 	"github.com/datawire/teleproxy/pkg/supervisor"
+	"github.com/datawire/teleproxy/pkg/tpu"
 
 	"github.com/datawire/teleproxy/internal/pkg/api"
+	"github.com/datawire/teleproxy/internal/pkg/cgroup"
 	"github.com/datawire/teleproxy/internal/pkg/dns"
 	"github.com/datawire/teleproxy/internal/pkg/docker"
+	"github.com/datawire/teleproxy/internal/pkg/fault"
+	"github.com/datawire/teleproxy/internal/pkg/helper"
 	"github.com/datawire/teleproxy/internal/pkg/interceptor"
+	"github.com/datawire/teleproxy/internal/pkg/nat"
+	"github.com/datawire/teleproxy/internal/pkg/netns"
 	"github.com/datawire/teleproxy/internal/pkg/proxy"
 	"github.com/datawire/teleproxy/internal/pkg/route"
+	"github.com/datawire/teleproxy/internal/pkg/tokengate"
+	"github.com/datawire/teleproxy/internal/pkg/watchdog"
 )
 
+// dockerBridge returns the address of the host on the default docker
+// bridge.
+func dockerBridge(p *supervisor.Process) (string, error) {
+	output, err := p.Command("docker", "inspect", "bridge",
+		"-f", "{{(index .IPAM.Config 0).Gateway}}").Capture(nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
 func dnsListeners(p *supervisor.Process, port string) (listeners []string) {
 	// turns out you need to listen on localhost for nat to work
 	// properly for udp, otherwise you get an "unexpected source
@@ -42,13 +66,12 @@ func dnsListeners(p *supervisor.Process, port string) (listeners []string) {
 		// This is the default docker bridge. We need to listen here because the nat logic we use to intercept
 		// dns packets will divert the packet to the interface it originates from, which in the case of
 		// containers is the docker bridge. Without this dns won't work from inside containers.
-		output, err := p.Command("docker", "inspect", "bridge",
-			"-f", "{{(index .IPAM.Config 0).Gateway}}").Capture(nil)
+		bridge, err := dockerBridge(p)
 		if err != nil {
 			p.Log("not listening on docker bridge")
 			return
 		}
-		listeners = append(listeners, fmt.Sprintf("%s:%s", strings.TrimSpace(output), port))
+		listeners = append(listeners, fmt.Sprintf("%s:%s", bridge, port))
 	}
 
 	return
@@ -61,6 +84,13 @@ const (
 	INTERCEPT = "intercept"
 	BRIDGE    = "bridge"
 	VERSION   = "version"
+	CHECK     = "check"
+	HELPER    = "helper"
+	RUN       = "run"
+	SHELL     = "shell"
+	DOCKERRUN = "docker-run"
+	WATCHDOG  = "watchdog"
+	GATE      = "gate"
 
 	// This is the port to which we redirect dns requests. It should probably eventually be configurable and/or
 	// dynamically chosen
@@ -76,6 +106,24 @@ const (
 	// to be fixed so that we can debug even if dns isn't working by doing stuff like `curl
 	// 127.254.254.254/api/...`. This value happens to be the last value in the ipv4 localhost range.
 	MAGIC_IP = "127.254.254.254"
+
+	// When we register with a resolver manager rather than intercepting the system nameserver, single label
+	// names like "teleproxy" are typically not sent to us, so the magic ip is also published under this name.
+	MAGIC_ALIAS = "teleproxy." + CLUSTER_DOMAIN
+
+	// This is the name of the NetworkManager dispatcher script installed by -nmDispatcher.
+	NM_DISPATCHER_SCRIPT = "90-teleproxy"
+
+	// This is the nameserver we register with a resolver manager (see the -resolver flag). Queries sent here are
+	// redirected to our dns server. It is a link-local address so that it is unlikely to conflict with anything.
+	RESOLVER_IP = "169.254.254.253"
+
+	// These are the -resolver values that don't name a resolver manager.
+	RESOLVER_AUTO      = "auto"
+	RESOLVER_INTERCEPT = "intercept"
+
+	// This is the dns suffix under which kubernetes services and pods are published.
+	CLUSTER_DOMAIN = "cluster.local"
 )
 
 func main() {
@@ -93,11 +141,18 @@ const (
 	K8S_PORTFORWARD = "KPF"
 	K8S_SSH         = "SSH"
 	K8S_APPLY       = "KAP"
+	K8S_AUTH        = "AUT"
+	K8S_GATE        = "GTE"
 	DKR_BRIDGE      = "DKR"
 	DNS_SERVER      = "DNS"
 	DNS_CONFIG      = "CFG"
 	CHECK_READY     = "RDY"
 	SIGNAL          = "SIG"
+	PRIV_HELPER     = "HLP"
+	SANDBOX         = "NNS"
+	RUN_COMMAND     = "CMD"
+	APP_CGROUP      = "CGR"
+	WATCHDOG_WORKER = "WDG"
 )
 
 var LOG_LEGEND = []struct {
@@ -113,35 +168,153 @@ var LOG_LEGEND = []struct {
 	{K8S_PORTFORWARD, "The kubernetes port forward used for connectivity."},
 	{K8S_SSH, "The SSH port forward used on top of the kubernetes port forward."},
 	{K8S_APPLY, "The kubernetes apply used to setup the in-cluster pod we talk with."},
+	{K8S_AUTH, "The check that we are (still) allowed to connect to the in-cluster pod."},
+	{K8S_GATE, "The in-cluster gate that only lets the connections of authorized OIDC/SSO users through (-oidc), or the local SOCKS proxy that connects through it."},
 	{DKR_BRIDGE, "The docker bridge."},
 	{DNS_SERVER, "The DNS server teleproxy runs to intercept dns requests."},
 	{CHECK_READY, "The worker teleproxy uses to do a self check and signal the system it is ready."},
+	{PRIV_HELPER, "The privileged helper that programs the firewall and resolver on behalf of an unprivileged teleproxy."},
+	{SANDBOX, "The network namespace that 'teleproxy run' executes its command in."},
+	{RUN_COMMAND, "The command executed by 'teleproxy run', 'teleproxy shell', or 'teleproxy docker-run'."},
+	{APP_CGROUP, "The cgroup whose processes are intercepted when -cgroup is given."},
+	{WATCHDOG_WORKER, "The watchdog that restores the dns and firewall settings if teleproxy dies without cleaning up."},
 }
 
 type Args struct {
-	mode       string
-	kubeconfig string
-	context    string
-	namespace  string
-	dnsIP      string
-	fallbackIP string
-	nosearch   bool
-	nocheck    bool
-	version    bool
+	mode         string
+	kubeconfig   string
+	context      string
+	namespace    string
+	kubeQPS      float64
+	kubeBurst    int
+	kubeRetries  int
+	kubeTimeout  time.Duration
+	userAgent    string
+	as           string
+	asGroups     tpu.ArrayFlags
+	dnsIP        string
+	fallbackIP   string
+	nosearch     bool
+	nocheck      bool
+	version      bool
+	faults       fault.Table
+	dnsLog       bool
+	dnsLogIncl   tpu.ArrayFlags
+	dnsLogExcl   tpu.ArrayFlags
+	dnsLogK8s    bool
+	helper       string
+	resolver     string
+	nmDispatcher bool
+	uid          string
+	gid          string
+	cgroup       string
+	keepalive    time.Duration
+	keepaliveMax int
+	tcpKeepalive time.Duration
+	idleTimeout  time.Duration
+	noWatchdog   bool
+	sniPorts     tpu.ArrayFlags
+	oidc         bool
+	reauthorize  time.Duration
+	gateImage    string
+	command      []string
+	shell        bool
+	contexts     chan contextSwitch
+	sandbox      *netns.Sandbox
+}
+
+// perApp returns true if only traffic from selected processes should
+// be intercepted.
+func (a Args) perApp() bool {
+	return a.uid != "" || a.gid != "" || a.cgroup != ""
+}
+
+// kubeInfo returns the KubeInfo of the given context and namespace,
+// impersonating the user and groups given on the command line.
+func (a Args) kubeInfo(context, namespace string) (*k8s.KubeInfo, error) {
+	kubeinfo, err := k8s.NewKubeInfo(a.kubeconfig, context, namespace)
+	if err != nil {
+		return nil, err
+	}
+	kubeinfo.ImpersonateUser = a.as
+	kubeinfo.ImpersonateGroups = a.asGroups
+	return kubeinfo, nil
+}
+
+// match returns the iptables match arguments that select the
+// processes to intercept.
+func (a Args) match() (result []string) {
+	if a.uid != "" || a.gid != "" {
+		result = append(result, "-m", "owner")
+		if a.uid != "" {
+			result = append(result, "--uid-owner", a.uid)
+		}
+		if a.gid != "" {
+			result = append(result, "--gid-owner", a.gid)
+		}
+	}
+	if a.cgroup != "" {
+		result = append(result, "-m", "cgroup", "--path", a.cgroup)
+	}
+	return
 }
 
 func _main() int {
-	args := Args{}
+	args := Args{faults: fault.Table{}, contexts: make(chan contextSwitch)}
 
 	flag.BoolVar(&args.version, "version", false, "alias for '-mode=version'")
-	flag.StringVar(&args.mode, "mode", "", "mode of operation ('intercept', 'bridge', or 'version')")
+	flag.StringVar(&args.mode, "mode", "", "mode of operation ('intercept', 'bridge', 'check', 'helper', 'run', 'shell', 'docker-run', 'gate', or 'version')")
 	flag.StringVar(&args.kubeconfig, "kubeconfig", "", "absolute path to the kubeconfig file")
 	flag.StringVar(&args.context, "context", "", "context to use (default: the current context)")
 	flag.StringVar(&args.namespace, "namespace", "", "namespace to use (default: the current namespace for the context")
+	flag.Float64Var(&args.kubeQPS, "kubeQPS", 0, "requests per second to the kubernetes api server (default: 5)")
+	flag.IntVar(&args.kubeBurst, "kubeBurst", 0, "bursts of requests to the kubernetes api server (default: 10)")
+	flag.IntVar(&args.kubeRetries, "kubeRetries", 0, "how often requests that the kubernetes api server "+
+		"throttles or fails are retried (default: 0)")
+	flag.DurationVar(&args.kubeTimeout, "kubeTimeout", 0, "timeout of requests to the kubernetes api server "+
+		"(default: none)")
+	flag.StringVar(&args.userAgent, "userAgent", "", "user agent to identify as to the kubernetes api server")
+	flag.StringVar(&args.as, "as", "", "user to impersonate in the cluster (default: the user of the context)")
+	flag.Var(&args.asGroups, "as-group", "group to impersonate in the cluster, with -as (may be repeated)")
 	flag.StringVar(&args.dnsIP, "dns", "", "dns ip address")
 	flag.StringVar(&args.fallbackIP, "fallback", "", "dns fallback")
 	flag.BoolVar(&args.nosearch, "noSearchOverride", false, "disable dns search override")
 	flag.BoolVar(&args.nocheck, "noCheck", false, "disable self check")
+	flag.Var(args.faults, "fault", "inject faults into intercepted traffic, "+
+		"e.g. '[SERVICE:]latency=200ms,jitter=50ms,fail=0.1' (may be repeated)")
+	flag.BoolVar(&args.dnsLog, "dnsLog", false, "log dns queries and how they were answered")
+	flag.Var(&args.dnsLogIncl, "dnsLogInclude", "only log dns queries matching this glob pattern (may be repeated)")
+	flag.Var(&args.dnsLogExcl, "dnsLogExclude", "don't log dns queries matching this glob pattern (may be repeated)")
+	flag.BoolVar(&args.dnsLogK8s, "dnsLogClusterOnly", false, "only log dns queries for the cluster domain")
+	flag.StringVar(&args.resolver, "resolver", RESOLVER_AUTO, "how to take over dns: 'intercept' the system nameserver, "+
+		"register with '"+dns.RESOLVED+"' or '"+dns.RESOLVCONF+"', or 'auto' to register with whichever is present")
+	flag.BoolVar(&args.nmDispatcher, "nmDispatcher", false, "install a NetworkManager dispatcher script that "+
+		"reapplies the dns and routing configuration after network changes")
+	flag.StringVar(&args.uid, "uid", "", "only intercept traffic from processes running as this user id")
+	flag.StringVar(&args.gid, "gid", "", "only intercept traffic from processes running as this group id")
+	flag.StringVar(&args.cgroup, "cgroup", "", "only intercept traffic from processes in this cgroup v2 group "+
+		"(created if it doesn't exist, relative to "+cgroup.Root+")")
+	flag.DurationVar(&args.keepalive, "tunnelKeepalive", 5*time.Second, "how often to check that the tunnel "+
+		"to the cluster is alive (0 to disable)")
+	flag.IntVar(&args.keepaliveMax, "tunnelKeepaliveCount", 3, "how many tunnel keepalives may go "+
+		"unanswered before the tunnel is considered dead and reconnected")
+	flag.DurationVar(&args.tcpKeepalive, "tcpKeepalive", 0, "TCP keepalive period for intercepted "+
+		"connections (default: the system default)")
+	flag.DurationVar(&args.idleTimeout, "idleTimeout", 0, "close intercepted connections that are idle "+
+		"for this long (default: never)")
+	flag.Var(&args.sniPorts, "sniPort", "route TLS connections to this port by the server name the client asks for "+
+		"rather than by address, without terminating them (may be repeated)")
+	flag.BoolVar(&args.oidc, "oidc", false, "require the context to authenticate with an OIDC/SSO credential plugin, "+
+		"and tunnel through an in-cluster gate that checks its token for every connection, and every minute "+
+		"for open ones, so that revoking access takes effect")
+	flag.DurationVar(&args.reauthorize, "reauthorize", 0, "how often to check that we are still allowed to "+
+		"use the tunnel, shutting down if not (default: never, or every minute with -oidc)")
+	flag.StringVar(&args.gateImage, "gateImage", "", "image of the in-cluster gate, required with -oidc, "+
+		"it runs 'teleproxy gate'")
+	flag.StringVar(&args.helper, "helper", "", "unix socket of a privileged helper to use instead of running as root "+
+		"(in 'helper' mode, the socket to listen on; default: "+helper.DefaultSocket+")")
+	flag.BoolVar(&args.noWatchdog, "noWatchdog", false, "don't start a watchdog process that restores the dns and "+
+		"firewall settings if teleproxy is killed without getting the chance to clean up")
 
 	flag.Parse()
 
@@ -149,16 +322,141 @@ func _main() int {
 		args.mode = VERSION
 	}
 
+	// allow the mode to be given as a subcommand, e.g. `teleproxy check`
+	if args.mode == DEFAULT && flag.NArg() > 0 {
+		args.mode = flag.Arg(0)
+	}
+
 	switch args.mode {
 	case DEFAULT, INTERCEPT, BRIDGE:
 		// do nothing
 	case VERSION:
 		fmt.Println("teleproxy", "version", Version)
 		return 0
+	case CHECK:
+		return check(args)
+	case HELPER:
+		return runHelper(args)
+	case WATCHDOG:
+		return runWatchdog()
+	case GATE:
+		return runGate(args)
+	case RUN, SHELL:
+		if args.mode == SHELL {
+			// teleproxy [flags] shell is 'teleproxy run' with the
+			// user's shell, set up to talk to the cluster
+			if flag.NArg() > 1 || (flag.NArg() == 1 && flag.Arg(0) != SHELL) {
+				fmt.Println("usage: teleproxy shell")
+				return 2
+			}
+			shell := os.Getenv("SHELL")
+			if shell == "" {
+				shell = "/bin/sh"
+			}
+			args.command = []string{shell}
+			args.shell = true
+		} else {
+			// teleproxy [flags] run [--] command [args...]
+			args.command = flag.Args()
+			if len(args.command) > 0 && args.command[0] == RUN {
+				args.command = args.command[1:]
+			}
+			if len(args.command) > 0 && args.command[0] == "--" {
+				args.command = args.command[1:]
+			}
+			if len(args.command) == 0 {
+				fmt.Println("usage: teleproxy run -- COMMAND [ARGS...]")
+				return 2
+			}
+		}
+		if runtime.GOOS != "linux" {
+			fmt.Printf("teleproxy %s is not supported on %s\n", args.mode, runtime.GOOS)
+			return 1
+		}
+		if args.helper != "" || args.perApp() {
+			fmt.Printf("teleproxy %s cannot be used with -helper, -uid, -gid, or -cgroup\n", args.mode)
+			return 1
+		}
+		args.mode = RUN
+		args.sandbox = netns.New(os.Getpid())
+	case DOCKERRUN:
+		// teleproxy [flags] docker-run [--] [docker run args...] IMAGE [command...]
+		args.command = flag.Args()
+		if len(args.command) > 0 && args.command[0] == DOCKERRUN {
+			args.command = args.command[1:]
+		}
+		if len(args.command) > 0 && args.command[0] == "--" {
+			args.command = args.command[1:]
+		}
+		if len(args.command) == 0 {
+			fmt.Println("usage: teleproxy docker-run -- [DOCKER RUN OPTIONS] IMAGE [COMMAND] [ARGS...]")
+			return 2
+		}
+		// on other platforms containers run in a vm whose
+		// traffic never passes through our firewall rules
+		if runtime.GOOS != "linux" {
+			fmt.Printf("teleproxy docker-run is not supported on %s\n", runtime.GOOS)
+			return 1
+		}
+		if args.perApp() {
+			fmt.Println("teleproxy docker-run cannot be used with -uid, -gid, or -cgroup")
+			return 1
+		}
 	default:
 		panic(fmt.Sprintf("TPY: unrecognized mode: %v", args.mode))
 	}
 
+	if args.perApp() {
+		if runtime.GOOS != "linux" {
+			fmt.Printf("-uid, -gid, and -cgroup are not supported on %s\n", runtime.GOOS)
+			return 1
+		}
+		if args.helper != "" {
+			fmt.Println("-uid, -gid, and -cgroup cannot be used with -helper")
+			return 1
+		}
+	}
+
+	// installing the dispatcher script takes privileges the helper
+	// doesn't lend us
+	if args.nmDispatcher && args.helper != "" {
+		fmt.Println("-nmDispatcher cannot be used with -helper")
+		return 1
+	}
+
+	// there is no published gate image to default to
+	if args.oidc && args.gateImage == "" {
+		fmt.Println("-oidc requires -gateImage")
+		return 1
+	}
+
+	// from here on args.resolver is the resolver manager we
+	// register with, or "" if we intercept the system nameserver
+	switch args.resolver {
+	case RESOLVER_AUTO:
+		args.resolver = ""
+		// the sandbox has its own resolv.conf, an unprivileged
+		// teleproxy can't register with anything, and when only
+		// selected processes are intercepted, the rest of the
+		// machine shouldn't be sending queries our way
+		if args.mode != RUN && args.helper == "" && !args.perApp() {
+			args.resolver = dns.DetectResolver()
+		}
+	case RESOLVER_INTERCEPT:
+		args.resolver = ""
+	case dns.RESOLVED, dns.RESOLVCONF:
+		if args.mode == RUN || args.perApp() {
+			args.resolver = ""
+		} else if args.helper != "" {
+			// registering takes privileges the helper doesn't
+			// lend us
+			fmt.Printf("-resolver=%s cannot be used with -helper\n", args.resolver)
+			return 1
+		}
+	default:
+		panic(fmt.Sprintf("TPY: unrecognized resolver: %v", args.resolver))
+	}
+
 	// do this up front so we don't miss out on cleanup if someone
 	// Control-C's just after starting us
 	signalChan := make(chan os.Signal, 1)
@@ -174,26 +472,51 @@ func _main() int {
 		},
 	})
 
-	sup.Supervise(&supervisor.Worker{
-		Name:     CHECK_READY,
-		Requires: []string{TRANSLATOR, API, DNS_SERVER, PROXY, DNS_CONFIG},
-		Work: func(p *supervisor.Process) error {
-			err := selfcheck(p)
-			if err != nil {
-				if args.nocheck {
-					p.Logf("WARNING, SELF CHECK FAILED: %v", err)
+	// the exit status of the command in 'run' mode
+	exitCode := 0
+
+	if args.mode == RUN || args.mode == DOCKERRUN {
+		sup.Supervise(&supervisor.Worker{
+			Name:     RUN_COMMAND,
+			Requires: []string{TRANSLATOR, API, DNS_SERVER, PROXY, DNS_CONFIG, K8S_BRIDGE, K8S_SSH},
+			Work: func(p *supervisor.Process) error {
+				exitCode = runCommand(p, args)
+				cancel()
+				return nil
+			},
+		})
+	} else {
+		sup.Supervise(&supervisor.Worker{
+			Name:     CHECK_READY,
+			Requires: []string{TRANSLATOR, API, DNS_SERVER, PROXY, DNS_CONFIG},
+			Work: func(p *supervisor.Process) error {
+				name := "teleproxy"
+				if args.resolver != "" {
+					name = MAGIC_ALIAS
+				}
+				var err error
+				if args.perApp() {
+					// we ourselves aren't intercepted
+					p.Log("skipping self check, only selected processes are intercepted")
 				} else {
-					return err
+					err = selfcheck(p, name)
 				}
-			}
-			p.Do(func() {
-				sd_daemon.Notification{State: "READY=1"}.Send(false)
-				p.Ready()
-			})
-			<-p.Shutdown()
-			return nil
-		},
-	})
+				if err != nil {
+					if args.nocheck {
+						p.Logf("WARNING, SELF CHECK FAILED: %v", err)
+					} else {
+						return err
+					}
+				}
+				p.Do(func() {
+					sd_daemon.Notification{State: "READY=1"}.Send(false)
+					p.Ready()
+				})
+				<-p.Shutdown()
+				return nil
+			},
+		})
+	}
 
 	sup.Supervise(&supervisor.Worker{
 		Name: SIGNAL,
@@ -228,13 +551,177 @@ func _main() int {
 	if len(errs) > 0 {
 		return 1
 	} else {
-		return 0
+		return exitCode
+	}
+}
+
+// runCommand executes the command given to 'teleproxy run' inside the
+// sandbox, or the container given to 'teleproxy docker-run', and
+// returns its exit status.
+func runCommand(p *supervisor.Process, args Args) int {
+	var cmd *exec.Cmd
+	if args.mode == DOCKERRUN {
+		dockerArgs, err := dockerRunArgs(p, args)
+		if err != nil {
+			p.Log(err)
+			return 1
+		}
+		cmd = exec.Command("docker", dockerArgs...)
+		p.Logf("running docker %v", dockerArgs)
+	} else {
+		cmd = args.sandbox.Command(args.command...)
+		p.Logf("running %v in %s", args.command, args.sandbox.Name)
+	}
+	if args.shell {
+		env, cleanup, err := shellEnv(p, args)
+		if err != nil {
+			p.Log(err)
+			return 1
+		}
+		defer cleanup()
+		cmd.Env = env
+		fmt.Println("Starting a shell connected to the cluster, exit it to stop teleproxy.")
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Start()
+	if err != nil {
+		p.Log(err)
+		return 1
+	}
+	p.Ready()
+
+	if !p.Do(func() {
+		err = cmd.Wait()
+	}) {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 1
+	}
+
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				p.Logf("command exited with status %d", status.ExitStatus())
+				return status.ExitStatus()
+			}
+		}
+		p.Log(err)
+		return 1
+	}
+	p.Logf("command exited successfully")
+	return 0
+}
+
+// dockerRunArgs returns the arguments to 'docker run' that wire the
+// container up the way pods in the cluster are wired up.
+func dockerRunArgs(p *supervisor.Process, args Args) ([]string, error) {
+	bridge, err := dockerBridge(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "finding docker bridge")
+	}
+
+	kubeinfo, err := args.kubeInfo(args.context, args.namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "k8s.NewKubeInfo")
+	}
+
+	// Queries the container sends to the bridge are redirected to
+	// our dns server, and everything else the container sends to
+	// the cluster is intercepted on its way through the host.
+	result := []string{"run", "--dns", bridge}
+	for _, domain := range []string{kubeinfo.Namespace + ".svc." + CLUSTER_DOMAIN, "svc." + CLUSTER_DOMAIN, CLUSTER_DOMAIN} {
+		result = append(result, "--dns-search", domain)
+	}
+
+	for _, kv := range proxyEnv() {
+		result = append(result, "-e", kv)
+	}
+
+	return append(result, args.command...), nil
+}
+
+// proxyEnv returns any proxy configuration in the environment, making
+// sure that cluster traffic doesn't get sent to the proxy.
+func proxyEnv() (result []string) {
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		if value := os.Getenv(name); value != "" {
+			result = append(result, name+"="+value)
+		}
+	}
+	if len(result) > 0 {
+		noProxy := []string{"." + CLUSTER_DOMAIN}
+		if value := os.Getenv("NO_PROXY"); value != "" {
+			noProxy = append([]string{value}, noProxy...)
+		}
+		value := strings.Join(noProxy, ",")
+		result = append(result, "NO_PROXY="+value, "no_proxy="+value)
 	}
+	return
+}
+
+// shellEnv returns the environment for 'teleproxy shell'. KUBECONFIG
+// points to a private kubeconfig for the context and namespace that
+// teleproxy is connected to, which the returned cleanup function
+// removes.
+func shellEnv(p *supervisor.Process, args Args) ([]string, func(), error) {
+	kubeinfo, err := args.kubeInfo(args.context, args.namespace)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "k8s.NewKubeInfo")
+	}
+
+	file, err := ioutil.TempFile("", "teleproxy-kubeconfig-")
+	if err != nil {
+		return nil, nil, err
+	}
+	kubeconfig := file.Name()
+	cleanup := func() {
+		if err := os.Remove(kubeconfig); err != nil {
+			p.Log(err)
+		}
+	}
+	err = file.Close()
+	if err == nil {
+		err = kubeinfo.WriteKubeconfig(kubeconfig)
+	}
+	if err == nil {
+		// the shell runs as whoever invoked sudo
+		if uid, e := strconv.Atoi(os.Getenv("SUDO_UID")); e == nil {
+			gid, _ := strconv.Atoi(os.Getenv("SUDO_GID"))
+			err = os.Chown(kubeconfig, uid, gid)
+		}
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	override := map[string]string{
+		"KUBECONFIG":        kubeconfig,
+		"TELEPROXY_CONTEXT": kubeinfo.Context,
+	}
+	for _, kv := range proxyEnv() {
+		parts := strings.SplitN(kv, "=", 2)
+		override[parts[0]] = parts[1]
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if _, ok := override[strings.SplitN(kv, "=", 2)[0]]; !ok {
+			env = append(env, kv)
+		}
+	}
+	for k, v := range override {
+		env = append(env, k+"="+v)
+	}
+	return env, cleanup, nil
 }
 
-func selfcheck(p *supervisor.Process) error {
+func selfcheck(p *supervisor.Process, magic string) error {
 	// XXX: these checks might not make sense if -dns is specified
-	for _, name := range []string{"teleproxy.", "teleproxy"} {
+	for _, name := range []string{magic + ".", magic} {
 		ips, err := net.LookupIP(name)
 		if err != nil {
 			return err
@@ -251,7 +738,7 @@ func selfcheck(p *supervisor.Process) error {
 		p.Logf("%s resolves to %v", name, ips)
 	}
 
-	curl := p.Command("curl", "-sqI", "teleproxy/api/tables/")
+	curl := p.Command("curl", "-sqI", magic+"/api/tables/")
 	err := curl.Start()
 	if err != nil {
 		return err
@@ -271,7 +758,7 @@ func selfcheck(p *supervisor.Process) error {
 }
 
 func teleproxy(p *supervisor.Process, args Args) error {
-	if args.mode == DEFAULT || args.mode == INTERCEPT {
+	if args.mode == DEFAULT || args.mode == INTERCEPT || args.mode == RUN || args.mode == DOCKERRUN {
 		err := intercept(p, args)
 		if err != nil {
 			return err
@@ -280,7 +767,7 @@ func teleproxy(p *supervisor.Process, args Args) error {
 
 	sup := p.Supervisor()
 
-	if args.mode == DEFAULT || args.mode == BRIDGE {
+	if args.mode == DEFAULT || args.mode == BRIDGE || args.mode == RUN || args.mode == DOCKERRUN {
 		requires := []string{}
 		if args.mode != BRIDGE {
 			requires = append(requires, TRANSLATOR)
@@ -294,12 +781,31 @@ func teleproxy(p *supervisor.Process, args Args) error {
 					return err
 				}
 
-				kubeinfo, err := k8s.NewKubeInfo(args.kubeconfig, args.context, args.namespace)
+				kubeinfo, err := args.kubeInfo(args.context, args.namespace)
 				if err != nil {
 					return errors.Wrap(err, "k8s.NewKubeInfo")
 				}
-				bridges(p, kubeinfo)
-				return nil
+				bridges(p, kubeinfo, args)
+				p.Ready()
+
+				// stay around to switch contexts on request
+				for {
+					select {
+					case <-p.Shutdown():
+						return nil
+					case req := <-args.contexts:
+						func() {
+							// reply even if the switch panics
+							err := errors.Errorf("switching to context %s failed", req.Context)
+							defer func() { req.result <- err }()
+							var next *k8s.KubeInfo
+							next, err = switchContext(p, kubeinfo, args, req)
+							if err == nil {
+								kubeinfo = next
+							}
+						}()
+					}
+				}
 			},
 		})
 	}
@@ -315,6 +821,10 @@ func checkKubectl(p *supervisor.Process) error {
 		return errors.Wrap(err, KUBECTL_ERR)
 	}
 
+	return parseKubectlVersion([]byte(output))
+}
+
+func parseKubectlVersion(output []byte) error {
 	var info struct {
 		ClientVersion struct {
 			Major string
@@ -322,7 +832,7 @@ func checkKubectl(p *supervisor.Process) error {
 		}
 	}
 
-	err = json.Unmarshal([]byte(output), &info)
+	err := json.Unmarshal(output, &info)
 	if err != nil {
 		return errors.Wrap(err, KUBECTL_ERR)
 	}
@@ -343,163 +853,750 @@ func checkKubectl(p *supervisor.Process) error {
 	return nil
 }
 
-// intercept starts the interceptor, and only returns once the
-// interceptor is successfully running in another goroutine.  It
-// returns a function to call to shut down that goroutine.
-//
-// If dnsIP is empty, it will be detected from /etc/resolv.conf
-//
-// If fallbackIP is empty, it will default to Google DNS.
-func intercept(p *supervisor.Process, args Args) error {
-	if os.Geteuid() != 0 {
-		return errors.New("ERROR: teleproxy must be run as root or suid root")
+// systemNameserver returns the first nameserver in /etc/resolv.conf.
+func systemNameserver() (string, error) {
+	dat, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
 	}
-
-	sup := p.Supervisor()
-
-	if args.dnsIP == "" {
-		dat, err := ioutil.ReadFile("/etc/resolv.conf")
-		if err != nil {
-			return err
-		}
-		for _, line := range strings.Split(string(dat), "\n") {
-			if strings.Contains(line, "nameserver") {
-				fields := strings.Fields(line)
-				args.dnsIP = fields[1]
-				log.Printf("TPY: Automatically set -dns=%v", args.dnsIP)
-				break
-			}
+	for _, line := range strings.Split(string(dat), "\n") {
+		if strings.Contains(line, "nameserver") {
+			fields := strings.Fields(line)
+			return fields[1], nil
 		}
 	}
-	if args.dnsIP == "" {
-		return errors.New("couldn't determine dns ip from /etc/resolv.conf")
-	}
+	return "", errors.New("couldn't determine dns ip from /etc/resolv.conf")
+}
 
-	if args.fallbackIP == "" {
-		if args.dnsIP == "8.8.8.8" {
-			args.fallbackIP = "8.8.4.4"
-		} else {
-			args.fallbackIP = "8.8.8.8"
-		}
-		log.Printf("TPY: Automatically set -fallback=%v", args.fallbackIP)
-	}
-	if args.fallbackIP == args.dnsIP {
-		return errors.New("if your fallbackIP and your dnsIP are the same, you will have a dns loop")
+// runHelper runs the privileged helper that performs firewall and
+// resolver changes on behalf of an unprivileged teleproxy started
+// with -helper.
+func runHelper(args Args) int {
+	socket := args.helper
+	if socket == "" {
+		socket = helper.DefaultSocket
 	}
 
-	iceptor := interceptor.NewInterceptor("teleproxy")
-	apis, err := api.NewAPIServer(iceptor)
-	if err != nil {
-		return errors.Wrap(err, "API Server")
-	}
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	sup.Supervise(&supervisor.Worker{
-		Name:     TRANSLATOR,
-		Requires: []string{}, // XXX: this will need to include the api server once it is changed to not bind early
-		Work:     iceptor.Work,
-	})
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := supervisor.WithContext(ctx)
 
 	sup.Supervise(&supervisor.Worker{
-		Name:     API,
-		Requires: []string{},
+		Name: PRIV_HELPER,
 		Work: func(p *supervisor.Process) error {
-			apis.Start()
-			p.Ready()
-			<-p.Shutdown()
-			apis.Stop()
-			return nil
+			return helper.Serve(p, socket, "teleproxy")
 		},
 	})
 
 	sup.Supervise(&supervisor.Worker{
-		Name:     DNS_SERVER,
-		Requires: []string{},
+		Name: SIGNAL,
 		Work: func(p *supervisor.Process) error {
-			srv := dns.Server{
-				Listeners: dnsListeners(p, DNS_REDIR_PORT),
-				Fallback:  args.fallbackIP + ":53",
-				Resolve: func(domain string) string {
-					route := iceptor.Resolve(domain)
-					if route != nil {
-						return route.Ip
-					} else {
-						return ""
-					}
-				},
-			}
-			err := srv.Start(p)
-			if err != nil {
-				return err
+			select {
+			case <-p.Shutdown():
+			case s := <-signalChan:
+				p.Logf("TPY: %v", s)
+				cancel()
 			}
-			p.Ready()
-			<-p.Shutdown()
-			// there is no srv.Stop()
 			return nil
 		},
 	})
 
+	errs := sup.Run()
+	for _, err := range errs {
+		fmt.Printf("  %v\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runWatchdog runs the watchdog process started by intercept. It
+// outlives teleproxy if teleproxy is killed, and then cleans up.
+func runWatchdog() int {
+	// these are meant for teleproxy, which is responsible for
+	// disarming us when it exits cleanly
+	signal.Ignore(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	sup := supervisor.WithContext(context.Background())
 	sup.Supervise(&supervisor.Worker{
-		Name:     PROXY,
-		Requires: []string{},
+		Name: WATCHDOG_WORKER,
 		Work: func(p *supervisor.Process) error {
-			// hmm, we may not actually need to get the original
-			// destination, we could just forward each ip to a unique port
-			// and either listen on that port or run port-forward
-			proxy, err := proxy.NewProxy(fmt.Sprintf(":%s", PROXY_REDIR_PORT), iceptor.Destination)
-			if err != nil {
-				return errors.Wrap(err, "Proxy")
-			}
-
-			proxy.Start(10000)
-			p.Ready()
-			<-p.Shutdown()
-			// there is no proxy.Stop()
-			return nil
+			return watchdog.Run(p, os.Stdin)
 		},
 	})
 
-	sup.Supervise(&supervisor.Worker{
-		Name:     DNS_CONFIG,
-		Requires: []string{TRANSLATOR},
-		Work: func(p *supervisor.Process) error {
-			bootstrap := route.Table{Name: "bootstrap"}
-			bootstrap.Add(route.Route{
-				Ip:     args.dnsIP,
-				Target: DNS_REDIR_PORT,
-				Proto:  "udp",
-			})
-			bootstrap.Add(route.Route{
-				Name:   "teleproxy",
-				Ip:     MAGIC_IP,
-				Target: apis.Port(),
-				Proto:  "tcp",
+	errs := sup.Run()
+	for _, err := range errs {
+		fmt.Printf("  %v\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runGate runs the in-cluster gate of -oidc, see TELEPROXY_GATE.
+func runGate(args Args) int {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := supervisor.WithContext(ctx)
+
+	sup.Supervise(&supervisor.Worker{
+		Name: K8S_GATE,
+		Work: func(p *supervisor.Process) error {
+			client, err := k8s.NewClientWithOptions(k8s.ClientOptions{})
+			if err != nil {
+				return err
+			}
+			recheck := args.reauthorize
+			if recheck == 0 {
+				recheck = time.Minute
+			}
+			gate := &tokengate.Gate{
+				Review:  tokengate.KubernetesReviewer(client, client.Namespace(), GATE_POD),
+				Recheck: recheck,
+			}
+			ln, err := net.Listen("tcp", ":"+GATE_PORT)
+			if err != nil {
+				return err
+			}
+			p.Ready()
+			p.Logf("gate listening on %s", ln.Addr())
+			p.Go(func(p *supervisor.Process) error {
+				<-p.Shutdown()
+				return ln.Close()
 			})
-			iceptor.Update(bootstrap)
+			err = gate.Serve(ln)
+			select {
+			case <-p.Shutdown():
+				return nil
+			default:
+				return err
+			}
+		},
+	})
 
-			var restore func()
-			if !args.nosearch {
-				restore = dns.OverrideSearchDomains(p, ".")
+	sup.Supervise(&supervisor.Worker{
+		Name: SIGNAL,
+		Work: func(p *supervisor.Process) error {
+			select {
+			case <-p.Shutdown():
+			case s := <-signalChan:
+				p.Logf("TPY: %v", s)
+				cancel()
 			}
+			return nil
+		},
+	})
+
+	errs := sup.Run()
+	for _, err := range errs {
+		fmt.Printf("  %v\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// intercept starts the interceptor, and only returns once the
+// interceptor is successfully running in another goroutine.  It
+// returns a function to call to shut down that goroutine.
+//
+// If dnsIP is empty, it will be detected from /etc/resolv.conf
+//
+// If fallbackIP is empty, it will default to Google DNS.
+func intercept(p *supervisor.Process, args Args) error {
+	if os.Geteuid() != 0 && args.helper == "" {
+		return errors.New("ERROR: teleproxy must be run as root or suid root, or with -helper")
+	}
+
+	sup := p.Supervisor()
+
+	// if the system nameserver was detected, we track it when
+	// reapplying our configuration
+	autoDNS := args.dnsIP == ""
+	if autoDNS {
+		var err error
+		args.dnsIP, err = systemNameserver()
+		if err != nil {
+			return err
+		}
+		log.Printf("TPY: Automatically set -dns=%v", args.dnsIP)
+	}
+
+	if args.mode == RUN {
+		// Inside the sandbox we are the nameserver. Queries made
+		// by the host itself are not intercepted, so we can fall
+		// back to whatever the host uses.
+		if args.fallbackIP == "" {
+			args.fallbackIP = args.dnsIP
+			log.Printf("TPY: Automatically set -fallback=%v", args.fallbackIP)
+		}
+		args.dnsIP = args.sandbox.HostIP
+	}
+
+	if args.resolver != "" {
+		// Rather than intercepting the queries sent to the
+		// system nameserver, we register a nameserver of our
+		// own with the resolver manager and intercept the
+		// queries sent to that. This leaves the nameservers of
+		// e.g. VPN clients alone.
+		log.Printf("TPY: Registering with %s as %s", args.resolver, RESOLVER_IP)
+		args.dnsIP = RESOLVER_IP
+		autoDNS = false
+	}
 
+	if args.fallbackIP == "" {
+		if args.dnsIP == "8.8.8.8" {
+			args.fallbackIP = "8.8.4.4"
+		} else {
+			args.fallbackIP = "8.8.8.8"
+		}
+		log.Printf("TPY: Automatically set -fallback=%v", args.fallbackIP)
+	}
+	if args.fallbackIP == args.dnsIP {
+		return errors.New("if your fallbackIP and your dnsIP are the same, you will have a dns loop")
+	}
+
+	var queryLog *dns.QueryLog
+	if args.dnsLog {
+		queryLog = &dns.QueryLog{
+			Include:       args.dnsLogIncl,
+			Exclude:       args.dnsLogExcl,
+			ClusterOnly:   args.dnsLogK8s,
+			ClusterSuffix: CLUSTER_DOMAIN,
+		}
+	}
+
+	// These are the privileged operations. Unless we have been
+	// pointed at a helper, we perform them ourselves.
+	var iceptor *interceptor.Interceptor
+	state := watchdog.State{
+		Translator: "teleproxy",
+		Resolver:   args.resolver,
+		Sandbox:    args.sandbox,
+		Cgroup:     args.cgroup,
+	}
+	if args.resolver == "" && !args.nosearch && args.mode != RUN {
+		state.SearchDomains = true
+	}
+	if args.nmDispatcher {
+		state.Dispatcher = NM_DISPATCHER_SCRIPT
+	}
+	flush := dns.Flush
+	overrideSearchDomains := dns.OverrideSearchDomains
+	if args.helper != "" {
+		client, err := helper.Dial(args.helper)
+		if err != nil {
+			return err
+		}
+		iceptor = interceptor.NewInterceptorWithTranslator(client)
+		flush = client.Flush
+		overrideSearchDomains = func(_ *supervisor.Process, domains string) func() {
+			return client.OverrideSearchDomains(domains)
+		}
+	} else if args.mode == RUN {
+		// only intercept traffic coming out of the sandbox
+		translator := nat.NewTranslator(args.sandbox.Name)
+		translator.Interface = args.sandbox.HostIf
+		state.Translator = args.sandbox.Name
+		state.Interface = translator.Interface
+		iceptor = interceptor.NewInterceptorWithTranslator(translator)
+	} else if args.perApp() {
+		translator := nat.NewTranslator("teleproxy")
+		translator.Match = args.match()
+		state.Match = translator.Match
+		iceptor = interceptor.NewInterceptorWithTranslator(translator)
+	} else {
+		iceptor = interceptor.NewInterceptor("teleproxy")
+	}
+
+	apis, err := api.NewAPIServer(iceptor, flush)
+	if err != nil {
+		return errors.Wrap(err, "API Server")
+	}
+
+	// e.g. `curl teleproxy/api/metrics/dns`
+	dnsMetrics := &dns.Metrics{}
+	apis.Handle("/api/metrics/dns", dnsMetrics)
+
+	// e.g. `curl teleproxy/api/metrics/supervisor`
+	registry := prometheus.NewRegistry()
+	if err := sup.Register(prometheus.WrapRegistererWithPrefix("teleproxy_", registry)); err != nil {
+		return errors.Wrap(err, "metrics")
+	}
+	apis.Handle("/api/metrics/supervisor", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	// e.g. `curl -X POST teleproxy/api/context -d '{"context": "staging"}'`
+	apis.Handle("/api/context", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch args.mode {
+		case INTERCEPT:
+			http.Error(w, "there is no kubernetes bridge in intercept mode", http.StatusConflict)
+			return
+		case RUN, DOCKERRUN:
+			// the command depends on the bridge
+			http.Error(w, "the context can't be switched while running a command", http.StatusConflict)
+			return
+		}
+		req := contextSwitch{result: make(chan error, 1)}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Context == "" {
+			http.Error(w, "no context given", http.StatusBadRequest)
+			return
+		}
+		select {
+		case args.contexts <- req:
+		case <-r.Context().Done():
+			return
+		}
+		select {
+		case err = <-req.result:
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("switched to " + req.Context + "\n"))
+	}))
+
+	// The dns server, and with it the magic "teleproxy" name,
+	// is only in effect inside the sandbox. We talk to the api
+	// server directly instead.
+	dnsRequires := []string{}
+	if args.mode == RUN || args.resolver != "" || args.perApp() {
+		apiURL = "http://127.0.0.1:" + apis.Port() + "/api"
+	}
+	translatorRequires := []string{}
+	// Everything that changes the system configuration requires
+	// the watchdog, so that it is the last thing to be shut down.
+	// The helper cleans up after us when we disconnect from it.
+	watchdogRequires := []string{}
+	if args.helper == "" && !args.noWatchdog {
+		watchdogRequires = append(watchdogRequires, WATCHDOG_WORKER)
+		translatorRequires = append(translatorRequires, WATCHDOG_WORKER)
+		sup.Supervise(&supervisor.Worker{
+			Name: WATCHDOG_WORKER,
+			Work: func(p *supervisor.Process) error {
+				exe, err := os.Executable()
+				if err != nil {
+					return errors.Wrap(err, "watchdog")
+				}
+				cmd := exec.Command(exe, "-mode="+WATCHDOG)
+				cmd.Stdout = os.Stdout
+				cmd.Stderr = os.Stderr
+				// keep signals sent to our process group,
+				// e.g. Control-C, from reaching the watchdog
+				cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+				w, err := watchdog.Start(cmd, state)
+				if err != nil {
+					return err
+				}
+				p.Ready()
+				<-p.Shutdown()
+				return w.Disarm()
+			},
+		})
+	}
+
+	if args.cgroup != "" {
+		// iptables insists that the cgroup exists
+		translatorRequires = append(translatorRequires, APP_CGROUP)
+		sup.Supervise(&supervisor.Worker{
+			Name:     APP_CGROUP,
+			Requires: watchdogRequires,
+			Work: func(p *supervisor.Process) error {
+				err := cgroup.Create(p, args.cgroup)
+				if err != nil {
+					return err
+				}
+				p.Ready()
+				<-p.Shutdown()
+				cgroup.Remove(p, args.cgroup)
+				return nil
+			},
+		})
+	}
+
+	if args.mode == RUN {
+		dnsRequires = append(dnsRequires, SANDBOX)
+		sup.Supervise(&supervisor.Worker{
+			Name:     SANDBOX,
+			Requires: watchdogRequires,
+			Work: func(p *supervisor.Process) error {
+				err := args.sandbox.Setup(p)
+				if err != nil {
+					return err
+				}
+				p.Ready()
+				<-p.Shutdown()
+				args.sandbox.Teardown(p)
+				return nil
+			},
+		})
+	}
+
+	sup.Supervise(&supervisor.Worker{
+		Name:     TRANSLATOR,
+		Requires: translatorRequires, // XXX: this will need to include the api server once it is changed to not bind early
+		Work:     iceptor.Work,
+	})
+
+	sup.Supervise(&supervisor.Worker{
+		Name:     API,
+		Requires: []string{},
+		Work: func(p *supervisor.Process) error {
+			apis.Start()
+			p.Ready()
+			<-p.Shutdown()
+			apis.Stop()
+			return nil
+		},
+	})
+
+	sup.Supervise(&supervisor.Worker{
+		Name:     DNS_SERVER,
+		Requires: dnsRequires,
+		Work: func(p *supervisor.Process) error {
+			listeners := []string{args.dnsIP + ":" + DNS_REDIR_PORT}
+			if args.mode != RUN {
+				listeners = dnsListeners(p, DNS_REDIR_PORT)
+			}
+			srv := dns.Server{
+				Listeners: listeners,
+				Fallback:  args.fallbackIP + ":53",
+				QueryLog:  queryLog,
+				Metrics:   dnsMetrics,
+				Resolve: func(domain string) (ips []string) {
+					for _, route := range iceptor.ResolveAll(domain) {
+						if route.Ip != "" {
+							ips = append(ips, route.Ip)
+						}
+					}
+					return
+				},
+				SRV: func(domain string) (records []dns.SRV) {
+					for _, route := range iceptor.ResolveAll(domain) {
+						port, err := strconv.ParseUint(route.Port, 10, 16)
+						if route.Host != "" && err == nil {
+							records = append(records, dns.SRV{Target: route.Host, Port: uint16(port)})
+						}
+					}
+					return
+				},
+				CNAME: func(domain string) string {
+					route := iceptor.Resolve(domain)
+					if route != nil {
+						return route.Alias
+					} else {
+						return ""
+					}
+				},
+			}
+			err := srv.Start(p)
+			if err != nil {
+				return err
+			}
 			p.Ready()
 			<-p.Shutdown()
+			// there is no srv.Stop()
+			return nil
+		},
+	})
 
-			if !args.nosearch {
-				restore()
+	sup.Supervise(&supervisor.Worker{
+		Name:     PROXY,
+		Requires: []string{},
+		Work: func(p *supervisor.Process) error {
+			// hmm, we may not actually need to get the original
+			// destination, we could just forward each ip to a unique port
+			// and either listen on that port or run port-forward
+			proxy, err := proxy.NewProxy(fmt.Sprintf(":%s", PROXY_REDIR_PORT), iceptor.Destination)
+			if err != nil {
+				return errors.Wrap(err, "Proxy")
 			}
 
-			dns.Flush()
+			if len(args.faults) > 0 {
+				p.Logf("injecting faults: %v", args.faults)
+				proxy.Fault = func(host string) fault.Fault {
+					ip, _, err := net.SplitHostPort(host)
+					if err != nil {
+						return args.faults.Lookup("")
+					}
+					route := iceptor.ResolveIP(ip)
+					if route == nil {
+						return args.faults.Lookup("")
+					}
+					return args.faults.Lookup(route.Name)
+				}
+			}
+
+			if len(args.sniPorts) > 0 {
+				// Names we know about are forwarded by name and
+				// resolved at the other end of the tunnel, so
+				// several services can share an address.
+				proxy.SNIPorts = args.sniPorts
+				proxy.SNI = func(name, host string) string {
+					if iceptor.Resolve(name) == nil {
+						return host
+					}
+					_, port, err := net.SplitHostPort(host)
+					if err != nil {
+						return host
+					}
+					return net.JoinHostPort(name, port)
+				}
+			}
+
+			proxy.KeepAlive = args.tcpKeepalive
+			proxy.IdleTimeout = args.idleTimeout
+			proxy.Start(10000)
+			p.Ready()
+			<-p.Shutdown()
+			// there is no proxy.Stop()
 			return nil
 		},
 	})
 
+	sup.Supervise(&supervisor.Worker{
+		Name:     DNS_CONFIG,
+		Requires: []string{TRANSLATOR},
+		Work: func(p *supervisor.Process) error {
+			dnsIP := args.dnsIP
+			updateBootstrap := func() {
+				bootstrap := route.Table{Name: "bootstrap"}
+				bootstrap.Add(route.Route{
+					Ip:     dnsIP,
+					Target: DNS_REDIR_PORT,
+					Proto:  "udp",
+				})
+				bootstrap.Add(route.Route{
+					Name:   "teleproxy",
+					Ip:     MAGIC_IP,
+					Target: apis.Port(),
+					Proto:  "tcp",
+				})
+				if args.resolver != "" {
+					bootstrap.Add(route.Route{
+						Name:  MAGIC_ALIAS,
+						Ip:    MAGIC_IP,
+						Proto: "tcp",
+					})
+				}
+				if args.mode == DOCKERRUN {
+					// the nameserver of the container
+					bridge, err := dockerBridge(p)
+					if err != nil {
+						p.Logf("not intercepting dns on docker bridge: %v", err)
+					} else {
+						bootstrap.Add(route.Route{
+							Ip:     bridge,
+							Target: DNS_REDIR_PORT,
+							Proto:  "udp",
+						})
+					}
+				}
+				iceptor.Update(bootstrap)
+			}
+			updateBootstrap()
+
+			// the sandbox has its own resolv.conf, so the host
+			// resolver is left alone
+			if args.mode == RUN {
+				p.Ready()
+				<-p.Shutdown()
+				return nil
+			}
+
+			// configure points the system resolver at us, and
+			// returns a function that undoes that
+			configure := func() (func(), error) {
+				if args.resolver != "" {
+					// We are the default route for
+					// queries, and the only route for
+					// the cluster domain. More specific
+					// routing domains, e.g. those of a
+					// VPN, still win.
+					return dns.ConfigureResolver(p, args.resolver, dnsIP,
+						[]string{"~.", "~" + CLUSTER_DOMAIN})
+				}
+				if args.nosearch {
+					return func() {}, nil
+				}
+				return overrideSearchDomains(p, "."), nil
+			}
+
+			// SIGHUP (which is what the reapply api and the
+			// NetworkManager dispatcher script send) reapplies
+			// our configuration
+			reapply := make(chan os.Signal, 1)
+			signal.Notify(reapply, syscall.SIGHUP)
+			defer signal.Stop(reapply)
+
+			if args.nmDispatcher {
+				remove, err := dns.InstallDispatcher(p, NM_DISPATCHER_SCRIPT,
+					fmt.Sprintf("curl -sf -X POST http://%s/api/reapply", MAGIC_IP))
+				if err != nil {
+					return err
+				}
+				defer remove()
+			}
+
+			restore, err := configure()
+			if err != nil {
+				return err
+			}
+
+			p.Ready()
+
+			for {
+				select {
+				case <-p.Shutdown():
+					restore()
+					flush()
+					return nil
+				case <-reapply:
+					p.Log("reapplying dns and routing configuration")
+					restore()
+					if autoDNS {
+						ip, err := systemNameserver()
+						if err != nil {
+							p.Log(err)
+						} else if ip != dnsIP && ip != args.fallbackIP {
+							p.Logf("system nameserver changed from %s to %s", dnsIP, ip)
+							dnsIP = ip
+							updateBootstrap()
+						}
+					}
+					iceptor.Refresh()
+					restore, err = configure()
+					if err != nil {
+						return err
+					}
+					flush()
+				}
+			}
+		},
+	})
+
 	return nil
 }
 
-func bridges(p *supervisor.Process, kubeinfo *k8s.KubeInfo) error {
+func bridges(p *supervisor.Process, kubeinfo *k8s.KubeInfo, args Args) error {
 	sup := p.Supervisor()
 
-	connect(p, kubeinfo)
+	kubeBridge(p, kubeinfo, args)
+
+	sup.Supervise(&supervisor.Worker{
+		Name: DKR_BRIDGE,
+		Work: func(p *supervisor.Process) error {
+			// setup docker bridge
+			dw := docker.NewWatcher()
+			dw.Start(func(w *docker.Watcher) {
+				table := route.Table{Name: "docker"}
+				for name, ip := range w.Containers {
+					table.Add(route.Route{Name: name, Ip: ip, Proto: "tcp"})
+				}
+				post(table)
+			})
+			p.Ready()
+			<-p.Shutdown()
+			dw.Stop()
+			return nil
+		},
+	})
+
+	return nil
+}
+
+// A contextSwitch is a request to switch the kubernetes bridge to
+// another context.
+type contextSwitch struct {
+	Context   string `json:"context"`
+	Namespace string `json:"namespace"`
+	result    chan error
+}
+
+// kubeWorkers are the workers that make up the kubernetes bridge, in
+// the order they are shut down in.
+var kubeWorkers = []string{K8S_BRIDGE, K8S_SSH, K8S_GATE, K8S_PORTFORWARD, K8S_APPLY, K8S_AUTH}
+
+// switchContext replaces the kubernetes bridge for the current context
+// with one for the requested context. The new bridge posts its routes
+// as a replacement for the "kubernetes" routing table, so names never
+// resolve to a mix of both clusters.
+func switchContext(p *supervisor.Process, current *k8s.KubeInfo, args Args, req contextSwitch) (*k8s.KubeInfo, error) {
+	kubeinfo, err := args.kubeInfo(req.Context, req.Namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "k8s.NewKubeInfo")
+	}
+
+	// make sure we can get at the new cluster before letting go of
+	// the old one
+	err = authorize(p, kubeinfo)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Logf("switching from ctx=%s ns=%s to ctx=%s ns=%s", current.Context, current.Namespace,
+		kubeinfo.Context, kubeinfo.Namespace)
+
+	sup := p.Supervisor()
+	var workers []*supervisor.Worker
+	for _, name := range kubeWorkers {
+		w := sup.Get(name)
+		if w != nil {
+			w.Shutdown()
+			workers = append(workers, w)
+		}
+	}
+	for _, w := range workers {
+		w.Wait()
+	}
+
+	kubeBridge(p, kubeinfo, args)
+	return kubeinfo, nil
+}
+
+// clientOptions returns the options for a kubernetes client of the
+// given context.
+func clientOptions(kubeinfo *k8s.KubeInfo, args Args) k8s.ClientOptions {
+	return k8s.ClientOptions{
+		Kubeconfig: kubeinfo.Kubeconfig,
+		Context:    kubeinfo.Context,
+		Namespace:  kubeinfo.Namespace,
+		QPS:        float32(args.kubeQPS),
+		Burst:      args.kubeBurst,
+		Retry:      k8s.RetryPolicy{Retries: args.kubeRetries},
+		Timeout:    args.kubeTimeout,
+		UserAgent:  args.userAgent,
+
+		ImpersonateUser:   kubeinfo.ImpersonateUser,
+		ImpersonateGroups: kubeinfo.ImpersonateGroups,
+	}
+}
+
+// kubeBridge sets up the connection to the cluster, the kubernetes
+// bridge, and the dns search path for the given context.
+func kubeBridge(p *supervisor.Process, kubeinfo *k8s.KubeInfo, args Args) {
+	sup := p.Supervisor()
+
+	connect(p, kubeinfo, args)
 
 	sup.Supervise(&supervisor.Worker{
 		Name: K8S_BRIDGE,
@@ -507,59 +1604,172 @@ func bridges(p *supervisor.Process, kubeinfo *k8s.KubeInfo) error {
 			// setup kubernetes bridge
 			p.Logf("kubernetes ctx=%s ns=%s", kubeinfo.Context, kubeinfo.Namespace)
 			var w *k8s.Watcher
+			var clientErr error
 
 			ok := p.Do(func() {
-				w = k8s.NewClient(kubeinfo).Watcher()
+				var client *k8s.Client
+				client, clientErr = k8s.NewClientWithOptions(clientOptions(kubeinfo, args))
+				if clientErr != nil {
+					return
+				}
+				w = client.Watcher()
 
 				updateTable := func(w *k8s.Watcher) {
 					table := route.Table{Name: "kubernetes"}
 
+					// A name may have several ips, and an ip
+					// several names, but only one route per ip
+					// may redirect it, and routes must be unique.
+					type key struct{ name, ip, host, port string }
+					seen := make(map[key]bool)
+					forwarded := make(map[string]bool)
+					add := func(r route.Route) {
+						k := key{r.Name, r.Ip, r.Host, r.Port}
+						if seen[k] {
+							return
+						}
+						seen[k] = true
+						if r.Target != "" {
+							if forwarded[r.Ip] {
+								r.Target = ""
+							} else {
+								forwarded[r.Ip] = true
+							}
+						}
+						table.Add(r)
+					}
+
+					headless := make(map[string]bool)
+
 					for _, svc := range w.List("services") {
+						qualName := svc.Name() + "." + svc.Namespace() + ".svc." + CLUSTER_DOMAIN
+
+						// ExternalName services are published
+						// as a CNAME for the external name
+						if svc.Spec()["type"] == "ExternalName" {
+							name, ok := svc.Spec()["externalName"].(string)
+							if ok && name != "" {
+								add(route.Route{
+									Name:  qualName,
+									Alias: name,
+									Proto: "tcp",
+								})
+							}
+							continue
+						}
+
 						ip, ok := svc.Spec()["clusterIP"]
-						// for headless services the IP is None, we
-						// should properly handle these by listening
-						// for endpoints and returning multiple A
-						// records at some point
-						if ok && ip != "None" {
-							qualName := svc.Name() + "." + svc.Namespace() + ".svc.cluster.local"
-							table.Add(route.Route{
+						// for headless services the IP is None,
+						// they resolve to their endpoints instead
+						if ok && ip == "None" {
+							headless[svc.QName()] = true
+						} else if ok {
+							add(route.Route{
 								Name:   qualName,
 								Ip:     ip.(string),
 								Proto:  "tcp",
 								Target: PROXY_REDIR_PORT,
 							})
+
+							var service struct {
+								Spec struct {
+									Ports []servicePort `json:"ports"`
+								} `json:"spec"`
+							}
+							err := svc.Decode(&service)
+							if err != nil {
+								p.Logf("error decoding service %s: %v", svc.QName(), err)
+								continue
+							}
+							for _, port := range service.Spec.Ports {
+								if r, ok := port.srv(qualName, qualName); ok {
+									add(r)
+								}
+							}
 						}
 					}
 
 					for _, pod := range w.List("pods") {
-						qname := ""
-
-						hostname, ok := pod.Spec()["hostname"]
-						if ok && hostname != "" {
-							qname += hostname.(string)
+						ip, ok := pod.Status()["podIP"].(string)
+						if !ok || ip == "" {
+							continue
 						}
 
-						subdomain, ok := pod.Spec()["subdomain"]
-						if ok && subdomain != "" {
-							qname += "." + subdomain.(string)
-						}
+						hostname, _ := pod.Spec()["hostname"].(string)
+						subdomain, _ := pod.Spec()["subdomain"].(string)
 
-						if qname == "" {
+						qname := ""
+						if hostname != "" && subdomain != "" {
+							qname = hostname + "." + subdomain + "." + pod.Namespace() + ".svc." + CLUSTER_DOMAIN
+						} else {
 							// Note: this is a departure from kubernetes, kubernetes will
 							// simply not publish a dns name in this case.
-							qname = pod.Name() + "." + pod.Namespace() + ".pod.cluster.local"
-						} else {
-							qname += ".svc.cluster.local"
+							qname = pod.Name() + "." + pod.Namespace() + ".pod." + CLUSTER_DOMAIN
 						}
 
-						ip, ok := pod.Status()["podIP"]
-						if ok && ip != "" {
-							table.Add(route.Route{
-								Name:   qname,
-								Ip:     ip.(string),
-								Proto:  "tcp",
-								Target: PROXY_REDIR_PORT,
-							})
+						add(route.Route{
+							Name:   qname,
+							Ip:     ip,
+							Proto:  "tcp",
+							Target: PROXY_REDIR_PORT,
+						})
+						// the a-b-c-d.namespace.pod record
+						add(route.Route{
+							Name:  strings.Replace(ip, ".", "-", -1) + "." + pod.Namespace() + ".pod." + CLUSTER_DOMAIN,
+							Ip:    ip,
+							Proto: "tcp",
+						})
+					}
+
+					for _, ep := range w.List("endpoints") {
+						if !headless[ep.QName()] {
+							continue
+						}
+						var endpoints struct {
+							Subsets []struct {
+								Addresses []struct {
+									IP       string `json:"ip"`
+									Hostname string `json:"hostname"`
+								} `json:"addresses"`
+								Ports []servicePort `json:"ports"`
+							} `json:"subsets"`
+						}
+						err := ep.Decode(&endpoints)
+						if err != nil {
+							p.Logf("error decoding endpoints %s: %v", ep.QName(), err)
+							continue
+						}
+
+						svcName := ep.Name() + "." + ep.Namespace() + ".svc." + CLUSTER_DOMAIN
+						for _, subset := range endpoints.Subsets {
+							for _, addr := range subset.Addresses {
+								// the service name resolves to
+								// all the ready endpoints
+								add(route.Route{
+									Name:   svcName,
+									Ip:     addr.IP,
+									Proto:  "tcp",
+									Target: PROXY_REDIR_PORT,
+								})
+								// and each endpoint has a name
+								// of its own
+								hostname := addr.Hostname
+								if hostname == "" {
+									hostname = strings.Replace(addr.IP, ".", "-", -1)
+								}
+								add(route.Route{
+									Name:  hostname + "." + svcName,
+									Ip:    addr.IP,
+									Proto: "tcp",
+								})
+								// as well as SRV records for
+								// each named port
+								for _, port := range subset.Ports {
+									if r, ok := port.srv(svcName, hostname+"."+svcName); ok {
+										add(r)
+									}
+								}
+							}
 						}
 					}
 
@@ -573,10 +1783,20 @@ func bridges(p *supervisor.Process, kubeinfo *k8s.KubeInfo) error {
 				w.Watch("pods", func(w *k8s.Watcher) {
 					updateTable(w)
 				})
+
+				w.Watch("endpoints", func(w *k8s.Watcher) {
+					updateTable(w)
+				})
 			})
 
+			if clientErr != nil {
+				return clientErr
+			}
 			if ok {
-				w.Start()
+				err := w.Start()
+				if err != nil {
+					return err
+				}
 				p.Ready()
 				<-p.Shutdown()
 				w.Stop()
@@ -597,32 +1817,40 @@ func bridges(p *supervisor.Process, kubeinfo *k8s.KubeInfo) error {
 	if err != nil {
 		panic(err)
 	}
-	_, err = http.Post("http://teleproxy/api/search", "application/json", bytes.NewReader(body))
+	_, err = http.Post(apiURL+"/search", "application/json", bytes.NewReader(body))
 	if err != nil {
 		log.Printf("BRG: error setting up search path: %v", err)
 		panic(err) // Because this will fail if we win the startup race
 	}
+}
 
-	sup.Supervise(&supervisor.Worker{
-		Name: DKR_BRIDGE,
-		Work: func(p *supervisor.Process) error {
-			// setup docker bridge
-			dw := docker.NewWatcher()
-			dw.Start(func(w *docker.Watcher) {
-				table := route.Table{Name: "docker"}
-				for name, ip := range w.Containers {
-					table.Add(route.Route{Name: name, Ip: ip, Proto: "tcp"})
-				}
-				post(table)
-			})
-			p.Ready()
-			<-p.Shutdown()
-			dw.Stop()
-			return nil
-		},
-	})
+// This is where the bridges find the api server.
+var apiURL = "http://teleproxy/api"
 
-	return nil
+// A servicePort is a port of a service or of its endpoints.
+type servicePort struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+}
+
+// srv returns the route for the SRV record that kubernetes publishes
+// for the port, i.e. _name._protocol.service pointing at the port of
+// target. Only named ports get SRV records.
+func (sp servicePort) srv(service, target string) (route.Route, bool) {
+	if sp.Name == "" || sp.Port == 0 {
+		return route.Route{}, false
+	}
+	proto := strings.ToLower(sp.Protocol)
+	if proto == "" {
+		proto = "tcp"
+	}
+	return route.Route{
+		Name:  "_" + sp.Name + "._" + proto + "." + service,
+		Host:  target,
+		Port:  strconv.Itoa(sp.Port),
+		Proto: proto,
+	}, true
 }
 
 func post(tables ...route.Table) {
@@ -636,7 +1864,7 @@ func post(tables ...route.Table) {
 	if err != nil {
 		panic(err)
 	}
-	resp, err := http.Post("http://teleproxy/api/tables/", "application/json", bytes.NewReader(body))
+	resp, err := http.Post(apiURL+"/tables/", "application/json", bytes.NewReader(body))
 	if err != nil {
 		log.Printf("BRG: error posting update to %s: %v", jnames, err)
 	} else {
@@ -661,15 +1889,150 @@ spec:
       containerPort: 8022
 `
 
-func connect(p *supervisor.Process, kubeinfo *k8s.KubeInfo) {
+// TELEPROXY_GATE is the in-cluster end of the tunnel with -oidc, in
+// place of TELEPROXY_POD. Rather than sshd, which lets anyone through
+// who can reach it, it runs 'teleproxy gate', which only lets the
+// connections through that present the token of a user who is allowed
+// to port forward to it, and closes them once that is no longer the
+// case, see tokengate. It is formatted with the namespace and the
+// image of the gate. Reviewing tokens takes the system:auth-delegator
+// role.
+const TELEPROXY_GATE = `
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: teleproxy-gate
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: teleproxy-gate-%[1]s
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:auth-delegator
+subjects:
+- kind: ServiceAccount
+  name: teleproxy-gate
+  namespace: %[1]s
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: teleproxy-gate
+  labels:
+    name: teleproxy-gate
+spec:
+  serviceAccountName: teleproxy-gate
+  containers:
+  - name: gate
+    image: %[2]s
+    command: ["teleproxy", "-mode=gate"]
+    ports:
+    - protocol: TCP
+      containerPort: 8022
+`
+
+const (
+	GATE_POD  = "teleproxy-gate"
+	GATE_PORT = "8022"
+)
+
+// gateToken returns the token that the local end of the gate presents
+// for the user of the context. Running a credential plugin for every
+// connection would be slow, so the token is reused for a minute, well
+// within what a plugin hands out tokens for.
+func gateToken(kubeinfo *k8s.KubeInfo) func() (string, error) {
+	var mutex sync.Mutex
+	var token string
+	var fetched time.Time
+	return func() (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if token == "" || time.Since(fetched) > time.Minute {
+			t, err := kubeinfo.GetToken()
+			if err != nil {
+				return "", err
+			}
+			token = t
+			fetched = time.Now()
+		}
+		return token, nil
+	}
+}
+
+// authorize checks that the user of the context is allowed to
+// port-forward to the in-cluster pod. For an OIDC/SSO user this runs
+// the credential plugin, so an expired session or revoked access
+// shows up here.
+func authorize(p *supervisor.Process, kubeinfo *k8s.KubeInfo) error {
+	output, err := p.Command("kubectl", kubeinfo.GetKubectlArray("auth", "can-i", "create",
+		"pods/teleproxy", "--subresource=portforward")...).Capture(nil)
+	if err != nil || strings.TrimSpace(output) != "yes" {
+		return errors.Errorf("not authorized to connect to pod/teleproxy: %s", strings.TrimSpace(output))
+	}
+	return nil
+}
+
+func connect(p *supervisor.Process, kubeinfo *k8s.KubeInfo, args Args) {
 	sup := p.Supervisor()
 
+	applyRequires := []string{}
+	if args.oidc || args.reauthorize > 0 {
+		applyRequires = append(applyRequires, K8S_AUTH)
+		sup.Supervise(&supervisor.Worker{
+			Name: K8S_AUTH,
+			Work: func(p *supervisor.Process) error {
+				if args.oidc {
+					plugin, err := kubeinfo.GetAuthPlugin()
+					if err != nil {
+						return err
+					}
+					if plugin == "" {
+						return errors.Errorf("context %s does not authenticate with a credential plugin", kubeinfo.Context)
+					}
+					p.Logf("authenticating with %s", plugin)
+				}
+
+				err := authorize(p, kubeinfo)
+				if err != nil {
+					return err
+				}
+				p.Ready()
+
+				interval := args.reauthorize
+				if interval == 0 {
+					interval = time.Minute
+				}
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-p.Shutdown():
+						return nil
+					case <-ticker.C:
+						// an error here shuts everything down
+						err := authorize(p, kubeinfo)
+						if err != nil {
+							return err
+						}
+					}
+				}
+			},
+		})
+	}
+
 	sup.Supervise(&supervisor.Worker{
-		Name: K8S_APPLY,
+		Name:     K8S_APPLY,
+		Requires: applyRequires,
 		Work: func(p *supervisor.Process) (err error) {
 			// setup remote teleproxy pod
 			apply := p.Command("kubectl", kubeinfo.GetKubectlArray("apply", "-f", "-")...)
 			apply.Stdin = strings.NewReader(TELEPROXY_POD)
+			if args.oidc {
+				apply.Stdin = strings.NewReader(fmt.Sprintf(TELEPROXY_GATE, kubeinfo.Namespace, args.gateImage))
+			}
 			err = apply.Start()
 			if err != nil {
 				return
@@ -691,7 +2054,11 @@ func connect(p *supervisor.Process, kubeinfo *k8s.KubeInfo) {
 		Requires: []string{K8S_APPLY},
 		Retry:    true,
 		Work: func(p *supervisor.Process) (err error) {
-			pf := p.Command("kubectl", kubeinfo.GetKubectlArray("port-forward", "pod/teleproxy", "8022")...)
+			pod := "pod/teleproxy"
+			if args.oidc {
+				pod = "pod/" + GATE_POD
+			}
+			pf := p.Command("kubectl", kubeinfo.GetKubectlArray("port-forward", pod, "8022")...)
 			err = pf.Start()
 			if err != nil {
 				return
@@ -701,7 +2068,7 @@ func connect(p *supervisor.Process, kubeinfo *k8s.KubeInfo) {
 				err = pf.Wait()
 				if err != nil {
 					inspect := p.Command("kubectl",
-						kubeinfo.GetKubectlArray("get", "pod/teleproxy")...)
+						kubeinfo.GetKubectlArray("get", pod)...)
 					inspect.Run()
 				}
 			}) {
@@ -711,16 +2078,52 @@ func connect(p *supervisor.Process, kubeinfo *k8s.KubeInfo) {
 		},
 	})
 
+	if args.oidc {
+		// the gate stands in for ssh, see TELEPROXY_GATE
+		sup.Supervise(&supervisor.Worker{
+			Name:     K8S_GATE,
+			Requires: []string{K8S_PORTFORWARD},
+			Work: func(p *supervisor.Process) error {
+				client := &tokengate.Client{Gate: "localhost:" + GATE_PORT, Token: gateToken(kubeinfo)}
+				ln, err := net.Listen("tcp", "localhost:1080")
+				if err != nil {
+					return err
+				}
+				p.Ready()
+				p.Go(func(p *supervisor.Process) error {
+					<-p.Shutdown()
+					return ln.Close()
+				})
+				err = client.ServeSOCKS(ln)
+				select {
+				case <-p.Shutdown():
+					return nil
+				default:
+					return err
+				}
+			},
+		})
+		return
+	}
+
 	sup.Supervise(&supervisor.Worker{
 		Name:     K8S_SSH,
 		Requires: []string{K8S_PORTFORWARD},
 		Retry:    true,
 		Work: func(p *supervisor.Process) (err error) {
-			// XXX: probably need some kind of keepalive check for ssh, first
-			// curl after wakeup seems to trigger detection of death
+			// The keepalive detects a dead tunnel (e.g. after
+			// wakeup, or when a NAT gateway has dropped it)
+			// within interval*count, at which point ssh exits
+			// and we reconnect. The traffic also keeps NAT
+			// gateways from dropping idle tunnels.
+			interval := int((args.keepalive + time.Second - 1) / time.Second)
 			ssh := p.Command("ssh", "-D", "localhost:1080", "-C", "-N", "-oConnectTimeout=5",
 				"-oExitOnForwardFailure=yes", "-oStrictHostKeyChecking=no",
-				"-oUserKnownHostsFile=/dev/null", "telepresence@localhost", "-p", "8022")
+				"-oUserKnownHostsFile=/dev/null",
+				fmt.Sprintf("-oServerAliveInterval=%d", interval),
+				fmt.Sprintf("-oServerAliveCountMax=%d", args.keepaliveMax),
+				"-oTCPKeepAlive=yes",
+				"telepresence@localhost", "-p", "8022")
 			err = ssh.Start()
 			if err != nil {
 				return
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// The admin API changes what a running watt watches:
+//
+//   - /admin/sources lists the initial sources (GET), adds one (POST
+//     {"kind": "ingresses"}), or removes one that was added that way
+//     (DELETE ?kind=ingresses)
+//   - /admin/watches lists the watches added on top of those of the
+//     watch hooks (GET), adds watches (POST), or removes them (DELETE),
+//     given in the format of the watch hooks, e.g. {"consul-watches":
+//     [{"consul-address": "127.0.0.1:8500", "datacenter": "dc1",
+//     "service-name": "foo"}]}
+//
+// The changes are reflected in the snapshots that follow. Since the
+// admin API can make watt watch anything it has access to, it is only
+// served when clients have to authenticate, see --api-token-file and
+// --tls-client-ca.
+
+// adminAllowed answers the request itself, and returns false, when the
+// admin API is not available.
+func (s *apiServer) adminAllowed(w http.ResponseWriter) bool {
+	if s.auth == nil {
+		http.Error(w, "the admin API requires --api-token-file or --tls-client-ca", http.StatusForbidden)
+		return false
+	}
+	if s.reloader == nil || s.aggregator == nil {
+		http.Error(w, "the admin API is not available", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func (s *apiServer) serveAdminSources(p *supervisor.Process, w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w) {
+		return
+	}
+	change := sourceChange{result: make(chan []string, 1)}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Kind == "" {
+			http.Error(w, `expected {"kind": "<kind>"}`, http.StatusBadRequest)
+			return
+		}
+		change.add = body.Kind
+	case http.MethodDelete:
+		change.remove = r.URL.Query().Get("kind")
+		if change.remove == "" {
+			http.Error(w, "expected ?kind=<kind>", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sources []string
+	select {
+	case s.reloader.SourceChanges <- change:
+		sources = <-change.result
+	case <-r.Context().Done():
+		return
+	case <-p.Shutdown():
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeAdminResult(p, w, sources)
+}
+
+func (s *apiServer) serveAdminWatches(p *supervisor.Process, w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w) {
+		return
+	}
+	change := watchChange{result: make(chan WatchSet, 1)}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		var body WatchSet
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPost {
+			change.add = body
+		} else {
+			change.remove = body
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var watches WatchSet
+	select {
+	case s.aggregator.WatchChanges <- change:
+		watches = <-change.result
+	case <-r.Context().Done():
+		return
+	case <-p.Shutdown():
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeAdminResult(p, w, watches)
+}
+
+func (s *apiServer) writeAdminResult(p *supervisor.Process, w http.ResponseWriter, result interface{}) {
+	bytes, err := json.MarshalIndent(result, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	if _, err := w.Write(bytes); err != nil {
+		p.Logf("write admin result error: %v", err)
+	}
+}
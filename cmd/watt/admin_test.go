@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+func TestAdminAPI(t *testing.T) {
+	watchHook := func(p *supervisor.Process, snapshot []byte) WatchSet { return WatchSet{} }
+	iso := newAggIsolator(t, []string{"service"}, watchHook)
+	bootstraps := make(chan config, 10)
+	newBootstrap := func(c config) *supervisor.Worker {
+		return &supervisor.Worker{
+			Name:     "kubebootstrap",
+			Requires: []string{"aggregator"},
+			Work: func(p *supervisor.Process) error {
+				bootstraps <- c
+				p.Ready()
+				<-p.Shutdown()
+				return nil
+			},
+		}
+	}
+	cfg := config{Sources: []string{"service"}}
+	r := &reloader{
+		current:       cfg,
+		SourceChanges: make(chan sourceChange),
+		bootstrap:     "kubebootstrap",
+		newBootstrap:  newBootstrap,
+		aggregator:    iso.aggregator,
+		invoker:       NewInvoker(0, nil),
+	}
+	iso.sup.Supervise(newBootstrap(cfg))
+	iso.sup.Supervise(&supervisor.Worker{Name: "reloader", Work: r.Work, Requires: []string{"aggregator"}})
+	iso.Start()
+	defer iso.Stop()
+	<-bootstraps
+
+	s := &apiServer{aggregator: iso.aggregator, reloader: r}
+	supervisor.MustRun("admin", func(p *supervisor.Process) error {
+		do := func(handler func(*supervisor.Process, http.ResponseWriter, *http.Request), method, url, body string,
+			result interface{}) int {
+			w := httptest.NewRecorder()
+			handler(p, w, httptest.NewRequest(method, url, strings.NewReader(body)))
+			if result != nil && w.Code == http.StatusOK {
+				if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+					t.Errorf("%s %s: %v", method, url, err)
+				}
+			}
+			return w.Code
+		}
+
+		// not without authentication
+		if status := do(s.serveAdminSources, "GET", "/admin/sources", "", nil); status != http.StatusForbidden {
+			t.Errorf("expected 403 without authentication, got %d", status)
+		}
+		s.auth = &apiAuth{token: "secret"}
+
+		var sources []string
+		do(s.serveAdminSources, "POST", "/admin/sources", `{"kind": "ingress"}`, &sources)
+		if !reflect.DeepEqual(sources, []string{"service", "ingress"}) {
+			t.Errorf("unexpected sources: %v", sources)
+		}
+		if c := <-bootstraps; !reflect.DeepEqual(c.Sources, []string{"service", "ingress"}) {
+			t.Errorf("unexpected bootstrap sources: %v", c.Sources)
+		}
+		// only the added sources can be removed
+		do(s.serveAdminSources, "DELETE", "/admin/sources?kind=service", "", &sources)
+		do(s.serveAdminSources, "DELETE", "/admin/sources?kind=ingress", "", &sources)
+		if !reflect.DeepEqual(sources, []string{"service"}) {
+			t.Errorf("unexpected sources: %v", sources)
+		}
+		if c := <-bootstraps; !reflect.DeepEqual(c.Sources, []string{"service"}) {
+			t.Errorf("unexpected bootstrap sources: %v", c.Sources)
+		}
+		if status := do(s.serveAdminSources, "POST", "/admin/sources", `{}`, nil); status != http.StatusBadRequest {
+			t.Errorf("expected 400 without a kind, got %d", status)
+		}
+
+		consul := `{"consul-watches": [{"consul-address": "127.0.0.1:8500", "datacenter": "dc1", "service-name": "foo"}]}`
+		var watches WatchSet
+		do(s.serveAdminWatches, "POST", "/admin/watches", consul, &watches)
+		if len(watches.ConsulWatches) != 1 || watches.ConsulWatches[0].ServiceName != "foo" {
+			t.Errorf("unexpected watches: %+v", watches)
+		}
+		// the consul watch manager is told about the watch, after
+		// whatever the source changes notified it of
+		awaitConsulWatches := func(expected int) {
+			timeout := time.After(10 * time.Second)
+			for {
+				select {
+				case w := <-iso.consulWatches:
+					if len(w) == expected {
+						return
+					}
+				case <-timeout:
+					t.Fatalf("expected %d consul watches", expected)
+				}
+			}
+		}
+		awaitConsulWatches(1)
+		do(s.serveAdminWatches, "DELETE", "/admin/watches", consul, &watches)
+		if len(watches.ConsulWatches) != 0 {
+			t.Errorf("unexpected watches: %+v", watches)
+		}
+		awaitConsulWatches(0)
+		if status := do(s.serveAdminWatches, "POST", "/admin/watches", `{"bogus": []}`, nil); status != http.StatusBadRequest {
+			t.Errorf("expected 400 for an unknown field, got %d", status)
+		}
+		return nil
+	})
+
+	select {
+	case c := <-bootstraps:
+		t.Errorf("unexpected bootstrap: %+v", c)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
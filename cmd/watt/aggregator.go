@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"os/exec"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/datawire/teleproxy/pkg/clock"
 	"github.com/datawire/teleproxy/pkg/consulwatch"
 	"github.com/datawire/teleproxy/pkg/limiter"
 	"github.com/datawire/teleproxy/pkg/watt"
@@ -15,19 +18,54 @@ import (
 	"github.com/datawire/teleproxy/pkg/supervisor"
 )
 
-type WatchHook func(p *supervisor.Process, snapshot string) WatchSet
+type WatchHook func(p *supervisor.Process, snapshot []byte) WatchSet
+
+// CONSUL_SECTION names the consul endpoints in a set of interests.
+const CONSUL_SECTION = "consul"
+
+// interests is the set of snapshot sections that a consumer of
+// snapshots looks at. Kubernetes resources are named by their kind,
+// consul endpoints by CONSUL_SECTION. No interests at all means that
+// the consumer looks at everything.
+type interests map[string]bool
+
+func newInterests(sections []string) interests {
+	if len(sections) == 0 {
+		return nil
+	}
+	result := make(interests)
+	for _, s := range sections {
+		result[strings.ToLower(s)] = true
+	}
+	return result
+}
+
+// covers returns true if any of the changed sections are of interest.
+func (i interests) covers(changed map[string]bool) bool {
+	for s := range changed {
+		if i == nil || i[s] {
+			return true
+		}
+	}
+	return false
+}
 
 type aggregator struct {
 	// Input channel used to tell us about kubernetes state.
 	KubernetesEvents chan k8sEvent
 	// Input channel used to tell us about consul endpoints.
 	ConsulEvents chan consulEvent
+	// Input channel used to reconfigure us, see reloader.
+	Reconfigure chan aggregatorConfig
+	// Input channel used to add and remove watches on top of those
+	// of the watch hook, see the admin API.
+	WatchChanges chan watchChange
 	// Output channel used to communicate with the k8s watch manager.
 	k8sWatches chan<- []KubernetesWatchSpec
 	// Output channel used to communicate with the consul watch manager.
 	consulWatches chan<- []ConsulWatchSpec
 	// Output channel used to communicate with the invoker.
-	snapshots chan<- string
+	snapshots chan<- []byte
 	// We won't consider ourselves "bootstrapped" until we hear
 	// about all these kinds.
 	requiredKinds       []string
@@ -38,25 +76,87 @@ type aggregator struct {
 	consulEndpoints     map[string]consulwatch.Endpoints
 	bootstrapped        bool
 	notifyMux           sync.Mutex
+	// The snapshot sections the watch hook and the receivers look
+	// at, changes to any other sections don't rerun the hook or
+	// notify the receivers.
+	hookInterests     interests
+	receiverInterests interests
+	// The sections that changed since the watch hook last ran and
+	// since the receivers were last notified.
+	hookChanges     map[string]bool
+	receiverChanges map[string]bool
+	changesMux      sync.Mutex
+	// The result of the last watch hook run, and whether the
+	// receivers were ever notified.
+	watchset WatchSet
+	hookRan  bool
+	notified bool
+	// Snapshots are encoded into buf, which is reused from one
+	// snapshot to the next. The encoded snapshot is copied out of it
+	// once, and that copy is shared by the watch hook, the receivers
+	// and the invoker, so it must not be modified.
+	buf      bytes.Buffer
+	snapshot []byte
+	// The limiter is checked back with on checkBack after the delay
+	// it asks for, according to clock.
+	checkBack chan struct{}
+	clock     clock.Clock
+	// Drops kubernetes resources and fields from the snapshots, nil
+	// keeps everything.
+	filter *resourceFilter
+	// When the first change since the receivers were last notified
+	// happened, for the snapshot generation latency in metrics.
+	changedSince time.Time
+	metrics      *metrics
+	// The required kinds that haven't been heard about yet, for
+	// /readyz.
+	unsynced    map[string]bool
+	unsyncedMux sync.Mutex
+	// The watches added with the admin API.
+	adminWatches WatchSet
 }
 
-func NewAggregator(snapshots chan<- string, k8sWatches chan<- []KubernetesWatchSpec, consulWatches chan<- []ConsulWatchSpec,
+func NewAggregator(snapshots chan<- []byte, k8sWatches chan<- []KubernetesWatchSpec, consulWatches chan<- []ConsulWatchSpec,
 	requiredKinds []string, watchHook WatchHook, limiter limiter.Limiter) *aggregator {
+	unsynced := make(map[string]bool)
+	for _, kind := range requiredKinds {
+		unsynced[kind] = true
+	}
 	return &aggregator{
 		KubernetesEvents:    make(chan k8sEvent),
 		ConsulEvents:        make(chan consulEvent),
+		Reconfigure:         make(chan aggregatorConfig),
+		WatchChanges:        make(chan watchChange),
 		k8sWatches:          k8sWatches,
 		consulWatches:       consulWatches,
 		snapshots:           snapshots,
 		requiredKinds:       requiredKinds,
 		watchHook:           watchHook,
 		limiter:             limiter,
+		checkBack:           make(chan struct{}, 1),
+		clock:               clock.Real,
 		ids:                 make(map[string]bool),
 		kubernetesResources: make(map[string]map[string][]k8s.Resource),
 		consulEndpoints:     make(map[string]consulwatch.Endpoints),
+		hookChanges:         make(map[string]bool),
+		receiverChanges:     make(map[string]bool),
+		unsynced:            unsynced,
 	}
 }
 
+// unsyncedSources returns the required kinds that haven't been heard
+// about yet.
+func (a *aggregator) unsyncedSources() []string {
+	a.unsyncedMux.Lock()
+	defer a.unsyncedMux.Unlock()
+	result := make([]string, 0, len(a.unsynced))
+	for kind := range a.unsynced {
+		result = append(result, kind)
+	}
+	sort.Strings(result)
+	return result
+}
+
 func (a *aggregator) Work(p *supervisor.Process) error {
 	p.Ready()
 
@@ -68,28 +168,190 @@ func (a *aggregator) Work(p *supervisor.Process) error {
 		case event := <-a.ConsulEvents:
 			a.updateConsulResources(event)
 			a.maybeNotify(p)
+		case c := <-a.Reconfigure:
+			a.reconfigure(c)
+			a.maybeNotify(p)
+		case c := <-a.WatchChanges:
+			c.result <- a.changeWatches(c)
+			a.maybeNotify(p)
+		case <-a.checkBack:
+			a.maybeNotify(p)
 		case <-p.Shutdown():
 			return nil
 		}
 	}
 }
 
+// aggregatorConfig is what can be changed about a running aggregator.
+type aggregatorConfig struct {
+	requiredKinds     []string
+	watchHook         WatchHook
+	limiter           limiter.Limiter
+	hookInterests     interests
+	receiverInterests interests
+}
+
+// reconfigure forgets the resources of the required kinds that are no
+// longer required, and reruns the watch hook on the next notify, which
+// starts and stops watches according to what it returns.
+func (a *aggregator) reconfigure(c aggregatorConfig) {
+	required := make(map[string]bool)
+	for _, kind := range c.requiredKinds {
+		required[kind] = true
+	}
+	for _, kind := range a.requiredKinds {
+		if !required[kind] {
+			delete(a.kubernetesResources[""], kind)
+			a.markChanged(strings.ToLower(kind))
+		}
+	}
+	a.unsyncedMux.Lock()
+	a.unsynced = make(map[string]bool)
+	for _, kind := range c.requiredKinds {
+		if _, ok := a.kubernetesResources[""][kind]; !ok {
+			a.unsynced[kind] = true
+		}
+	}
+	a.unsyncedMux.Unlock()
+
+	a.requiredKinds = c.requiredKinds
+	a.watchHook = c.watchHook
+	a.limiter = c.limiter
+	a.hookInterests = c.hookInterests
+	a.receiverInterests = c.receiverInterests
+	a.hookRan = false
+}
+
+// watchChange adds and removes watches on top of those of the watch
+// hook. The watches added with the admin API after the change are sent
+// back on result.
+type watchChange struct {
+	add    WatchSet
+	remove WatchSet
+	result chan WatchSet
+}
+
+// changeWatches changes the admin watches. The resources of the watches
+// that are gone are forgotten, the watch managers stop the watches
+// themselves on the next notify.
+func (a *aggregator) changeWatches(c watchChange) WatchSet {
+	removeK8s := make(map[string]bool)
+	for _, w := range c.remove.KubernetesWatches {
+		removeK8s[w.WatchId()] = true
+	}
+	removeConsul := make(map[string]bool)
+	for _, w := range c.remove.ConsulWatches {
+		removeConsul[w.WatchId()] = true
+	}
+
+	var result WatchSet
+	seen := make(map[string]bool)
+	for _, w := range append(a.adminWatches.KubernetesWatches, c.add.KubernetesWatches...) {
+		id := w.WatchId()
+		if !seen[id] && !removeK8s[id] {
+			result.KubernetesWatches = append(result.KubernetesWatches, w)
+		}
+		seen[id] = true
+	}
+	for _, w := range append(a.adminWatches.ConsulWatches, c.add.ConsulWatches...) {
+		id := w.WatchId()
+		if !seen[id] && !removeConsul[id] {
+			result.ConsulWatches = append(result.ConsulWatches, w)
+		}
+		seen[id] = true
+	}
+	a.adminWatches = result
+
+	// the watch hook may still watch what was removed
+	watched := make(map[string]bool)
+	for _, w := range a.withAdminWatches(a.watchset).KubernetesWatches {
+		watched[w.WatchId()] = true
+	}
+	for _, w := range a.withAdminWatches(a.watchset).ConsulWatches {
+		watched[w.WatchId()] = true
+	}
+	for _, w := range c.remove.KubernetesWatches {
+		id := w.WatchId()
+		if _, ok := a.kubernetesResources[id]; ok && !watched[id] {
+			delete(a.kubernetesResources, id)
+			delete(a.ids, id)
+			a.markChanged(strings.ToLower(w.Kind))
+		}
+	}
+	for _, w := range c.remove.ConsulWatches {
+		id := w.WatchId()
+		if _, ok := a.consulEndpoints[w.ServiceName]; ok && !watched[id] {
+			delete(a.consulEndpoints, w.ServiceName)
+			delete(a.ids, id)
+			a.markChanged(CONSUL_SECTION)
+		}
+	}
+	return result
+}
+
+// withAdminWatches returns the watches together with those added with
+// the admin API.
+func (a *aggregator) withAdminWatches(watchset WatchSet) WatchSet {
+	admin := a.adminWatches.interpolate()
+	return WatchSet{
+		KubernetesWatches: append(append([]KubernetesWatchSpec(nil), watchset.KubernetesWatches...),
+			admin.KubernetesWatches...),
+		ConsulWatches: append(append([]ConsulWatchSpec(nil), watchset.ConsulWatches...), admin.ConsulWatches...),
+	}
+}
+
 func (a *aggregator) updateConsulResources(event consulEvent) {
+	a.metrics.eventReceived(CONSUL_SECTION)
+	a.metrics.setConsulEndpoints(event.Endpoints.Service, len(event.Endpoints.Endpoints))
 	a.ids[event.WatchId] = true
 	a.consulEndpoints[event.Endpoints.Service] = event.Endpoints
+	a.markChanged(CONSUL_SECTION)
 }
 
 func (a *aggregator) setKubernetesResources(event k8sEvent) {
+	a.metrics.eventReceived(strings.ToLower(event.kind))
 	a.ids[event.watchId] = true
 	submap, ok := a.kubernetesResources[event.watchId]
 	if !ok {
 		submap = make(map[string][]k8s.Resource)
 		a.kubernetesResources[event.watchId] = submap
 	}
-	submap[event.kind] = event.resources
+	resources := event.resources
+	if a.filter != nil {
+		resources = a.filter.apply(event.kind, resources)
+	}
+	submap[event.kind] = resources
+	a.markChanged(strings.ToLower(event.kind))
+	if event.watchId == "" {
+		a.unsyncedMux.Lock()
+		delete(a.unsynced, event.kind)
+		a.unsyncedMux.Unlock()
+	}
+}
+
+func (a *aggregator) markChanged(section string) {
+	a.changesMux.Lock()
+	defer a.changesMux.Unlock()
+	a.hookChanges[section] = true
+	a.receiverChanges[section] = true
+	if a.changedSince.IsZero() {
+		a.changedSince = a.clock.Now()
+	}
+}
+
+// takeChanges returns the sections in changes and forgets about them.
+func (a *aggregator) takeChanges(changes map[string]bool) map[string]bool {
+	a.changesMux.Lock()
+	defer a.changesMux.Unlock()
+	result := make(map[string]bool)
+	for s := range changes {
+		result[s] = true
+		delete(changes, s)
+	}
+	return result
 }
 
-func (a *aggregator) generateSnapshot() (string, error) {
+func (a *aggregator) generateSnapshot() ([]byte, error) {
 	k8sResources := make(map[string][]k8s.Resource)
 	for _, submap := range a.kubernetesResources {
 		for k, v := range submap {
@@ -101,12 +363,29 @@ func (a *aggregator) generateSnapshot() (string, error) {
 		Kubernetes: k8sResources,
 	}
 
-	jsonBytes, err := json.MarshalIndent(s, "", "    ")
+	a.buf.Reset()
+	encoder := json.NewEncoder(&a.buf)
+	encoder.SetIndent("", "    ")
+	err := encoder.Encode(s)
 	if err != nil {
-		return "{}", err
+		return nil, err
 	}
 
-	return string(jsonBytes), nil
+	// the encoder terminates every value with a newline
+	return append([]byte(nil), bytes.TrimSuffix(a.buf.Bytes(), []byte("\n"))...), nil
+}
+
+// currentSnapshot returns the snapshot of the current notify,
+// generating it the first time it is asked for.
+func (a *aggregator) currentSnapshot() ([]byte, error) {
+	if a.snapshot == nil {
+		snapshot, err := a.generateSnapshot()
+		if err != nil {
+			return nil, err
+		}
+		a.snapshot = snapshot
+	}
+	return a.snapshot, nil
 }
 
 func (a *aggregator) isKubernetesBootstrapped(p *supervisor.Process) bool {
@@ -154,13 +433,17 @@ func (a *aggregator) isComplete(p *supervisor.Process, watchset WatchSet) bool {
 }
 
 func (a *aggregator) maybeNotify(p *supervisor.Process) {
-	now := time.Now()
+	now := a.clock.Now()
 	delay := a.limiter.Limit(now)
 	if delay == 0 {
 		a.notify(p)
 	} else if delay > 0 {
-		time.AfterFunc(delay, func() {
-			a.notify(p)
+		a.clock.AfterFunc(delay, func() {
+			select {
+			case a.checkBack <- struct{}{}:
+			default:
+				// already due to check back
+			}
 		})
 	}
 }
@@ -169,7 +452,8 @@ func (a *aggregator) notify(p *supervisor.Process) {
 	a.notifyMux.Lock()
 	defer a.notifyMux.Unlock()
 
-	watchset := a.getWatches(p)
+	a.snapshot = nil
+	watchset := a.withAdminWatches(a.getWatches(p))
 
 	p.Logf("found %d kubernetes watches", len(watchset.KubernetesWatches))
 	p.Logf("found %d consul watches", len(watchset.ConsulWatches))
@@ -186,32 +470,56 @@ func (a *aggregator) notify(p *supervisor.Process) {
 	}
 
 	if a.bootstrapped {
-		snapshot, err := a.generateSnapshot()
+		changes := a.takeChanges(a.receiverChanges)
+		a.changesMux.Lock()
+		changedSince := a.changedSince
+		a.changedSince = time.Time{}
+		a.changesMux.Unlock()
+		if a.notified && !a.receiverInterests.covers(changes) {
+			p.Logf("skipping notification, no interesting changes")
+			return
+		}
+
+		snapshot, err := a.currentSnapshot()
 		if err != nil {
 			p.Logf("generate snapshot failed %v", err)
 			return
 		}
 
+		if !changedSince.IsZero() {
+			a.metrics.snapshotGenerated(a.clock.Now().Sub(changedSince), len(snapshot))
+		}
 		a.snapshots <- snapshot
+		a.notified = true
 	}
 }
 
 func (a *aggregator) getWatches(p *supervisor.Process) WatchSet {
-	snapshot, err := a.generateSnapshot()
+	changes := a.takeChanges(a.hookChanges)
+	if a.hookRan && !a.hookInterests.covers(changes) {
+		p.Logf("skipping watch hook, no interesting changes")
+		return a.watchset
+	}
+
+	snapshot, err := a.currentSnapshot()
 	if err != nil {
 		p.Logf("generate snapshot failed %v", err)
 		return WatchSet{}
 	}
 	result := a.watchHook(p, snapshot)
-	return result.interpolate()
+	a.watchset = result.interpolate()
+	a.hookRan = true
+	return a.watchset
 }
 
-func ExecWatchHook(watchHooks []string) WatchHook {
-	return func(p *supervisor.Process, snapshot string) WatchSet {
+// ExecWatchHook runs the watch hooks as commands, recording how long
+// they took and how they exited in m, if not nil.
+func ExecWatchHook(watchHooks []string, m *metrics) WatchHook {
+	return func(p *supervisor.Process, snapshot []byte) WatchSet {
 		result := WatchSet{}
 
 		for _, hook := range watchHooks {
-			ws := invokeHook(p, hook, snapshot)
+			ws := invokeHook(p, hook, snapshot, m)
 			result.KubernetesWatches = append(result.KubernetesWatches, ws.KubernetesWatches...)
 			result.ConsulWatches = append(result.ConsulWatches, ws.ConsulWatches...)
 		}
@@ -224,13 +532,15 @@ func lines(st string) []string {
 	return strings.Split(st, "\n")
 }
 
-func invokeHook(p *supervisor.Process, hook, snapshot string) WatchSet {
+func invokeHook(p *supervisor.Process, hook string, snapshot []byte, m *metrics) WatchSet {
 	cmd := exec.Command(hook)
-	cmd.Stdin = strings.NewReader(snapshot)
+	cmd.Stdin = bytes.NewReader(snapshot)
 	var watches, errors strings.Builder
 	cmd.Stdout = &watches
 	cmd.Stderr = &errors
+	start := time.Now()
 	err := cmd.Run()
+	m.hookRan(time.Since(start), err)
 	stderr := errors.String()
 	if stderr != "" {
 		for _, line := range lines(stderr) {
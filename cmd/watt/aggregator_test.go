@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/datawire/teleproxy/pkg/clock"
 	"github.com/datawire/teleproxy/pkg/consulwatch"
 
 	"github.com/datawire/teleproxy/pkg/watt"
@@ -17,7 +19,7 @@ import (
 )
 
 type aggIsolator struct {
-	snapshots     chan string
+	snapshots     chan []byte
 	k8sWatches    chan []KubernetesWatchSpec
 	consulWatches chan []ConsulWatchSpec
 	aggregator    *aggregator
@@ -37,7 +39,7 @@ func newAggIsolator(t *testing.T, requiredKinds []string, watchHook WatchHook) *
 		// the test
 		k8sWatches:    make(chan []KubernetesWatchSpec, 100),
 		consulWatches: make(chan []ConsulWatchSpec, 100),
-		snapshots:     make(chan string, 100),
+		snapshots:     make(chan []byte, 100),
 		// for signaling when the isolator is done
 		done: make(chan struct{}),
 	}
@@ -134,8 +136,8 @@ var WATCH = ConsulWatchSpec{
 //   b) received (possibly empty) endpoint info about all referenced
 //      consul services...
 func TestAggregatorBootstrap(t *testing.T) {
-	watchHook := func(p *supervisor.Process, snapshot string) WatchSet {
-		if strings.Contains(snapshot, "configmap") {
+	watchHook := func(p *supervisor.Process, snapshot []byte) WatchSet {
+		if bytes.Contains(snapshot, []byte("configmap")) {
 			return WatchSet{
 				ConsulWatches: []ConsulWatchSpec{WATCH},
 			}
@@ -201,3 +203,87 @@ func TestAggregatorBootstrap(t *testing.T) {
 		return ok
 	})
 }
+
+// Check that changes to sections nobody is interested in neither
+// rerun the watch hook nor notify the receivers.
+func TestAggregatorInterests(t *testing.T) {
+	hooks := make(chan []byte, 100)
+	watchHook := func(p *supervisor.Process, snapshot []byte) WatchSet {
+		hooks <- snapshot
+		return WatchSet{}
+	}
+	iso := newAggIsolator(t, []string{"service", "configmap"}, watchHook)
+	iso.aggregator.hookInterests = newInterests([]string{"Service"})
+	iso.aggregator.receiverInterests = newInterests([]string{"configmap"})
+	iso.Start()
+	defer iso.Stop()
+
+	// the hook always runs the first time around
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "service", SERVICES}
+	expect(t, hooks, func(string) bool { return true })
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "configmap", RESOLVER}
+	expect(t, hooks, Timeout(100*time.Millisecond))
+	// and so does the first notification
+	expect(t, iso.snapshots, func(string) bool { return true })
+
+	// services are only interesting to the hook
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "service", SERVICES}
+	expect(t, hooks, func(string) bool { return true })
+	expect(t, iso.snapshots, Timeout(100*time.Millisecond))
+
+	// configmaps are only interesting to the receivers
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "configmap", RESOLVER}
+	expect(t, hooks, Timeout(100*time.Millisecond))
+	expect(t, iso.snapshots, func(string) bool { return true })
+}
+
+// Check that the limiter is checked back with, so that a debounced
+// burst of changes ends up in a single snapshot.
+func TestAggregatorDebounce(t *testing.T) {
+	watchHook := func(p *supervisor.Process, snapshot []byte) WatchSet { return WatchSet{} }
+	iso := newAggIsolator(t, []string{"service", "configmap"}, watchHook)
+	iso.aggregator.limiter = limiter.NewDebounce(100*time.Millisecond, time.Second)
+	fake := clock.NewFake(time.Now())
+	iso.aggregator.clock = fake
+	iso.Start()
+	defer iso.Stop()
+
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "service", SERVICES}
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "configmap", RESOLVER}
+	expect(t, iso.snapshots, Timeout(100*time.Millisecond))
+	fake.Advance(100 * time.Millisecond)
+	expect(t, iso.snapshots, func(snapshot string) bool {
+		return strings.Contains(snapshot, "configmap") && strings.Contains(snapshot, "service")
+	})
+	expect(t, iso.snapshots, Timeout(300*time.Millisecond))
+}
+
+func TestAggregatorMetrics(t *testing.T) {
+	watchHook := func(p *supervisor.Process, snapshot []byte) WatchSet { return WatchSet{} }
+	iso := newAggIsolator(t, []string{"service", "configmap"}, watchHook)
+	iso.aggregator.limiter = limiter.NewDebounce(100*time.Millisecond, time.Second)
+	fake := clock.NewFake(time.Now())
+	iso.aggregator.clock = fake
+	iso.aggregator.metrics = newMetrics()
+	iso.Start()
+	defer iso.Stop()
+
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "service", SERVICES}
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "configmap", RESOLVER}
+	expect(t, iso.snapshots, Timeout(100*time.Millisecond))
+	fake.Advance(100 * time.Millisecond)
+	expect(t, iso.snapshots, func(snapshot string) bool { return true })
+
+	text := gather(t, iso.aggregator.metrics)
+	for _, line := range []string{
+		"watt_snapshot_generation_latency_seconds_bucket{le=\"0.1\"} 1\n",
+		"watt_snapshot_generation_latency_seconds_bucket{le=\"0.05\"} 0\n",
+		"watt_snapshots_total 1\n",
+		"watt_events_received_total{source=\"configmap\"} 1\n",
+		"watt_events_received_total{source=\"service\"} 1\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("expected %q in:\n%s", line, text)
+		}
+	}
+}
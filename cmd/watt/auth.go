@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// newAPITLSConfig returns the TLS configuration of the API server, see
+// --tls-cert and --tls-key. With a client CA, see --tls-client-ca,
+// clients have to present a certificate signed by it, unless
+// optionalClientCert is set, in which case a client without a
+// certificate has to authenticate with the bearer token instead.
+func newAPITLSConfig(certFile, keyFile, clientCAFile string, optionalClientCert bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if optionalClientCert {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return config, nil
+}
+
+// readToken reads the bearer token from a file, see --api-token-file,
+// so that it doesn't show up in the command line of watt.
+func readToken(path string) (string, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(bytes))
+	if token == "" {
+		return "", fmt.Errorf("%s: empty token", path)
+	}
+	return token, nil
+}
+
+// apiAuth requires the clients of the API server to authenticate,
+// either with the bearer token, or with a client certificate that was
+// verified during the TLS handshake.
+type apiAuth struct {
+	token      string
+	clientCert bool
+}
+
+func (a *apiAuth) authenticated(r *http.Request) bool {
+	return a.check(r.TLS, r.Header.Get("Authorization"))
+}
+
+// check authenticates a client given the state of its TLS connection,
+// if any, and its Authorization header.
+func (a *apiAuth) check(state *tls.ConnectionState, authorization string) bool {
+	if a.clientCert && state != nil && len(state.VerifiedChains) > 0 {
+		return true
+	}
+	if a.token != "" && strings.HasPrefix(authorization, "Bearer ") {
+		given := strings.TrimPrefix(authorization, "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(given), []byte(a.token)) == 1
+	}
+	return false
+}
+
+// authenticatedStream is authenticated for the clients of the gRPC
+// server, which pass the bearer token as authorization metadata.
+func (a *apiAuth) authenticatedStream(ctx context.Context) bool {
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	return a.check(state, authorization)
+}
+
+// streamInterceptor requires authentication for every stream the gRPC
+// server serves.
+func (a *apiAuth) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	if !a.authenticatedStream(ss.Context()) {
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return handler(srv, ss)
+}
+
+// wrap requires authentication for everything the handler serves,
+// except for the public paths.
+func (a *apiAuth) wrap(handler http.Handler, public ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range public {
+			if r.URL.Path == path {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		if !a.authenticated(r) {
+			if a.token != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="watt"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
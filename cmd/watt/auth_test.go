@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// newCert returns a certificate and its key signed by the parent, or
+// self-signed without one.
+func newCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writePEM(t *testing.T, path, kind string, bytes []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: bytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecureAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watt-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newCert(t, "ca", nil)
+	server := newCert(t, "localhost", &ca)
+	client := newCert(t, "client", &ca)
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Certificate[0])
+	writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", server.Certificate[0])
+	key, err := x509.MarshalECPrivateKey(server.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "key.pem"), "EC PRIVATE KEY", key)
+
+	config, err := newAPITLSConfig(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"),
+		filepath.Join(dir, "ca.pem"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(dir, "api.sock")
+	invoker := NewInvoker(0, nil)
+	invoker.storeSnapshot([]byte("{}"))
+	s := &apiServer{
+		invoker: invoker,
+		socket:  socket,
+		tls:     config,
+		auth:    &apiAuth{token: "secret", clientCert: true},
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	get := func(cert *tls.Certificate, token string) int {
+		tlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+		if cert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*cert}
+		}
+		c := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+			TLSClientConfig: tlsConfig,
+		}}
+		req, err := http.NewRequest("GET", "https://localhost/snapshots/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	sup := supervisor.WithContext(context.Background())
+	sup.Supervise(&supervisor.Worker{Name: "api", Work: s.Work})
+	sup.Supervise(&supervisor.Worker{
+		Name:     "client",
+		Requires: []string{"api"},
+		Work: func(p *supervisor.Process) error {
+			for _, test := range []struct {
+				cert     *tls.Certificate
+				token    string
+				expected int
+			}{
+				{nil, "", http.StatusUnauthorized},
+				{nil, "wrong", http.StatusUnauthorized},
+				{nil, "secret", http.StatusOK},
+				{&client, "", http.StatusOK},
+			} {
+				if status := get(test.cert, test.token); status != test.expected {
+					t.Errorf("cert %v token %q: expected %d, got %d", test.cert != nil, test.token, test.expected, status)
+				}
+			}
+			p.Supervisor().Shutdown()
+			return nil
+		},
+	})
+	if errs := sup.Run(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/datawire/teleproxy/pkg/limiter"
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// config is the part of watt's configuration that can be given in a
+// yaml file with --config as well as with flags, and that is reloaded
+// on SIGHUP or when the file changes. What the file sets overrides the
+// flags. Durations are given like "250ms", e.g.
+//
+//	sources: [service, configmap]
+//	namespace: default,ambassador
+//	excludeNamespaces: [kube-system]
+//	labels: app=ambassador
+//	watch: [/bin/watch-hook]
+//	notify: [/bin/receiver]
+//	interval: 1s
+type config struct {
+	// Namespace is a comma-separated list of namespaces, all of
+	// them if empty.
+	Namespace         string   `yaml:"namespace"`
+	ExcludeNamespaces []string `yaml:"excludeNamespaces"`
+	Sources           []string `yaml:"sources"`
+	Fields            string   `yaml:"fields"`
+	Labels            string   `yaml:"labels"`
+	Watch             []string `yaml:"watch"`
+	Notify            []string `yaml:"notify"`
+	WatchInterests    []string `yaml:"watchInterests"`
+	NotifyInterests   []string `yaml:"notifyInterests"`
+	Interval          string   `yaml:"interval"`
+	Burst             int      `yaml:"burst"`
+	MaxInterval       string   `yaml:"maxInterval"`
+	Debounce          string   `yaml:"debounce"`
+	DebounceMax       string   `yaml:"debounceMax"`
+}
+
+// flagConfig returns the config given with flags.
+func flagConfig() config {
+	return config{
+		Namespace:         kubernetesNamespace,
+		ExcludeNamespaces: excludedNamespaces,
+		Sources:           initialSources,
+		Fields:            initialFieldSelector,
+		Labels:            initialLabelSelector,
+		Watch:             watchHooks,
+		Notify:            notifyReceivers,
+		WatchInterests:    watchInterests,
+		NotifyInterests:   notifyInterests,
+		Interval:          interval.String(),
+		Burst:             burst,
+		MaxInterval:       maxInterval.String(),
+		Debounce:          debounce.String(),
+		DebounceMax:       debounceMax.String(),
+	}
+}
+
+// loadConfig reads the config file on top of base.
+func loadConfig(path string, base config) (config, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return base, err
+	}
+	// don't let the decoder reuse the slices of base
+	result := base
+	for _, s := range []*[]string{&result.ExcludeNamespaces, &result.Sources, &result.Watch, &result.Notify, &result.WatchInterests, &result.NotifyInterests} {
+		*s = append([]string(nil), *s...)
+	}
+	if err := yaml.Unmarshal(bytes, &result); err != nil {
+		return base, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, err := result.limits(); err != nil {
+		return base, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(result.Sources) == 0 {
+		return base, fmt.Errorf("%s: no initial sources configured", path)
+	}
+	if _, err := result.namespaces(); err != nil {
+		return base, fmt.Errorf("%s: %v", path, err)
+	}
+	return result, nil
+}
+
+// namespaces returns the namespaces to watch without the excluded
+// ones, or just "" for all namespaces.
+func (c config) namespaces() ([]string, error) {
+	excluded := make(map[string]bool)
+	for _, ns := range c.ExcludeNamespaces {
+		excluded[ns] = true
+	}
+	listed := false
+	var result []string
+	for _, ns := range strings.Split(c.Namespace, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" {
+			continue
+		}
+		listed = true
+		if !excluded[ns] {
+			excluded[ns] = true
+			result = append(result, ns)
+		}
+	}
+	if !listed {
+		return []string{""}, nil
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("all of the namespaces %q are excluded", c.Namespace)
+	}
+	return result, nil
+}
+
+// sameSources returns true if both configs watch the same initial
+// sources in the same way.
+func (c config) sameSources(other config) bool {
+	return c.sameNamespaces(other) && c.Fields == other.Fields && c.Labels == other.Labels &&
+		reflect.DeepEqual(c.Sources, other.Sources)
+}
+
+func (c config) sameNamespaces(other config) bool {
+	return c.Namespace == other.Namespace && reflect.DeepEqual(c.ExcludeNamespaces, other.ExcludeNamespaces)
+}
+
+type limits struct {
+	interval    time.Duration
+	burst       int
+	maxInterval time.Duration
+	debounce    time.Duration
+	debounceMax time.Duration
+}
+
+func (c config) limits() (limits, error) {
+	result := limits{burst: c.Burst}
+	for _, d := range []struct {
+		name  string
+		value string
+		out   *time.Duration
+	}{
+		{"interval", c.Interval, &result.interval},
+		{"maxInterval", c.MaxInterval, &result.maxInterval},
+		{"debounce", c.Debounce, &result.debounce},
+		{"debounceMax", c.DebounceMax, &result.debounceMax},
+	} {
+		if d.value == "" {
+			continue
+		}
+		value, err := time.ParseDuration(d.value)
+		if err != nil {
+			return result, fmt.Errorf("%s: %v", d.name, err)
+		}
+		*d.out = value
+	}
+	modes := 0
+	for _, set := range []bool{result.burst > 0, result.maxInterval > 0, result.debounce > 0} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return result, fmt.Errorf("only one of --burst, --max-interval and --debounce can be used")
+	}
+	return result, nil
+}
+
+// newLimiter returns the limiter of the receivers, and what to tell
+// how long the receivers took, if anything.
+func newLimiter(l limits) (limiter.Limiter, func(time.Duration)) {
+	var observe func(time.Duration)
+	steady := limiter.NewInterval(l.interval)
+	if l.burst > 0 {
+		steady = limiter.NewTokenBucket(l.interval, l.burst)
+	}
+	if l.maxInterval > 0 {
+		adaptive := limiter.NewAdaptive(l.interval, l.maxInterval)
+		observe = adaptive.Observe
+		steady = adaptive
+	}
+	if l.debounce > 0 {
+		steady = limiter.NewDebounce(l.debounce, l.debounceMax)
+	}
+	return limiter.NewComposite(limiter.NewUnlimited(), steady, l.interval), observe
+}
+
+// reloader reloads the config file, if any, on SIGHUP or when it
+// changes, and applies the changes to the running watt: the initial
+// sources are watched anew if they changed, the watches of the watch
+// hooks are restarted if the namespaces changed, the aggregator reruns
+// the watch hooks, which starts and stops their watches as needed, and
+// the
+// receivers and limits change from the next snapshot on. The snapshot
+// server keeps serving throughout. A config that doesn't load is
+// logged and otherwise ignored. The initial sources added with the
+// admin API, see SourceChanges, are kept across reloads.
+type reloader struct {
+	path    string
+	base    config
+	current config
+	poll    time.Duration
+	// SourceChanges adds and removes initial sources.
+	SourceChanges chan sourceChange
+	added         []string
+	// bootstrap is the name of the worker that watches the initial
+	// sources, newBootstrap returns it for a config
+	bootstrap    string
+	newBootstrap func(config) *supervisor.Worker
+	// watchMaker makes the watches of the watch hooks, they are
+	// restarted with restartWatches
+	watchMaker     *KubernetesWatchMaker
+	restartWatches chan<- struct{}
+	aggregator     *aggregator
+	invoker        *invoker
+	metrics        *metrics
+}
+
+// sourceChange adds or removes an initial source. The initial sources
+// after the change are sent back on result.
+type sourceChange struct {
+	add    string
+	remove string
+	result chan []string
+}
+
+func (r *reloader) Work(p *supervisor.Process) error {
+	// without a config file there's nothing to reload
+	var hup chan os.Signal
+	var tick <-chan time.Time
+	if r.path != "" {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(r.poll)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modTime := r.modTime()
+
+	p.Ready()
+	for {
+		select {
+		case c := <-r.SourceChanges:
+			c.result <- r.changeSources(p, c)
+		case <-hup:
+			p.Logf("reloading %s on SIGHUP", r.path)
+			modTime = r.modTime()
+			r.reload(p)
+		case <-tick:
+			if t := r.modTime(); !t.Equal(modTime) {
+				p.Logf("reloading %s, it changed", r.path)
+				modTime = t
+				r.reload(p)
+			}
+		case <-p.Shutdown():
+			return nil
+		}
+	}
+}
+
+func (r *reloader) modTime() time.Time {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (r *reloader) reload(p *supervisor.Process) {
+	next, err := loadConfig(r.path, r.base)
+	if err != nil {
+		p.Logf("reload failed, keeping the current config: %v", err)
+		return
+	}
+	for _, kind := range r.added {
+		next.Sources = addSource(next.Sources, kind)
+	}
+	r.apply(p, next)
+}
+
+// changeSources adds or removes an initial source. Only the sources
+// that were added this way can be removed.
+func (r *reloader) changeSources(p *supervisor.Process, c sourceChange) []string {
+	next := r.current
+	next.Sources = append([]string(nil), r.current.Sources...)
+	if c.add != "" {
+		r.added = addSource(r.added, c.add)
+		next.Sources = addSource(next.Sources, c.add)
+	}
+	if c.remove != "" {
+		for i, kind := range r.added {
+			if kind == c.remove {
+				r.added = append(r.added[:i:i], r.added[i+1:]...)
+				next.Sources = removeSource(next.Sources, kind)
+				break
+			}
+		}
+	}
+	if !r.current.sameSources(next) {
+		r.apply(p, next)
+	}
+	return r.current.Sources
+}
+
+func addSource(sources []string, kind string) []string {
+	for _, s := range sources {
+		if s == kind {
+			return sources
+		}
+	}
+	return append(append([]string(nil), sources...), kind)
+}
+
+func removeSource(sources []string, kind string) []string {
+	var result []string
+	for _, s := range sources {
+		if s != kind {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (r *reloader) apply(p *supervisor.Process, next config) {
+	sourcesChanged := !r.current.sameSources(next)
+	if sourcesChanged {
+		p.Logf("watching the initial sources %v anew", next.Sources)
+		p.Supervisor().Remove(r.bootstrap)
+	}
+
+	if r.watchMaker != nil && !r.current.sameNamespaces(next) {
+		// loadConfig checked the namespaces
+		namespaces, _ := next.namespaces()
+		p.Logf("restarting the watches in namespaces %q", fmtNamespaces(namespaces))
+		r.watchMaker.reconfigure(namespaces, next.ExcludeNamespaces)
+		select {
+		case r.restartWatches <- struct{}{}:
+		case <-p.Shutdown():
+			return
+		}
+	}
+
+	// loadConfig checked the limits
+	limits, _ := next.limits()
+	limiter, observe := newLimiter(limits)
+	r.invoker.reconfigure(next.Notify, observe)
+	select {
+	case r.aggregator.Reconfigure <- aggregatorConfig{
+		requiredKinds:     next.Sources,
+		watchHook:         ExecWatchHook(next.Watch, r.metrics),
+		limiter:           limiter,
+		hookInterests:     newInterests(next.WatchInterests),
+		receiverInterests: newInterests(next.NotifyInterests),
+	}:
+	case <-p.Shutdown():
+		return
+	}
+
+	if sourcesChanged {
+		p.Supervisor().Supervise(r.newBootstrap(next))
+	}
+	r.current = next
+	p.Logf("reloaded %s", r.path)
+}
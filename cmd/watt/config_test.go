@@ -0,0 +1,164 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+func writeConfig(t *testing.T, path, content string, modTime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watt-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watt.yaml")
+
+	base := config{Sources: []string{"service"}, Notify: []string{"receiver"}, Interval: "250ms"}
+	writeConfig(t, path, "sources:\n- service\n- configmap\nlabels: app=foo\ninterval: 1s\n", time.Now())
+	cfg, err := loadConfig(path, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Sources, []string{"service", "configmap"}) || cfg.Labels != "app=foo" ||
+		!reflect.DeepEqual(cfg.Notify, []string{"receiver"}) {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if limits, _ := cfg.limits(); limits.interval != time.Second {
+		t.Errorf("unexpected limits: %+v", limits)
+	}
+	if !reflect.DeepEqual(base.Sources, []string{"service"}) {
+		t.Errorf("the base config was modified: %+v", base)
+	}
+	if cfg.sameSources(base) {
+		t.Errorf("the sources should differ")
+	}
+
+	for _, content := range []string{
+		"interval: soon\n",
+		"burst: 2\ndebounce: 1s\n",
+		"sources: []\n",
+		"namespace: kube-system\nexcludeNamespaces:\n- kube-system\n",
+	} {
+		writeConfig(t, path, content, time.Now())
+		if _, err := loadConfig(path, base); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+	}
+}
+
+func TestConfigNamespaces(t *testing.T) {
+	for _, c := range []struct {
+		namespace string
+		exclude   []string
+		expected  []string
+	}{
+		{"", nil, []string{""}},
+		{"", []string{"kube-system"}, []string{""}},
+		{"default", nil, []string{"default"}},
+		{"default, ambassador,default,,kube-system", []string{"kube-system"}, []string{"default", "ambassador"}},
+	} {
+		namespaces, err := config{Namespace: c.namespace, ExcludeNamespaces: c.exclude}.namespaces()
+		if err != nil || !reflect.DeepEqual(namespaces, c.expected) {
+			t.Errorf("%q without %v: expected %v, got %v, %v", c.namespace, c.exclude, c.expected, namespaces, err)
+		}
+	}
+	if _, err := (config{Namespace: "kube-system", ExcludeNamespaces: []string{"kube-system"}}).namespaces(); err == nil {
+		t.Errorf("expected an error when every namespace is excluded")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watt-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "watt.yaml")
+	writeConfig(t, path, "sources:\n- service\n- configmap\nnotify:\n- first\n", time.Now().Add(-time.Hour))
+	cfg, err := loadConfig(path, config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watchHook := func(p *supervisor.Process, snapshot []byte) WatchSet { return WatchSet{} }
+	iso := newAggIsolator(t, cfg.Sources, watchHook)
+	invoker := NewInvoker(0, cfg.Notify)
+	bootstraps := make(chan config, 10)
+	newBootstrap := func(c config) *supervisor.Worker {
+		return &supervisor.Worker{
+			Name:     "kubebootstrap",
+			Requires: []string{"aggregator"},
+			Work: func(p *supervisor.Process) error {
+				bootstraps <- c
+				p.Ready()
+				<-p.Shutdown()
+				return nil
+			},
+		}
+	}
+	r := &reloader{
+		path:         path,
+		current:      cfg,
+		poll:         10 * time.Millisecond,
+		bootstrap:    "kubebootstrap",
+		newBootstrap: newBootstrap,
+		aggregator:   iso.aggregator,
+		invoker:      invoker,
+	}
+	iso.sup.Supervise(newBootstrap(cfg))
+	iso.sup.Supervise(&supervisor.Worker{Name: "reloader", Work: r.Work, Requires: []string{"aggregator"}})
+	iso.Start()
+	defer iso.Stop()
+
+	started := func() config {
+		select {
+		case c := <-bootstraps:
+			return c
+		case <-time.After(10 * time.Second):
+			t.Fatal("kubebootstrap was not started")
+		}
+		return config{}
+	}
+	started()
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "service", SERVICES}
+	iso.aggregator.KubernetesEvents <- k8sEvent{"", "configmap", RESOLVER}
+	expect(t, iso.snapshots, func(snapshot string) bool {
+		return strings.Contains(snapshot, `"configmap"`) && strings.Contains(snapshot, `"service"`)
+	})
+
+	// a config that doesn't load changes nothing
+	writeConfig(t, path, "sources:\n- service\nburst: 2\ndebounce: 1s\n", time.Now().Add(-time.Minute))
+	expect(t, bootstraps, Timeout(100*time.Millisecond))
+
+	// the initial sources are watched anew, and the resources of the
+	// ones that were dropped are gone from the snapshots
+	writeConfig(t, path, "sources:\n- service\nnotify:\n- second\n", time.Now())
+	if c := started(); !reflect.DeepEqual(c.Sources, []string{"service"}) {
+		t.Errorf("unexpected sources: %v", c.Sources)
+	}
+	expect(t, iso.snapshots, func(snapshot string) bool {
+		return !strings.Contains(snapshot, `"configmap"`) && strings.Contains(snapshot, `"service"`)
+	})
+	invoker.mux.Lock()
+	notify := invoker.notify
+	invoker.mux.Unlock()
+	if !reflect.DeepEqual(notify, []string{"second"}) {
+		t.Errorf("unexpected receivers: %v", notify)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"time"
 
 	"github.com/datawire/teleproxy/pkg/consulwatch"
 
@@ -19,7 +21,15 @@ type consulEvent struct {
 type consulwatchman struct {
 	WatchMaker IConsulWatchMaker
 	watchesCh  <-chan []ConsulWatchSpec
-	watched    map[string]*supervisor.Worker
+	// watches supervises the watch workers, it runs as a subtree
+	// of the supervisor of the consulwatchman so that all the
+	// watches can be restarted together
+	watches *supervisor.Supervisor
+	// watched are the names of the watch workers
+	watched map[string]bool
+	// resolvers are the consul address and datacenter pairs that
+	// are watched
+	resolvers map[string]bool
 }
 
 type ConsulWatchMaker struct {
@@ -66,7 +76,9 @@ func (m *ConsulWatchMaker) MakeConsulWatch(spec ConsulWatchSpec) (*supervisor.Wo
 			w.Stop()
 			return nil
 		},
-		Retry: true,
+		// back off when consul is unreachable for a while
+		Restart: supervisor.OnFailure,
+		Backoff: supervisor.Backoff{Initial: time.Second, Max: time.Minute, Jitter: 0.2},
 	}
 
 	return worker, nil
@@ -77,7 +89,9 @@ func (w *consulwatchman) Work(p *supervisor.Process) error {
 	for {
 		select {
 		case watches := <-w.watchesCh:
-			found := make(map[string]*supervisor.Worker)
+			found := make(map[string]bool)
+			resolvers := make(map[string]bool)
+			var added []*supervisor.Worker
 			p.Logf("processing %d consul watches", len(watches))
 			for _, cw := range watches {
 				worker, err := w.WatchMaker.MakeConsulWatch(cw)
@@ -86,27 +100,35 @@ func (w *consulwatchman) Work(p *supervisor.Process) error {
 					continue
 				}
 
-				if _, exists := w.watched[worker.Name]; exists {
-					found[worker.Name] = w.watched[worker.Name]
-				} else {
-					p.Logf("add consul watcher %s\n", worker.Name)
-					p.Supervisor().Supervise(worker)
-					w.watched[worker.Name] = worker
-					found[worker.Name] = worker
+				found[worker.Name] = true
+				resolvers[fmt.Sprintf("%s|%s", cw.ConsulAddress, cw.Datacenter)] = true
+				if w.watches.Get(worker.Name) == nil {
+					added = append(added, worker)
 				}
 			}
 
 			// purge the watches that no longer are needed because they did not come through the in the latest
 			// report
-			for workerName, worker := range w.watched {
-				if _, exists := found[workerName]; !exists {
+			for workerName := range w.watched {
+				if !found[workerName] {
 					p.Logf("remove consul watcher %s\n", workerName)
-					worker.Shutdown()
-					worker.Wait()
+					w.watches.Remove(workerName)
 				}
 			}
 
+			// the remaining watches start over when the resolvers change
+			if w.resolvers != nil && !reflect.DeepEqual(resolvers, w.resolvers) {
+				p.Logf("consul resolvers changed, restarting consul watches")
+				w.watches.RestartAll()
+			}
+
+			for _, worker := range added {
+				p.Logf("add consul watcher %s\n", worker.Name)
+				w.watches.Supervise(worker)
+			}
+
 			w.watched = found
+			w.resolvers = resolvers
 		case <-p.Shutdown():
 			p.Logf("shutdown initiated")
 			return nil
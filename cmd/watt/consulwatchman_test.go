@@ -44,8 +44,8 @@ func TestAddAndRemoveConsulWatchers(t *testing.T) {
 	}
 
 	assert.Len(t, iso.watchman.watched, len(specs))
-	for k, worker := range iso.watchman.watched {
-		assert.Equal(t, k, worker.Name)
+	for k := range iso.watchman.watched {
+		assert.NotNil(t, iso.watchman.watches.Get(k))
 	}
 
 	specs = []ConsulWatchSpec{
@@ -63,8 +63,8 @@ func TestAddAndRemoveConsulWatchers(t *testing.T) {
 	}
 
 	assert.Len(t, iso.watchman.watched, len(specs))
-	for k, worker := range iso.watchman.watched {
-		assert.Equal(t, k, worker.Name)
+	for k := range iso.watchman.watched {
+		assert.NotNil(t, iso.watchman.watches.Get(k))
 	}
 
 	specs = []ConsulWatchSpec{
@@ -82,8 +82,8 @@ func TestAddAndRemoveConsulWatchers(t *testing.T) {
 	}
 
 	assert.Len(t, iso.watchman.watched, len(specs))
-	for k, worker := range iso.watchman.watched {
-		assert.Equal(t, k, worker.Name)
+	for k := range iso.watchman.watched {
+		assert.NotNil(t, iso.watchman.watches.Get(k))
 	}
 }
 
@@ -124,18 +124,22 @@ func newConsulwatchmanIsolator(t *testing.T) *consulwatchmanIsolator {
 		done: make(chan struct{}),
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	iso.cancel = cancel
+
 	iso.watchman = &consulwatchman{
 		WatchMaker: &ConsulWatchMaker{},
 		watchesCh:  iso.aggregatorToWatchmanCh,
-		watched:    map[string]*supervisor.Worker{},
+		watches:    supervisor.WithContext(ctx),
+		watched:    map[string]bool{},
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	iso.cancel = cancel
 	iso.sup = supervisor.WithContext(ctx)
+	iso.sup.Supervise(iso.watchman.watches.AsWorker("consulwatches"))
 	iso.sup.Supervise(&supervisor.Worker{
-		Name: "consulwatchman",
-		Work: iso.watchman.Work,
+		Name:     "consulwatchman",
+		Work:     iso.watchman.Work,
+		Requires: []string{"consulwatches"},
 	})
 	return iso
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// serveDelta serves the JSON Patch (RFC 6902) that turns the snapshot
+// given with ?from= into the one with the given id, so that consumers
+// that have the former fetch kilobytes instead of megabytes. Either
+// snapshot may not be around anymore, in which case the consumer has
+// to fetch the whole snapshot.
+func (s *apiServer) serveDelta(p *supervisor.Process, w http.ResponseWriter, r *http.Request, id string) {
+	to, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "ID is not an integer", http.StatusBadRequest)
+		return
+	}
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		http.Error(w, "from is not an integer", http.StatusBadRequest)
+		return
+	}
+
+	fromSnapshot := s.invoker.getSnapshot(from)
+	toSnapshot := s.invoker.getSnapshot(to)
+	if fromSnapshot == nil || toSnapshot == nil {
+		http.Error(w, fmt.Sprintf("snapshot %d or %d is not retained", from, to), http.StatusNotFound)
+		return
+	}
+
+	patch, err := snapshotDelta(fromSnapshot.snapshot, toSnapshot.snapshot)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json-patch+json")
+	w.Header().Set("content-length", strconv.Itoa(len(patch)))
+	if _, err := w.Write(patch); err != nil {
+		p.Logf("write delta error: %v", err)
+	}
+}
+
+// snapshotDelta returns the JSON Patch between two snapshots.
+func snapshotDelta(from, to []byte) ([]byte, error) {
+	var f, t k8s.Resource
+	if err := json.Unmarshal(from, &f); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &t); err != nil {
+		return nil, err
+	}
+	patch := k8s.Diff(f, t)
+	if patch == nil {
+		patch = k8s.Patch{}
+	}
+	return json.Marshal(patch)
+}
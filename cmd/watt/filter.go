@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+)
+
+// filterRule matches kubernetes resources, see --include and
+// --exclude. A rule is a list of conditions separated by ";", all of
+// which must hold for a resource to match:
+//
+//   - kind=<kind>: the kind, as given to --source or as in the resource
+//   - namespace=<namespace>
+//   - labels=<selector>: a label selector, e.g. app=foo,tier!=db
+//   - expr=<expression>: a k8s.Expression, e.g. data.mode == "debug"
+//   - size><bytes>: the JSON encoding is larger than the given size
+//
+// For example "kind=configmap;size>65536" matches the large configmaps.
+type filterRule struct {
+	source    string
+	kind      string
+	namespace string
+	selector  labels.Selector
+	expr      *k8s.Expression
+	size      int
+}
+
+func parseFilterRule(source string) (*filterRule, error) {
+	rule := &filterRule{source: source, size: -1}
+	conditions := 0
+	for _, cond := range strings.Split(source, ";") {
+		cond = strings.TrimSpace(cond)
+		if cond == "" {
+			continue
+		}
+		conditions++
+		if strings.HasPrefix(cond, "size>") {
+			size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(cond, "size>")))
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("%s: size is not a number of bytes: %s", source, cond)
+			}
+			rule.size = size
+			continue
+		}
+		parts := strings.SplitN(cond, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s: condition is not key=value: %s", source, cond)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "kind":
+			rule.kind = value
+		case "namespace":
+			rule.namespace = value
+		case "labels":
+			selector, err := labels.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", source, err)
+			}
+			rule.selector = selector
+		case "expr":
+			expr, err := k8s.ParseExpression(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.expr = expr
+		default:
+			return nil, fmt.Errorf("%s: unknown condition: %s", source, key)
+		}
+	}
+	if conditions == 0 {
+		return nil, fmt.Errorf("empty rule")
+	}
+	return rule, nil
+}
+
+func (r *filterRule) String() string {
+	return r.source
+}
+
+// matches returns true if the resource of the given kind meets all the
+// conditions of the rule.
+func (r *filterRule) matches(kind string, resource k8s.Resource) bool {
+	if r.kind != "" && !strings.EqualFold(r.kind, kind) && !strings.EqualFold(r.kind, resource.Kind()) {
+		return false
+	}
+	if r.namespace != "" && r.namespace != resource.Namespace() {
+		return false
+	}
+	if r.selector != nil {
+		set := make(labels.Set)
+		for k, v := range resource.Labels() {
+			set[k] = fmt.Sprint(v)
+		}
+		if !r.selector.Matches(set) {
+			return false
+		}
+	}
+	if r.expr != nil && !r.expr.Eval(resource) {
+		return false
+	}
+	if r.size >= 0 {
+		bytes, err := json.Marshal(resource)
+		if err != nil || len(bytes) <= r.size {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceFilter decides what kubernetes resources make it into the
+// snapshots. A resource is kept if it matches any of the include rules,
+// or there are none, and none of the exclude rules. The fields to strip,
+// e.g. metadata.managedFields, are removed from the resources that are
+// kept.
+type resourceFilter struct {
+	include []*filterRule
+	exclude []*filterRule
+	strip   [][]string
+}
+
+// newResourceFilter returns nil when there is nothing to filter.
+func newResourceFilter(include, exclude, strip []string) (*resourceFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 && len(strip) == 0 {
+		return nil, nil
+	}
+	f := &resourceFilter{}
+	for _, source := range include {
+		rule, err := parseFilterRule(source)
+		if err != nil {
+			return nil, fmt.Errorf("--include %v", err)
+		}
+		f.include = append(f.include, rule)
+	}
+	for _, source := range exclude {
+		rule, err := parseFilterRule(source)
+		if err != nil {
+			return nil, fmt.Errorf("--exclude %v", err)
+		}
+		f.exclude = append(f.exclude, rule)
+	}
+	for _, field := range strip {
+		path := strings.Split(field, ".")
+		for _, name := range path {
+			if name == "" {
+				return nil, fmt.Errorf("--strip-field %s: empty field name", field)
+			}
+		}
+		f.strip = append(f.strip, path)
+	}
+	return f, nil
+}
+
+func (f *resourceFilter) keep(kind string, resource k8s.Resource) bool {
+	included := len(f.include) == 0
+	for _, rule := range f.include {
+		if rule.matches(kind, resource) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, rule := range f.exclude {
+		if rule.matches(kind, resource) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the resources of the given kind that are kept, with the
+// fields stripped. The resources themselves are not modified, they may
+// be the ones in the store of a watcher.
+func (f *resourceFilter) apply(kind string, resources []k8s.Resource) []k8s.Resource {
+	result := make([]k8s.Resource, 0, len(resources))
+	for _, r := range resources {
+		if !f.keep(kind, r) {
+			continue
+		}
+		for _, path := range f.strip {
+			r = stripField(r, path)
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// stripField returns a copy of obj without the field at path. Only the
+// maps on the way to the field are copied.
+func stripField(obj map[string]interface{}, path []string) map[string]interface{} {
+	value, ok := obj[path[0]]
+	if !ok {
+		return obj
+	}
+	var stripped interface{}
+	if len(path) > 1 {
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			return obj
+		}
+		stripped = stripField(child, path[1:])
+	}
+	result := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		result[k] = v
+	}
+	if len(path) == 1 {
+		delete(result, path[0])
+	} else {
+		result[path[0]] = stripped
+	}
+	return result
+}
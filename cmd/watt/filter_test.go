@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+)
+
+func resource(kind, namespace, name string, extra map[string]interface{}) k8s.Resource {
+	r := k8s.Resource{
+		"kind": kind,
+		"metadata": map[string]interface{}{
+			"name":          name,
+			"namespace":     namespace,
+			"managedFields": []interface{}{"noise"},
+		},
+	}
+	for k, v := range extra {
+		r[k] = v
+	}
+	return r
+}
+
+func names(resources []k8s.Resource) string {
+	var result []string
+	for _, r := range resources {
+		result = append(result, r.Name())
+	}
+	return strings.Join(result, ",")
+}
+
+func TestResourceFilter(t *testing.T) {
+	resources := []k8s.Resource{
+		resource("ConfigMap", "default", "small", map[string]interface{}{"data": map[string]interface{}{"a": "b"}}),
+		resource("ConfigMap", "default", "large", map[string]interface{}{"data": map[string]interface{}{"a": strings.Repeat("b", 1000)}}),
+		resource("ConfigMap", "kube-system", "system", nil),
+		resource("ConfigMap", "default", "debug", map[string]interface{}{"data": map[string]interface{}{"mode": "debug"}}),
+	}
+
+	for _, test := range []struct {
+		include, exclude []string
+		expected         string
+	}{
+		{nil, []string{"namespace=kube-system"}, "small,large,debug"},
+		{nil, []string{"kind=configmap;size>500"}, "small,system,debug"},
+		{nil, []string{"kind=service;size>500"}, "small,large,system,debug"},
+		{nil, []string{`expr=data.mode == "debug"`, "namespace=kube-system"}, "small,large"},
+		{[]string{"namespace=default"}, []string{"size>500"}, "small,debug"},
+		{[]string{"kind=configmaps"}, nil, "small,large,system,debug"},
+		{[]string{"kind=service"}, nil, ""},
+	} {
+		f, err := newResourceFilter(test.include, test.exclude, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(f.apply("configmaps", resources)); got != test.expected {
+			t.Errorf("include %v exclude %v: expected %q, got %q", test.include, test.exclude, test.expected, got)
+		}
+	}
+
+	for _, rule := range []string{"", "kind", "size>lots", "color=red", "expr=a =="} {
+		if _, err := newResourceFilter(nil, []string{rule}, nil); err == nil {
+			t.Errorf("expected an error for %q", rule)
+		}
+	}
+}
+
+func TestStripField(t *testing.T) {
+	original := resource("ConfigMap", "default", "small", nil)
+	f, err := newResourceFilter(nil, nil, []string{"metadata.managedFields", "spec.missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := f.apply("configmaps", []k8s.Resource{original})[0]
+	if _, ok := stripped.Metadata()["managedFields"]; ok {
+		t.Errorf("managedFields was not stripped: %v", stripped)
+	}
+	if stripped.Name() != "small" {
+		t.Errorf("unexpected name: %v", stripped)
+	}
+	// the original may be in the store of a watcher
+	if _, ok := original.Metadata()["managedFields"]; !ok {
+		t.Errorf("the original resource was modified: %v", original)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+	"github.com/datawire/teleproxy/pkg/watt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// grpcServer serves the watt.SnapshotService, see --grpc-port. It is
+// secured like the API server, with TLS if tls is set, and requiring
+// clients to authenticate if auth is set.
+type grpcServer struct {
+	port int
+	hub  *snapshotHub
+	tls  *tls.Config
+	auth *apiAuth
+}
+
+func (g *grpcServer) Work(p *supervisor.Process) error {
+	listenHostAndPort := fmt.Sprintf(":%d", g.port)
+	listener, err := net.Listen("tcp", listenHostAndPort)
+	if err != nil {
+		return err
+	}
+
+	var options []grpc.ServerOption
+	if g.tls != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(g.tls)))
+	}
+	if g.auth != nil {
+		options = append(options, grpc.StreamInterceptor(g.auth.streamInterceptor))
+	}
+	srv := grpc.NewServer(options...)
+	watt.RegisterSnapshotServiceServer(srv, &snapshotService{hub: g.hub})
+
+	p.Ready()
+	p.Logf("grpc server listening on: %s", listenHostAndPort)
+	// launch an anonymous child worker to serve requests
+	p.Go(func(p *supervisor.Process) error {
+		// Serve only returns nil once stopped
+		return srv.Serve(listener)
+	})
+
+	<-p.Shutdown()
+	// Watch streams don't end on their own, so there is no point
+	// in stopping gracefully
+	srv.Stop()
+	return nil
+}
+
+// snapshotService implements the watt.SnapshotService on top of the
+// snapshot hub. Each Watch takes the snapshots of its own
+// subscription, so a subscriber that doesn't keep up, and in turn
+// holds up sending to it, skips to the latest snapshot.
+type snapshotService struct {
+	hub *snapshotHub
+}
+
+func (s *snapshotService) Watch(req *watt.WatchRequest, stream watt.WatchServer) error {
+	sub := s.hub.subscribe()
+	defer sub.Close()
+	var previous []byte
+	for {
+		next := sub.Wait(stream.Context().Done())
+		if next == nil {
+			return nil
+		}
+		update := &watt.SnapshotUpdate{Id: int64(next.id), Json: next.snapshot}
+		if req.Delta && previous != nil {
+			patch, err := snapshotDelta(previous, next.snapshot)
+			if err != nil {
+				return err
+			}
+			update.Json = patch
+			update.Delta = true
+		}
+		if err := stream.Send(update); err != nil {
+			return err
+		}
+		previous = next.snapshot
+	}
+}
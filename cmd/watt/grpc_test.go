@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/watt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type fakeWatchServer struct {
+	grpc.ServerStream
+	ctx     context.Context
+	updates chan *watt.SnapshotUpdate
+	// Send returns once the test received the update and lets it
+	// proceed
+	proceed chan struct{}
+}
+
+func (f *fakeWatchServer) Context() context.Context { return f.ctx }
+
+func (f *fakeWatchServer) Send(m *watt.SnapshotUpdate) error {
+	f.updates <- m
+	<-f.proceed
+	return nil
+}
+
+func TestSnapshotServiceWatch(t *testing.T) {
+	hub := newSnapshotHub()
+	hub.publish(newStoredSnapshot(1, []byte(`{"Kubernetes": {"service": [1]}}`)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &fakeWatchServer{
+		ctx:     ctx,
+		updates: make(chan *watt.SnapshotUpdate),
+		proceed: make(chan struct{}),
+	}
+	done := make(chan error)
+	go func() {
+		done <- (&snapshotService{hub: hub}).Watch(&watt.WatchRequest{Delta: true}, stream)
+	}()
+
+	// the latest snapshot comes first, in full
+	update := <-stream.updates
+	if update.Id != 1 || update.Delta || string(update.Json) != `{"Kubernetes": {"service": [1]}}` {
+		t.Errorf("unexpected update: %v", update)
+	}
+
+	// while the subscriber doesn't keep up, it skips to the latest
+	hub.publish(newStoredSnapshot(2, []byte(`{"Kubernetes": {"service": [2]}}`)))
+	hub.publish(newStoredSnapshot(3, []byte(`{"Kubernetes": {"service": [3], "configmap": null}}`)))
+	stream.proceed <- struct{}{}
+	update = <-stream.updates
+	// the null is added as a value, unlike with a JSON merge patch
+	expected := `[{"op":"add","path":"/Kubernetes/configmap","value":null},` +
+		`{"op":"replace","path":"/Kubernetes/service/0","value":3}]`
+	if update.Id != 3 || !update.Delta || string(update.Json) != expected {
+		t.Errorf("unexpected update: %v", update)
+	}
+
+	stream.proceed <- struct{}{}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Watch did not return")
+	}
+	if len(hub.subscriptions) != 0 {
+		t.Errorf("subscription not closed")
+	}
+}
+
+func TestStreamInterceptor(t *testing.T) {
+	auth := &apiAuth{token: "secret", clientCert: true}
+	verified := credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}}
+	for name, c := range map[string]struct {
+		ctx      context.Context
+		expected codes.Code
+	}{
+		"anonymous": {context.Background(), codes.Unauthenticated},
+		"wrong token": {metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs("authorization", "Bearer guess")), codes.Unauthenticated},
+		"token": {metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs("authorization", "Bearer secret")), codes.OK},
+		"unverified client": {peer.NewContext(context.Background(),
+			&peer.Peer{AuthInfo: credentials.TLSInfo{}}), codes.Unauthenticated},
+		"client cert": {peer.NewContext(context.Background(), &peer.Peer{AuthInfo: verified}), codes.OK},
+	} {
+		handled := false
+		handler := func(srv interface{}, stream grpc.ServerStream) error {
+			handled = true
+			return nil
+		}
+		err := auth.streamInterceptor(nil, &fakeWatchServer{ctx: c.ctx}, &grpc.StreamServerInfo{}, handler)
+		if code := status.Code(err); code != c.expected || handled != (c.expected == codes.OK) {
+			t.Errorf("%s: expected %v, got %v (handled: %v)", name, c.expected, code, handled)
+		}
+	}
+}
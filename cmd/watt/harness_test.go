@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/consulwatch"
+	"github.com/datawire/teleproxy/pkg/k8s"
+	"github.com/datawire/teleproxy/pkg/limiter"
+	"github.com/datawire/teleproxy/pkg/supervisor"
+	"github.com/datawire/teleproxy/pkg/watt"
+)
+
+// fakeKubernetes is an in-memory stand-in for the kubernetes API. It
+// hands out watches just like KubernetesWatchMaker does, and every
+// change made with Apply or Delete is delivered to the watches of the
+// affected kind. Kinds are matched literally, there is no
+// canonicalization. Namespaces and label selectors of the form
+// "key=value,..." are honored, field selectors are ignored.
+type fakeKubernetes struct {
+	notify    chan<- k8sEvent
+	mux       sync.Mutex
+	resources map[string][]k8s.Resource
+	listeners map[string][]chan struct{}
+}
+
+func newFakeKubernetes(notify chan<- k8sEvent) *fakeKubernetes {
+	return &fakeKubernetes{
+		notify:    notify,
+		resources: make(map[string][]k8s.Resource),
+		listeners: make(map[string][]chan struct{}),
+	}
+}
+
+// Apply creates or replaces the given resources of the given kind.
+func (k *fakeKubernetes) Apply(kind string, resources ...k8s.Resource) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	for _, res := range resources {
+		replaced := false
+		for idx, existing := range k.resources[kind] {
+			if existing.QName() == res.QName() {
+				k.resources[kind][idx] = res
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			k.resources[kind] = append(k.resources[kind], res)
+		}
+	}
+	k.changed(kind)
+}
+
+// Delete removes the resource of the given kind and qualified name.
+func (k *fakeKubernetes) Delete(kind, qname string) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	var kept []k8s.Resource
+	for _, res := range k.resources[kind] {
+		if res.QName() != qname {
+			kept = append(kept, res)
+		}
+	}
+	k.resources[kind] = kept
+	k.changed(kind)
+}
+
+func (k *fakeKubernetes) changed(kind string) {
+	for _, ch := range k.listeners[kind] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (k *fakeKubernetes) subscribe(kind string) chan struct{} {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	ch := make(chan struct{}, 1)
+	k.listeners[kind] = append(k.listeners[kind], ch)
+	return ch
+}
+
+func (k *fakeKubernetes) unsubscribe(kind string, ch chan struct{}) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	var kept []chan struct{}
+	for _, l := range k.listeners[kind] {
+		if l != ch {
+			kept = append(kept, l)
+		}
+	}
+	k.listeners[kind] = kept
+}
+
+func matchLabels(res k8s.Resource, selector string) bool {
+	if selector == "" {
+		return true
+	}
+	labels := res.Labels()
+	for _, term := range strings.Split(selector, ",") {
+		parts := strings.SplitN(term, "=", 2)
+		if len(parts) != 2 || labels[strings.TrimSpace(parts[0])] != strings.TrimSpace(parts[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (k *fakeKubernetes) list(spec KubernetesWatchSpec) []k8s.Resource {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	result := make([]k8s.Resource, 0)
+	for _, res := range k.resources[spec.Kind] {
+		if spec.Namespace != "" && res.Namespace() != spec.Namespace {
+			continue
+		}
+		if !matchLabels(res, spec.LabelSelector) {
+			continue
+		}
+		result = append(result, res)
+	}
+	return result
+}
+
+// watch delivers the current resources matching the spec and then
+// does it again on every change until shutdown.
+func (k *fakeKubernetes) watch(p *supervisor.Process, watchId string, spec KubernetesWatchSpec) error {
+	changed := k.subscribe(spec.Kind)
+	defer k.unsubscribe(spec.Kind, changed)
+	p.Ready()
+	for {
+		select {
+		case k.notify <- k8sEvent{watchId: watchId, kind: spec.Kind, resources: k.list(spec)}:
+		case <-p.Shutdown():
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-p.Shutdown():
+			return nil
+		}
+	}
+}
+
+func (k *fakeKubernetes) MakeKubernetesWatch(spec KubernetesWatchSpec) (*supervisor.Worker, error) {
+	return &supervisor.Worker{
+		Name: fmt.Sprintf("kubernetes:%s", spec.WatchId()),
+		Work: func(p *supervisor.Process) error {
+			return k.watch(p, spec.WatchId(), spec)
+		},
+	}, nil
+}
+
+// fakeConsul is an in-memory stand-in for consul. It hands out
+// watches just like ConsulWatchMaker does. A service without
+// endpoints is reported with an empty set of endpoints, like consul
+// does.
+type fakeConsul struct {
+	notify    chan<- consulEvent
+	mux       sync.Mutex
+	endpoints map[string][]consulwatch.Endpoint
+	listeners map[string][]chan struct{}
+}
+
+func newFakeConsul(notify chan<- consulEvent) *fakeConsul {
+	return &fakeConsul{
+		notify:    notify,
+		endpoints: make(map[string][]consulwatch.Endpoint),
+		listeners: make(map[string][]chan struct{}),
+	}
+}
+
+// SetEndpoints replaces the endpoints of the given service.
+func (c *fakeConsul) SetEndpoints(service string, endpoints ...consulwatch.Endpoint) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.endpoints[service] = endpoints
+	for _, ch := range c.listeners[service] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *fakeConsul) subscribe(service string) chan struct{} {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	ch := make(chan struct{}, 1)
+	c.listeners[service] = append(c.listeners[service], ch)
+	return ch
+}
+
+func (c *fakeConsul) unsubscribe(service string, ch chan struct{}) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var kept []chan struct{}
+	for _, l := range c.listeners[service] {
+		if l != ch {
+			kept = append(kept, l)
+		}
+	}
+	c.listeners[service] = kept
+}
+
+func (c *fakeConsul) get(spec ConsulWatchSpec) consulwatch.Endpoints {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return consulwatch.Endpoints{
+		Id:        spec.Id,
+		Service:   spec.ServiceName,
+		Endpoints: append([]consulwatch.Endpoint{}, c.endpoints[spec.ServiceName]...),
+	}
+}
+
+func (c *fakeConsul) MakeConsulWatch(spec ConsulWatchSpec) (*supervisor.Worker, error) {
+	return &supervisor.Worker{
+		Name: fmt.Sprintf("consul:%s", spec.WatchId()),
+		Work: func(p *supervisor.Process) error {
+			changed := c.subscribe(spec.ServiceName)
+			defer c.unsubscribe(spec.ServiceName, changed)
+			p.Ready()
+			for {
+				select {
+				case c.notify <- consulEvent{spec.WatchId(), c.get(spec)}:
+				case <-p.Shutdown():
+					return nil
+				}
+				select {
+				case <-changed:
+				case <-p.Shutdown():
+					return nil
+				}
+			}
+		},
+	}, nil
+}
+
+// harness wires the aggregator, the watch managers, the invoker and
+// the api server together the same way runWatt does, except that
+// kubernetes and consul are replaced by in-memory fakes that tests
+// can script. Every snapshot handed to the invoker is also made
+// available to the test through Expect.
+type harness struct {
+	t          *testing.T
+	Kubernetes *fakeKubernetes
+	Consul     *fakeConsul
+	port       int
+	snapshots  chan []byte
+	sup        *supervisor.Supervisor
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func newHarness(t *testing.T, requiredKinds []string, watchHook WatchHook) *harness {
+	h := &harness{
+		t:         t,
+		port:      freePort(t),
+		snapshots: make(chan []byte, 100),
+		done:      make(chan struct{}),
+	}
+
+	k8sWatches := make(chan []KubernetesWatchSpec)
+	consulWatches := make(chan []ConsulWatchSpec)
+	tap := make(chan []byte)
+
+	invoker := NewInvoker(h.port, nil)
+	aggregator := NewAggregator(tap, k8sWatches, consulWatches, requiredKinds, watchHook, limiter.NewUnlimited())
+
+	h.Kubernetes = newFakeKubernetes(aggregator.KubernetesEvents)
+	h.Consul = newFakeConsul(aggregator.ConsulEvents)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	h.cancel = cancel
+	h.sup = supervisor.WithContext(ctx)
+
+	for _, kind := range requiredKinds {
+		spec := KubernetesWatchSpec{Kind: kind}
+		h.sup.Supervise(&supervisor.Worker{
+			Name: "kubebootstrap:" + kind,
+			Work: func(p *supervisor.Process) error {
+				return h.Kubernetes.watch(p, "", spec)
+			},
+		})
+	}
+
+	consulwatches := supervisor.WithContext(ctx)
+	h.sup.Supervise(consulwatches.AsWorker("consulwatches"))
+	h.sup.Supervise(&supervisor.Worker{
+		Name: "consulwatchman",
+		Work: (&consulwatchman{
+			WatchMaker: h.Consul,
+			watchesCh:  consulWatches,
+			watches:    consulwatches,
+			watched:    make(map[string]bool),
+		}).Work,
+		Requires: []string{"consulwatches"},
+	})
+
+	h.sup.Supervise(&supervisor.Worker{
+		Name: "kubewatchman",
+		Work: (&kubewatchman{
+			WatchMaker: h.Kubernetes,
+			in:         k8sWatches,
+		}).Work,
+	})
+
+	h.sup.Supervise(&supervisor.Worker{
+		Name: "aggregator",
+		Work: aggregator.Work,
+	})
+
+	h.sup.Supervise(&supervisor.Worker{
+		Name: "tap",
+		Work: func(p *supervisor.Process) error {
+			p.Ready()
+			for {
+				select {
+				case snapshot := <-tap:
+					h.snapshots <- snapshot
+					select {
+					case invoker.Snapshots <- snapshot:
+					case <-p.Shutdown():
+						return nil
+					}
+				case <-p.Shutdown():
+					return nil
+				}
+			}
+		},
+	})
+
+	h.sup.Supervise(&supervisor.Worker{
+		Name: "invoker",
+		Work: invoker.Work,
+	})
+
+	h.sup.Supervise(&supervisor.Worker{
+		Name: "api",
+		Work: (&apiServer{port: h.port, invoker: invoker}).Work,
+	})
+
+	return h
+}
+
+// Start starts watt, anything applied to the fakes beforehand is part
+// of the initial state.
+func (h *harness) Start() {
+	go func() {
+		errs := h.sup.Run()
+		if len(errs) > 0 {
+			h.t.Errorf("unexpected errors: %v", errs)
+		}
+		close(h.done)
+	}()
+}
+
+func (h *harness) Stop() {
+	h.sup.Shutdown()
+	h.cancel()
+	<-h.done
+}
+
+// Expect waits for the next snapshot and checks it with the given
+// predicate.
+func (h *harness) Expect(predicate func(watt.Snapshot) bool) {
+	h.t.Helper()
+	select {
+	case encoded := <-h.snapshots:
+		var snapshot watt.Snapshot
+		if err := json.Unmarshal(encoded, &snapshot); err != nil {
+			h.t.Fatalf("bad snapshot: %v", err)
+		}
+		if !predicate(snapshot) {
+			h.t.Fatalf("unexpected snapshot: %s", encoded)
+		}
+	case <-time.After(10 * time.Second):
+		h.t.Fatal("timed out waiting for a snapshot")
+	}
+}
+
+// Get fetches the snapshot with the given id from the api server.
+func (h *harness) Get(id int) (int, string) {
+	h.t.Helper()
+	resp, body := h.fetch(fmt.Sprintf("/snapshots/%d", id), nil)
+	return resp.StatusCode, body
+}
+
+// GetLatest fetches the latest snapshot from the api server unless it
+// matches the given etag, and returns the status and the etag.
+func (h *harness) GetLatest(ifNoneMatch string) (int, string) {
+	h.t.Helper()
+	header := http.Header{}
+	if ifNoneMatch != "" {
+		header.Set("If-None-Match", ifNoneMatch)
+	}
+	resp, _ := h.fetch("/snapshots/latest", header)
+	return resp.StatusCode, resp.Header.Get("etag")
+}
+
+func (h *harness) fetch(path string, header http.Header) (*http.Response, string) {
+	h.t.Helper()
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d%s", h.port, path), nil)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header = header
+	var resp *http.Response
+	// the api server may still be starting up
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		resp, err = http.DefaultClient.Do(req)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestEndToEnd(t *testing.T) {
+	watchHook := func(p *supervisor.Process, snapshot []byte) WatchSet {
+		if bytes.Contains(snapshot, []byte("getambassador.io/consul-resolver")) {
+			return WatchSet{ConsulWatches: []ConsulWatchSpec{WATCH}}
+		}
+		return WatchSet{}
+	}
+
+	h := newHarness(t, []string{"service", "configmap"}, watchHook)
+	h.Kubernetes.Apply("service", SERVICES...)
+	h.Kubernetes.Apply("configmap", RESOLVER...)
+	h.Start()
+	defer h.Stop()
+
+	// the resolver refers to a consul service, so the first snapshot
+	// is delivered once consul has reported on it
+	h.Expect(func(s watt.Snapshot) bool {
+		endpoints, ok := s.Consul.Endpoints["bar"]
+		return ok && len(endpoints.Endpoints) == 0 &&
+			len(s.Kubernetes["service"]) == 1 && len(s.Kubernetes["configmap"]) == 1
+	})
+
+	h.Consul.SetEndpoints("bar", consulwatch.Endpoint{Service: "bar", Address: "1.2.3.4", Port: 80})
+	h.Expect(func(s watt.Snapshot) bool {
+		endpoints := s.Consul.Endpoints["bar"].Endpoints
+		return len(endpoints) == 1 && endpoints[0].Address == "1.2.3.4"
+	})
+
+	h.Kubernetes.Delete("service", "foo")
+	h.Expect(func(s watt.Snapshot) bool {
+		return len(s.Kubernetes["service"]) == 0
+	})
+
+	status, body := h.Get(2)
+	if status != http.StatusOK || !strings.Contains(body, "1.2.3.4") {
+		t.Errorf("unexpected snapshot 2: %d %s", status, body)
+	}
+
+	status, _ = h.Get(100)
+	if status != http.StatusNotFound {
+		t.Errorf("expected snapshot 100 to be missing, got %d", status)
+	}
+
+	// pollers of the latest snapshot only get it again once it
+	// changes
+	status, etag := h.GetLatest("")
+	if status != http.StatusOK || etag == "" {
+		t.Errorf("unexpected latest snapshot: %d %q", status, etag)
+	}
+	if status, _ = h.GetLatest(etag); status != http.StatusNotModified {
+		t.Errorf("expected the latest snapshot to be unmodified, got %d", status)
+	}
+	if status, _ = h.GetLatest(`"other", W/` + etag); status != http.StatusNotModified {
+		t.Errorf("expected the latest snapshot to be unmodified, got %d", status)
+	}
+	if status, _ = h.GetLatest(`"other"`); status != http.StatusOK {
+		t.Errorf("expected the latest snapshot, got %d", status)
+	}
+
+	// consumers with the previous snapshot fetch just the changes
+	resp, body := h.fetch("/snapshots/3/delta?from=2", nil)
+	if resp.StatusCode != http.StatusOK || body != `[{"op":"replace","path":"/Kubernetes/service","value":null}]` {
+		t.Errorf("unexpected delta: %d %s", resp.StatusCode, body)
+	}
+	resp, _ = h.fetch("/snapshots/3/delta?from=100", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the delta from snapshot 100 to be missing, got %d", resp.StatusCode)
+	}
+
+	var retained []SnapshotInfo
+	resp, body = h.fetch("/snapshots", nil)
+	if err := json.Unmarshal([]byte(body), &retained); err != nil || len(retained) != 3 || retained[2].ID != 3 {
+		t.Errorf("unexpected retained snapshots: %d %s", resp.StatusCode, body)
+	}
+}
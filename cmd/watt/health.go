@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// readiness is what /readyz reports. watt is ready once all the initial
+// sources have synced and it has produced a snapshot, one restored from
+// before it was restarted doesn't count.
+type readiness struct {
+	Ready           bool     `json:"ready"`
+	UnsyncedSources []string `json:"unsyncedSources"`
+	Snapshot        bool     `json:"snapshot"`
+}
+
+func (s *apiServer) readiness() readiness {
+	result := readiness{UnsyncedSources: []string{}}
+	if s.aggregator != nil {
+		result.UnsyncedSources = s.aggregator.unsyncedSources()
+	}
+	latest := s.invoker.getLatest()
+	result.Snapshot = latest != nil && !latest.stale
+	result.Ready = len(result.UnsyncedSources) == 0 && result.Snapshot
+	return result
+}
+
+// serveHealth answers /healthz, watt is alive as long as it answers.
+func (s *apiServer) serveHealth(p *supervisor.Process, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/plain")
+	if _, err := w.Write([]byte("ok\n")); err != nil {
+		p.Logf("write health error: %v", err)
+	}
+}
+
+// serveReadiness answers /readyz with 200 when watt is ready, and with
+// 503 and what it is still waiting for when it isn't, so that
+// kubernetes doesn't route traffic to a watt that is bootstrapping.
+func (s *apiServer) serveReadiness(p *supervisor.Process, w http.ResponseWriter, r *http.Request) {
+	status := s.readiness()
+	bytes, err := json.MarshalIndent(status, "", "    ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if _, err := w.Write(bytes); err != nil {
+		p.Logf("write readiness error: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/datawire/teleproxy/pkg/limiter"
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+func TestReadiness(t *testing.T) {
+	invoker := NewInvoker(0, nil)
+	aggregator := NewAggregator(nil, nil, nil, []string{"service", "configmap"}, nil, limiter.NewUnlimited())
+	s := &apiServer{invoker: invoker, aggregator: aggregator}
+
+	supervisor.MustRun("readiness", func(p *supervisor.Process) error {
+		ready := func() int {
+			w := httptest.NewRecorder()
+			s.serveReadiness(p, w, httptest.NewRequest("GET", "/readyz", nil))
+			return w.Code
+		}
+
+		if status := ready(); status != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 before bootstrap, got %d", status)
+		}
+		aggregator.setKubernetesResources(k8sEvent{kind: "service"})
+		// a watch of a watch hook is not an initial source
+		aggregator.setKubernetesResources(k8sEvent{watchId: "hook", kind: "configmap"})
+		if got := s.readiness(); got.Ready || len(got.UnsyncedSources) != 1 || got.UnsyncedSources[0] != "configmap" {
+			t.Errorf("unexpected readiness: %+v", got)
+		}
+		aggregator.setKubernetesResources(k8sEvent{kind: "configmap"})
+		if status := ready(); status != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 without a snapshot, got %d", status)
+		}
+
+		// a restored snapshot is not enough
+		invoker.invokedSnapshots[1] = newStoredSnapshot(1, []byte("{}"))
+		invoker.invokedSnapshots[1].stale = true
+		invoker.id = 1
+		if status := ready(); status != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 with a stale snapshot, got %d", status)
+		}
+		invoker.storeSnapshot([]byte("{}"))
+		if status := ready(); status != http.StatusOK {
+			t.Errorf("expected 200, got %d", status)
+		}
+		return nil
+	})
+}
+
+func TestProbesAreUnauthenticated(t *testing.T) {
+	auth := &apiAuth{token: "secret"}
+	handler := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "/healthz")
+	for path, expected := range map[string]int{
+		"/healthz":   http.StatusOK,
+		"/snapshots": http.StatusUnauthorized,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != expected {
+			t.Errorf("%s: expected %d, got %d", path, expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// storedSnapshot is a snapshot as stored by the invoker, along with
+// the id it is served under, a hash of its content and when it was
+// stored.
+type storedSnapshot struct {
+	id       int
+	snapshot []byte
+	hash     string
+	time     time.Time
+	// stale is set for a snapshot that was restored from before
+	// watt was restarted, see snapshotStore
+	stale bool
+}
+
+// newStoredSnapshot hashes the snapshot to store it.
+func newStoredSnapshot(id int, snapshot []byte) *storedSnapshot {
+	sum := sha256.Sum256(snapshot)
+	return &storedSnapshot{id: id, snapshot: snapshot, hash: hex.EncodeToString(sum[:]), time: time.Now()}
+}
+
+// snapshotHub hands the snapshots the invoker stores to the API
+// clients that are subscribed to them, rather than have them poll the
+// snapshot server. Every subscription holds just the latest snapshot
+// that its client has yet to take, so a slow client skips the
+// snapshots it can't keep up with instead of holding up the invoker
+// or the other clients.
+type snapshotHub struct {
+	mutex         sync.Mutex
+	latest        *storedSnapshot
+	subscriptions map[*subscription]bool
+}
+
+func newSnapshotHub() *snapshotHub {
+	return &snapshotHub{subscriptions: make(map[*subscription]bool)}
+}
+
+type subscription struct {
+	hub *snapshotHub
+	// pending is signaled when there is a snapshot to take
+	pending chan struct{}
+	next    *storedSnapshot
+}
+
+// subscribe returns a subscription to the snapshots stored from now
+// on, starting with the latest one, if any.
+func (h *snapshotHub) subscribe() *subscription {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := &subscription{hub: h, pending: make(chan struct{}, 1)}
+	h.subscriptions[s] = true
+	if h.latest != nil {
+		s.offer(h.latest)
+	}
+	return s
+}
+
+// publish hands the snapshot to all the subscriptions.
+func (h *snapshotHub) publish(snapshot *storedSnapshot) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.latest = snapshot
+	for s := range h.subscriptions {
+		s.offer(h.latest)
+	}
+}
+
+// this assumes that s.hub.mutex is already held
+func (s *subscription) offer(snapshot *storedSnapshot) {
+	s.next = snapshot
+	select {
+	case s.pending <- struct{}{}:
+	default:
+		// the client has yet to take the previous one
+	}
+}
+
+// Pending returns a channel that receives when there is a snapshot to
+// take.
+func (s *subscription) Pending() <-chan struct{} {
+	return s.pending
+}
+
+// take returns the latest snapshot that wasn't taken yet, or nil if
+// there is none.
+func (s *subscription) take() *storedSnapshot {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	next := s.next
+	s.next = nil
+	return next
+}
+
+// Wait blocks until there is a snapshot to take and takes it, or
+// returns nil once done is closed.
+func (s *subscription) Wait(done <-chan struct{}) *storedSnapshot {
+	for {
+		select {
+		case <-s.pending:
+			if next := s.take(); next != nil {
+				return next
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// Close ends the subscription.
+func (s *subscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	delete(s.hub.subscriptions, s)
+}
@@ -1,41 +1,87 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/datawire/teleproxy/pkg/supervisor"
 	"github.com/datawire/teleproxy/pkg/tpu"
 )
 
 type invoker struct {
-	Snapshots        chan string
+	Snapshots        chan []byte
 	mux              sync.Mutex
-	invokedSnapshots map[int]string
+	invokedSnapshots map[int]*storedSnapshot
 	id               int
 	notify           []string
+	webhooks         []*webhook
+	hub              *snapshotHub
+	store            *snapshotStore
+	retention        retention
 	apiServerPort    int
+	// apiURL, if set, is where receivers fetch the snapshots from
+	// instead of http://localhost:<port>, e.g. when the API server
+	// serves TLS or listens on a unix socket
+	apiURL string
 
 	// This stores the latest snapshot, but we don't assign an id
 	// unless/until we invoke... some of these will be discarded
 	// by the rate limiting/coalescing logic
-	latestSnapshot string
+	latestSnapshot []byte
 	process        *supervisor.Process
+
+	// observe, if set, is told how long the receivers took to
+	// process each snapshot
+	observe func(time.Duration)
+	// metrics, if set, counts the receivers that failed, and is
+	// served at /metrics
+	metrics *metrics
 }
 
 func NewInvoker(port int, notify []string) *invoker {
 	return &invoker{
-		Snapshots:        make(chan string),
-		invokedSnapshots: make(map[int]string),
+		Snapshots:        make(chan []byte),
+		invokedSnapshots: make(map[int]*storedSnapshot),
+		hub:              newSnapshotHub(),
+		retention:        retention{maxCount: 10},
 		notify:           notify,
 		apiServerPort:    port,
 	}
 }
 
+// restore loads the snapshots persisted before watt was restarted, if
+// any, and serves them marked as stale until a fresh one is stored.
+// The ids of new snapshots continue from the restored ones.
+func (a *invoker) restore() error {
+	snapshots, err := a.store.load()
+	if err != nil {
+		return err
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	for _, snapshot := range snapshots {
+		snapshot.stale = true
+		a.invokedSnapshots[snapshot.id] = snapshot
+		a.id = snapshot.id
+	}
+	if len(snapshots) > 0 {
+		a.hub.publish(a.invokedSnapshots[a.id])
+	}
+	return nil
+}
+
 func (a *invoker) Work(p *supervisor.Process) error {
 	a.process = p
 	p.Ready()
@@ -50,30 +96,64 @@ func (a *invoker) Work(p *supervisor.Process) error {
 	}
 }
 
-func (a *invoker) storeSnapshot(snapshot string) int {
+func (a *invoker) storeSnapshot(snapshot []byte) int {
 	a.mux.Lock()
 	defer a.mux.Unlock()
 	a.id += 1
-	a.invokedSnapshots[a.id] = snapshot
+	stored := newStoredSnapshot(a.id, snapshot)
+	a.invokedSnapshots[a.id] = stored
 	a.gcSnapshots()
+	a.hub.publish(stored)
 	return a.id
 }
 
+// retention is how many snapshots the invoker keeps around to serve,
+// the limits that are zero don't apply. The latest snapshot is always
+// kept.
+type retention struct {
+	maxCount int
+	maxAge   time.Duration
+	maxBytes int
+}
+
+// gcSnapshots deletes the oldest snapshots for as long as the
+// retention limits are exceeded. This assumes that a.mux is already
+// held.
 func (a *invoker) gcSnapshots() {
-	for k := range a.invokedSnapshots {
-		if k <= a.id-10 {
-			delete(a.invokedSnapshots, k)
-			a.process.Logf("deleting snapshot %d", k)
+	ids := a.getKeys()
+	sort.Ints(ids)
+	total := 0
+	for _, id := range ids {
+		total += len(a.invokedSnapshots[id].snapshot)
+	}
+	now := time.Now()
+	r := a.retention
+	for len(ids) > 1 {
+		oldest := a.invokedSnapshots[ids[0]]
+		if (r.maxCount <= 0 || len(ids) <= r.maxCount) &&
+			(r.maxAge <= 0 || now.Sub(oldest.time) <= r.maxAge) &&
+			(r.maxBytes <= 0 || total <= r.maxBytes) {
+			break
 		}
+		delete(a.invokedSnapshots, oldest.id)
+		a.process.Logf("deleting snapshot %d", oldest.id)
+		total -= len(oldest.snapshot)
+		ids = ids[1:]
 	}
 }
 
-func (a *invoker) getSnapshot(id int) string {
+func (a *invoker) getSnapshot(id int) *storedSnapshot {
 	a.mux.Lock()
 	defer a.mux.Unlock()
 	return a.invokedSnapshots[id]
 }
 
+func (a *invoker) getLatest() *storedSnapshot {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.invokedSnapshots[a.id]
+}
+
 func (a *invoker) getKeys() (result []int) {
 	for i := range a.invokedSnapshots {
 		result = append(result, i)
@@ -81,23 +161,92 @@ func (a *invoker) getKeys() (result []int) {
 	return
 }
 
+// SnapshotInfo describes a retained snapshot, see GET /snapshots.
+type SnapshotInfo struct {
+	ID    int       `json:"id"`
+	Time  time.Time `json:"time"`
+	Size  int       `json:"size"`
+	Stale bool      `json:"stale,omitempty"`
+}
+
+func (a *invoker) retained() []SnapshotInfo {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	ids := a.getKeys()
+	sort.Ints(ids)
+	result := make([]SnapshotInfo, 0, len(ids))
+	for _, id := range ids {
+		s := a.invokedSnapshots[id]
+		result = append(result, SnapshotInfo{ID: id, Time: s.time, Size: len(s.snapshot), Stale: s.stale})
+	}
+	return result
+}
+
 func (a *invoker) invoke() {
 	id := a.storeSnapshot(a.latestSnapshot)
-	for _, n := range a.notify {
-		k := tpu.NewKeeper("notify", fmt.Sprintf("%s http://localhost:%d/snapshots/%d", n, a.apiServerPort, id))
+	if a.store != nil {
+		if err := a.store.save(a.getSnapshot(id)); err != nil {
+			a.process.Logf("persist snapshot %d failed: %v", id, err)
+		}
+	}
+	base := a.apiURL
+	if base == "" {
+		base = fmt.Sprintf("http://localhost:%d", a.apiServerPort)
+	}
+	url := fmt.Sprintf("%s/snapshots/%d", base, id)
+	a.mux.Lock()
+	notify, observe := a.notify, a.observe
+	a.mux.Unlock()
+	start := time.Now()
+	for _, n := range notify {
+		k := tpu.NewKeeper("notify", fmt.Sprintf("%s %s", n, url))
 		k.Limit = 1
 		k.Start()
 		k.Wait()
+		if k.Err != nil {
+			a.metrics.receiverFailed(n)
+		}
+	}
+	for _, h := range a.webhooks {
+		if err := h.notify(a.process, url, a.latestSnapshot); err != nil {
+			a.process.Logf("notify %s failed: %v", h.url, err)
+		}
+	}
+	if observe != nil {
+		observe(time.Since(start))
 	}
 }
 
+// reconfigure changes the receivers, and what is told how long they
+// took, from the next snapshot on.
+func (a *invoker) reconfigure(notify []string, observe func(time.Duration)) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.notify = notify
+	a.observe = observe
+}
+
 type apiServer struct {
-	port    int
-	invoker *invoker
+	port     int
+	invoker  *invoker
+	watchers *watcherRegistry
+	// aggregator, if set, tells /readyz what initial sources
+	// haven't synced yet, and changes watches for the admin API
+	aggregator *aggregator
+	// reloader, if set, changes initial sources for the admin API
+	reloader *reloader
+	// socket, if set, is the path of a unix socket to listen on
+	// instead of the port, for local consumers only
+	socket string
+	// tls, if set, serves the API over TLS
+	tls *tls.Config
+	// auth, if set, requires clients to authenticate
+	auth *apiAuth
 }
 
 func (s *apiServer) Work(p *supervisor.Process) error {
-	http.HandleFunc("/snapshots/", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/snapshots/", func(w http.ResponseWriter, r *http.Request) {
 		relpath := strings.TrimPrefix(r.URL.Path, "/snapshots/")
 
 		if relpath == "" {
@@ -105,29 +254,133 @@ func (s *apiServer) Work(p *supervisor.Process) error {
 			if _, err := w.Write([]byte(s.index())); err != nil {
 				p.Logf("write index error: %v", err)
 			}
+		} else if relpath == "stream" {
+			s.streamSnapshots(p, w, r)
+		} else if relpath == "ws" {
+			s.serveWebSocket(p, w, r)
+		} else if strings.HasSuffix(relpath, "/delta") {
+			s.serveDelta(p, w, r, strings.TrimSuffix(relpath, "/delta"))
 		} else {
-			id, err := strconv.Atoi(relpath)
-			if err != nil {
-				http.Error(w, "ID is not an integer", http.StatusBadRequest)
-				return
+			var snapshot *storedSnapshot
+			if relpath == "latest" {
+				snapshot = s.invoker.getLatest()
+			} else {
+				id, err := strconv.Atoi(relpath)
+				if err != nil {
+					http.Error(w, "ID is not an integer", http.StatusBadRequest)
+					return
+				}
+				snapshot = s.invoker.getSnapshot(id)
 			}
 
-			snapshot := s.invoker.getSnapshot(id)
-
-			if snapshot == "" {
+			if snapshot == nil {
 				w.WriteHeader(http.StatusNotFound)
 				return
 			}
 
+			// the etag is the hash of the content, so that
+			// pollers of the latest snapshot don't transfer
+			// it again until it changes, even if its id does
+			etag := fmt.Sprintf("%q", snapshot.hash)
+			w.Header().Set("etag", etag)
+			w.Header().Set("content-location", fmt.Sprintf("/snapshots/%d", snapshot.id))
+			if snapshot.stale {
+				// restored from before watt was restarted
+				w.Header().Set("x-watt-stale", "true")
+			}
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
 			w.Header().Set("content-type", "application/json")
-			if _, err := w.Write([]byte(snapshot)); err != nil {
+			w.Header().Set("content-length", strconv.Itoa(len(snapshot.snapshot)))
+			if _, err := w.Write(snapshot.snapshot); err != nil {
 				p.Logf("write snapshot error: %v", err)
 			}
 		}
 	})
 
-	listenHostAndPort := fmt.Sprintf(":%d", port)
-	listener, err := net.Listen("tcp", listenHostAndPort)
+	// the retained snapshots, oldest first
+	mux.HandleFunc("/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := json.MarshalIndent(s.invoker.retained(), "", "    ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		if _, err := w.Write(bytes); err != nil {
+			p.Logf("write snapshots error: %v", err)
+		}
+	})
+
+	// the status of the kubernetes watches, so that consumers can
+	// tell whether the snapshots are stale
+	mux.HandleFunc("/watches", func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := json.MarshalIndent(s.watchers.status(), "", "    ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		if _, err := w.Write(bytes); err != nil {
+			p.Logf("write watches error: %v", err)
+		}
+	})
+
+	// the status of watt's workers, for debugging
+	mux.HandleFunc("/debug/workers", func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := json.MarshalIndent(p.Supervisor().Status(), "", "    ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		if _, err := w.Write(bytes); err != nil {
+			p.Logf("write workers error: %v", err)
+		}
+	})
+
+	// liveness and readiness, for the probes of kubernetes
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveHealth(p, w, r)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveReadiness(p, w, r)
+	})
+
+	// changes to what watt watches, see admin.go
+	mux.HandleFunc("/admin/sources", func(w http.ResponseWriter, r *http.Request) {
+		s.serveAdminSources(p, w, r)
+	})
+	mux.HandleFunc("/admin/watches", func(w http.ResponseWriter, r *http.Request) {
+		s.serveAdminWatches(p, w, r)
+	})
+
+	// operational metrics of watt's workers, e.g. their restarts,
+	// of its webhooks, and of watt itself, see metrics
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWithPrefix("watt_", registry)
+	if err := p.Supervisor().Register(registerer); err != nil {
+		return err
+	}
+	if err := s.invoker.metrics.register(registerer); err != nil {
+		return err
+	}
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	network, address := "tcp", fmt.Sprintf(":%d", s.port)
+	if s.socket != "" {
+		network, address = "unix", s.socket
+		// a socket left behind by a previous run would fail the
+		// listen
+		if info, err := os.Stat(s.socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(s.socket); err != nil {
+				return err
+			}
+		}
+	}
+	listener, err := net.Listen(network, address)
 	if err != nil {
 		return err
 	}
@@ -137,21 +390,47 @@ func (s *apiServer) Work(p *supervisor.Process) error {
 			p.Logf("listener close error: %v", err)
 		}
 	}()
+	if s.tls != nil {
+		listener = tls.NewListener(listener, s.tls)
+	}
+
+	var handler http.Handler = mux
+	if s.auth != nil {
+		// the probes of kubernetes don't authenticate
+		handler = s.auth.wrap(mux, "/healthz", "/readyz")
+	}
 
 	p.Ready()
-	p.Logf("snapshot server listening on: %s", listenHostAndPort)
+	p.Logf("snapshot server listening on: %s", address)
 	srv := &http.Server{
-		Addr: listenHostAndPort,
+		Addr:    address,
+		Handler: handler,
 	}
 	// launch an anonymous child worker to serve requests
 	p.Go(func(p *supervisor.Process) error {
-		return srv.Serve(listener)
+		err := srv.Serve(listener)
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
 	})
 
 	<-p.Shutdown()
 	return srv.Shutdown(p.Context())
 }
 
+// etagMatches returns whether an If-None-Match header matches the
+// etag. Etags are compared weakly, as they are for GET requests.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *apiServer) index() string {
 	var result strings.Builder
 
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+func TestRetention(t *testing.T) {
+	ids := func(a *invoker) string {
+		var result []int
+		for _, info := range a.retained() {
+			result = append(result, info.ID)
+		}
+		return fmt.Sprint(result)
+	}
+
+	supervisor.MustRun("retention", func(p *supervisor.Process) error {
+		a := NewInvoker(0, nil)
+		a.process = p
+		a.retention = retention{maxCount: 3}
+		for i := 0; i < 5; i++ {
+			a.storeSnapshot([]byte("{}"))
+		}
+		if got := ids(a); got != "[3 4 5]" {
+			t.Errorf("unexpected snapshots with a max count: %s", got)
+		}
+
+		a.retention = retention{maxBytes: 10}
+		a.storeSnapshot([]byte("12345"))
+		a.storeSnapshot([]byte("1234"))
+		if got := ids(a); got != "[6 7]" {
+			t.Errorf("unexpected snapshots with max bytes: %s", got)
+		}
+		// the latest snapshot is kept regardless
+		a.storeSnapshot([]byte("12345678901"))
+		if got := ids(a); got != "[8]" {
+			t.Errorf("unexpected snapshots with max bytes: %s", got)
+		}
+
+		a.retention = retention{maxAge: time.Minute}
+		a.getSnapshot(8).time = time.Now().Add(-time.Hour)
+		a.storeSnapshot([]byte("{}"))
+		if got := ids(a); got != "[9]" {
+			t.Errorf("unexpected snapshots with a max age: %s", got)
+		}
+		info := a.retained()[0]
+		if info.Size != 2 || time.Since(info.Time) > time.Minute {
+			t.Errorf("unexpected snapshot info: %+v", info)
+		}
+		return nil
+	})
+}
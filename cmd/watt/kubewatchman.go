@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/datawire/teleproxy/pkg/k8s"
 	"github.com/datawire/teleproxy/pkg/supervisor"
+	"github.com/datawire/teleproxy/pkg/watt"
 )
 
 type k8sEvent struct {
@@ -14,8 +18,119 @@ type k8sEvent struct {
 }
 
 type KubernetesWatchMaker struct {
-	kubeAPI *k8s.Client
+	clients map[string]*k8s.Client
 	notify  chan<- k8sEvent
+	// namespaces are watched for the specs that don't name a
+	// namespace, resources in excludeNamespaces are never watched,
+	// see reconfigure
+	mutex             sync.Mutex
+	namespaces        []string
+	excludeNamespaces []string
+	watchers          *watcherRegistry
+	validator         *validator
+}
+
+// reconfigure changes the namespaces that the watches made from now
+// on watch, the running ones need to be restarted to pick them up.
+func (m *KubernetesWatchMaker) reconfigure(namespaces, excludeNamespaces []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.namespaces = namespaces
+	m.excludeNamespaces = excludeNamespaces
+}
+
+func (m *KubernetesWatchMaker) currentNamespaces() (namespaces, excludeNamespaces []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.namespaces, m.excludeNamespaces
+}
+
+// validator flags the resources that don't match the schema of the
+// cluster they come from with watt.INVALID_ANNOTATION. A nil validator
+// flags nothing.
+type validator struct {
+	clients map[string]*k8s.Client
+}
+
+func (v *validator) flag(p *supervisor.Process, resources []k8s.Resource) []k8s.Resource {
+	if v == nil {
+		return resources
+	}
+	result := make([]k8s.Resource, len(resources))
+	for i, r := range resources {
+		result[i] = r
+		client, ok := v.clients[r.Cluster()]
+		if !ok {
+			continue
+		}
+		err := client.ValidateAgainstSchema(r)
+		if _, invalid := err.(k8s.ValidationError); invalid {
+			p.Logf("%s %s is invalid: %v", r.Kind(), r.QName(), err)
+			result[i] = r.WithAnnotation(watt.INVALID_ANNOTATION, err.Error())
+		} else if err != nil {
+			// e.g. the schema couldn't be fetched, which
+			// doesn't make the resource invalid
+			p.Logf("validating %s %s: %v", r.Kind(), r.QName(), err)
+		}
+	}
+	return result
+}
+
+// watcherRegistry keeps track of the running watchers so that their
+// status can be reported. A nil registry keeps track of nothing.
+type watcherRegistry struct {
+	mutex    sync.Mutex
+	watchers map[string]*k8s.MultiWatcher
+}
+
+func newWatcherRegistry() *watcherRegistry {
+	return &watcherRegistry{watchers: make(map[string]*k8s.MultiWatcher)}
+}
+
+func (r *watcherRegistry) add(name string, watcher *k8s.MultiWatcher) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.watchers[name] = watcher
+}
+
+func (r *watcherRegistry) remove(name string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.watchers, name)
+}
+
+// watchStatus is the status of a watch together with the name of the
+// watcher it belongs to.
+type watchStatus struct {
+	Watcher string `json:"watcher"`
+	k8s.WatchStatus
+}
+
+// status returns the status of every watch of every watcher.
+func (r *watcherRegistry) status() []watchStatus {
+	result := make([]watchStatus, 0)
+	if r == nil {
+		return result
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var names []string
+	for name := range r.watchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, status := range r.watchers[name].Status() {
+			result = append(result, watchStatus{name, status})
+		}
+	}
+	return result
 }
 
 func (m *KubernetesWatchMaker) MakeKubernetesWatch(spec KubernetesWatchSpec) (*supervisor.Worker, error) {
@@ -25,25 +140,41 @@ func (m *KubernetesWatchMaker) MakeKubernetesWatch(spec KubernetesWatchSpec) (*s
 	worker = &supervisor.Worker{
 		Name: fmt.Sprintf("kubernetes:%s", spec.WatchId()),
 		Work: func(p *supervisor.Process) error {
-			watcher := m.kubeAPI.Watcher()
-			watchFunc := func(watchId, ns, kind string) func(watcher *k8s.Watcher) {
-				return func(watcher *k8s.Watcher) {
-					resources := watcher.List(kind)
-					p.Logf("found %d %q in namespace %q", len(resources), kind, fmtNamespace(ns))
+			watcher := k8s.NewMultiWatcher(m.clients)
+			watchFunc := func(watchId, ns, kind string) func(watcher *k8s.MultiWatcher) {
+				return func(watcher *k8s.MultiWatcher) {
+					resources := m.validator.flag(p, watcher.List(kind))
+					p.Logf("found %d %q in namespace %q", len(resources), kind, ns)
 					m.notify <- k8sEvent{watchId: watchId, kind: kind, resources: resources}
 					p.Logf("sent %q to receivers", kind)
 				}
 			}
 
-			watcherErr := watcher.SelectiveWatch(spec.Namespace, spec.Kind, spec.FieldSelector, spec.LabelSelector,
-				watchFunc(spec.WatchId(), spec.Namespace, spec.Kind))
+			namespaces, excludeNamespaces := m.currentNamespaces()
+			query := k8s.Query{
+				Kind:              spec.Kind,
+				Namespace:         spec.Namespace,
+				FieldSelector:     spec.FieldSelector,
+				LabelSelector:     spec.LabelSelector,
+				ExcludeNamespaces: excludeNamespaces,
+			}
+			if spec.Namespace == "" && len(namespaces) > 0 {
+				query.Namespaces = namespaces
+			}
+			// the kind may be a CRD that isn't installed yet
+			watcherErr := watcher.WatchWhenAvailable(query, watchFunc(spec.WatchId(), fmtQueryNamespaces(query), spec.Kind))
 
 			if watcherErr != nil {
 				return watcherErr
 			}
 
-			watcher.Start()
+			watcherErr = watcher.Start()
+			if watcherErr != nil {
+				return watcherErr
+			}
+			m.watchers.add(p.Worker().Name, watcher)
 			<-p.Shutdown()
+			m.watchers.remove(p.Worker().Name)
 			watcher.Stop()
 			return nil
 		},
@@ -56,19 +187,25 @@ func (m *KubernetesWatchMaker) MakeKubernetesWatch(spec KubernetesWatchSpec) (*s
 
 type kubewatchman struct {
 	WatchMaker IKubernetesWatchMaker
-	watched    map[string]*supervisor.Worker
-	in         <-chan []KubernetesWatchSpec
+	// watched are the names of the watch workers, which are
+	// supervised by the supervisor of the kubewatchman
+	watched map[string]bool
+	in      <-chan []KubernetesWatchSpec
+	// restart stops all the watches, they are started again with
+	// the next specs, e.g. once the namespaces changed
+	restart <-chan struct{}
 }
 
 func (w *kubewatchman) Work(p *supervisor.Process) error {
 	p.Ready()
 
-	w.watched = make(map[string]*supervisor.Worker)
+	w.watched = make(map[string]bool)
+	sup := p.Supervisor()
 
 	for {
 		select {
 		case watches := <-w.in:
-			found := make(map[string]*supervisor.Worker)
+			found := make(map[string]bool)
 			p.Logf("processing %d kubernetes watch specs", len(watches))
 			for _, spec := range watches {
 				worker, err := w.WatchMaker.MakeKubernetesWatch(spec)
@@ -77,25 +214,27 @@ func (w *kubewatchman) Work(p *supervisor.Process) error {
 					continue
 				}
 
-				if _, exists := w.watched[worker.Name]; exists {
-					found[worker.Name] = w.watched[worker.Name]
-				} else {
+				found[worker.Name] = true
+				if sup.Get(worker.Name) == nil {
 					p.Logf("add kubernetes watcher %s\n", worker.Name)
-					p.Supervisor().Supervise(worker)
-					w.watched[worker.Name] = worker
-					found[worker.Name] = worker
+					sup.Supervise(worker)
 				}
 			}
 
-			for workerName, worker := range w.watched {
-				if _, exists := found[workerName]; !exists {
+			for workerName := range w.watched {
+				if !found[workerName] {
 					p.Logf("remove kubernetes watcher %s\n", workerName)
-					worker.Shutdown()
-					worker.Wait()
+					sup.Remove(workerName)
 				}
 			}
 
 			w.watched = found
+		case <-w.restart:
+			for workerName := range w.watched {
+				p.Logf("restart kubernetes watcher %s\n", workerName)
+				sup.Remove(workerName)
+			}
+			w.watched = make(map[string]bool)
 		case <-p.Shutdown():
 			p.Logf("shutdown initiated")
 			return nil
@@ -104,12 +243,17 @@ func (w *kubewatchman) Work(p *supervisor.Process) error {
 }
 
 type kubebootstrap struct {
-	namespace      string
-	kinds          []string
-	fieldSelector  string
-	labelSelector  string
-	notify         []chan<- k8sEvent
-	kubeAPIWatcher *k8s.Watcher
+	// namespaces are watched with an informer each, "" watches
+	// all of them
+	namespaces        []string
+	kinds             []string
+	fieldSelector     string
+	labelSelector     string
+	excludeNamespaces []string
+	notify            []chan<- k8sEvent
+	kubeAPIWatcher    *k8s.MultiWatcher
+	watchers          *watcherRegistry
+	validator         *validator
 }
 
 func fmtNamespace(ns string) string {
@@ -120,14 +264,29 @@ func fmtNamespace(ns string) string {
 	return ns
 }
 
+func fmtQueryNamespaces(query k8s.Query) string {
+	if query.Namespaces != nil {
+		return fmtNamespaces(query.Namespaces)
+	}
+	return fmtNamespace(query.Namespace)
+}
+
+func fmtNamespaces(namespaces []string) string {
+	var result []string
+	for _, ns := range namespaces {
+		result = append(result, fmtNamespace(ns))
+	}
+	return strings.Join(result, ",")
+}
+
 func (b *kubebootstrap) Work(p *supervisor.Process) error {
 	for _, kind := range b.kinds {
-		p.Logf("adding kubernetes watch for %q in namespace %q", kind, fmtNamespace(kubernetesNamespace))
+		p.Logf("adding kubernetes watch for %q in namespace %q", kind, fmtNamespaces(b.namespaces))
 
-		watcherFunc := func(ns, kind string) func(watcher *k8s.Watcher) {
-			return func(watcher *k8s.Watcher) {
-				resources := watcher.List(watcher.Canonical(kind))
-				p.Logf("found %d %q in namespace %q", len(resources), kind, fmtNamespace(ns))
+		watcherFunc := func(ns, kind string) func(watcher *k8s.MultiWatcher) {
+			return func(watcher *k8s.MultiWatcher) {
+				resources := b.validator.flag(p, watcher.List(watcher.Canonical(kind)))
+				p.Logf("found %d %q in namespace %q", len(resources), kind, ns)
 				for _, n := range b.notify {
 					n <- k8sEvent{kind: kind, resources: resources}
 				}
@@ -135,20 +294,31 @@ func (b *kubebootstrap) Work(p *supervisor.Process) error {
 			}
 		}
 
-		err := b.kubeAPIWatcher.SelectiveWatch(b.namespace, kind, b.fieldSelector, b.labelSelector, watcherFunc(b.namespace, kind))
+		query := k8s.Query{
+			Kind:              kind,
+			Namespaces:        b.namespaces,
+			FieldSelector:     b.fieldSelector,
+			LabelSelector:     b.labelSelector,
+			ExcludeNamespaces: b.excludeNamespaces,
+		}
+		err := b.kubeAPIWatcher.WatchQuery(query, watcherFunc(fmtNamespaces(b.namespaces), kind))
 
 		if err != nil {
 			return err
 		}
 	}
 
-	b.kubeAPIWatcher.Start()
+	err := b.kubeAPIWatcher.Start()
+	if err != nil {
+		return err
+	}
+	b.watchers.add(p.Worker().Name, b.kubeAPIWatcher)
 	p.Ready()
 
-	for range p.Shutdown() {
-		p.Logf("shutdown initiated")
-		b.kubeAPIWatcher.Stop()
-	}
+	<-p.Shutdown()
+	p.Logf("shutdown initiated")
+	b.watchers.remove(p.Worker().Name)
+	b.kubeAPIWatcher.Stop()
 
 	return nil
 }
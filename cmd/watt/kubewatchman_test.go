@@ -32,8 +32,8 @@ func TestAddAndRemoveKubernetesWatchers(t *testing.T) {
 	}
 
 	assert.Len(t, iso.watchman.watched, len(specs))
-	for k, worker := range iso.watchman.watched {
-		assert.Equal(t, k, worker.Name)
+	for k := range iso.watchman.watched {
+		assert.NotNil(t, iso.sup.Get(k))
 	}
 
 	specs = []KubernetesWatchSpec{
@@ -51,8 +51,8 @@ func TestAddAndRemoveKubernetesWatchers(t *testing.T) {
 	}
 
 	assert.Len(t, iso.watchman.watched, len(specs))
-	for k, worker := range iso.watchman.watched {
-		assert.Equal(t, k, worker.Name)
+	for k := range iso.watchman.watched {
+		assert.NotNil(t, iso.sup.Get(k))
 	}
 
 	specs = []KubernetesWatchSpec{
@@ -69,13 +69,50 @@ func TestAddAndRemoveKubernetesWatchers(t *testing.T) {
 	}
 
 	assert.Len(t, iso.watchman.watched, len(specs))
-	for k, worker := range iso.watchman.watched {
-		assert.Equal(t, k, worker.Name)
+	for k := range iso.watchman.watched {
+		assert.NotNil(t, iso.sup.Get(k))
+	}
+}
+
+func TestRestartKubernetesWatchers(t *testing.T) {
+	iso := startKubewatchmanIsolator(t)
+	defer iso.Stop()
+
+	specs := []KubernetesWatchSpec{
+		{Kind: "Service", Namespace: "", FieldSelector: "metadata.name=foo", LabelSelector: ""},
+		{Kind: "Service", Namespace: "", FieldSelector: "metadata.name=bar", LabelSelector: ""},
+	}
+	running := func(expected bool) func() bool {
+		return func() bool {
+			for _, spec := range specs {
+				worker, _ := iso.watchman.WatchMaker.MakeKubernetesWatch(spec)
+				if (iso.sup.Get(worker.Name) != nil) != expected {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	iso.aggregatorToWatchmanCh <- specs
+	if err := awaitility.Await(100*time.Millisecond, 1000*time.Millisecond, running(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the watches are stopped, and started again with the next specs
+	iso.restartCh <- struct{}{}
+	if err := awaitility.Await(100*time.Millisecond, 1000*time.Millisecond, running(false)); err != nil {
+		t.Fatal(err)
+	}
+	iso.aggregatorToWatchmanCh <- specs
+	if err := awaitility.Await(100*time.Millisecond, 1000*time.Millisecond, running(true)); err != nil {
+		t.Fatal(err)
 	}
 }
 
 type kubewatchmanIsolator struct {
 	aggregatorToWatchmanCh          chan []KubernetesWatchSpec
+	restartCh                       chan struct{}
 	kubernetesResourcesToAggregator chan k8sEvent
 	watchman                        *kubewatchman
 	sup                             *supervisor.Supervisor
@@ -109,6 +146,7 @@ func (iso *kubewatchmanIsolator) Stop() {
 func newKubewatchmanIsolator(t *testing.T) *kubewatchmanIsolator {
 	iso := &kubewatchmanIsolator{
 		aggregatorToWatchmanCh: make(chan []KubernetesWatchSpec),
+		restartCh:              make(chan struct{}),
 
 		// we need to create buffered channels for outputs because
 		// nothing is asynchronously reading them in the test
@@ -121,6 +159,7 @@ func newKubewatchmanIsolator(t *testing.T) *kubewatchmanIsolator {
 	iso.watchman = &kubewatchman{
 		WatchMaker: &MockWatchMaker{},
 		in:         iso.aggregatorToWatchmanCh,
+		restart:    iso.restartCh,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
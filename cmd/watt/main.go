@@ -2,24 +2,61 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"time"
 
 	"github.com/datawire/teleproxy/pkg/k8s"
-	"github.com/datawire/teleproxy/pkg/limiter"
 	"github.com/datawire/teleproxy/pkg/supervisor"
+	"github.com/datawire/teleproxy/pkg/watt"
 	"github.com/spf13/cobra"
 )
 
 var kubernetesNamespace string
+var kubeContexts = make([]string, 0)
+var clientOptions k8s.ClientOptions
+var excludedNamespaces = make([]string, 0)
+var validate bool
+var recordFile string
+var replayFile string
+var replaySpeed float64
 var initialSources = make([]string, 0)
 var initialFieldSelector string
 var initialLabelSelector string
 var watchHooks = make([]string, 0)
 var notifyReceivers = make([]string, 0)
+var notifyURLs = make([]string, 0)
+var notifyHeaders = make([]string, 0)
+var notifyURLOnly bool
+var notifyTimeout time.Duration
+var notifyRetries int
+var notifyBackoff time.Duration
+var watchInterests = make([]string, 0)
+var notifyInterests = make([]string, 0)
+var includeRules = make([]string, 0)
+var excludeRules = make([]string, 0)
+var stripFields = make([]string, 0)
+var configFile string
 var port int
+var apiSocket string
+var tlsCert string
+var tlsKey string
+var tlsClientCA string
+var apiTokenFile string
+var grpcPort int
+var snapshotDir string
+var snapshotKeep int
+var snapshotMaxCount int
+var snapshotMaxAge time.Duration
+var snapshotMaxBytes int
 var interval time.Duration
+var burst int
+var maxInterval time.Duration
+var debounce time.Duration
+var debounceMax time.Duration
 
 var rootCmd = &cobra.Command{
 	Use:              "watt",
@@ -29,17 +66,155 @@ var rootCmd = &cobra.Command{
 	Run:              runWatt,
 }
 
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "print the JSON schema of the snapshots watt delivers",
+	Args:  cobra.NoArgs,
+	Run:   runSchema,
+}
+
+var resourcesCmd = &cobra.Command{
+	Use:   "resources",
+	Short: "print what the kubernetes resource type names, e.g. those given to --source, resolve to",
+	Args:  cobra.NoArgs,
+	Run:   runResources,
+}
+
+var resourcesOptions k8s.ClientOptions
+var onlyAmbiguous bool
+
 func init() {
-	rootCmd.Flags().StringVarP(&kubernetesNamespace, "namespace", "n", "", "namespace to watch (default: all)")
+	rootCmd.AddCommand(schemaCmd)
+	rootCmd.AddCommand(resourcesCmd)
+
+	resourcesCmd.Flags().StringVar(&resourcesOptions.Kubeconfig, "kubeconfig", "",
+		"path of the kubeconfig file (default: $KUBECONFIG or ~/.kube/config)")
+	resourcesCmd.Flags().StringVar(&resourcesOptions.Context, "context", "",
+		"kubeconfig context of the cluster (default: the current context)")
+	resourcesCmd.Flags().BoolVar(&onlyAmbiguous, "ambiguous", false,
+		"only print the names that more than one resource type goes by")
+
+	rootCmd.Flags().StringVarP(&kubernetesNamespace, "namespace", "n", "",
+		"comma-separated namespace(s) to watch, with an informer each, e.g. default,ambassador (default: all)")
+	rootCmd.Flags().StringSliceVar(&kubeContexts, "context", []string{},
+		"kubeconfig context(s) whose clusters are watched as one, resources are annotated with the context "+
+			"they come from (default: the current context)")
+	rootCmd.Flags().StringVar(&clientOptions.Kubeconfig, "kubeconfig", "",
+		"path of the kubeconfig file (default: $KUBECONFIG or ~/.kube/config)")
+	rootCmd.Flags().Float32Var(&clientOptions.QPS, "kube-qps", 0,
+		"requests per second to the kubernetes api server (default: 5)")
+	rootCmd.Flags().IntVar(&clientOptions.Burst, "kube-burst", 0,
+		"bursts of requests to the kubernetes api server (default: 10)")
+	rootCmd.Flags().IntVar(&clientOptions.Retry.Retries, "kube-retries", 0,
+		"how often requests that the kubernetes api server throttles or fails are retried (default: 0)")
+	rootCmd.Flags().DurationVar(&clientOptions.Retry.Backoff, "kube-backoff", 0,
+		"delay before the first retry of a request, doubled for every further retry (default: 1s)")
+	rootCmd.Flags().DurationVar(&clientOptions.Timeout, "kube-timeout", 0,
+		"timeout of requests to the kubernetes api server (default: none)")
+	rootCmd.Flags().StringVar(&clientOptions.UserAgent, "user-agent", "",
+		"user agent to identify as to the kubernetes api server")
+	rootCmd.Flags().StringVar(&clientOptions.ImpersonateUser, "as", "",
+		"user to impersonate in the cluster(s) (default: the user of the context)")
+	rootCmd.Flags().StringSliceVar(&clientOptions.ImpersonateGroups, "as-group", []string{},
+		"group(s) to impersonate in the cluster(s), with --as")
+	rootCmd.Flags().StringVar(&recordFile, "record", "",
+		"record the kubernetes resources and changes watt sees to the file, see --replay")
+	rootCmd.Flags().StringVar(&replayFile, "replay", "",
+		"watch the kubernetes resources and changes recorded in the file with --record instead of the cluster(s)")
+	rootCmd.Flags().Float64Var(&replaySpeed, "replay-speed", 1,
+		"how much faster than recorded changes are replayed, 0 replays them as fast as possible")
+	rootCmd.Flags().StringSliceVar(&excludedNamespaces, "exclude-namespace", []string{},
+		"namespace(s) whose resources are never watched, e.g. kube-system")
+	rootCmd.Flags().BoolVar(&validate, "validate", false,
+		"validate resources against the schema of the cluster, invalid ones are annotated with "+
+			watt.INVALID_ANNOTATION)
 	rootCmd.Flags().StringSliceVarP(&initialSources, "source", "s", []string{}, "configure an initial static source")
 	rootCmd.Flags().StringVar(&initialFieldSelector, "fields", "", "configure an initial field selector string")
 	rootCmd.Flags().StringVar(&initialLabelSelector, "labels", "", "configure an initial label selector string")
 	rootCmd.Flags().StringSliceVarP(&watchHooks, "watch", "w", []string{}, "configure watch hook(s)")
 	rootCmd.Flags().StringSliceVar(&notifyReceivers, "notify", []string{},
 		"invoke the program with the given arguments as a receiver")
+	rootCmd.Flags().StringSliceVar(&notifyURLs, "notify-url", []string{},
+		"POST each snapshot to the given URL as a receiver")
+	rootCmd.Flags().StringSliceVar(&notifyHeaders, "notify-header", []string{},
+		"add the given \"Name: value\" header to the --notify-url requests")
+	rootCmd.Flags().BoolVar(&notifyURLOnly, "notify-url-only", false,
+		"POST the URL of each snapshot on the snapshot server to the --notify-url receivers rather than the snapshot")
+	rootCmd.Flags().DurationVar(&notifyTimeout, "notify-timeout", 10*time.Second,
+		"configure the timeout of the --notify-url requests")
+	rootCmd.Flags().IntVar(&notifyRetries, "notify-retries", 3,
+		"retry failed --notify-url requests up to this many times")
+	rootCmd.Flags().DurationVar(&notifyBackoff, "notify-backoff", 500*time.Millisecond,
+		"wait this long before retrying a failed --notify-url request, twice that before the next retry, and so on")
+	rootCmd.Flags().StringSliceVar(&watchInterests, "watch-interests", []string{},
+		"kubernetes kinds (or \""+CONSUL_SECTION+"\") the watch hooks look at, other changes don't rerun them (default: all)")
+	rootCmd.Flags().StringSliceVar(&notifyInterests, "notify-interests", []string{},
+		"kubernetes kinds (or \""+CONSUL_SECTION+"\") the receivers look at, other changes don't notify them (default: all)")
+	rootCmd.Flags().StringArrayVar(&includeRules, "include", []string{},
+		"only put the kubernetes resources matching one of these rules in the snapshots, e.g. \"namespace=default;labels=app=foo\" (conditions: kind, namespace, labels, expr, size>)")
+	rootCmd.Flags().StringArrayVar(&excludeRules, "exclude", []string{},
+		"leave the kubernetes resources matching any of these rules out of the snapshots, e.g. \"kind=configmap;size>65536\"")
+	rootCmd.Flags().StringSliceVar(&stripFields, "strip-field", []string{},
+		"remove these fields from the kubernetes resources in the snapshots, e.g. metadata.managedFields")
+	rootCmd.Flags().StringVar(&configFile, "config", "",
+		"read the sources, selectors, watch hooks, receivers and limits from this yaml file on top of the flags, "+
+			"and reload it on SIGHUP or when it changes")
 	rootCmd.Flags().IntVarP(&port, "port", "p", 7000, "configure the snapshot server port")
+	rootCmd.Flags().StringVar(&apiSocket, "api-socket", "",
+		"serve the snapshot API on this unix socket instead of --port, for local consumers only")
+	rootCmd.Flags().StringVar(&tlsCert, "tls-cert", "", "serve the snapshot API over TLS with this certificate (requires --tls-key)")
+	rootCmd.Flags().StringVar(&tlsKey, "tls-key", "", "the private key of --tls-cert")
+	rootCmd.Flags().StringVar(&tlsClientCA, "tls-client-ca", "",
+		"require clients of the snapshot API to present a certificate signed by this CA, or the --api-token-file token if that is given too (requires --tls-cert)")
+	rootCmd.Flags().StringVar(&apiTokenFile, "api-token-file", "",
+		"require clients of the snapshot API, including the --notify receivers, to send the bearer token in this file")
+	rootCmd.Flags().StringVar(&snapshotDir, "snapshot-dir", "",
+		"persist the most recent snapshots to the given directory, and serve the last one as stale on startup (default: don't)")
+	rootCmd.Flags().IntVar(&snapshotKeep, "snapshot-keep", 10, "configure how many snapshots --snapshot-dir keeps")
+	rootCmd.Flags().IntVar(&snapshotMaxCount, "snapshot-max-count", 10,
+		"serve at most this many of the most recent snapshots (0: no limit)")
+	rootCmd.Flags().DurationVar(&snapshotMaxAge, "snapshot-max-age", 0,
+		"serve only the snapshots stored at most this long ago, and the latest one (default: no limit)")
+	rootCmd.Flags().IntVar(&snapshotMaxBytes, "snapshot-max-bytes", 0,
+		"serve at most this many bytes of the most recent snapshots, and the latest one (default: no limit)")
+	rootCmd.Flags().IntVar(&grpcPort, "grpc-port", 0,
+		"stream snapshots to subscribers of the gRPC SnapshotService on the given port, secured like the API (default: don't)")
 	rootCmd.Flags().DurationVarP(&interval, "interval", "i", 250*time.Millisecond,
 		"configure the rate limit interval")
+	rootCmd.Flags().IntVar(&burst, "burst", 0,
+		"let bursts of up to this many snapshots through before rate limiting to one per interval on average (default: one per interval)")
+	rootCmd.Flags().DurationVar(&maxInterval, "max-interval", 0,
+		"adapt the rate limit interval, up to this long, to how long the receivers take to process a snapshot (default: fixed interval)")
+	rootCmd.Flags().DurationVar(&debounce, "debounce", 0,
+		"wait for changes to quiet down for this long before notifying, rather than notifying right away (default: don't wait)")
+	rootCmd.Flags().DurationVar(&debounceMax, "debounce-max", 2*time.Second,
+		"notify at the latest this long after the first of a series of changes that doesn't quiet down")
+}
+
+func runSchema(cmd *cobra.Command, args []string) {
+	bytes, err := json.MarshalIndent(watt.Schema(), "", "    ")
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(string(bytes))
+}
+
+func runResources(cmd *cobra.Command, args []string) {
+	client, err := k8s.NewClientWithOptions(resourcesOptions)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	resolutions := client.ResolveNames()
+	if onlyAmbiguous {
+		resolutions = client.ListAmbiguities()
+	}
+	err = k8s.PrintNameResolutions(os.Stdout, resolutions)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
 }
 
 func runWatt(cmd *cobra.Command, args []string) {
@@ -47,17 +222,50 @@ func runWatt(cmd *cobra.Command, args []string) {
 }
 
 func _runWatt(cmd *cobra.Command, args []string) int {
-	if len(initialSources) == 0 {
+	cfg := flagConfig()
+	if configFile != "" {
+		var err error
+		cfg, err = loadConfig(configFile, cfg)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+	if len(cfg.Sources) == 0 {
 		log.Println("no initial sources configured")
 		return 1
 	}
+	limits, err := cfg.limits()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	namespaces, err := cfg.namespaces()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
 
-	// XXX: we don't need to create this here anymore
-	client := k8s.NewClient(nil)
-	kubeAPIWatcher := client.Watcher()
-	/*for idx := range initialSources {
-		initialSources[idx] = kubeAPIWatcher.Canonical(initialSources[idx])
-	}*/
+	if recordFile != "" {
+		recording, err := k8s.NewRecording(recordFile)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		defer recording.Close()
+		clientOptions.Recording = recording
+	}
+
+	var clients map[string]*k8s.Client
+	if replayFile != "" {
+		clients, err = replayClients(replayFile, kubeContexts, replaySpeed)
+	} else {
+		clients, err = kubeClients(clientOptions, kubeContexts)
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
 
 	log.Printf("starting watt...")
 
@@ -69,47 +277,147 @@ func _runWatt(cmd *cobra.Command, args []string) int {
 	// kubernetes watch manager.
 	aggregatorToKubewatchmanCh := make(chan []KubernetesWatchSpec)
 
-	invoker := NewInvoker(port, notifyReceivers)
-	limiter := limiter.NewComposite(limiter.NewUnlimited(), limiter.NewInterval(interval), interval)
+	wattMetrics := newMetrics()
+	invoker := NewInvoker(port, cfg.Notify)
+	invoker.metrics = wattMetrics
+	invoker.retention = retention{
+		maxCount: snapshotMaxCount,
+		maxAge:   snapshotMaxAge,
+		maxBytes: snapshotMaxBytes,
+	}
+	if snapshotDir != "" {
+		invoker.store = &snapshotStore{dir: snapshotDir, keep: snapshotKeep}
+		if err := invoker.restore(); err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+	headers, err := parseHeaders(notifyHeaders)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	for _, url := range notifyURLs {
+		h := newWebhook(url, headers, notifyTimeout, notifyRetries, notifyBackoff)
+		h.urlOnly = notifyURLOnly
+		h.metrics = wattMetrics
+		wattMetrics.webhookAdded(url)
+		invoker.webhooks = append(invoker.webhooks, h)
+	}
+	filter, err := newResourceFilter(includeRules, excludeRules, stripFields)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	limiter, observe := newLimiter(limits)
+	invoker.observe = observe
 	aggregator := NewAggregator(invoker.Snapshots, aggregatorToKubewatchmanCh, aggregatorToConsulwatchmanCh,
-		initialSources, ExecWatchHook(watchHooks), limiter)
+		cfg.Sources, ExecWatchHook(cfg.Watch, wattMetrics), limiter)
+	aggregator.hookInterests = newInterests(cfg.WatchInterests)
+	aggregator.receiverInterests = newInterests(cfg.NotifyInterests)
+	aggregator.filter = filter
+	aggregator.metrics = wattMetrics
+
+	watchers := newWatcherRegistry()
 
-	kubebootstrap := kubebootstrap{
-		namespace:      kubernetesNamespace,
-		kinds:          initialSources,
-		fieldSelector:  initialFieldSelector,
-		labelSelector:  initialLabelSelector,
-		kubeAPIWatcher: kubeAPIWatcher,
-		notify:         []chan<- k8sEvent{aggregator.KubernetesEvents},
+	var resourceValidator *validator
+	if validate {
+		resourceValidator = &validator{clients: clients}
 	}
 
+	// the initial sources are watched anew by a new worker when
+	// they are reconfigured
+	newBootstrap := func(c config) *supervisor.Worker {
+		// the config was checked when it was loaded
+		namespaces, _ := c.namespaces()
+		kubebootstrap := &kubebootstrap{
+			namespaces:        namespaces,
+			kinds:             c.Sources,
+			fieldSelector:     c.Fields,
+			labelSelector:     c.Labels,
+			excludeNamespaces: c.ExcludeNamespaces,
+			kubeAPIWatcher:    k8s.NewMultiWatcher(clients),
+			watchers:          watchers,
+			validator:         resourceValidator,
+			notify:            []chan<- k8sEvent{aggregator.KubernetesEvents},
+		}
+		return &supervisor.Worker{
+			Name:     "kubebootstrap",
+			Work:     kubebootstrap.Work,
+			Requires: []string{"aggregator"},
+		}
+	}
+
+	ctx := context.Background()
+	s := supervisor.WithContext(ctx)
+	s.OnCrash = logCrash
+
+	consulwatches := supervisor.WithContext(ctx)
+	consulwatches.OnCrash = logCrash
+
 	consulwatchman := consulwatchman{
 		WatchMaker: &ConsulWatchMaker{aggregatorCh: aggregator.ConsulEvents},
 		watchesCh:  aggregatorToConsulwatchmanCh,
-		watched:    make(map[string]*supervisor.Worker),
+		watches:    consulwatches,
+		watched:    make(map[string]bool),
 	}
 
+	kubewatchMaker := &KubernetesWatchMaker{
+		clients:           clients,
+		notify:            aggregator.KubernetesEvents,
+		namespaces:        namespaces,
+		excludeNamespaces: cfg.ExcludeNamespaces,
+		watchers:          watchers,
+		validator:         resourceValidator,
+	}
+	restartWatches := make(chan struct{})
 	kubewatchman := kubewatchman{
-		WatchMaker: &KubernetesWatchMaker{kubeAPI: client, notify: aggregator.KubernetesEvents},
+		WatchMaker: kubewatchMaker,
 		in:         aggregatorToKubewatchmanCh,
+		restart:    restartWatches,
+	}
+
+	// the reloader applies changes of the config file, if any, and
+	// of the initial sources with the admin API
+	reloader := &reloader{
+		path:           configFile,
+		base:           flagConfig(),
+		current:        cfg,
+		poll:           2 * time.Second,
+		SourceChanges:  make(chan sourceChange),
+		bootstrap:      "kubebootstrap",
+		newBootstrap:   newBootstrap,
+		watchMaker:     kubewatchMaker,
+		restartWatches: restartWatches,
+		aggregator:     aggregator,
+		invoker:        invoker,
+		metrics:        wattMetrics,
 	}
 
 	apiServer := &apiServer{
-		port:    port,
-		invoker: invoker,
+		port:       port,
+		invoker:    invoker,
+		watchers:   watchers,
+		aggregator: aggregator,
+		reloader:   reloader,
+		socket:     apiSocket,
+	}
+	if err := secureAPI(apiServer); err != nil {
+		log.Println(err)
+		return 1
 	}
 
-	ctx := context.Background()
-	s := supervisor.WithContext(ctx)
+	// every worker requires the workers it sends to, so that they
+	// are ready to receive before it starts and are still around
+	// until it has shut down
+	s.Supervise(newBootstrap(cfg))
 
-	s.Supervise(&supervisor.Worker{
-		Name: "kubebootstrap",
-		Work: kubebootstrap.Work,
-	})
+	s.Supervise(consulwatches.AsWorker("consulwatches"))
 
 	s.Supervise(&supervisor.Worker{
-		Name: "consulwatchman",
-		Work: consulwatchman.Work,
+		Name:     "consulwatchman",
+		Work:     consulwatchman.Work,
+		Requires: []string{"consulwatches"},
 	})
 
 	s.Supervise(&supervisor.Worker{
@@ -118,8 +426,9 @@ func _runWatt(cmd *cobra.Command, args []string) int {
 	})
 
 	s.Supervise(&supervisor.Worker{
-		Name: "aggregator",
-		Work: aggregator.Work,
+		Name:     "aggregator",
+		Work:     aggregator.Work,
+		Requires: []string{"invoker", "kubewatchman", "consulwatchman"},
 	})
 
 	s.Supervise(&supervisor.Worker{
@@ -128,10 +437,26 @@ func _runWatt(cmd *cobra.Command, args []string) int {
 	})
 
 	s.Supervise(&supervisor.Worker{
-		Name: "api",
-		Work: apiServer.Work,
+		Name:     "api",
+		Work:     apiServer.Work,
+		Requires: []string{"invoker"},
+	})
+
+	s.Supervise(&supervisor.Worker{
+		Name:     "reloader",
+		Work:     reloader.Work,
+		Requires: []string{"aggregator", "invoker"},
 	})
 
+	if grpcPort != 0 {
+		grpcServer := &grpcServer{port: grpcPort, hub: invoker.hub, tls: apiServer.tls, auth: apiServer.auth}
+		s.Supervise(&supervisor.Worker{
+			Name:     "grpc",
+			Work:     grpcServer.Work,
+			Requires: []string{"invoker"},
+		})
+	}
+
 	if errs := s.Run(); len(errs) > 0 {
 		for _, err := range errs {
 			log.Println(err)
@@ -142,6 +467,96 @@ func _runWatt(cmd *cobra.Command, args []string) int {
 	return 0
 }
 
+// logCrash logs the details of a panic as a single json line, so that
+// crashes are easy to find in the logs and to report.
+func logCrash(crash *supervisor.PanicError) {
+	report := *crash
+	report.Value = fmt.Sprint(crash.Value)
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("crash report: %v", err)
+		return
+	}
+	log.Printf("crash report: %s", bytes)
+}
+
+// kubeClients returns a client configured with the options for the
+// cluster of each of the contexts, or for the current context without
+// a name if there are none.
+func kubeClients(options k8s.ClientOptions, contexts []string) (map[string]*k8s.Client, error) {
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+	clients := make(map[string]*k8s.Client, len(contexts))
+	for _, context := range contexts {
+		options.Context = context
+		client, err := k8s.NewClientWithOptions(options)
+		if err != nil {
+			return nil, err
+		}
+		clients[context] = client
+	}
+	return clients, nil
+}
+
+// replayClients is kubeClients for a recording, see --replay.
+func replayClients(path string, contexts []string, speed float64) (map[string]*k8s.Client, error) {
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+	clients := make(map[string]*k8s.Client, len(contexts))
+	for _, context := range contexts {
+		client, err := k8s.NewReplayClient(path, context, speed)
+		if err != nil {
+			return nil, err
+		}
+		clients[context] = client
+	}
+	return clients, nil
+}
+
+// secureAPI configures TLS and authentication on the API server as
+// given by --tls-cert, --tls-key, --tls-client-ca and --api-token-file,
+// and where the receivers fetch the snapshots from accordingly.
+func secureAPI(s *apiServer) error {
+	if (tlsCert == "") != (tlsKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	if tlsClientCA != "" && tlsCert == "" {
+		return fmt.Errorf("--tls-client-ca requires --tls-cert")
+	}
+
+	var token string
+	if apiTokenFile != "" {
+		var err error
+		token, err = readToken(apiTokenFile)
+		if err != nil {
+			return err
+		}
+	}
+	if tlsCert != "" {
+		config, err := newAPITLSConfig(tlsCert, tlsKey, tlsClientCA, token != "")
+		if err != nil {
+			return err
+		}
+		s.tls = config
+	}
+	if token != "" || tlsClientCA != "" {
+		s.auth = &apiAuth{token: token, clientCert: tlsClientCA != ""}
+	}
+
+	scheme := "http"
+	if s.tls != nil {
+		scheme = "https"
+	}
+	if s.socket != "" {
+		s.invoker.apiURL = fmt.Sprintf("%s+unix://%s", scheme, url.PathEscape(s.socket))
+	} else if s.tls != nil {
+		s.invoker.apiURL = fmt.Sprintf("https://localhost:%d", s.port)
+	}
+	return nil
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		log.Println(err)
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// latencyBuckets are the upper bounds of the histogram buckets of
+// durations, in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics are watt's own metrics and those of its webhooks, served at
+// /metrics along with those of its workers, so that one can alert when
+// watt falls behind. A nil *metrics records nothing.
+type metrics struct {
+	// how long it took from the first change to the snapshot that
+	// includes it, which includes the time spent waiting for the
+	// limiter and the watch hooks
+	generationLatency prometheus.Histogram
+	snapshots         prometheus.Counter
+	snapshotBytes     prometheus.Gauge
+	// events by source, i.e. by kubernetes kind or consul
+	events           *prometheus.CounterVec
+	hookDuration     prometheus.Histogram
+	hookRuns         *prometheus.CounterVec
+	receiverFailures *prometheus.CounterVec
+	consulEndpoints  *prometheus.GaugeVec
+	webhookRequests  *prometheus.CounterVec
+	webhookFailures  *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		generationLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "snapshot_generation_latency_seconds",
+			Help:    "Time from the first change a snapshot includes to the snapshot.",
+			Buckets: latencyBuckets,
+		}),
+		snapshots: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snapshots_total",
+			Help: "Snapshots generated.",
+		}),
+		snapshotBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "snapshot_size_bytes",
+			Help: "Size of the latest snapshot.",
+		}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "events_received_total",
+			Help: "Events received, by source.",
+		}, []string{"source"}),
+		hookDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "watch_hook_duration_seconds",
+			Help:    "Time the watch hooks took.",
+			Buckets: latencyBuckets,
+		}),
+		hookRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "watch_hook_runs_total",
+			Help: "Watch hook runs, by exit code, -1 if it didn't run or was killed.",
+		}, []string{"exit_code"}),
+		receiverFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "notify_receiver_failures_total",
+			Help: "Failed --notify receiver runs, by receiver.",
+		}, []string{"receiver"}),
+		consulEndpoints: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "consul_endpoints",
+			Help: "Consul endpoints, by service.",
+		}, []string{"service"}),
+		webhookRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "notify_webhook_requests_total",
+			Help: "Webhook notify requests, by url and result.",
+		}, []string{"url", "result"}),
+		webhookFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "notify_webhook_failures_total",
+			Help: "Snapshots that could not be delivered to a webhook, by url.",
+		}, []string{"url"}),
+	}
+}
+
+// register registers the metrics with the registerer, which is
+// expected to give their names the watt_ prefix.
+func (m *metrics) register(registerer prometheus.Registerer) error {
+	if m == nil {
+		return nil
+	}
+	for _, c := range []prometheus.Collector{m.generationLatency, m.snapshots, m.snapshotBytes, m.events,
+		m.hookDuration, m.hookRuns, m.receiverFailures, m.consulEndpoints, m.webhookRequests, m.webhookFailures} {
+		if err := registerer.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *metrics) eventReceived(source string) {
+	if m == nil {
+		return
+	}
+	m.events.WithLabelValues(source).Inc()
+}
+
+func (m *metrics) setConsulEndpoints(service string, count int) {
+	if m == nil {
+		return
+	}
+	m.consulEndpoints.WithLabelValues(service).Set(float64(count))
+}
+
+func (m *metrics) snapshotGenerated(latency time.Duration, size int) {
+	if m == nil {
+		return
+	}
+	m.generationLatency.Observe(latency.Seconds())
+	m.snapshots.Inc()
+	m.snapshotBytes.Set(float64(size))
+}
+
+// hookRan records a watch hook run that ended with err, if any.
+func (m *metrics) hookRan(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.hookDuration.Observe(duration.Seconds())
+	m.hookRuns.WithLabelValues(strconv.Itoa(exitCode(err))).Inc()
+}
+
+func (m *metrics) receiverFailed(receiver string) {
+	if m == nil {
+		return
+	}
+	m.receiverFailures.WithLabelValues(receiver).Inc()
+}
+
+// webhookAdded makes the requests and failures of a webhook show up
+// before the first one.
+func (m *metrics) webhookAdded(url string) {
+	if m == nil {
+		return
+	}
+	m.webhookRequests.WithLabelValues(url, "success")
+	m.webhookRequests.WithLabelValues(url, "failure")
+	m.webhookFailures.WithLabelValues(url)
+}
+
+// webhookRequested records a POST to a webhook that failed with err,
+// if any.
+func (m *metrics) webhookRequested(url string, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.webhookRequests.WithLabelValues(url, result).Inc()
+}
+
+// webhookFailed records a snapshot that couldn't be delivered to a
+// webhook.
+func (m *metrics) webhookFailed(url string) {
+	if m == nil {
+		return
+	}
+	m.webhookFailures.WithLabelValues(url).Inc()
+}
+
+// exitCode returns the exit code of a command that ended with err, or
+// -1 if it didn't run or was killed.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
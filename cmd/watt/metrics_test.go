@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// gather returns the metrics in the text exposition format.
+func gather(t *testing.T, m *metrics) string {
+	registry := prometheus.NewRegistry()
+	if err := m.register(prometheus.WrapRegistererWithPrefix("watt_", registry)); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	m.hookRan(30*time.Millisecond, nil)
+	m.hookRan(time.Second, exec.Command("sh", "-c", "exit 3").Run())
+	m.hookRan(time.Second, exec.Command("/nonexistent").Run())
+	m.receiverFailed("receiver")
+	m.setConsulEndpoints("bar", 2)
+	m.setConsulEndpoints("foo", 3)
+	m.setConsulEndpoints("foo", 1)
+
+	text := gather(t, m)
+	for _, line := range []string{
+		"# TYPE watt_watch_hook_duration_seconds histogram\n",
+		"watt_watch_hook_duration_seconds_bucket{le=\"0.05\"} 1\n",
+		"watt_watch_hook_duration_seconds_bucket{le=\"+Inf\"} 3\n",
+		"watt_watch_hook_duration_seconds_count 3\n",
+		"watt_watch_hook_runs_total{exit_code=\"-1\"} 1\n",
+		"watt_watch_hook_runs_total{exit_code=\"0\"} 1\n",
+		"watt_watch_hook_runs_total{exit_code=\"3\"} 1\n",
+		"watt_notify_receiver_failures_total{receiver=\"receiver\"} 1\n",
+		"watt_consul_endpoints{service=\"bar\"} 2\nwatt_consul_endpoints{service=\"foo\"} 1\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("expected %q in:\n%s", line, text)
+		}
+	}
+
+	// a nil *metrics records nothing
+	var none *metrics
+	none.eventReceived("service")
+	if text := gather(t, none); text != "" {
+		t.Errorf("expected no metrics, got:\n%s", text)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// streamSnapshots serves the snapshots as server-sent events, one
+// "snapshot" event per snapshot, or "stale-snapshot" for one restored
+// from before watt was restarted, with the id of the snapshot as the
+// event id and the snapshot as the data, or just its id with
+// ?content=false. The latest snapshot is sent right away, unless the
+// client says it already has it with a Last-Event-ID, e.g. when the
+// EventSource reconnects. A client that doesn't keep up skips to the
+// latest snapshot, see snapshotHub.
+func (s *apiServer) streamSnapshots(p *supervisor.Process, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	content := r.URL.Query().Get("content") != "false"
+	lastID, err := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		lastID = 0
+	}
+
+	sub := s.invoker.hub.subscribe()
+	defer sub.Close()
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// the stream ends when the client goes away, or when watt
+	// shuts down so that the server can
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-r.Context().Done():
+		case <-p.Shutdown():
+		}
+		close(done)
+	}()
+
+	for {
+		next := sub.Wait(done)
+		if next == nil {
+			return
+		}
+		if next.id == lastID {
+			continue
+		}
+		name := "snapshot"
+		if next.stale {
+			name = "stale-snapshot"
+		}
+		var event strings.Builder
+		fmt.Fprintf(&event, "id: %d\nevent: %s\n", next.id, name)
+		if content {
+			for _, line := range strings.Split(string(next.snapshot), "\n") {
+				fmt.Fprintf(&event, "data: %s\n", line)
+			}
+		} else {
+			fmt.Fprintf(&event, "data: %d\n", next.id)
+		}
+		event.WriteString("\n")
+		if _, err := w.Write([]byte(event.String())); err != nil {
+			p.Logf("write snapshot event error: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// readEvent reads the next server-sent event.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	var event strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		if line == "\n" {
+			return event.String()
+		}
+		event.WriteString(line)
+	}
+}
+
+func TestStreamSnapshots(t *testing.T) {
+	invoker := NewInvoker(0, nil)
+	s := &apiServer{invoker: invoker}
+	invoker.hub.publish(newStoredSnapshot(1, []byte("{\n    \"a\": 1\n}")))
+
+	errs := supervisor.Run("sse", func(p *supervisor.Process) error {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.streamSnapshots(p, w, r)
+		}))
+		defer srv.Close()
+
+		// a client that already has the latest snapshot waits for
+		// the next one
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Last-Event-ID", "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("content-type"); ct != "text/event-stream" {
+			t.Errorf("unexpected content type: %s", ct)
+		}
+		invoker.hub.publish(newStoredSnapshot(2, []byte("{\n    \"a\": 2\n}")))
+		event := readEvent(t, bufio.NewReader(resp.Body))
+		if event != "id: 2\nevent: snapshot\ndata: {\ndata:     \"a\": 2\ndata: }\n" {
+			t.Errorf("unexpected event: %q", event)
+		}
+
+		// a new client gets the latest snapshot right away
+		ids, err := http.Get(srv.URL + "?content=false")
+		if err != nil {
+			return err
+		}
+		defer ids.Body.Close()
+		event = readEvent(t, bufio.NewReader(ids.Body))
+		if event != "id: 2\nevent: snapshot\ndata: 2\n" {
+			t.Errorf("unexpected event: %q", event)
+		}
+		return nil
+	})
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// snapshotStore persists the most recent snapshots to a directory, see
+// --snapshot-dir, so that watt can serve the last one it knew of right
+// away when it restarts, rather than nothing until it has caught up
+// with kubernetes and consul. Each snapshot is stored in <id>.json.
+type snapshotStore struct {
+	dir  string
+	keep int
+}
+
+// save writes the snapshot, and removes the ones that are no longer
+// among the most recent.
+func (st *snapshotStore) save(snapshot *storedSnapshot) error {
+	if err := os.MkdirAll(st.dir, 0755); err != nil {
+		return err
+	}
+	// write and rename, so that a crash doesn't leave a partial
+	// snapshot behind
+	path := filepath.Join(st.dir, fmt.Sprintf("%d.json", snapshot.id))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, snapshot.snapshot, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	ids, err := st.ids()
+	if err != nil {
+		return err
+	}
+	for len(ids) > st.keep {
+		if err := os.Remove(filepath.Join(st.dir, fmt.Sprintf("%d.json", ids[0]))); err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// load reads the most recent snapshots, oldest first.
+func (st *snapshotStore) load() ([]*storedSnapshot, error) {
+	ids, err := st.ids()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > st.keep {
+		ids = ids[len(ids)-st.keep:]
+	}
+	var result []*storedSnapshot
+	for _, id := range ids {
+		path := filepath.Join(st.dir, fmt.Sprintf("%d.json", id))
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshot := newStoredSnapshot(id, bytes)
+		if info, err := os.Stat(path); err == nil {
+			snapshot.time = info.ModTime()
+		}
+		result = append(result, snapshot)
+	}
+	return result, nil
+}
+
+// ids returns the ids of the stored snapshots in ascending order.
+func (st *snapshotStore) ids() ([]int, error) {
+	files, err := ioutil.ReadDir(st.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
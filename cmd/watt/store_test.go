@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watt-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &snapshotStore{dir: dir, keep: 3}
+	for id := 1; id <= 5; id++ {
+		if err := store.save(newStoredSnapshot(id, []byte(fmt.Sprintf(`{"id": %d}`, id)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 3 {
+		t.Errorf("expected 3 files, got %d", len(files))
+	}
+
+	// a restarted invoker serves the restored snapshots as stale
+	// until it stores a fresh one
+	invoker := NewInvoker(0, nil)
+	invoker.store = store
+	if err := invoker.restore(); err != nil {
+		t.Fatal(err)
+	}
+	if invoker.getSnapshot(2) != nil {
+		t.Errorf("snapshot 2 should not be retained")
+	}
+	latest := invoker.getLatest()
+	if latest == nil || latest.id != 5 || !latest.stale || string(latest.snapshot) != `{"id": 5}` {
+		t.Errorf("unexpected latest snapshot: %+v", latest)
+	}
+	sub := invoker.hub.subscribe()
+	defer sub.Close()
+	if next := sub.take(); next != latest {
+		t.Errorf("the restored snapshot was not published: %+v", next)
+	}
+
+	id := invoker.storeSnapshot([]byte(`{"id": 6}`))
+	if latest := invoker.getLatest(); id != 6 || latest.stale {
+		t.Errorf("unexpected latest snapshot: %d %+v", id, latest)
+	}
+}
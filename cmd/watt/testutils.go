@@ -63,7 +63,12 @@ func expect(t *testing.T, ch interface{}, values ...interface{}) {
 					panic(fmt.Sprintf("predicate %d failed value %v", idx, value))
 				}
 			case func(string) bool:
-				if !exp(value.String()) {
+				str := value.String()
+				if value.Kind() == reflect.Slice {
+					// snapshots are []byte
+					str = string(value.Bytes())
+				}
+				if !exp(str) {
 					panic(fmt.Sprintf("predicate %d failed value %v", idx, value))
 				}
 			case func([]k8s.Resource) bool:
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// webhook is a receiver that is notified by POSTing each snapshot, or
+// just its URL, to an HTTP endpoint, see --notify-url. This is a lot
+// cheaper than forking a process for every snapshot.
+type webhook struct {
+	url     string
+	headers http.Header
+	// urlOnly says to post the URL of the snapshot on the API
+	// server rather than the snapshot itself
+	urlOnly bool
+	// a failed POST is retried up to retries times, after backoff,
+	// twice that, and so on
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	// metrics, if set, counts the requests and the snapshots that
+	// couldn't be delivered
+	metrics *metrics
+}
+
+func newWebhook(url string, headers http.Header, timeout time.Duration, retries int, backoff time.Duration) *webhook {
+	return &webhook{
+		url:     url,
+		headers: headers,
+		retries: retries,
+		backoff: backoff,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// parseHeaders parses headers given as "Name: value".
+func parseHeaders(headers []string) (http.Header, error) {
+	result := make(http.Header)
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("header %q is not of the form \"Name: value\"", h)
+		}
+		result.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return result, nil
+}
+
+// notify delivers the snapshot with the given URL, retrying failed
+// POSTs. It gives up early if the process is shut down.
+func (h *webhook) notify(p *supervisor.Process, snapshotURL string, snapshot []byte) error {
+	delay := h.backoff
+	for attempt := 0; ; attempt++ {
+		err := h.post(snapshotURL, snapshot)
+		h.metrics.webhookRequested(h.url, err)
+		if err == nil {
+			return nil
+		}
+		if attempt >= h.retries {
+			h.metrics.webhookFailed(h.url)
+			return err
+		}
+		p.Logf("notify %s failed, retrying after %s: %v", h.url, delay, err)
+		select {
+		case <-p.Supervisor().Clock.After(delay):
+		case <-p.Shutdown():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func (h *webhook) post(snapshotURL string, snapshot []byte) error {
+	body, contentType := snapshot, "application/json"
+	if h.urlOnly {
+		body, contentType = []byte(snapshotURL), "text/plain"
+	}
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range h.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("content-type", contentType)
+	req.Header.Set("x-watt-snapshot-url", snapshotURL)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s responded %s", h.url, resp.Status)
+	}
+	return nil
+}
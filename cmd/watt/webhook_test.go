@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+func TestWebhook(t *testing.T) {
+	var bodies []string
+	failures := 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected authorization: %q", r.Header.Get("Authorization"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	headers, err := parseHeaders([]string{"Authorization: Bearer secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newWebhook(srv.URL, headers, time.Second, 2, time.Millisecond)
+	h.metrics = newMetrics()
+	errs := supervisor.Run("notify", func(p *supervisor.Process) error {
+		if err := h.notify(p, "http://localhost/snapshots/1", []byte(`{"one": 1}`)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// with the retries used up the snapshot isn't delivered
+		failures = 3
+		if err := h.notify(p, "http://localhost/snapshots/2", []byte(`{"two": 2}`)); err == nil {
+			t.Errorf("expected an error")
+		}
+		failures = 0
+		h.urlOnly = true
+		return h.notify(p, "http://localhost/snapshots/3", []byte(`{"three": 3}`))
+	})
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if len(bodies) != 7 || bodies[2] != `{"one": 1}` || bodies[6] != "http://localhost/snapshots/3" {
+		t.Errorf("unexpected bodies: %q", bodies)
+	}
+
+	text := gather(t, h.metrics)
+	for _, line := range []string{
+		`watt_notify_webhook_requests_total{result="success",url="` + srv.URL + `"} 2`,
+		`watt_notify_webhook_requests_total{result="failure",url="` + srv.URL + `"} 5`,
+		`watt_notify_webhook_failures_total{url="` + srv.URL + `"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, text)
+		}
+	}
+}
+
+func TestWebhookShutdown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	h := newWebhook(srv.URL, nil, time.Second, 100, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := supervisor.WithContext(ctx)
+	s.Supervise(&supervisor.Worker{
+		Name: "notify",
+		Work: func(p *supervisor.Process) error {
+			p.Ready()
+			if err := h.notify(p, "http://localhost/snapshots/1", nil); err == nil {
+				t.Errorf("expected an error")
+			}
+			return nil
+		},
+	})
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if errs := s.Run(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	if _, err := parseHeaders([]string{"no colon"}); err == nil {
+		t.Errorf("expected an error")
+	}
+	headers, err := parseHeaders([]string{"X-One: 1", "X-One: 2", "X-Two:two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(headers["X-One"], ",") != "1,2" || headers.Get("X-Two") != "two" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+	"github.com/gorilla/websocket"
+)
+
+// wsMessage is what the API server and the clients of /snapshots/ws
+// send each other:
+//
+//   - the server sends {"type": "snapshot", "id": N, "hash": H} for
+//     every new snapshot, starting with the latest one, with
+//     "stale": true if it was restored from before watt was restarted
+//   - a client sends {"type": "get", "id": N} for the content of a
+//     snapshot, and the server answers with {"type": "body", "id": N,
+//     "snapshot": {...}}, or {"type": "error", "id": N, "error": E}
+//     if the snapshot isn't around anymore
+type wsMessage struct {
+	Type     string          `json:"type"`
+	ID       int             `json:"id"`
+	Hash     string          `json:"hash,omitempty"`
+	Stale    bool            `json:"stale,omitempty"`
+	Snapshot json.RawMessage `json:"snapshot,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// wsConn is the part of a websocket.Conn that pushSnapshots uses.
+type wsConn interface {
+	ReadJSON(v interface{}) error
+	WriteJSON(v interface{}) error
+}
+
+var upgrader = websocket.Upgrader{}
+
+// serveWebSocket upgrades the request to a websocket and pushes the
+// snapshots over it, see wsMessage.
+func (s *apiServer) serveWebSocket(p *supervisor.Process, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already responded with an error
+		p.Logf("websocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+	s.pushSnapshots(p, conn)
+}
+
+// pushSnapshots notifies the client of the snapshots and answers its
+// requests until either goes away. Closing the connection afterwards
+// stops the goroutine that reads the requests.
+func (s *apiServer) pushSnapshots(p *supervisor.Process, conn wsConn) {
+	sub := s.invoker.hub.subscribe()
+	defer sub.Close()
+
+	// a connection supports one reader and one writer at a time, so
+	// requests are read here and answered below
+	requests := make(chan wsMessage)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(requests)
+		for {
+			var req wsMessage
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			select {
+			case requests <- req:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	for {
+		var msg wsMessage
+		select {
+		case <-sub.Pending():
+			next := sub.take()
+			if next == nil {
+				continue
+			}
+			msg = wsMessage{Type: "snapshot", ID: next.id, Hash: next.hash, Stale: next.stale}
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			msg = s.answer(req)
+		case <-p.Shutdown():
+			return
+		}
+		if err := conn.WriteJSON(msg); err != nil {
+			p.Logf("websocket write error: %v", err)
+			return
+		}
+	}
+}
+
+func (s *apiServer) answer(req wsMessage) wsMessage {
+	if req.Type != "get" {
+		return wsMessage{Type: "error", ID: req.ID, Error: "unknown request type: " + req.Type}
+	}
+	snapshot := s.invoker.getSnapshot(req.ID)
+	if snapshot == nil {
+		return wsMessage{Type: "error", ID: req.ID, Error: "not found"}
+	}
+	return wsMessage{Type: "body", ID: req.ID, Snapshot: snapshot.snapshot}
+}
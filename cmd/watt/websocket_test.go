@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+type fakeWSConn struct {
+	in  chan wsMessage
+	out chan wsMessage
+}
+
+func (c *fakeWSConn) ReadJSON(v interface{}) error {
+	msg, ok := <-c.in
+	if !ok {
+		return io.EOF
+	}
+	*v.(*wsMessage) = msg
+	return nil
+}
+
+func (c *fakeWSConn) WriteJSON(v interface{}) error {
+	c.out <- v.(wsMessage)
+	return nil
+}
+
+func TestPushSnapshots(t *testing.T) {
+	invoker := NewInvoker(0, nil)
+	s := &apiServer{invoker: invoker}
+	id := invoker.storeSnapshot([]byte(`{"a": 1}`))
+
+	errs := supervisor.Run("ws", func(p *supervisor.Process) error {
+		conn := &fakeWSConn{in: make(chan wsMessage), out: make(chan wsMessage)}
+		done := make(chan struct{})
+		go func() {
+			s.pushSnapshots(p, conn)
+			close(done)
+		}()
+
+		msg := <-conn.out
+		if msg.Type != "snapshot" || msg.ID != id || msg.Hash != newStoredSnapshot(id, []byte(`{"a": 1}`)).hash {
+			t.Errorf("unexpected notification: %+v", msg)
+		}
+
+		conn.in <- wsMessage{Type: "get", ID: id}
+		msg = <-conn.out
+		if msg.Type != "body" || msg.ID != id || string(msg.Snapshot) != `{"a": 1}` {
+			t.Errorf("unexpected body: %+v", msg)
+		}
+
+		conn.in <- wsMessage{Type: "get", ID: id + 1}
+		msg = <-conn.out
+		if msg.Type != "error" || msg.Error != "not found" {
+			t.Errorf("unexpected error: %+v", msg)
+		}
+
+		// the client going away ends the push
+		close(conn.in)
+		<-done
+		return nil
+	})
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"syscall"
 
 	"github.com/datawire/teleproxy/internal/pkg/dns"
 	"github.com/datawire/teleproxy/internal/pkg/interceptor"
@@ -15,10 +16,18 @@ import (
 
 type APIServer struct {
 	listener net.Listener
+	handler  *http.ServeMux
 	server   http.Server
 }
 
-func NewAPIServer(iceptor *interceptor.Interceptor) (*APIServer, error) {
+// NewAPIServer returns an APIServer for the given interceptor. The
+// flush function is invoked to flush the system dns cache whenever
+// the routing tables change; if it is nil, dns.Flush is used.
+func NewAPIServer(iceptor *interceptor.Interceptor, flush func()) (*APIServer, error) {
+	if flush == nil {
+		flush = dns.Flush
+	}
+
 	handler := http.NewServeMux()
 	tables := "/api/tables/"
 	handler.HandleFunc(tables, func(w http.ResponseWriter, r *http.Request) {
@@ -42,7 +51,7 @@ func NewAPIServer(iceptor *interceptor.Interceptor) (*APIServer, error) {
 				for _, t := range table {
 					iceptor.Update(t)
 				}
-				dns.Flush()
+				flush()
 			}
 		case http.MethodDelete:
 			iceptor.Delete(table)
@@ -69,6 +78,14 @@ func NewAPIServer(iceptor *interceptor.Interceptor) (*APIServer, error) {
 			}
 		}
 	})
+	handler.HandleFunc("/api/reapply", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Reapplying configuration\n"))
+		p, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			panic(err)
+		}
+		p.Signal(syscall.SIGHUP)
+	})
 	handler.HandleFunc("/api/shutdown", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Goodbye!\n"))
 		p, err := os.FindProcess(os.Getpid())
@@ -85,12 +102,19 @@ func NewAPIServer(iceptor *interceptor.Interceptor) (*APIServer, error) {
 
 	return &APIServer{
 		listener: ln,
+		handler:  handler,
 		server: http.Server{
 			Handler: handler,
 		},
 	}, nil
 }
 
+// Handle registers an additional handler with the server. It must be
+// called before Start.
+func (a *APIServer) Handle(pattern string, handler http.Handler) {
+	a.handler.Handle(pattern, handler)
+}
+
 func (a *APIServer) Port() string {
 	_, port, err := net.SplitHostPort(a.listener.Addr().String())
 	if err != nil {
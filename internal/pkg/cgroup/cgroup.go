@@ -0,0 +1,61 @@
+// Package cgroup manages the cgroup v2 groups that teleproxy uses to
+// tag the processes whose traffic it should intercept.
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// Root is where the cgroup v2 hierarchy is mounted.
+const Root = "/sys/fs/cgroup"
+
+// Procs returns the file that a process id is written to in order to
+// move that process into the given group.
+func Procs(path string) string {
+	return filepath.Join(Root, path, "cgroup.procs")
+}
+
+// Create creates the group with the given path (relative to Root).
+// If teleproxy was started via sudo, the invoking user is allowed to
+// move processes into the group.
+func Create(p *supervisor.Process, path string) error {
+	if _, err := os.Stat(filepath.Join(Root, "cgroup.controllers")); err != nil {
+		return errors.Wrapf(err, "no cgroup v2 hierarchy at %s", Root)
+	}
+
+	dir := filepath.Join(Root, path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+		gid, err := strconv.Atoi(os.Getenv("SUDO_GID"))
+		if err != nil {
+			gid = -1
+		}
+		for _, name := range []string{dir, Procs(path)} {
+			if err := os.Chown(name, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.Logf("created cgroup %s, to intercept a process run: echo PID > %s", dir, Procs(path))
+	return nil
+}
+
+// Remove removes the group. This fails if there are still processes
+// in it, in which case they are left alone.
+func Remove(p *supervisor.Process, path string) {
+	dir := filepath.Join(Root, path)
+	if err := os.Remove(dir); err != nil {
+		p.Logf("could not remove cgroup %s: %v", dir, err)
+	}
+}
@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// This is where NetworkManager looks for dispatcher scripts.
+const dispatcherDir = "/etc/NetworkManager/dispatcher.d"
+
+// The NetworkManager events after which we reapply our configuration.
+const dispatcherScript = `#!/bin/sh
+# Installed by teleproxy, and removed again when it exits. Asks
+# teleproxy to reapply its dns and routing configuration whenever
+# NetworkManager changes the network state.
+case "$2" in
+    up|down|vpn-up|vpn-down|dhcp4-change|dhcp6-change|connectivity-change|dns-change)
+        %s >/dev/null 2>&1 || true
+        ;;
+esac
+`
+
+// InstallDispatcher installs a NetworkManager dispatcher script
+// called name that runs the given shell command after network state
+// changes. It returns a function that removes the script again.
+func InstallDispatcher(p *supervisor.Process, name, command string) (func(), error) {
+	if runtime.GOOS != "linux" {
+		return nil, errors.Errorf("NetworkManager is not supported on %s", runtime.GOOS)
+	}
+	if _, err := os.Stat(dispatcherDir); err != nil {
+		return nil, errors.Wrap(err, "NetworkManager does not appear to be installed")
+	}
+
+	script := filepath.Join(dispatcherDir, name)
+	// the dispatcher ignores scripts that are writable by anyone
+	// but root
+	err := ioutil.WriteFile(script, []byte(fmt.Sprintf(dispatcherScript, command)), 0755)
+	if err != nil {
+		return nil, err
+	}
+	p.Logf("installed NetworkManager dispatcher script %s", script)
+
+	return func() {
+		RemoveDispatcher(p, name)
+	}, nil
+}
+
+// RemoveDispatcher removes a dispatcher script installed by
+// InstallDispatcher.
+func RemoveDispatcher(p *supervisor.Process, name string) {
+	script := filepath.Join(dispatcherDir, name)
+	if err := os.Remove(script); err != nil {
+		p.Log(err)
+	} else {
+		p.Logf("removed NetworkManager dispatcher script %s", script)
+	}
+}
@@ -1,9 +1,11 @@
 package dns
 
 import (
+	"fmt"
 	_log "log"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
@@ -11,10 +13,29 @@ import (
 	"github.com/datawire/teleproxy/pkg/supervisor"
 )
 
+// SRV is the target of an SRV record.
+type SRV struct {
+	Target string
+	Port   uint16
+}
+
 type Server struct {
 	Listeners []string
 	Fallback  string
-	Resolve   func(string) string
+	// Resolve returns the addresses of the given domain, or
+	// nothing if the domain should be resolved by the Fallback.
+	Resolve func(string) []string
+	// CNAME, if set, returns the name that the given domain is
+	// an alias for, or "" if it isn't an alias.
+	CNAME func(string) string
+	// SRV, if set, returns the SRV records of the given domain.
+	SRV func(string) []SRV
+	// QueryLog, if set, enables logging of the queries that pass
+	// its filters.
+	QueryLog *QueryLog
+	// Metrics, if set, accumulates statistics about the queries
+	// handled.
+	Metrics *Metrics
 }
 
 func log(line string, args ...interface{}) {
@@ -22,12 +43,28 @@ func log(line string, args ...interface{}) {
 }
 
 func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
 	domain := strings.ToLower(r.Question[0].Name)
+	if s.CNAME != nil {
+		if target := s.CNAME(domain); target != "" {
+			s.serveCNAME(w, r, domain, dns.Fqdn(target), start)
+			return
+		}
+	}
 	switch r.Question[0].Qtype {
+	case dns.TypeSRV:
+		var records []SRV
+		if s.SRV != nil {
+			records = s.SRV(domain)
+		}
+		if len(records) > 0 {
+			s.serveSRV(w, r, domain, records, start)
+			return
+		}
 	case dns.TypeA:
-		ip := s.Resolve(domain)
-		if ip != "" {
-			log("QUERY %s -> %s", domain, ip)
+		ips := s.Resolve(domain)
+		if len(ips) > 0 {
+			log("QUERY %s -> %s", domain, strings.Join(ips, ", "))
 			msg := dns.Msg{}
 			msg.SetReply(r)
 			msg.Authoritative = true
@@ -39,21 +76,26 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			// if we don't give back the same domain
 			// requested, then mac dns seems to return an
 			// nxdomain
-			msg.Answer = append(msg.Answer, &dns.A{
-				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
-				A:   net.ParseIP(ip),
-			})
+			for _, ip := range ips {
+				msg.Answer = append(msg.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.ParseIP(ip),
+				})
+			}
+			s.QueryLog.log(r.Question[0].Qtype, domain, strings.Join(ips, ", "))
+			s.Metrics.observe(r.Question[0].Qtype, SourceCluster, msg.Rcode, time.Since(start))
 			w.WriteMsg(&msg)
 			return
 		}
 	default:
-		ip := s.Resolve(domain)
-		if ip != "" {
+		if len(s.Resolve(domain)) > 0 {
 			log("QTYPE[%v] %s -> EMPTY", r.Question[0].Qtype, domain)
 			msg := dns.Msg{}
 			msg.SetReply(r)
 			msg.Authoritative = true
 			msg.RecursionAvailable = true
+			s.QueryLog.log(r.Question[0].Qtype, domain, "EMPTY")
+			s.Metrics.observe(r.Question[0].Qtype, SourceCluster, msg.Rcode, time.Since(start))
 			w.WriteMsg(&msg)
 			return
 		}
@@ -62,11 +104,96 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	in, err := dns.Exchange(r, s.Fallback)
 	if err != nil {
 		log(err.Error())
+		s.QueryLog.log(r.Question[0].Qtype, domain, "FALLBACK ERROR: "+err.Error())
+		s.Metrics.failed(r.Question[0].Qtype)
 		return
 	}
+	s.QueryLog.log(r.Question[0].Qtype, domain, "FALLBACK "+dns.RcodeToString[in.Rcode])
+	s.Metrics.observe(r.Question[0].Qtype, SourceUpstream, in.Rcode, time.Since(start))
 	w.WriteMsg(in)
 }
 
+// serveCNAME answers a query for an alias. Like the cluster dns, we
+// chase the alias on behalf of the client, since stub resolvers
+// generally won't.
+func (s *Server) serveCNAME(w dns.ResponseWriter, r *dns.Msg, domain, target string, start time.Time) {
+	qtype := r.Question[0].Qtype
+	log("QTYPE[%v] %s -> CNAME %s", qtype, domain, target)
+	msg := dns.Msg{}
+	msg.SetReply(r)
+	msg.Authoritative = true
+	msg.RecursionAvailable = true
+	msg.Answer = append(msg.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+		Target: target,
+	})
+
+	if qtype != dns.TypeCNAME {
+		if ips := s.Resolve(target); len(ips) > 0 {
+			if qtype == dns.TypeA {
+				for _, ip := range ips {
+					msg.Answer = append(msg.Answer, &dns.A{
+						Hdr: dns.RR_Header{Name: target, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+						A:   net.ParseIP(ip),
+					})
+				}
+			}
+		} else {
+			query := dns.Msg{}
+			query.SetQuestion(target, qtype)
+			query.RecursionDesired = true
+			in, err := dns.Exchange(&query, s.Fallback)
+			if err != nil {
+				log(err.Error())
+			} else {
+				msg.Answer = append(msg.Answer, in.Answer...)
+			}
+		}
+	}
+
+	s.QueryLog.log(qtype, domain, "CNAME "+target)
+	s.Metrics.observe(qtype, SourceCluster, msg.Rcode, time.Since(start))
+	w.WriteMsg(&msg)
+}
+
+// serveSRV answers an SRV query. Like the cluster dns, all records
+// have the same priority and share the weight, and the addresses of
+// the targets are included as additional records.
+func (s *Server) serveSRV(w dns.ResponseWriter, r *dns.Msg, domain string, records []SRV, start time.Time) {
+	log("QTYPE[%v] %s -> %d SRV records", r.Question[0].Qtype, domain, len(records))
+	msg := dns.Msg{}
+	msg.SetReply(r)
+	msg.Authoritative = true
+	msg.RecursionAvailable = true
+
+	weight := uint16(100 / len(records))
+	if weight == 0 {
+		weight = 1
+	}
+	var targets []string
+	for _, record := range records {
+		target := dns.Fqdn(record.Target)
+		targets = append(targets, fmt.Sprintf("%s:%d", target, record.Port))
+		msg.Answer = append(msg.Answer, &dns.SRV{
+			Hdr:      dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60},
+			Priority: 0,
+			Weight:   weight,
+			Port:     record.Port,
+			Target:   target,
+		})
+		for _, ip := range s.Resolve(target) {
+			msg.Extra = append(msg.Extra, &dns.A{
+				Hdr: dns.RR_Header{Name: target, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			})
+		}
+	}
+
+	s.QueryLog.log(r.Question[0].Qtype, domain, strings.Join(targets, ", "))
+	s.Metrics.observe(r.Question[0].Qtype, SourceCluster, msg.Rcode, time.Since(start))
+	w.WriteMsg(&msg)
+}
+
 func (s *Server) Start(p *supervisor.Process) error {
 	listeners := make([]net.PacketConn, len(s.Listeners))
 	for i, addr := range s.Listeners {
@@ -0,0 +1,173 @@
+package dns
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// These are the ways in which a query can be answered.
+const (
+	// the query was answered from the routing table
+	SourceCluster = "cluster"
+	// the query was forwarded to the fallback server
+	SourceUpstream = "upstream"
+)
+
+// The upper bounds (in seconds) of the resolution latency histogram
+// buckets.
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Metrics accumulates statistics about the queries handled by a
+// Server. It is safe for concurrent use, and a nil *Metrics discards
+// everything. Metrics implements http.Handler, rendering the
+// statistics in the Prometheus text exposition format.
+type Metrics struct {
+	mutex    sync.Mutex
+	queries  map[string]uint64
+	answers  map[string]uint64
+	rcodes   map[string]uint64
+	failures uint64
+	latency  map[string]*histogram
+}
+
+// observe records a query of the given type that was answered from
+// the given source with the given rcode in the given amount of time.
+func (m *Metrics) observe(qtype uint16, source string, rcode int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.queries == nil {
+		m.queries = make(map[string]uint64)
+		m.answers = make(map[string]uint64)
+		m.rcodes = make(map[string]uint64)
+		m.latency = make(map[string]*histogram)
+	}
+
+	m.queries[typeString(qtype)]++
+	m.answers[source]++
+	m.rcodes[dns.RcodeToString[rcode]]++
+	h, ok := m.latency[source]
+	if !ok {
+		h = &histogram{}
+		m.latency[source] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// failed records a query that could not be answered at all because
+// the fallback server could not be reached.
+func (m *Metrics) failed(qtype uint16) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.queries == nil {
+		m.queries = make(map[string]uint64)
+	}
+	m.queries[typeString(qtype)]++
+	m.failures++
+}
+
+func typeString(qtype uint16) string {
+	if s, ok := dns.TypeToString[qtype]; ok {
+		return s
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var n int64
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err != nil {
+			return
+		}
+		var c int
+		c, err = fmt.Fprintf(w, format, args...)
+		n += int64(c)
+	}
+
+	counter := func(name, help, label string, values map[string]uint64) {
+		printf("# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, k := range sortedKeys(values) {
+			printf("%s{%s=%q} %d\n", name, label, k, values[k])
+		}
+	}
+
+	counter("teleproxy_dns_queries_total", "DNS queries received, by query type.", "type", m.queries)
+	counter("teleproxy_dns_answers_total", "DNS queries answered, by source.", "source", m.answers)
+	counter("teleproxy_dns_responses_total", "DNS responses sent, by rcode.", "rcode", m.rcodes)
+
+	printf("# HELP teleproxy_dns_upstream_failures_total DNS queries that could not be forwarded upstream.\n")
+	printf("# TYPE teleproxy_dns_upstream_failures_total counter\n")
+	printf("teleproxy_dns_upstream_failures_total %d\n", m.failures)
+
+	name := "teleproxy_dns_resolution_duration_seconds"
+	printf("# HELP %s Time taken to answer DNS queries, by source.\n# TYPE %s histogram\n", name, name)
+	sources := make([]string, 0, len(m.latency))
+	for source := range m.latency {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		h := m.latency[source]
+		for i, bound := range latencyBuckets {
+			printf("%s_bucket{source=%q,le=\"%g\"} %d\n", name, source, bound, h.counts[i])
+		}
+		printf("%s_bucket{source=%q,le=\"+Inf\"} %d\n", name, source, h.count)
+		printf("%s_sum{source=%q} %g\n", name, source, h.sum)
+		printf("%s_count{source=%q} %d\n", name, source, h.count)
+	}
+
+	return n, err
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
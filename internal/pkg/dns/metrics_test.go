@@ -0,0 +1,45 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMetrics(t *testing.T) {
+	m := &Metrics{}
+	m.observe(dns.TypeA, SourceCluster, dns.RcodeSuccess, 200*time.Microsecond)
+	m.observe(dns.TypeA, SourceUpstream, dns.RcodeNameError, 30*time.Millisecond)
+	m.observe(dns.TypeAAAA, SourceUpstream, dns.RcodeSuccess, 2*time.Second)
+	m.failed(dns.TypeA)
+
+	var out strings.Builder
+	_, err := m.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`teleproxy_dns_queries_total{type="A"} 3`,
+		`teleproxy_dns_queries_total{type="AAAA"} 1`,
+		`teleproxy_dns_answers_total{source="cluster"} 1`,
+		`teleproxy_dns_answers_total{source="upstream"} 2`,
+		`teleproxy_dns_responses_total{rcode="NXDOMAIN"} 1`,
+		`teleproxy_dns_upstream_failures_total 1`,
+		`teleproxy_dns_resolution_duration_seconds_bucket{source="cluster",le="0.0005"} 1`,
+		`teleproxy_dns_resolution_duration_seconds_bucket{source="upstream",le="0.025"} 0`,
+		`teleproxy_dns_resolution_duration_seconds_bucket{source="upstream",le="0.05"} 1`,
+		`teleproxy_dns_resolution_duration_seconds_bucket{source="upstream",le="+Inf"} 2`,
+		`teleproxy_dns_resolution_duration_seconds_count{source="upstream"} 2`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out.String())
+		}
+	}
+
+	var disabled *Metrics
+	disabled.observe(dns.TypeA, SourceCluster, dns.RcodeSuccess, time.Millisecond)
+	disabled.failed(dns.TypeA)
+}
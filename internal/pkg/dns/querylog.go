@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"path"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// QueryLog controls the opt-in dns query log. Each query that passes
+// the filters is logged along with how it was answered, which makes
+// it possible to figure out why a given name does not resolve
+// through teleproxy without reaching for tcpdump.
+//
+// Include and Exclude hold shell-style glob patterns (see
+// path.Match) matched against the lowercased query name without the
+// trailing dot. A query is logged if it matches at least one Include
+// pattern (or there are no Include patterns) and does not match any
+// Exclude pattern. If ClusterOnly is set, only queries ending in
+// ClusterSuffix are considered at all.
+type QueryLog struct {
+	Include       []string
+	Exclude       []string
+	ClusterOnly   bool
+	ClusterSuffix string
+}
+
+// Matches returns true if the given query name should be logged.
+func (q *QueryLog) Matches(domain string) bool {
+	if q == nil {
+		return false
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	if q.ClusterOnly {
+		suffix := strings.Trim(strings.ToLower(q.ClusterSuffix), ".")
+		if name != suffix && !strings.HasSuffix(name, "."+suffix) {
+			return false
+		}
+	}
+
+	if len(q.Include) > 0 && !matchAny(q.Include, name) {
+		return false
+	}
+
+	return !matchAny(q.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		matched, err := path.Match(strings.ToLower(pattern), name)
+		if err != nil {
+			log("bad query log pattern %q: %v", pattern, err)
+			continue
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *QueryLog) log(qtype uint16, domain, result string) {
+	if q.Matches(domain) {
+		log("QUERY-LOG %s %s -> %s", dns.TypeToString[qtype], domain, result)
+	}
+}
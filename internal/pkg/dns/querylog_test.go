@@ -0,0 +1,38 @@
+package dns
+
+import (
+	"testing"
+)
+
+func TestQueryLogMatches(t *testing.T) {
+	ql := &QueryLog{
+		Include:       []string{"*.svc.cluster.local", "*.example.com"},
+		Exclude:       []string{"kube-dns.*"},
+		ClusterOnly:   false,
+		ClusterSuffix: "cluster.local",
+	}
+
+	for domain, expected := range map[string]bool{
+		"foo.default.svc.cluster.local.":          true,
+		"kube-dns.kube-system.svc.cluster.local.": false,
+		"www.example.com.":                        true,
+		"www.google.com.":                         false,
+	} {
+		if ql.Matches(domain) != expected {
+			t.Errorf("%s: expected %v", domain, expected)
+		}
+	}
+
+	ql = &QueryLog{ClusterOnly: true, ClusterSuffix: "cluster.local"}
+	if !ql.Matches("Foo.Default.svc.cluster.local.") {
+		t.Errorf("expected cluster query to match")
+	}
+	if ql.Matches("www.google.com.") {
+		t.Errorf("expected non-cluster query not to match")
+	}
+
+	var disabled *QueryLog
+	if disabled.Matches("foo.default.svc.cluster.local.") {
+		t.Errorf("expected nil query log not to match")
+	}
+}
@@ -0,0 +1,133 @@
+package dns
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// These are the system resolver managers we know how to integrate
+// with.
+const (
+	RESOLVED   = "systemd-resolved"
+	RESOLVCONF = "resolvconf"
+)
+
+// This is the dummy interface that systemd-resolved associates our
+// nameserver and routing domains with.
+const resolvedLink = "teleproxy0"
+
+// This is the name under which we register with resolvconf. The "lo."
+// prefix sorts it ahead of the real interfaces in the default
+// interface order of both debian resolvconf and openresolv.
+const resolvconfRecord = "lo.teleproxy"
+
+// DetectResolver returns the resolver manager in use on this system,
+// or "" if there isn't one we know how to integrate with.
+func DetectResolver() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	if _, err := os.Stat("/run/systemd/resolve/stub-resolv.conf"); err == nil {
+		if _, err := exec.LookPath("resolvectl"); err == nil {
+			return RESOLVED
+		}
+	}
+	if _, err := exec.LookPath("resolvconf"); err == nil {
+		return RESOLVCONF
+	}
+	return ""
+}
+
+// ConfigureResolver registers ip as a nameserver with the given
+// resolver manager, leaving whatever other nameservers it manages
+// (e.g. those of a VPN client) in place. Domains are the routing
+// domains (in systemd-resolved syntax, e.g. "~cluster.local") that
+// should be sent to ip. It returns a function that undoes the
+// registration.
+func ConfigureResolver(p *supervisor.Process, manager, ip string, domains []string) (func(), error) {
+	switch manager {
+	case RESOLVED:
+		return configureResolved(p, ip, domains)
+	case RESOLVCONF:
+		return configureResolvconf(p, ip, domains)
+	default:
+		return nil, errors.Errorf("unsupported resolver: %q", manager)
+	}
+}
+
+// UnconfigureResolver undoes ConfigureResolver. It doesn't need
+// anything but the resolver manager, so that it can be used to clean
+// up after a teleproxy that is no longer around.
+func UnconfigureResolver(p *supervisor.Process, manager string) {
+	switch manager {
+	case RESOLVED:
+		run(p, "", "resolvectl", "revert", resolvedLink)
+		run(p, "", "ip", "link", "del", resolvedLink)
+	case RESOLVCONF:
+		run(p, "", "resolvconf", "-d", resolvconfRecord)
+	}
+}
+
+func run(p *supervisor.Process, stdin string, name string, args ...string) error {
+	output, err := p.Command(name, args...).Capture(strings.NewReader(stdin))
+	if err != nil {
+		return errors.Wrapf(err, "%s %s: %s", name, strings.Join(args, " "), strings.TrimSpace(output))
+	}
+	return nil
+}
+
+func configureResolved(p *supervisor.Process, ip string, domains []string) (func(), error) {
+	// systemd-resolved wants nameservers to belong to a link, so
+	// we make one up
+	run(p, "", "ip", "link", "del", resolvedLink)
+	restore := func() {
+		UnconfigureResolver(p, RESOLVED)
+	}
+
+	steps := [][]string{
+		{"ip", "link", "add", resolvedLink, "type", "dummy"},
+		{"ip", "addr", "add", ip + "/32", "dev", resolvedLink},
+		{"ip", "link", "set", resolvedLink, "up"},
+		{"resolvectl", "dns", resolvedLink, ip},
+		append([]string{"resolvectl", "domain", resolvedLink}, domains...),
+	}
+	for _, step := range steps {
+		if err := run(p, "", step[0], step[1:]...); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+
+	return restore, nil
+}
+
+func configureResolvconf(p *supervisor.Process, ip string, domains []string) (func(), error) {
+	// resolvconf has no notion of routing domains, so the best we
+	// can do is to be the first nameserver, and use the plain
+	// domains as search domains
+	record := "nameserver " + ip + "\n"
+	var search []string
+	for _, d := range domains {
+		if !strings.HasPrefix(d, "~") {
+			search = append(search, d)
+		}
+	}
+	if len(search) > 0 {
+		record += "search " + strings.Join(search, " ") + "\n"
+	}
+
+	err := run(p, record, "resolvconf", "-a", resolvconfRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		UnconfigureResolver(p, RESOLVCONF)
+	}, nil
+}
@@ -17,6 +17,26 @@ func OverrideSearchDomains(p *supervisor.Process, domains string) func() {
 		return func() {}
 	}
 
+	previous := saveSearchDomains(p)
+	for _, prev := range previous {
+		// setup dns search path
+		setSearchDomains(p, prev.Interface, domains)
+	}
+
+	return restoreSearchDomains(p, previous)
+}
+
+// SaveSearchDomains records the current dns search domains, and
+// returns a function that restores them.
+func SaveSearchDomains(p *supervisor.Process) func() {
+	if runtime.GOOS != "darwin" {
+		return func() {}
+	}
+
+	return restoreSearchDomains(p, saveSearchDomains(p))
+}
+
+func saveSearchDomains(p *supervisor.Process) []searchDomains {
 	ifaces, err := getIfaces(p)
 	if err != nil {
 		panic(err)
@@ -24,17 +44,19 @@ func OverrideSearchDomains(p *supervisor.Process, domains string) func() {
 	previous := []searchDomains{}
 
 	for _, iface := range ifaces {
-		// setup dns search path
 		domain, err := getSearchDomains(p, iface)
 		if err != nil {
 			log("DNS: error getting search domain for interface %v: %v", iface, err)
 		} else {
-			setSearchDomains(p, iface, domains)
 			previous = append(previous, searchDomains{iface, domain})
 		}
 	}
 
-	// return function to restore dns search paths
+	return previous
+}
+
+// return function to restore dns search paths
+func restoreSearchDomains(p *supervisor.Process, previous []searchDomains) func() {
 	return func() {
 		for _, prev := range previous {
 			setSearchDomains(p, prev.Interface, prev.Domains)
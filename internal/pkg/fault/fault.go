@@ -0,0 +1,135 @@
+package fault
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Fault describes the artificial degradation applied to a proxied
+// connection: a fixed latency, a random jitter on top of that
+// latency, and the probability that the connection fails outright.
+type Fault struct {
+	Latency     time.Duration
+	Jitter      time.Duration
+	FailureRate float64
+}
+
+// Zero reports whether the fault does nothing at all.
+func (f Fault) Zero() bool {
+	return f.Latency == 0 && f.Jitter == 0 && f.FailureRate == 0
+}
+
+// Delay returns how long to wait before relaying data, i.e. the
+// latency plus a random amount of jitter.
+func (f Fault) Delay() time.Duration {
+	delay := f.Latency
+	if f.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(f.Jitter)))
+	}
+	return delay
+}
+
+// Fail randomly decides whether a connection should be failed based
+// on the failure rate.
+func (f Fault) Fail() bool {
+	return f.FailureRate > 0 && rand.Float64() < f.FailureRate
+}
+
+func (f Fault) String() string {
+	return fmt.Sprintf("latency=%s,jitter=%s,fail=%g", f.Latency, f.Jitter, f.FailureRate)
+}
+
+// Table maps destination service names to the fault that should be
+// injected for that service. The empty name applies to every
+// destination that doesn't have a more specific entry.
+type Table map[string]Fault
+
+// Parse decodes a fault specification of the form:
+//
+//   [SERVICE:]latency=DURATION,jitter=DURATION,fail=RATE
+//
+// Any of the settings may be omitted. If SERVICE is omitted, the
+// fault applies to all intercepted destinations.
+func Parse(spec string) (service string, fault Fault, err error) {
+	settings := spec
+	if idx := strings.Index(spec, ":"); idx >= 0 {
+		service = spec[:idx]
+		settings = spec[idx+1:]
+	}
+
+	for _, setting := range strings.Split(settings, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return "", Fault{}, fmt.Errorf("%s: expecting KEY=VALUE, got %q", spec, setting)
+		}
+		key, value := parts[0], parts[1]
+		switch key {
+		case "latency":
+			fault.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			fault.Jitter, err = time.ParseDuration(value)
+		case "fail":
+			fault.FailureRate, err = strconv.ParseFloat(value, 64)
+			if err == nil && (fault.FailureRate < 0 || fault.FailureRate > 1) {
+				err = fmt.Errorf("failure rate must be between 0 and 1")
+			}
+		default:
+			err = fmt.Errorf("unrecognized setting")
+		}
+		if err != nil {
+			return "", Fault{}, fmt.Errorf("%s: %s: %v", spec, key, err)
+		}
+	}
+
+	return
+}
+
+// Set implements flag.Value so that a Table can be populated from
+// repeated command line flags.
+func (t Table) Set(spec string) error {
+	service, fault, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	t[strings.ToLower(service)] = fault
+	return nil
+}
+
+func (t Table) String() string {
+	var specs []string
+	for service, fault := range t {
+		specs = append(specs, fmt.Sprintf("%s:%s", service, fault))
+	}
+	return strings.Join(specs, " ")
+}
+
+// Lookup returns the fault for the named service. A service entry
+// matches the name either exactly or as a dotted prefix, so "foo" and
+// "foo.default" both match "foo.default.svc.cluster.local".
+func (t Table) Lookup(name string) Fault {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	best := ""
+	found := false
+	for service := range t {
+		if service == "" {
+			continue
+		}
+		if name == service || strings.HasPrefix(name, service+".") {
+			if !found || len(service) > len(best) {
+				best = service
+				found = true
+			}
+		}
+	}
+	if found {
+		return t[best]
+	}
+	return t[""]
+}
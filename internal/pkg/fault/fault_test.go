@@ -0,0 +1,58 @@
+package fault
+
+import (
+	"testing"
+	"time"
+)
+
+var specs = []struct {
+	in      string
+	service string
+	out     Fault
+	err     string
+}{
+	{"latency=100ms", "", Fault{Latency: 100 * time.Millisecond}, ""},
+	{"foo.default:latency=1s,jitter=200ms,fail=0.5", "foo.default",
+		Fault{Latency: time.Second, Jitter: 200 * time.Millisecond, FailureRate: 0.5}, ""},
+	{"fail=2", "", Fault{}, "fail=2: fail: failure rate must be between 0 and 1"},
+	{"bogus=1", "", Fault{}, "bogus=1: bogus: unrecognized setting"},
+	{"latency", "", Fault{}, `latency: expecting KEY=VALUE, got "latency"`},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range specs {
+		service, fault, err := Parse(tt.in)
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Errorf("got %v, expected %v", err, tt.err)
+			}
+			continue
+		}
+		if tt.err != "" {
+			t.Errorf("expected error %v", tt.err)
+		}
+		if service != tt.service || fault != tt.out {
+			t.Errorf("got %s %v, expected %s %v", service, fault, tt.service, tt.out)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table := Table{}
+	for _, spec := range []string{"latency=1s", "foo:latency=2s", "foo.bar:latency=3s"} {
+		if err := table.Set(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, expected := range map[string]time.Duration{
+		"baz.default.svc.cluster.local.": time.Second,
+		"foo.default.svc.cluster.local.": 2 * time.Second,
+		"foo.bar.svc.cluster.local.":     3 * time.Second,
+		"foobar.default":                 time.Second,
+	} {
+		if got := table.Lookup(name).Latency; got != expected {
+			t.Errorf("%s: got %s, expected %s", name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"net"
+	"net/rpc"
+	"runtime"
+
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/internal/pkg/nat"
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// Client talks to a running helper. It implements
+// interceptor.Translator so that an unprivileged Interceptor can
+// program the firewall through the helper.
+type Client struct {
+	rpc *rpc.Client
+	// On linux the original destination of a redirected
+	// connection can be recovered without any privileges, so we
+	// do that locally rather than asking the helper.
+	local *nat.Translator
+}
+
+// Dial connects to the helper listening on the given unix socket.
+func Dial(socket string) (*Client, error) {
+	c, err := rpc.Dial("unix", socket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to teleproxy helper at %s", socket)
+	}
+	return &Client{rpc: c, local: nat.NewTranslator("")}, nil
+}
+
+// call invokes the named helper method. Like the firewall operations
+// of nat.Translator, failures are reported by panicking, which the
+// supervisor will turn into a worker error.
+func (c *Client) call(method string, args interface{}) {
+	err := c.rpc.Call("Helper."+method, args, &Empty{})
+	if err != nil {
+		panic(errors.Wrap(err, method))
+	}
+}
+
+func (c *Client) Enable(p *supervisor.Process) {
+	c.call("Enable", Empty{})
+}
+
+func (c *Client) Disable(p *supervisor.Process) {
+	c.call("Disable", Empty{})
+}
+
+func (c *Client) ForwardTCP(p *supervisor.Process, ip, toPort string) {
+	c.call("Forward", Rule{Proto: "tcp", Ip: ip, Port: toPort})
+}
+
+func (c *Client) ForwardUDP(p *supervisor.Process, ip, toPort string) {
+	c.call("Forward", Rule{Proto: "udp", Ip: ip, Port: toPort})
+}
+
+func (c *Client) ClearTCP(p *supervisor.Process, ip string) {
+	c.call("Clear", Rule{Proto: "tcp", Ip: ip})
+}
+
+func (c *Client) ClearUDP(p *supervisor.Process, ip string) {
+	c.call("Clear", Rule{Proto: "udp", Ip: ip})
+}
+
+func (c *Client) GetOriginalDst(conn *net.TCPConn) (rawaddr []byte, host string, err error) {
+	if runtime.GOOS == "linux" {
+		return c.local.GetOriginalDst(conn)
+	}
+
+	err = c.rpc.Call("Helper.OriginalDst", Conn{
+		Remote: conn.RemoteAddr().String(),
+		Local:  conn.LocalAddr().String(),
+	}, &host)
+	return nil, host, err
+}
+
+// OverrideSearchDomains asks the helper to override the system dns
+// search domains, and returns a function that restores them.
+func (c *Client) OverrideSearchDomains(domains string) func() {
+	c.call("OverrideSearchDomains", domains)
+	return func() {
+		c.call("RestoreSearchDomains", Empty{})
+	}
+}
+
+// Flush asks the helper to flush the system dns cache.
+func (c *Client) Flush() {
+	// flushing is best effort, just like dns.Flush
+	_ = c.rpc.Call("Helper.Flush", Empty{}, &Empty{})
+}
+
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
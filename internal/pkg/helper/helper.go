@@ -0,0 +1,248 @@
+// Package helper implements privilege separation for teleproxy. The
+// helper is a small process that runs as root and performs the
+// handful of operations that actually require privileges (programming
+// the firewall and changing the system resolver configuration) on
+// behalf of an unprivileged teleproxy process. The two talk over
+// net/rpc on a unix socket that only the invoking user may access.
+package helper
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/internal/pkg/dns"
+	"github.com/datawire/teleproxy/internal/pkg/nat"
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+// DefaultSocket is where the helper listens unless told otherwise.
+const DefaultSocket = "/var/run/teleproxy-helper.sock"
+
+// Empty is used for rpc arguments and replies that carry no data.
+type Empty struct{}
+
+// Rule identifies a firewall redirect.
+type Rule struct {
+	Proto string
+	Ip    string
+	Port  string
+}
+
+// Conn identifies a redirected connection by its endpoints.
+type Conn struct {
+	Remote string
+	Local  string
+}
+
+// Helper is the rpc receiver exposed by the privileged helper
+// process. Every client connection gets its own Helper, which keeps
+// track of what that client changed so that it can be undone when the
+// client goes away. All methods of all Helpers are serialized.
+type Helper struct {
+	process    *supervisor.Process
+	translator *nat.Translator
+	enabled    bool
+	restore    func()
+	mutex      *sync.Mutex
+}
+
+func (h *Helper) do(f func()) (err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("HELPER PANICKED: %v\n%s", r, debug.Stack())
+			h.process.Log(err)
+		}
+	}()
+	f()
+	return nil
+}
+
+func (h *Helper) Enable(_ Empty, _ *Empty) error {
+	return h.do(func() {
+		h.translator.Enable(h.process)
+		h.enabled = true
+	})
+}
+
+func (h *Helper) Disable(_ Empty, _ *Empty) error {
+	return h.do(func() {
+		h.translator.Disable(h.process)
+		h.enabled = false
+	})
+}
+
+func (h *Helper) Forward(rule Rule, _ *Empty) error {
+	return h.do(func() {
+		switch rule.Proto {
+		case "tcp":
+			h.translator.ForwardTCP(h.process, rule.Ip, rule.Port)
+		case "udp":
+			h.translator.ForwardUDP(h.process, rule.Ip, rule.Port)
+		default:
+			panic(fmt.Sprintf("unrecognized protocol: %s", rule.Proto))
+		}
+	})
+}
+
+func (h *Helper) Clear(rule Rule, _ *Empty) error {
+	return h.do(func() {
+		switch rule.Proto {
+		case "tcp":
+			h.translator.ClearTCP(h.process, rule.Ip)
+		case "udp":
+			h.translator.ClearUDP(h.process, rule.Ip)
+		default:
+			panic(fmt.Sprintf("unrecognized protocol: %s", rule.Proto))
+		}
+	})
+}
+
+func (h *Helper) OriginalDst(conn Conn, host *string) error {
+	remote, err := net.ResolveTCPAddr("tcp", conn.Remote)
+	if err != nil {
+		return err
+	}
+	local, err := net.ResolveTCPAddr("tcp", conn.Local)
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	*host, err = h.translator.OriginalDst(remote, local)
+	return err
+}
+
+func (h *Helper) OverrideSearchDomains(domains string, _ *Empty) error {
+	return h.do(func() {
+		if h.restore != nil {
+			h.restore()
+		}
+		h.restore = dns.OverrideSearchDomains(h.process, domains)
+	})
+}
+
+func (h *Helper) RestoreSearchDomains(_ Empty, _ *Empty) error {
+	return h.do(func() {
+		if h.restore != nil {
+			h.restore()
+			h.restore = nil
+		}
+	})
+}
+
+func (h *Helper) Flush(_ Empty, _ *Empty) error {
+	return h.do(dns.Flush)
+}
+
+// cleanup undoes anything the client left behind, e.g. because it
+// went away without shutting down cleanly.
+func (h *Helper) cleanup() {
+	h.do(func() {
+		if h.restore != nil {
+			h.process.Log("restoring search domains")
+			h.restore()
+			h.restore = nil
+		}
+		if h.enabled {
+			h.process.Log("disabling firewall rules")
+			h.translator.Disable(h.process)
+			h.enabled = false
+		}
+		dns.Flush()
+	})
+}
+
+// Serve runs the helper on the given unix socket until the process is
+// asked to shut down. Whatever a client changed is undone when its
+// connection closes, e.g. because teleproxy crashed, or when the
+// helper shuts down. If the helper was started via sudo, the socket
+// is handed over to the invoking user so that the unprivileged
+// teleproxy can connect to it; otherwise only root may connect.
+func Serve(p *supervisor.Process, socket, name string) error {
+	if os.Geteuid() != 0 {
+		return errors.New("the teleproxy helper must be run as root")
+	}
+
+	os.Remove(socket)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	if err := os.Chmod(socket, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+		gid, err := strconv.Atoi(os.Getenv("SUDO_GID"))
+		if err != nil {
+			gid = -1
+		}
+		if err := os.Chown(socket, uid, gid); err != nil {
+			ln.Close()
+			return err
+		}
+		p.Logf("allowing uid %d to connect", uid)
+	}
+
+	translator := nat.NewTranslator(name)
+	serialize := &sync.Mutex{}
+
+	var mutex sync.Mutex
+	clients := make(map[*Helper]net.Conn)
+
+	p.Logf("listening on %s", socket)
+	p.Ready()
+
+	p.Go(func(p *supervisor.Process) error {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				// the listener was closed
+				return nil
+			}
+			h := &Helper{process: p, translator: translator, mutex: serialize}
+			srv := rpc.NewServer()
+			if err := srv.RegisterName("Helper", h); err != nil {
+				conn.Close()
+				return err
+			}
+			mutex.Lock()
+			clients[h] = conn
+			mutex.Unlock()
+			p.Logf("client connected")
+			go func() {
+				srv.ServeConn(conn)
+				mutex.Lock()
+				_, ok := clients[h]
+				delete(clients, h)
+				mutex.Unlock()
+				if ok {
+					p.Logf("client disconnected")
+					h.cleanup()
+				}
+			}()
+		}
+	})
+
+	<-p.Shutdown()
+	ln.Close()
+	mutex.Lock()
+	defer mutex.Unlock()
+	for h, conn := range clients {
+		conn.Close()
+		h.cleanup()
+		delete(clients, h)
+	}
+	return nil
+}
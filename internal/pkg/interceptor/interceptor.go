@@ -13,12 +13,27 @@ import (
 	rt "github.com/datawire/teleproxy/internal/pkg/route"
 )
 
+// Translator is the set of firewall operations the interceptor relies
+// upon. It is implemented by *nat.Translator, and by helper.Client
+// when the firewall is programmed by a separate privileged process.
+type Translator interface {
+	Enable(p *supervisor.Process)
+	Disable(p *supervisor.Process)
+	ForwardTCP(p *supervisor.Process, ip, toPort string)
+	ForwardUDP(p *supervisor.Process, ip, toPort string)
+	ClearTCP(p *supervisor.Process, ip string)
+	ClearUDP(p *supervisor.Process, ip string)
+	GetOriginalDst(conn *net.TCPConn) (rawaddr []byte, host string, err error)
+}
+
 type Interceptor struct {
-	translator *nat.Translator
+	translator Translator
 	tables     map[string]rt.Table
 	tablesLock sync.RWMutex
 
-	domains     map[string]rt.Route
+	// a domain maps to several routes when it has several
+	// addresses, e.g. for headless services
+	domains     map[string][]rt.Route
 	domainsLock sync.RWMutex
 
 	search     []string
@@ -28,10 +43,16 @@ type Interceptor struct {
 }
 
 func NewInterceptor(name string) *Interceptor {
+	return NewInterceptorWithTranslator(nat.NewTranslator(name))
+}
+
+// NewInterceptorWithTranslator returns an Interceptor that programs
+// the firewall through the supplied Translator.
+func NewInterceptorWithTranslator(translator Translator) *Interceptor {
 	ret := &Interceptor{
 		tables:     make(map[string]rt.Table),
-		translator: nat.NewTranslator(name),
-		domains:    make(map[string]rt.Route),
+		translator: translator,
+		domains:    make(map[string][]rt.Route),
 		search:     []string{""},
 		work:       make(chan func(*supervisor.Process) error),
 	}
@@ -66,6 +87,16 @@ func (i *Interceptor) Work(p *supervisor.Process) error {
 // or nil on failure. This implementation does not count the number of
 // dots in the query.
 func (i *Interceptor) Resolve(query string) *rt.Route {
+	routes := i.ResolveAll(query)
+	if len(routes) == 0 {
+		return nil
+	}
+	return &routes[0]
+}
+
+// ResolveAll is like Resolve, but returns all the routes for the
+// first name in the search path that has any.
+func (i *Interceptor) ResolveAll(query string) []rt.Route {
 	if !strings.HasSuffix(query, ".") {
 		query += "."
 	}
@@ -77,14 +108,74 @@ func (i *Interceptor) Resolve(query string) *rt.Route {
 
 	for _, suffix := range i.search {
 		name := query + suffix
-		value, ok := i.domains[strings.ToLower(name)]
+		routes, ok := i.domains[strings.ToLower(name)]
 		if ok {
-			return &value
+			return append([]rt.Route(nil), routes...)
+		}
+	}
+	return nil
+}
+
+// ResolveIP returns the route whose ip matches the given ip, or nil
+// if the ip isn't associated with any named route.
+func (i *Interceptor) ResolveIP(ip string) *rt.Route {
+	i.domainsLock.RLock()
+	defer i.domainsLock.RUnlock()
+
+	for _, routes := range i.domains {
+		for _, route := range routes {
+			if route.Ip == ip {
+				return &route
+			}
 		}
 	}
 	return nil
 }
 
+// store records the route under its domain, replacing any route with
+// the same key. It assumes that .domainsLock is held for writing.
+func (i *Interceptor) store(route rt.Route) {
+	domain := route.Domain()
+	routes := i.domains[domain]
+	for idx, r := range routes {
+		if keyOf(r) == keyOf(route) {
+			routes[idx] = route
+			return
+		}
+	}
+	i.domains[domain] = append(routes, route)
+}
+
+// forget is the inverse of store.
+func (i *Interceptor) forget(route rt.Route) {
+	domain := route.Domain()
+	routes := i.domains[domain]
+	for idx, r := range routes {
+		if keyOf(r) == keyOf(route) {
+			routes = append(routes[:idx:idx], routes[idx+1:]...)
+			break
+		}
+	}
+	if len(routes) == 0 {
+		delete(i.domains, domain)
+	} else {
+		i.domains[domain] = routes
+	}
+}
+
+// Routes are identified by name and ip (or SRV target), since a name
+// may have several of them.
+type routeKey struct {
+	name string
+	ip   string
+	host string
+	port string
+}
+
+func keyOf(route rt.Route) routeKey {
+	return routeKey{route.Name, route.Ip, route.Host, route.Port}
+}
+
 func (i *Interceptor) Destination(conn *net.TCPConn) (string, error) {
 	_, host, err := i.translator.GetOriginalDst(conn)
 	return host, err
@@ -174,56 +265,77 @@ func (i *Interceptor) Update(table rt.Table) {
 func (i *Interceptor) update(p *supervisor.Process, table rt.Table) error {
 	oldTable, ok := i.tables[table.Name]
 
-	oldRoutes := make(map[string]rt.Route)
+	oldRoutes := make(map[routeKey]rt.Route)
 	if ok {
 		for _, route := range oldTable.Routes {
-			oldRoutes[route.Name] = route
+			oldRoutes[keyOf(route)] = route
 		}
 	}
 
+	// the old versions of the routes that changed or were removed,
+	// whose addresses may no longer be forwarded, see unforward
+	var replaced []rt.Route
+
 	for _, newRoute := range table.Routes {
-		oldRoute, oldRouteOk := oldRoutes[newRoute.Name]
+		oldRoute, oldRouteOk := oldRoutes[keyOf(newRoute)]
 		// A nil Route (when oldRouteOk != true) will compare
 		// inequal to any valid new Route.
 		if newRoute != oldRoute {
-			// delete the old version
 			if oldRouteOk {
-				switch newRoute.Proto {
-				case "tcp":
-					i.translator.ClearTCP(p, oldRoute.Ip)
-				case "udp":
-					i.translator.ClearUDP(p, oldRoute.Ip)
-				default:
-					log.Printf("INT: unrecognized protocol: %v", newRoute)
-				}
+				replaced = append(replaced, oldRoute)
 			}
-			// and add the new version
+			// add the new version
 			if newRoute.Target != "" {
-				switch newRoute.Proto {
-				case "tcp":
-					i.translator.ForwardTCP(p, newRoute.Ip, newRoute.Target)
-				case "udp":
-					i.translator.ForwardUDP(p, newRoute.Ip, newRoute.Target)
-				default:
-					log.Printf("INT: unrecognized protocol: %v", newRoute)
-				}
+				i.forward(p, newRoute)
 			}
 
 			if newRoute.Name != "" {
 				log.Printf("INT: STORE %v->%v", newRoute.Domain(), newRoute)
-				i.domains[newRoute.Domain()] = newRoute
+				i.store(newRoute)
 			}
 		}
 
 		// remove the route from our map of old routes so we
 		// don't end up deleting it below
-		delete(oldRoutes, newRoute.Name)
+		delete(oldRoutes, keyOf(newRoute))
 	}
 
 	for _, route := range oldRoutes {
 		log.Printf("INT: CLEAR %v->%v", route.Domain(), route)
-		delete(i.domains, route.Domain())
+		if route.Name != "" {
+			i.forget(route)
+		}
+		replaced = append(replaced, route)
+	}
+
+	if table.Routes == nil || len(table.Routes) == 0 {
+		delete(i.tables, table.Name)
+	} else {
+		i.tables[table.Name] = table
+	}
+
+	i.unforward(p, replaced)
+
+	return nil
+}
 
+// unforward clears the forwarding of the addresses of the given
+// routes, which are no longer current. Routes that had no target or no
+// ip never forwarded anything, and an address that another route
+// still forwards, e.g. the ip of a pod behind several headless
+// services, stays forwarded to the target of that route. It assumes
+// that .tablesLock is held for writing.
+func (i *Interceptor) unforward(p *supervisor.Process, routes []rt.Route) {
+	for _, route := range routes {
+		if route.Target == "" || route.Ip == "" {
+			continue
+		}
+		if current, ok := i.forwarding(route.Proto, route.Ip); ok {
+			if current.Target != route.Target {
+				i.forward(p, current)
+			}
+			continue
+		}
 		switch route.Proto {
 		case "tcp":
 			i.translator.ClearTCP(p, route.Ip)
@@ -232,16 +344,56 @@ func (i *Interceptor) update(p *supervisor.Process, table rt.Table) error {
 		default:
 			log.Printf("INT: unrecognized protocol: %v", route)
 		}
+	}
+}
 
+// forwarding returns a current route that forwards the address, if
+// any. It assumes that .tablesLock is held.
+func (i *Interceptor) forwarding(proto, ip string) (rt.Route, bool) {
+	for _, table := range i.tables {
+		for _, route := range table.Routes {
+			if route.Target != "" && route.Proto == proto && route.Ip == ip {
+				return route, true
+			}
+		}
 	}
+	return rt.Route{}, false
+}
 
-	if table.Routes == nil || len(table.Routes) == 0 {
-		delete(i.tables, table.Name)
-	} else {
-		i.tables[table.Name] = table
+func (i *Interceptor) forward(p *supervisor.Process, route rt.Route) {
+	switch route.Proto {
+	case "tcp":
+		i.translator.ForwardTCP(p, route.Ip, route.Target)
+	case "udp":
+		i.translator.ForwardUDP(p, route.Ip, route.Target)
+	default:
+		log.Printf("INT: unrecognized protocol: %v", route)
 	}
+}
 
-	return nil
+// Refresh reprograms the firewall from scratch with the current set of
+// routes. This is useful when something else may have clobbered our
+// rules, e.g. a firewall reload after a network change.
+func (i *Interceptor) Refresh() {
+	result := make(chan struct{})
+	i.work <- func(p *supervisor.Process) error {
+		defer close(result)
+		i.tablesLock.RLock()
+		defer i.tablesLock.RUnlock()
+
+		i.translator.Disable(p)
+		i.translator.Enable(p)
+		for _, table := range i.tables {
+			for _, route := range table.Routes {
+				if route.Target == "" {
+					continue
+				}
+				i.forward(p, route)
+			}
+		}
+		return nil
+	}
+	<-result
 }
 
 // SetSearchPath updates the DNS search path used by the resolver
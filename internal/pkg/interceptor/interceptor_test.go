@@ -0,0 +1,85 @@
+package interceptor
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+
+	rt "github.com/datawire/teleproxy/internal/pkg/route"
+)
+
+// translator records what is forwarded where, keyed by "proto ip".
+type translator struct {
+	forwarded map[string]string
+	cleared   []string
+}
+
+func (t *translator) Enable(p *supervisor.Process)  {}
+func (t *translator) Disable(p *supervisor.Process) {}
+
+func (t *translator) ForwardTCP(p *supervisor.Process, ip, toPort string) {
+	t.forwarded["tcp "+ip] = toPort
+}
+
+func (t *translator) ForwardUDP(p *supervisor.Process, ip, toPort string) {
+	t.forwarded["udp "+ip] = toPort
+}
+
+func (t *translator) ClearTCP(p *supervisor.Process, ip string) {
+	t.cleared = append(t.cleared, "tcp "+ip)
+	delete(t.forwarded, "tcp "+ip)
+}
+
+func (t *translator) ClearUDP(p *supervisor.Process, ip string) {
+	t.cleared = append(t.cleared, "udp "+ip)
+	delete(t.forwarded, "udp "+ip)
+}
+
+func (t *translator) GetOriginalDst(conn *net.TCPConn) ([]byte, string, error) {
+	return nil, "", nil
+}
+
+func TestUpdateSharedIP(t *testing.T) {
+	tr := &translator{forwarded: make(map[string]string)}
+	i := NewInterceptorWithTranslator(tr)
+
+	// a pod behind two headless services
+	i.update(nil, rt.Table{Name: "kubernetes", Routes: []rt.Route{
+		{Name: "foo", Ip: "10.0.0.1", Proto: "tcp", Target: "1234"},
+		{Name: "bar", Ip: "10.0.0.1", Proto: "tcp", Target: "1234"},
+		{Name: "_http._tcp.foo", Proto: "tcp", Host: "foo", Port: "80"},
+		{Name: "baz", Ip: "10.0.0.2", Proto: "tcp"},
+	}})
+	expected := map[string]string{"tcp 10.0.0.1": "1234"}
+	if !reflect.DeepEqual(tr.forwarded, expected) {
+		t.Errorf("expected %v forwarded, got %v", expected, tr.forwarded)
+	}
+
+	// removing one of them, and the routes that never forwarded
+	// anything, clears nothing
+	i.update(nil, rt.Table{Name: "kubernetes", Routes: []rt.Route{
+		{Name: "bar", Ip: "10.0.0.1", Proto: "tcp", Target: "1234"},
+	}})
+	if !reflect.DeepEqual(tr.forwarded, expected) || len(tr.cleared) != 0 {
+		t.Errorf("expected %v forwarded and nothing cleared, got %v and cleared %v",
+			expected, tr.forwarded, tr.cleared)
+	}
+
+	// the same goes for a route of another table
+	i.update(nil, rt.Table{Name: "other", Routes: []rt.Route{
+		{Name: "other", Ip: "10.0.0.1", Proto: "tcp", Target: "1234"},
+	}})
+	i.update(nil, rt.Table{Name: "other"})
+	if !reflect.DeepEqual(tr.forwarded, expected) || len(tr.cleared) != 0 {
+		t.Errorf("expected %v forwarded and nothing cleared, got %v and cleared %v",
+			expected, tr.forwarded, tr.cleared)
+	}
+
+	// removing the last one clears the ip
+	i.update(nil, rt.Table{Name: "kubernetes"})
+	if len(tr.forwarded) != 0 || !reflect.DeepEqual(tr.cleared, []string{"tcp 10.0.0.1"}) {
+		t.Errorf("expected tcp 10.0.0.1 cleared, got %v forwarded and cleared %v", tr.forwarded, tr.cleared)
+	}
+}
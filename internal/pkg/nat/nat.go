@@ -9,6 +9,14 @@ import (
 type commonTranslator struct {
 	Name     string
 	Mappings map[Address]string
+	// If Interface is set, only traffic arriving on that interface
+	// is intercepted, and locally originated traffic is left
+	// alone. This is currently only supported with iptables.
+	Interface string
+	// If Match is set, only locally originated traffic that
+	// satisfies these iptables match arguments (e.g. "-m",
+	// "owner", "--uid-owner", "1000") is intercepted.
+	Match []string
 }
 
 type Address struct {
@@ -3,6 +3,7 @@
 package nat
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"syscall"
@@ -23,24 +24,41 @@ func (t *Translator) ipt(p *supervisor.Process, args ...string) {
 	cmd.Wait()
 }
 
-func (t *Translator) Enable(p *supervisor.Process) {
-	// XXX: -D only removes one copy of the rule, need to figure out how to remove all copies just in case
-	t.ipt(p, "-D", "OUTPUT", "-j", t.Name)
+// hooks returns the builtin chains (along with any match arguments)
+// that jump to our chain.
+func (t *Translator) hooks() [][]string {
+	if t.Interface != "" {
+		return [][]string{{"PREROUTING", "-i", t.Interface}}
+	}
+	if len(t.Match) > 0 {
+		// forwarded traffic has no owner, so there is no
+		// point in looking at PREROUTING
+		return [][]string{append([]string{"OUTPUT"}, t.Match...)}
+	}
 	// we need to be in the PREROUTING chain in order to get traffic
 	// from docker containers, not sure you would *always* want this,
 	// but probably makes sense as a default
-	t.ipt(p, "-D", "PREROUTING", "-j", t.Name)
+	return [][]string{{"OUTPUT"}, {"PREROUTING"}}
+}
+
+func (t *Translator) Enable(p *supervisor.Process) {
+	// XXX: -D only removes one copy of the rule, need to figure out how to remove all copies just in case
+	for _, hook := range t.hooks() {
+		t.ipt(p, append(append([]string{"-D"}, hook...), "-j", t.Name)...)
+	}
 	t.ipt(p, "-N", t.Name)
 	t.ipt(p, "-F", t.Name)
-	t.ipt(p, "-I", "OUTPUT", "1", "-j", t.Name)
-	t.ipt(p, "-I", "PREROUTING", "1", "-j", t.Name)
+	for _, hook := range t.hooks() {
+		t.ipt(p, append(append([]string{"-I", hook[0], "1"}, hook[1:]...), "-j", t.Name)...)
+	}
 	t.ipt(p, "-A", t.Name, "-j", "RETURN", "--dest", "127.0.0.1/32", "-p", "tcp")
 }
 
 func (t *Translator) Disable(p *supervisor.Process) {
 	// XXX: -D only removes one copy of the rule, need to figure out how to remove all copies just in case
-	t.ipt(p, "-D", "OUTPUT", "-j", t.Name)
-	t.ipt(p, "-D", "PREROUTING", "-j", t.Name)
+	for _, hook := range t.hooks() {
+		t.ipt(p, append(append([]string{"-D"}, hook...), "-j", t.Name)...)
+	}
 	t.ipt(p, "-F", t.Name)
 	t.ipt(p, "-X", t.Name)
 }
@@ -74,6 +92,13 @@ func (t *Translator) clear(p *supervisor.Process, protocol, ip string) {
 	}
 }
 
+// OriginalDst is not supported with iptables; the original
+// destination can only be recovered from the connection itself (see
+// GetOriginalDst), which does not require any privileges on linux.
+func (t *Translator) OriginalDst(remote, local *net.TCPAddr) (string, error) {
+	return "", errors.New("OriginalDst is not supported on linux, use GetOriginalDst")
+}
+
 const (
 	SO_ORIGINAL_DST      = 80
 	IP6T_SO_ORIGINAL_DST = 80
@@ -143,10 +143,19 @@ func (t *Translator) clear(protocol, ip string) {
 func (t *Translator) GetOriginalDst(conn *net.TCPConn) (rawaddr []byte, host string, err error) {
 	remote := conn.RemoteAddr().(*net.TCPAddr)
 	local := conn.LocalAddr().(*net.TCPAddr)
+	host, err = t.OriginalDst(remote, local)
+	return nil, host, err
+}
+
+// OriginalDst looks up the original destination of a redirected
+// connection given its endpoints. Unlike GetOriginalDst, this does
+// not require access to the connection itself, which lets a
+// privileged helper perform the lookup on behalf of another process.
+func (t *Translator) OriginalDst(remote, local *net.TCPAddr) (string, error) {
 	addr, port, err := t.dev.NatLook(remote.IP.String(), remote.Port, local.IP.String(), local.Port)
 	if err != nil {
-		return
+		return "", err
 	}
 
-	return nil, fmt.Sprintf("%s:%d", addr, port), nil
+	return fmt.Sprintf("%s:%d", addr, port), nil
 }
@@ -0,0 +1,174 @@
+// Package netns sets up a linux network namespace that is wired to
+// the host through a veth pair. Traffic leaving the namespace enters
+// the host on a dedicated interface, so it can be intercepted without
+// touching the way the host itself reaches the network.
+package netns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+const ipForward = "/proc/sys/net/ipv4/ip_forward"
+
+// A Sandbox is a network namespace along with the veth pair and
+// firewall rules that connect it to the host.
+type Sandbox struct {
+	Name   string
+	HostIf string
+	NsIf   string
+	// HostIP is the address of the host end of the veth pair. It
+	// is the default gateway and the nameserver of the namespace.
+	HostIP string
+	// NsIP is the address of the namespace end of the veth pair.
+	NsIP string
+
+	// the previous value of ipForward, restored on teardown
+	forward string
+}
+
+// New returns a Sandbox whose names and addresses are derived from
+// the given id (typically a pid) so that several sandboxes can
+// coexist.
+func New(id int) *Sandbox {
+	// carve a /30 out of 10.213.0.0/16
+	n := id % (1 << 14)
+	base := fmt.Sprintf("10.213.%d.", n>>6)
+	host := (n & 63) * 4
+	return &Sandbox{
+		Name:   fmt.Sprintf("teleproxy-%d", id),
+		HostIf: fmt.Sprintf("tph%d", id),
+		NsIf:   fmt.Sprintf("tpn%d", id),
+		HostIP: fmt.Sprintf("%s%d", base, host+1),
+		NsIP:   fmt.Sprintf("%s%d", base, host+2),
+	}
+}
+
+func (s *Sandbox) subnet() string {
+	return s.NsIP + "/30"
+}
+
+func (s *Sandbox) resolvConf() string {
+	return filepath.Join("/etc/netns", s.Name)
+}
+
+func (s *Sandbox) run(p *supervisor.Process, name string, args ...string) error {
+	output, err := p.Command(name, args...).Capture(nil)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s: %s", name, strings.Join(args, " "), strings.TrimSpace(output))
+	}
+	return nil
+}
+
+func (s *Sandbox) nsrun(p *supervisor.Process, args ...string) error {
+	return s.run(p, "ip", append([]string{"netns", "exec", s.Name}, args...)...)
+}
+
+// Setup creates the namespace. If anything goes wrong, whatever was
+// already set up is torn down again.
+func (s *Sandbox) Setup(p *supervisor.Process) (err error) {
+	if runtime.GOOS != "linux" {
+		return errors.Errorf("network namespaces are not supported on %s", runtime.GOOS)
+	}
+
+	defer func() {
+		if err != nil {
+			s.Teardown(p)
+		}
+	}()
+
+	steps := [][]string{
+		{"ip", "netns", "add", s.Name},
+		{"ip", "link", "add", s.HostIf, "type", "veth", "peer", "name", s.NsIf},
+		{"ip", "link", "set", s.NsIf, "netns", s.Name},
+		{"ip", "addr", "add", s.HostIP + "/30", "dev", s.HostIf},
+		{"ip", "link", "set", s.HostIf, "up"},
+		// let the namespace reach the rest of the world through us
+		{"iptables", "-I", "FORWARD", "-i", s.HostIf, "-j", "ACCEPT"},
+		{"iptables", "-I", "FORWARD", "-o", s.HostIf, "-j", "ACCEPT"},
+		{"iptables", "-t", "nat", "-A", "POSTROUTING", "-s", s.subnet(), "-j", "MASQUERADE"},
+	}
+	for _, step := range steps {
+		if err = s.run(p, step[0], step[1:]...); err != nil {
+			return
+		}
+	}
+
+	nsteps := [][]string{
+		{"ip", "link", "set", "lo", "up"},
+		{"ip", "addr", "add", s.NsIP + "/30", "dev", s.NsIf},
+		{"ip", "link", "set", s.NsIf, "up"},
+		{"ip", "route", "add", "default", "via", s.HostIP},
+	}
+	for _, step := range nsteps {
+		if err = s.nsrun(p, step...); err != nil {
+			return
+		}
+	}
+
+	forward, err := ioutil.ReadFile(ipForward)
+	if err != nil {
+		return
+	}
+	s.forward = strings.TrimSpace(string(forward))
+	if s.forward != "1" {
+		p.Logf("enabling ip forwarding")
+		if err = ioutil.WriteFile(ipForward, []byte("1\n"), 0644); err != nil {
+			return
+		}
+	}
+
+	// `ip netns exec` bind mounts this over /etc/resolv.conf
+	if err = os.MkdirAll(s.resolvConf(), 0755); err != nil {
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(s.resolvConf(), "resolv.conf"),
+		[]byte(fmt.Sprintf("nameserver %s\n", s.HostIP)), 0644)
+	return
+}
+
+// Teardown removes the namespace and everything that was set up for
+// it. It is best effort, and is safe to call on a partially set up
+// Sandbox.
+func (s *Sandbox) Teardown(p *supervisor.Process) {
+	os.RemoveAll(s.resolvConf())
+
+	if s.forward != "" && s.forward != "1" {
+		p.Logf("restoring ip forwarding to %s", s.forward)
+		ioutil.WriteFile(ipForward, []byte(s.forward+"\n"), 0644)
+	}
+	s.forward = ""
+
+	for _, step := range [][]string{
+		{"iptables", "-t", "nat", "-D", "POSTROUTING", "-s", s.subnet(), "-j", "MASQUERADE"},
+		{"iptables", "-D", "FORWARD", "-o", s.HostIf, "-j", "ACCEPT"},
+		{"iptables", "-D", "FORWARD", "-i", s.HostIf, "-j", "ACCEPT"},
+		// deleting the namespace destroys our end of the veth
+		// pair, which in turn destroys the host end
+		{"ip", "netns", "del", s.Name},
+	} {
+		if err := s.run(p, step[0], step[1:]...); err != nil {
+			p.Log(err)
+		}
+	}
+}
+
+// Command returns a command that runs inside the namespace. If
+// teleproxy was started via sudo, the command runs as the invoking
+// user.
+func (s *Sandbox) Command(args ...string) *exec.Cmd {
+	argv := []string{"netns", "exec", s.Name}
+	if uid := os.Getenv("SUDO_UID"); uid != "" {
+		argv = append(argv, "setpriv", "--reuid", uid, "--regid", os.Getenv("SUDO_GID"), "--init-groups")
+	}
+	return exec.Command("ip", append(argv, args...)...)
+}
@@ -4,7 +4,10 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync/atomic"
+	"time"
 
+	"github.com/datawire/teleproxy/internal/pkg/fault"
 	"github.com/datawire/teleproxy/pkg/tpu"
 	"golang.org/x/net/proxy"
 )
@@ -12,13 +15,29 @@ import (
 type Proxy struct {
 	listener net.Listener
 	router   func(*net.TCPConn) (string, error)
+	// Fault, if set, is consulted for every connection with the
+	// original destination and returns the latency, jitter, and
+	// failure rate to inject for that destination.
+	Fault func(host string) fault.Fault
+	// KeepAlive, if nonzero, enables TCP keepalives with the given
+	// period on intercepted connections.
+	KeepAlive time.Duration
+	// IdleTimeout, if nonzero, closes connections that have not
+	// carried any data in either direction for that long.
+	IdleTimeout time.Duration
+	// SNI, if set, is consulted for TLS connections to the ports in
+	// SNIPorts with the server name the client asked for and the
+	// original destination, and returns the destination to forward
+	// the connection to. The TLS session is not terminated.
+	SNI      func(name, host string) string
+	SNIPorts []string
 }
 
 func NewProxy(address string, router func(*net.TCPConn) (string, error)) (proxy *Proxy, err error) {
 	tpu.Rlimit()
 	ln, err := net.Listen("tcp", ":1234")
 	if err == nil {
-		proxy = &Proxy{ln, router}
+		proxy = &Proxy{listener: ln, router: router}
 	}
 	return
 }
@@ -59,8 +78,36 @@ func (p *Proxy) handleConnection(conn *net.TCPConn) {
 		return
 	}
 
+	var f fault.Fault
+	if p.Fault != nil {
+		f = p.Fault(host)
+	}
+
+	if f.Fail() {
+		p.log("FAULT %s %s: injected connection failure", conn.RemoteAddr(), host)
+		conn.Close()
+		return
+	}
+
+	var peeked []byte
+	if p.SNI != nil && p.sniPort(host) {
+		var name string
+		name, peeked = serverName(conn, sniTimeout)
+		if name != "" {
+			if dest := p.SNI(name, host); dest != host {
+				p.log("SNI %s %s -> %s", conn.RemoteAddr(), name, dest)
+				host = dest
+			}
+		}
+	}
+
 	p.log("CONNECT %s %s", conn.RemoteAddr(), host)
 
+	if p.KeepAlive > 0 {
+		conn.SetKeepAlive(true)
+		conn.SetKeepAlivePeriod(p.KeepAlive)
+	}
+
 	// setting up an ssh tunnel with dynamic socks proxy at this end
 	// seems faster than connecting directly to a socks proxy
 	dialer, err := proxy.SOCKS5("tcp", "localhost:1080", nil, proxy.Direct)
@@ -79,15 +126,55 @@ func (p *Proxy) handleConnection(conn *net.TCPConn) {
 	}
 	proxy := _proxy.(*net.TCPConn)
 
+	// whatever we read while looking for the server name
+	if len(peeked) > 0 {
+		_, err = proxy.Write(peeked)
+		if err != nil {
+			p.log(err.Error())
+			conn.Close()
+			proxy.Close()
+			return
+		}
+	}
+
 	done := tpu.NewLatch(2)
+	activity := &activity{}
+	activity.touch()
 
-	go p.pipe(conn, proxy, done)
-	go p.pipe(proxy, conn, done)
+	go p.pipe(conn, proxy, f, activity, done)
+	go p.pipe(proxy, conn, f, activity, done)
 
 	done.Wait()
 }
 
-func (p *Proxy) pipe(from, to *net.TCPConn, done tpu.Latch) {
+func (p *Proxy) sniPort(host string) bool {
+	_, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return false
+	}
+	for _, sniPort := range p.SNIPorts {
+		if sniPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// activity tracks when a connection last carried data in either
+// direction.
+type activity struct {
+	last int64
+}
+
+func (a *activity) touch() {
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+func (a *activity) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+func (p *Proxy) pipe(from, to *net.TCPConn, f fault.Fault, activity *activity, done tpu.Latch) {
 	defer func() {
 		p.log("CLOSED WRITE %v", to.RemoteAddr())
 		to.CloseWrite()
@@ -100,14 +187,37 @@ func (p *Proxy) pipe(from, to *net.TCPConn, done tpu.Latch) {
 
 	const size = 64 * 1024
 	var buf [size]byte
+	// the latency is injected once, before the first data is
+	// relayed, rather than again for every read
+	delayed := false
 	for {
+		if p.IdleTimeout > 0 {
+			from.SetReadDeadline(time.Now().Add(p.IdleTimeout))
+		}
 		n, err := from.Read(buf[0:size])
 		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() && p.IdleTimeout > 0 {
+				// the other direction may have been busy
+				if activity.idle() < p.IdleTimeout {
+					continue
+				}
+				p.log("IDLE TIMEOUT %v after %v", from.RemoteAddr(), p.IdleTimeout)
+				from.Close()
+				to.Close()
+				break
+			}
 			if err != io.EOF {
 				p.log(err.Error())
 			}
 			break
 		} else {
+			if !delayed {
+				delayed = true
+				if delay := f.Delay(); delay > 0 {
+					time.Sleep(delay)
+				}
+			}
+			activity.touch()
 			_, err := to.Write(buf[0:n])
 
 			if err != nil {
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/internal/pkg/fault"
+	"github.com/datawire/teleproxy/pkg/tpu"
+)
+
+// tcpPair returns both ends of a tcp connection.
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	return client.(*net.TCPConn), server.(*net.TCPConn)
+}
+
+func TestPipeDelaysOnce(t *testing.T) {
+	src, from := tcpPair(t)
+	defer src.Close()
+	to, dst := tcpPair(t)
+	defer dst.Close()
+
+	latency := 50 * time.Millisecond
+	done := tpu.NewLatch(1)
+	activity := &activity{}
+	go (&Proxy{}).pipe(from, to, fault.Fault{Latency: latency}, activity, done)
+
+	// every chunk is sent once the previous one got through, so
+	// that each is read separately
+	const chunks = 10
+	start := time.Now()
+	buf := make([]byte, 5)
+	for i := 0; i < chunks; i++ {
+		if _, err := src.Write([]byte("chunk")); err != nil {
+			t.Fatal(err)
+		}
+		dst.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(dst, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elapsed := time.Since(start)
+	if elapsed < latency {
+		t.Errorf("expected a delay of at least %v, took %v", latency, elapsed)
+	}
+	if elapsed >= chunks/2*latency {
+		t.Errorf("expected the delay to be injected once, took %v for %d chunks", elapsed, chunks)
+	}
+
+	src.Close()
+	done.Wait()
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// How long to wait for a client to send its TLS ClientHello before
+// giving up and forwarding the connection by address.
+const sniTimeout = time.Second
+
+var errSniffed = errors.New("sniffed")
+
+// sniffConn is a net.Conn that can only be read from, and that records
+// everything read from it.
+type sniffConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c sniffConn) Read(b []byte) (int, error)  { return c.reader.Read(b) }
+func (c sniffConn) Write(b []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// serverName reads the TLS ClientHello from conn, without completing
+// the handshake, and returns the server name the client asked for (if
+// any) along with all the bytes it had to read from conn to find out.
+// Those bytes need to be passed on to wherever the connection ends up.
+// If the client doesn't speak TLS, the name is empty.
+func serverName(conn net.Conn, timeout time.Duration) (name string, peeked []byte) {
+	var buf bytes.Buffer
+	sniff := sniffConn{Conn: conn, reader: io.TeeReader(conn, &buf)}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	tls.Server(sniff, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+			return nil, errSniffed
+		},
+	}).Handshake()
+
+	return name, buf.Bytes()
+}
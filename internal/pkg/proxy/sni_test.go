@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerName(t *testing.T) {
+	for _, name := range []string{"foo.default.svc.cluster.local", ""} {
+		client, server := net.Pipe()
+		sent := &bytes.Buffer{}
+		go func() {
+			// the handshake fails once the pipe is closed
+			tls.Client(recorder{client, sent}, &tls.Config{ServerName: name, InsecureSkipVerify: true}).Handshake()
+		}()
+
+		got, peeked := serverName(server, time.Second)
+		client.Close()
+		server.Close()
+
+		if got != name {
+			t.Errorf("got %q, expected %q", got, name)
+		}
+		if len(peeked) == 0 || !bytes.HasPrefix(sent.Bytes(), peeked) {
+			t.Errorf("peeked %d bytes that weren't what the client sent", len(peeked))
+		}
+	}
+}
+
+func TestServerNameNotTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+
+	got, peeked := serverName(server, time.Second)
+	if got != "" {
+		t.Errorf("got %q, expected no name", got)
+	}
+	if len(peeked) == 0 || !bytes.HasPrefix([]byte("GET / HTTP/1.1\r\n\r\n"), peeked) {
+		t.Errorf("unexpected peeked bytes %q", peeked)
+	}
+}
+
+func TestServerNameTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got, peeked := serverName(server, 10*time.Millisecond)
+	if got != "" || len(peeked) != 0 {
+		t.Errorf("got %q %q, expected nothing", got, peeked)
+	}
+}
+
+// recorder is a net.Conn that records everything written to it.
+type recorder struct {
+	net.Conn
+	written *bytes.Buffer
+}
+
+func (r recorder) Write(b []byte) (int, error) {
+	r.written.Write(b)
+	return r.Conn.Write(b)
+}
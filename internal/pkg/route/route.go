@@ -19,6 +19,14 @@ type Route struct {
 	Proto  string `json:"proto"`
 	Target string `json:"target"`
 	Action string `json:"action,omitempty"`
+	// Alias, if set, is a dns name that Name is an alias for.
+	// Queries for Name are answered with a CNAME record pointing
+	// at Alias, and Ip is ignored.
+	Alias string `json:"alias,omitempty"`
+	// Host and Port, if set, publish Name as an SRV record
+	// pointing at port Port of Host, and Ip is ignored.
+	Host string `json:"host,omitempty"`
+	Port string `json:"port,omitempty"`
 }
 
 func (r Route) Domain() string {
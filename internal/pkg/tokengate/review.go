@@ -0,0 +1,60 @@
+package tokengate
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+)
+
+// KubernetesReviewer lets the users through that the cluster
+// authenticates with their token (a TokenReview), and that are allowed
+// to port forward to the pod of the gate (a SubjectAccessReview). The
+// service account of the gate needs to be allowed to create both, e.g.
+// by binding it to the system:auth-delegator cluster role.
+func KubernetesReviewer(client *k8s.Client, namespace, pod string) Reviewer {
+	return func(token string) (string, error) {
+		review, err := client.Create(k8s.Resource{
+			"apiVersion": "authentication.k8s.io/v1",
+			"kind":       "TokenReview",
+			"spec":       map[string]interface{}{"token": token},
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "token review")
+		}
+		status := review.Status()
+		if authenticated, _ := status["authenticated"].(bool); !authenticated {
+			reason, _ := status["error"].(string)
+			return "", errors.Errorf("not authenticated: %s", reason)
+		}
+		user, _ := status["user"].(map[string]interface{})
+		username, _ := user["username"].(string)
+
+		spec := map[string]interface{}{
+			"user": username,
+			"resourceAttributes": map[string]interface{}{
+				"namespace":   namespace,
+				"verb":        "create",
+				"resource":    "pods",
+				"subresource": "portforward",
+				"name":        pod,
+			},
+		}
+		for _, key := range []string{"uid", "groups", "extra"} {
+			if value, ok := user[key]; ok {
+				spec[key] = value
+			}
+		}
+		access, err := client.Create(k8s.Resource{
+			"apiVersion": "authorization.k8s.io/v1",
+			"kind":       "SubjectAccessReview",
+			"spec":       spec,
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "subject access review")
+		}
+		if allowed, _ := access.Status()["allowed"].(bool); !allowed {
+			return "", errors.Errorf("%s is not allowed to port forward to pod/%s", username, pod)
+		}
+		return username, nil
+	}
+}
@@ -0,0 +1,321 @@
+// Package tokengate ties the tunnel into the cluster to the
+// credentials of the user rather than to whoever can reach the
+// in-cluster end of it.
+//
+// The gate runs in the cluster. Every connection to it starts with the
+// bearer token of the user, e.g. an OIDC id token, and the address the
+// connection is for:
+//
+//	<token>\n<host:port>\n
+//
+// The gate reviews the token, answers "OK\n" and connects the client
+// to the address, or answers "DENIED <reason>\n" and hangs up. The
+// token of an open connection is reviewed again every Recheck, and the
+// connection is closed once it no longer passes, so revoking the
+// access of a user, or the user's session expiring, cuts off the
+// connections already made too.
+//
+// The client end serves SOCKS5 locally, so that it can stand in for
+// the dynamic port forward of ssh, and makes a connection to the gate
+// for every connection it accepts.
+package tokengate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A Reviewer tells who the token belongs to, or returns an error if
+// the token isn't (or is no longer) allowed through the gate.
+type Reviewer func(token string) (user string, err error)
+
+// Gate is the in-cluster end of the tunnel.
+type Gate struct {
+	Review Reviewer
+	// Recheck is how often the token of an open connection is
+	// reviewed again, never if zero.
+	Recheck time.Duration
+	// Dial connects to the address a client asked for, it defaults
+	// to net.Dial.
+	Dial func(network, address string) (net.Conn, error)
+}
+
+// Serve serves the connections accepted from the listener until it
+// is closed.
+func (g *Gate) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go g.handle(conn)
+	}
+}
+
+func (g *Gate) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// the handshake has to arrive promptly
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	token, err := readLine(reader)
+	if err != nil {
+		return
+	}
+	address, err := readLine(reader)
+	if err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	user, err := g.Review(token)
+	if err != nil {
+		log.Printf("GTE: denied connection to %s: %v", address, err)
+		fmt.Fprintf(conn, "DENIED %s\n", oneLine(err.Error()))
+		return
+	}
+
+	dial := g.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	upstream, err := dial("tcp", address)
+	if err != nil {
+		fmt.Fprintf(conn, "DENIED %s\n", oneLine(err.Error()))
+		return
+	}
+	defer upstream.Close()
+	if _, err := io.WriteString(conn, "OK\n"); err != nil {
+		return
+	}
+	log.Printf("GTE: %s connected to %s", user, address)
+
+	done := make(chan struct{})
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			close(done)
+			conn.Close()
+			upstream.Close()
+		})
+	}
+	go func() {
+		// whatever the client sent along with the handshake
+		// is buffered in the reader
+		io.Copy(upstream, reader)
+		closeBoth()
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		closeBoth()
+	}()
+
+	if g.Recheck > 0 {
+		ticker := time.NewTicker(g.Recheck)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := g.Review(token); err != nil {
+					log.Printf("GTE: closing connection of %s to %s: %v", user, address, err)
+					closeBoth()
+					return
+				}
+			}
+		}
+	}
+	<-done
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func oneLine(s string) string {
+	return strings.Replace(s, "\n", " ", -1)
+}
+
+// Client is the local end of the tunnel.
+type Client struct {
+	// Gate is the address of the gate, e.g. of a port forward to
+	// it.
+	Gate string
+	// Token returns the token to present to the gate. It is called
+	// for every connection, so that a refreshed token is picked up.
+	Token func() (string, error)
+}
+
+// Dial connects to the address through the gate.
+func (c *Client) Dial(address string) (net.Conn, error) {
+	token, err := c.Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "token")
+	}
+	if strings.ContainsAny(token, "\r\n") || strings.ContainsAny(address, "\r\n") {
+		return nil, errors.New("malformed token or address")
+	}
+	conn, err := net.DialTimeout("tcp", c.Gate, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintf(conn, "%s\n%s\n", token, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the answer is read a byte at a time, so that nothing that
+	// follows it is consumed
+	var answer []byte
+	buf := make([]byte, 1)
+	for {
+		_, err := conn.Read(buf)
+		if err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "gate")
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		answer = append(answer, buf[0])
+	}
+	if string(answer) != "OK" {
+		conn.Close()
+		return nil, errors.Errorf("gate: %s", strings.TrimPrefix(string(answer), "DENIED "))
+	}
+	return conn, nil
+}
+
+// ServeSOCKS serves SOCKS5 CONNECT requests without authentication on
+// the listener until it is closed, and connects them through the gate.
+func (c *Client) ServeSOCKS(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go c.handleSOCKS(conn)
+	}
+}
+
+// SOCKS5, see RFC 1928
+const (
+	socksVersion     = 5
+	socksNoAuth      = 0
+	socksNoMethods   = 0xff
+	socksConnect     = 1
+	socksIPv4        = 1
+	socksDomain      = 3
+	socksIPv6        = 4
+	socksSucceeded   = 0
+	socksFailure     = 1
+	socksUnsupported = 7
+)
+
+func (c *Client) handleSOCKS(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// greeting: VER NMETHODS METHODS...
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil || header[0] != socksVersion {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return
+	}
+	method := byte(socksNoMethods)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil || method != socksNoAuth {
+		return
+	}
+
+	// request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil || request[0] != socksVersion {
+		return
+	}
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		ip := make([]byte, net.IPv4len)
+		if request[3] == socksIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case socksDomain:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksUnsupported)
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return
+	}
+	if request[1] != socksConnect {
+		socksReply(conn, socksUnsupported)
+		return
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	upstream, err := c.Dial(address)
+	if err != nil {
+		log.Printf("GTE: %s: %v", address, err)
+		socksReply(conn, socksFailure)
+		return
+	}
+	defer upstream.Close()
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// socksReply replies with the given status and an unspecified bound
+// address, which clients don't need for CONNECT.
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
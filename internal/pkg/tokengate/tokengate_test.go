@@ -0,0 +1,169 @@
+package tokengate
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// echo serves connections by echoing what they send.
+func echo(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+// tokens is a Reviewer whose tokens can be revoked.
+type tokens struct {
+	mutex   sync.Mutex
+	allowed map[string]string
+}
+
+func (ts *tokens) review(token string) (string, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	user, ok := ts.allowed[token]
+	if !ok {
+		return "", errors.New("revoked")
+	}
+	return user, nil
+}
+
+func (ts *tokens) revoke(token string) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	delete(ts.allowed, token)
+}
+
+func startGate(t *testing.T, ts *tokens) net.Listener {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := &Gate{Review: ts.review, Recheck: 10 * time.Millisecond}
+	go gate.Serve(ln)
+	return ln
+}
+
+func roundTrip(conn net.Conn, message string) (string, error) {
+	if _, err := io.WriteString(conn, message); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(message))
+	_, err := io.ReadFull(conn, buf)
+	return string(buf), err
+}
+
+func TestGate(t *testing.T) {
+	upstream := echo(t)
+	defer upstream.Close()
+	ts := &tokens{allowed: map[string]string{"good": "alice", "other": "bob"}}
+	gate := startGate(t, ts)
+	defer gate.Close()
+
+	token := "bogus"
+	client := &Client{Gate: gate.Addr().String(), Token: func() (string, error) { return token, nil }}
+	if _, err := client.Dial(upstream.Addr().String()); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("expected the token to be denied, got %v", err)
+	}
+
+	token = "good"
+	conn, err := client.Dial(upstream.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got, err := roundTrip(conn, "hello"); err != nil || got != "hello" {
+		t.Errorf("expected hello, got %q, %v", got, err)
+	}
+
+	// a connection of another user is not affected
+	token = "other"
+	other, err := client.Dial(upstream.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// revoking the token cuts off the open connection
+	ts.revoke("good")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("expected the connection to be closed")
+	}
+	if got, err := roundTrip(other, "still there"); err != nil || got != "still there" {
+		t.Errorf("expected still there, got %q, %v", got, err)
+	}
+}
+
+func TestServeSOCKS(t *testing.T) {
+	upstream := echo(t)
+	defer upstream.Close()
+	gate := startGate(t, &tokens{allowed: map[string]string{"good": "alice"}})
+	defer gate.Close()
+
+	socks, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks.Close()
+	client := &Client{Gate: gate.Addr().String(), Token: func() (string, error) { return "good", nil }}
+	go client.ServeSOCKS(socks)
+
+	conn, err := net.Dial("tcp", socks.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// greeting, offering no authentication
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0 {
+		t.Fatalf("unexpected method selection %v, %v", reply, err)
+	}
+
+	// CONNECT to the upstream by name
+	host, portString, _ := net.SplitHostPort(upstream.Addr().String())
+	port, _ := net.LookupPort("tcp", portString)
+	if host == "127.0.0.1" {
+		host = "localhost"
+	}
+	request := append([]byte{5, 1, 0, 3, byte(len(host))}, host...)
+	request = append(request, 0, 0)
+	binary.BigEndian.PutUint16(request[len(request)-2:], uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	reply = make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0 {
+		t.Fatalf("unexpected reply %v, %v", reply, err)
+	}
+	if got, err := roundTrip(conn, "through the gate"); err != nil || got != "through the gate" {
+		t.Errorf("expected through the gate, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,141 @@
+// Package watchdog makes sure that the changes teleproxy makes to the
+// system networking configuration are undone even if teleproxy dies
+// without getting the chance to clean up after itself, e.g. because
+// it was sent SIGKILL or killed by the OOM killer.
+//
+// The watchdog is a separate process that is handed a description of
+// what teleproxy is about to change over a pipe. If the pipe is closed
+// without the watchdog having been disarmed first, teleproxy is gone,
+// and the watchdog undoes the changes.
+package watchdog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/datawire/teleproxy/internal/pkg/cgroup"
+	"github.com/datawire/teleproxy/internal/pkg/dns"
+	"github.com/datawire/teleproxy/internal/pkg/nat"
+	"github.com/datawire/teleproxy/internal/pkg/netns"
+	"github.com/datawire/teleproxy/pkg/supervisor"
+)
+
+const disarm = "disarm"
+
+// State describes the changes that need to be undone.
+type State struct {
+	// the firewall chain or anchor, and how it is hooked up
+	Translator string
+	Interface  string
+	Match      []string
+	// the resolver manager we registered with, if any
+	Resolver string
+	// whether the search domains are overridden
+	SearchDomains bool
+	// the NetworkManager dispatcher script, if any
+	Dispatcher string
+	// the network namespace sandbox, if any
+	Sandbox *netns.Sandbox
+	// the cgroup, if any
+	Cgroup string
+}
+
+// A Watchdog is the teleproxy end of the pipe to the watchdog
+// process.
+type Watchdog struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// Start launches the watchdog process with the given command, which
+// is expected to end up calling Run, and hands it the state.
+func Start(cmd *exec.Cmd, state State) (*Watchdog, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrap(err, "starting watchdog")
+	}
+
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		panic(err)
+	}
+	_, err = stdin.Write(append(bytes, '\n'))
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, errors.Wrap(err, "starting watchdog")
+	}
+
+	return &Watchdog{cmd: cmd, stdin: stdin}, nil
+}
+
+// Disarm tells the watchdog that teleproxy has cleaned up after
+// itself, and waits for it to exit.
+func (w *Watchdog) Disarm() error {
+	w.stdin.Write([]byte(disarm + "\n"))
+	w.stdin.Close()
+	return w.cmd.Wait()
+}
+
+// Run is the watchdog process. It reads the state from in, and then
+// waits for in to be closed.
+func Run(p *supervisor.Process, in io.Reader) error {
+	reader := bufio.NewReader(in)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return errors.Wrap(err, "reading state")
+	}
+	var state State
+	err = json.Unmarshal([]byte(line), &state)
+	if err != nil {
+		return errors.Wrap(err, "reading state")
+	}
+
+	// teleproxy hasn't touched these yet
+	var restoreSearch func()
+	if state.SearchDomains {
+		restoreSearch = dns.SaveSearchDomains(p)
+	}
+
+	p.Ready()
+
+	line, _ = reader.ReadString('\n')
+	if strings.TrimSpace(line) == disarm {
+		return nil
+	}
+
+	p.Log("teleproxy exited without cleaning up, restoring networking")
+
+	if state.Translator != "" {
+		translator := nat.NewTranslator(state.Translator)
+		translator.Interface = state.Interface
+		translator.Match = state.Match
+		translator.Disable(p)
+	}
+	if state.Resolver != "" {
+		dns.UnconfigureResolver(p, state.Resolver)
+	}
+	if restoreSearch != nil {
+		restoreSearch()
+	}
+	if state.Dispatcher != "" {
+		dns.RemoveDispatcher(p, state.Dispatcher)
+	}
+	if state.Sandbox != nil {
+		state.Sandbox.Teardown(p)
+	}
+	if state.Cgroup != "" {
+		cgroup.Remove(p, state.Cgroup)
+	}
+	dns.Flush()
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and runs things after a delay. Code that
+// takes a Clock rather than calling time.Now and friends directly can
+// be tested with a Fake clock, which only moves when the test
+// advances it.
+type Clock interface {
+	Now() time.Time
+	// After is like time.After.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc is like time.AfterFunc.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A Timer is a pending call of a function, see Clock.AfterFunc.
+type Timer interface {
+	// Stop is like time.Timer.Stop.
+	Stop() bool
+}
+
+type real struct{}
+
+// Real is the Clock of the time package.
+var Real Clock = real{}
+
+func (real) Now() time.Time                         { return time.Now() }
+func (real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (real) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Fake is a Clock whose time only changes when it is advanced. The
+// functions of its timers run, in the order they are due, in the
+// goroutine that advances it past when they are due.
+type Fake struct {
+	mutex   sync.Mutex
+	changed *sync.Cond // signals when a timer is added
+	now     time.Time
+	timers  []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *Fake
+	at    time.Time
+	f     func()
+}
+
+// Constructs a new fake clock that starts at the given time.
+func NewFake(now time.Time) *Fake {
+	c := &Fake{now: now}
+	c.changed = sync.NewCond(&c.mutex)
+	return c
+}
+
+func (c *Fake) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *Fake) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() {
+		ch <- c.Now()
+	})
+	return ch
+}
+
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	c.changed.Broadcast()
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Pending returns how many timers are waiting for the clock to be
+// advanced.
+func (c *Fake) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil waits until at least n timers are pending, e.g. until
+// the code under test is waiting for the clock to be advanced.
+func (c *Fake) BlockUntil(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+// Advance moves the clock forward by d and runs the functions of the
+// timers that are due by then. Timers that those functions start are
+// run too if they are due.
+func (c *Fake) Advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool {
+			return c.timers[i].at.Before(c.timers[j].at)
+		})
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mutex.Unlock()
+		t.f()
+		c.mutex.Lock()
+	}
+	c.now = end
+	c.mutex.Unlock()
+}
@@ -0,0 +1,44 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewFake(start)
+	var fired []string
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, "two") })
+	c.AfterFunc(1*time.Second, func() {
+		fired = append(fired, "one")
+		c.AfterFunc(500*time.Millisecond, func() { fired = append(fired, "one and a half") })
+	})
+	stopped := c.AfterFunc(1*time.Second, func() { fired = append(fired, "stopped") })
+	if !stopped.Stop() {
+		t.Errorf("expected the timer to be stopped")
+	}
+	after := c.After(3 * time.Second)
+
+	c.Advance(2 * time.Second)
+	if len(fired) != 3 || fired[0] != "one" || fired[1] != "one and a half" || fired[2] != "two" {
+		t.Errorf("unexpected timers fired: %v", fired)
+	}
+	if now := c.Now(); !now.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("unexpected time: %v", now)
+	}
+	select {
+	case <-after:
+		t.Errorf("fired early")
+	default:
+	}
+
+	c.BlockUntil(1)
+	c.Advance(1 * time.Second)
+	if now := <-after; !now.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("unexpected time: %v", now)
+	}
+	if c.Pending() != 0 {
+		t.Errorf("unexpected pending timers: %d", c.Pending())
+	}
+}
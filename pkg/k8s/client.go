@@ -8,16 +8,24 @@
 package k8s
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
 	"github.com/google/shlex"
 	"github.com/pkg/errors"
@@ -25,10 +33,14 @@ import (
 
 // KubeInfo holds the data required to talk to a cluster
 type KubeInfo struct {
-	Kubeconfig   string
-	Context      string
-	Namespace    string
-	clientConfig clientcmd.ClientConfig
+	Kubeconfig string
+	Context    string
+	Namespace  string
+	// ImpersonateUser and ImpersonateGroups, if set, are who the
+	// requests are made as instead of the user of the context.
+	ImpersonateUser   string
+	ImpersonateGroups []string
+	clientConfig      clientcmd.ClientConfig
 }
 
 // NewKubeInfo returns a useable KubeInfo, handling optional
@@ -68,10 +80,10 @@ func NewKubeInfo(configfile, context, namespace string) (*KubeInfo, error) {
 	}
 
 	res := KubeInfo{
-		configfile,
-		resultContext,
-		resultNamespace,
-		kubeconfig,
+		Kubeconfig:   configfile,
+		Context:      resultContext,
+		Namespace:    resultNamespace,
+		clientConfig: kubeconfig,
 	}
 
 	return &res, nil
@@ -94,6 +106,13 @@ func (info *KubeInfo) GetRestConfig() (*rest.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	refreshInClusterToken(config)
+	if info.ImpersonateUser != "" {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: info.ImpersonateUser,
+			Groups:   info.ImpersonateGroups,
+		}
+	}
 	return config, nil
 }
 
@@ -113,14 +132,194 @@ func (info *KubeInfo) GetKubectlArray(args ...string) []string {
 		res = append(res, "--kubeconfig", info.Kubeconfig)
 	}
 	res = append(res, "--context", info.Context, "--namespace", info.Namespace)
+	if info.ImpersonateUser != "" {
+		res = append(res, "--as", info.ImpersonateUser)
+		for _, group := range info.ImpersonateGroups {
+			res = append(res, "--as-group", group)
+		}
+	}
 	res = append(res, args...)
 	return res[1:] // Drop leading "kubectl" because reasons...
 }
 
+// WriteKubeconfig writes a kubeconfig file whose current context and
+// namespace are those of the KubeInfo, so that e.g. kubectl run with it
+// talks to the same cluster without any further arguments.
+func (info *KubeInfo) WriteKubeconfig(path string) error {
+	config, err := info.clientConfig.RawConfig()
+	if err != nil {
+		return err
+	}
+	context, ok := config.Contexts[info.Context]
+	if !ok {
+		return errors.Errorf("context %q not found", info.Context)
+	}
+	// copy the context so the namespace can be changed
+	ctx := *context
+	ctx.Namespace = info.Namespace
+	config.Contexts[info.Context] = &ctx
+	config.CurrentContext = info.Context
+	if info.ImpersonateUser != "" {
+		// and the user so the impersonation can be added
+		user := clientcmdapi.AuthInfo{}
+		if u, ok := config.AuthInfos[ctx.AuthInfo]; ok {
+			user = *u
+		}
+		user.Impersonate = info.ImpersonateUser
+		user.ImpersonateGroups = info.ImpersonateGroups
+		config.AuthInfos[ctx.AuthInfo] = &user
+	}
+	return clientcmd.WriteToFile(config, path)
+}
+
+// GetAuthPlugin returns a description of the credential plugin (an
+// exec plugin or an auth provider such as oidc) that the user of the
+// context authenticates with, or "" if the user has static
+// credentials.
+func (info *KubeInfo) GetAuthPlugin() (string, error) {
+	user, err := info.authInfo()
+	if err != nil || user == nil {
+		return "", err
+	}
+	switch {
+	case user.Exec != nil:
+		return "exec " + user.Exec.Command, nil
+	case user.AuthProvider != nil:
+		return user.AuthProvider.Name, nil
+	default:
+		return "", nil
+	}
+}
+
+// GetToken returns the bearer token that the user of the context
+// authenticates with: the token of an exec plugin, which is run for
+// it, the id token of an auth provider, which kubectl keeps fresh in
+// the kubeconfig, or the static token of the user.
+func (info *KubeInfo) GetToken() (string, error) {
+	user, err := info.authInfo()
+	if err != nil {
+		return "", err
+	}
+	var token string
+	switch {
+	case user == nil:
+	case user.Exec != nil:
+		token, err = execToken(user.Exec)
+		if err != nil {
+			return "", errors.Wrapf(err, "exec %s", user.Exec.Command)
+		}
+	case user.AuthProvider != nil:
+		token = user.AuthProvider.Config["id-token"]
+	default:
+		token = user.Token
+	}
+	if token == "" {
+		return "", errors.Errorf("context %q has no bearer token", info.Context)
+	}
+	return token, nil
+}
+
+// execToken runs the exec plugin and returns the token of the
+// ExecCredential it prints.
+func execToken(config *clientcmdapi.ExecConfig) (string, error) {
+	cmd := exec.Command(config.Command, config.Args...)
+	// the plugin is told what it is run for, like kubectl does
+	info := fmt.Sprintf(`{"apiVersion": %q, "kind": "ExecCredential", "spec": {"interactive": false}}`,
+		config.APIVersion)
+	cmd.Env = append(os.Environ(), "KUBERNETES_EXEC_INFO="+info)
+	for _, env := range config.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	var credential struct {
+		Status struct {
+			Token string `json:"token"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(output, &credential); err != nil {
+		return "", err
+	}
+	return credential.Status.Token, nil
+}
+
+// authInfo returns the user of the context, nil if it has none.
+func (info *KubeInfo) authInfo() (*clientcmdapi.AuthInfo, error) {
+	config, err := info.clientConfig.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	context, ok := config.Contexts[info.Context]
+	if !ok {
+		return nil, errors.Errorf("context %q not found", info.Context)
+	}
+	return config.AuthInfos[context.AuthInfo], nil
+}
+
 // Client is the top-level handle to the Kubernetes cluster.
 type Client struct {
 	config    *rest.Config
-	resources []*v1.APIResourceList
+	context   string
+	namespace string
+	disco     *discovery.DiscoveryClient
+	// recording, if set, records the lists and watches, replay, if
+	// set, serves them instead of the cluster
+	recording *Recording
+	replay    *replay
+	// dynamic, if set, is used instead of a dynamic client for the
+	// config, see NewClientForDynamic
+	dynamic dynamic.Interface
+
+	// resourcesMu protects resources, refreshed and schema, the
+	// resource types are rediscovered when a lookup misses, see
+	// RefreshResourceTypes
+	resourcesMu sync.RWMutex
+	resources   []*v1.APIResourceList
+	refreshed   time.Time
+	schema      *OpenAPISchema
+
+	informers informerRegistry
+}
+
+// DISCOVERY_REFRESH is how often at most the resource types are
+// rediscovered because a lookup didn't find a resource type, e.g.
+// because its CRD was only just installed.
+var DISCOVERY_REFRESH = 10 * time.Second
+
+// ClientOptions configure a Client, the zero value of each option
+// means the default.
+type ClientOptions struct {
+	// Kubeconfig is the path of the kubeconfig file, by default
+	// $KUBECONFIG or ~/.kube/config.
+	Kubeconfig string
+	// Context is the kubeconfig context, by default the current
+	// context.
+	Context string
+	// Namespace is the namespace resources without one are
+	// created in, by default the namespace of the context.
+	Namespace string
+	// QPS and Burst limit the rate of requests to the api server,
+	// by default to 5 per second with bursts of 10.
+	QPS   float32
+	Burst int
+	// Retry says how requests are retried when the api server is
+	// overloaded or unavailable, by default they aren't.
+	Retry RetryPolicy
+	// Timeout limits how long a request may take, except for
+	// watches. By default there is no limit.
+	Timeout time.Duration
+	// UserAgent is what the client tells the api server it is.
+	UserAgent string
+	// ImpersonateUser and ImpersonateGroups, if set, are who the
+	// client acts as, see KubeInfo.
+	ImpersonateUser   string
+	ImpersonateGroups []string
+	// Recording, if set, records what the client lists and
+	// watches, see NewReplayClient.
+	Recording *Recording
 }
 
 // NewClient constructs a k8s.Client, optionally using a previously-constructed
@@ -133,25 +332,125 @@ func NewClient(info *KubeInfo) *Client {
 			panic(err)
 		}
 	}
+	client, err := newClient(info, ClientOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// NewClientWithOptions constructs a k8s.Client configured with the
+// options. Unlike NewClient, it returns an error if the cluster can't
+// be reached.
+func NewClientWithOptions(options ClientOptions) (*Client, error) {
+	info, err := NewKubeInfo(options.Kubeconfig, options.Context, options.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	info.ImpersonateUser = options.ImpersonateUser
+	info.ImpersonateGroups = options.ImpersonateGroups
+	return newClient(info, options)
+}
+
+func newClient(info *KubeInfo, options ClientOptions) (*Client, error) {
 	config, err := info.GetRestConfig()
 	if err != nil {
-		panic(fmt.Sprintf("Failed to get REST config: %v", err))
+		return nil, fmt.Errorf("Failed to get REST config: %v", err)
+	}
+	if options.QPS > 0 {
+		config.QPS = options.QPS
+	}
+	if options.Burst > 0 {
+		config.Burst = options.Burst
 	}
+	if options.Timeout > 0 {
+		config.Timeout = options.Timeout
+	}
+	if options.UserAgent != "" {
+		config.UserAgent = options.UserAgent
+	}
+	options.Retry.retry(config)
 
 	disco, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	resources, err := disco.ServerResources()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return &Client{
 		config:    config,
+		context:   info.Context,
+		namespace: info.Namespace,
+		disco:     disco,
+		recording: options.Recording,
 		resources: resources,
+		refreshed: time.Now(),
+	}, nil
+}
+
+// NewClientForDynamic returns a client that serves the given resource
+// types through the given dynamic client instead of talking to a
+// cluster, e.g. through a fake one in tests, see the fake package.
+// Resources without a namespace are created in the given namespace.
+// The client can't discover anything, so Apply and OpenAPISchema fail.
+func NewClientForDynamic(dyn dynamic.Interface, namespace string, types ...ResourceType) *Client {
+	var resources []*v1.APIResourceList
+	for _, rt := range types {
+		resources = addResourceType(resources, rt, "create", "delete", "deletecollection", "get",
+			"list", "patch", "update", "watch")
 	}
+	return &Client{namespace: namespace, dynamic: dyn, resources: resources, refreshed: time.Now()}
+}
+
+// RefreshResourceTypes rediscovers the resource types the cluster
+// serves, so that e.g. CRDs installed since the client was created
+// can be looked up. If discovery fails, the resource types known so
+// far are kept.
+func (c *Client) RefreshResourceTypes() error {
+	if c.disco == nil {
+		return errors.New("no discovery client")
+	}
+	resources, err := c.disco.ServerResources()
+	if err != nil {
+		return err
+	}
+	c.resourcesMu.Lock()
+	defer c.resourcesMu.Unlock()
+	c.resources = resources
+	c.refreshed = time.Now()
+	// the schema is fetched again when needed, it may have new
+	// kinds as well
+	c.schema = nil
+	return nil
+}
+
+// refreshStale rediscovers the resource types unless that was done
+// within the last DISCOVERY_REFRESH, and returns whether it did.
+func (c *Client) refreshStale() bool {
+	c.resourcesMu.RLock()
+	stale := c.disco != nil && time.Since(c.refreshed) >= DISCOVERY_REFRESH
+	c.resourcesMu.RUnlock()
+	return stale && c.RefreshResourceTypes() == nil
+}
+
+// resourceLists returns the resource types as last discovered.
+func (c *Client) resourceLists() []*v1.APIResourceList {
+	c.resourcesMu.RLock()
+	defer c.resourcesMu.RUnlock()
+	return c.resources
+}
+
+// Namespace returns the namespace that resources without one are
+// created in.
+func (c *Client) Namespace() string {
+	if c.namespace == "" {
+		return "default"
+	}
+	return c.namespace
 }
 
 // ResourceType describes a Kubernetes resource type in a particular cluster.
@@ -169,7 +468,8 @@ type ResourceType struct {
 // ResolveResourceType takes the name of a resource type (singular,
 // plural, or an abbreviation; like you might pass to `kubectl get`)
 // and returns cluster-specific canonical information about that
-// resource type.
+// resource type. It panics if the resource type can't be resolved,
+// see LookupResourceType for the details.
 //
 // For example, with Kubernetes v1.10.5:
 //   "pod"        --> {Group: "",           Version: "v1",      Name: "pods",        Kind: "Pod",        Namespaced: true}
@@ -181,55 +481,217 @@ type ResourceType struct {
 // clusters, it may be a good idea to use this even for internal
 // callers, rather than treating it purely as a UI concern.
 //
-// BUG(lukeshu): ResolveResourceType currently only takes the type name, it should
-// accept TYPE[[.VERSION].GROUP], like `kubectl`.
-//
-// BUG(lukeshu): ResolveResourceType currently returns the first
-// match.  In the event of multiple resource types with the same name
-// (multiple API groups, multiple versions), it should do something
-// more intelligent than that; it should at least pay attention to the
-// API group's PreferredVersion.
-//
 // Should be equivalent to
 // k8s.io/cli-runtime/pkg/genericclioptions/resource.Builder.mappingFor(),
 // which calls
 // k8s.io/apimachinery/pkg/runtime/schema.ParseResourceArg() and
 // k8s.io/client-go/restmapper.shortcutExpander.expandResourceShortcut()
 func (c *Client) ResolveResourceType(resource string) ResourceType {
+	rt, err := c.LookupResourceType(resource)
+	if err != nil {
+		panic(err.Error())
+	}
+	return rt
+}
+
+// LookupResourceType is ResolveResourceType, except that it returns an
+// error instead of panicking. Like `kubectl`, it accepts
+// TYPE[[.VERSION].GROUP], e.g. "deployments.v1.apps" or
+// "deployments.apps" as well as plain "deployments".
+//
+// A plain type name may be served by more than one API group or
+// version. The core group always wins. Otherwise, if all of the
+// candidates are the same resource type, the first one in discovery
+// order wins, which lists the preferred version of a group first. If
+// the candidates are different resource types, e.g. because two CRDs
+// use the same short name, the result is an error that lists them.
+// Full names take precedence over short names.
+//
+// If the resource type isn't found, the resource types are
+// rediscovered, see DISCOVERY_REFRESH, before giving up with an error
+// for which IsUnrecognized is true.
+func (c *Client) LookupResourceType(resource string) (ResourceType, error) {
 	if resource == "" {
-		panic("empty resource string")
+		return ResourceType{}, errors.New("empty resource string")
 	}
 	lresource := strings.ToLower(resource)
-	for _, rl := range c.resources {
+
+	matches := c.findResourceTypes(lresource)
+	if len(matches) == 0 && c.refreshStale() {
+		matches = c.findResourceTypes(lresource)
+	}
+	if len(matches) == 0 {
+		return ResourceType{}, unrecognizedError(resource)
+	}
+	return pickResourceType(resource, matches)
+}
+
+// LookupResourceTypeOf returns the resource type of the resource, of
+// the version its apiVersion names rather than the preferred version
+// of its kind, e.g. of a custom resource that is served in several
+// versions.
+func (c *Client) LookupResourceTypeOf(resource Resource) (ResourceType, error) {
+	return c.LookupResourceType(qualifiedKind(resource))
+}
+
+// qualifiedKind returns the kind of the resource qualified with the
+// version and group of its apiVersion, if it has one, in the form
+// accepted by LookupResourceType.
+func qualifiedKind(resource Resource) string {
+	if apiVersion, ok := resource["apiVersion"].(string); ok && apiVersion != "" {
+		if gv, err := schema.ParseGroupVersion(apiVersion); err == nil {
+			return ResourceType{Group: gv.Group, Version: gv.Version, Name: resource.Kind()}.String()
+		}
+	}
+	return resource.Kind()
+}
+
+// pickResourceType picks the resource type that the name refers to
+// among the matches, see LookupResourceType.
+func pickResourceType(resource string, matches []ResourceType) (ResourceType, error) {
+	// the core group always wins, just like with kubectl
+	for _, m := range matches {
+		if m.Group == "" {
+			return m, nil
+		}
+	}
+
+	for _, m := range matches[1:] {
+		if m.Name != matches[0].Name {
+			var candidates []string
+			seen := make(map[string]bool)
+			for _, m := range matches {
+				if !seen[m.String()] {
+					candidates = append(candidates, m.String())
+					seen[m.String()] = true
+				}
+			}
+			return ResourceType{}, errors.Errorf("ambiguous resource %s, candidates are: %s", resource,
+				strings.Join(candidates, ", "))
+		}
+	}
+
+	return matches[0], nil
+}
+
+// unrecognizedError is returned by LookupResourceType for resource
+// types the cluster doesn't serve.
+type unrecognizedError string
+
+func (e unrecognizedError) Error() string {
+	return "unrecognized resource: " + string(e)
+}
+
+// IsUnrecognized returns true if the error says that the cluster
+// doesn't serve the resource type, or at least didn't when it was
+// last asked.
+func IsUnrecognized(err error) bool {
+	_, ok := errors.Cause(err).(unrecognizedError)
+	return ok
+}
+
+// findResourceTypes returns the resource types that the lowercase
+// TYPE[[.VERSION].GROUP] may refer to.
+func (c *Client) findResourceTypes(lresource string) []ResourceType {
+	var matches []ResourceType
+	// TYPE.VERSION.GROUP
+	if parts := strings.SplitN(lresource, ".", 3); len(parts) == 3 {
+		matches = c.matchResourceTypes(parts[0], func(gv schema.GroupVersion) bool {
+			return gv.Version == parts[1] && gv.Group == parts[2]
+		})
+	}
+	// TYPE.GROUP, or TYPE.VERSION for the core group
+	if parts := strings.SplitN(lresource, ".", 2); len(matches) == 0 && len(parts) == 2 {
+		matches = c.matchResourceTypes(parts[0], func(gv schema.GroupVersion) bool {
+			return gv.Group == parts[1] || (gv.Group == "" && gv.Version == parts[1])
+		})
+	}
+	// TYPE
+	if len(matches) == 0 {
+		matches = c.matchResourceTypes(lresource, func(schema.GroupVersion) bool { return true })
+	}
+	return matches
+}
+
+// matchResourceTypes returns the resource types in the accepted group
+// versions that have the given name, kind or singular name, or
+// failing that, the given short name. Subresources are never matched.
+func (c *Client) matchResourceTypes(name string, accept func(schema.GroupVersion) bool) []ResourceType {
+	var full, short []ResourceType
+	for _, rl := range c.resourceLists() {
+		gv, err := schema.ParseGroupVersion(rl.GroupVersion)
+		if err != nil || !accept(gv) {
+			continue
+		}
+		for _, r := range rl.APIResources {
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			rt := ResourceType{gv.Group, gv.Version, r.Name, r.Kind, r.Namespaced}
+			for _, candidate := range []string{r.Name, r.Kind, r.SingularName} {
+				if name == strings.ToLower(candidate) {
+					full = append(full, rt)
+					break
+				}
+			}
+			for _, candidate := range r.ShortNames {
+				if name == strings.ToLower(candidate) {
+					short = append(short, rt)
+					break
+				}
+			}
+		}
+	}
+	if len(full) > 0 {
+		return full
+	}
+	return short
+}
+
+// String returns the fully qualified name of the resource type in the
+// form accepted by LookupResourceType, e.g. "deployments.v1.apps" or
+// "pods.v1".
+func (rt ResourceType) String() string {
+	if rt.Group == "" {
+		return rt.Name + "." + rt.Version
+	}
+	return rt.Name + "." + rt.Version + "." + rt.Group
+}
+
+// ResourceTypes returns the resource types known to the cluster that
+// support all of the given verbs, e.g. "list" and "delete".
+// Subresources are not included. A resource type that is served by
+// more than one API group or version is returned once for each.
+func (c *Client) ResourceTypes(verbs ...string) []ResourceType {
+	var result []ResourceType
+	for _, rl := range c.resourceLists() {
+		gv, err := schema.ParseGroupVersion(rl.GroupVersion)
+		if err != nil {
+			continue
+		}
+	resources:
 		for _, r := range rl.APIResources {
-			candidates := []string{
-				r.Name,         // lowercase plural
-				r.Kind,         // uppercase singular
-				r.SingularName, // lowercase singular
+			if strings.Contains(r.Name, "/") {
+				continue
 			}
-			candidates = append(candidates, r.ShortNames...)
-
-			for _, c := range candidates {
-				if lresource == strings.ToLower(c) {
-					var group string
-					var version string
-					parts := strings.Split(rl.GroupVersion, "/")
-					switch len(parts) {
-					case 1:
-						group = ""
-						version = parts[0]
-					case 2:
-						group = parts[0]
-						version = parts[1]
-					default:
-						panic("unrecognized GroupVersion")
-					}
-					return ResourceType{group, version, r.Name, r.Kind, r.Namespaced}
+			for _, verb := range verbs {
+				if !hasVerb(r.Verbs, verb) {
+					continue resources
 				}
 			}
+			result = append(result, ResourceType{gv.Group, gv.Version, r.Name, r.Kind, r.Namespaced})
 		}
 	}
-	panic(fmt.Sprintf("unrecognized resource: %s", resource))
+	return result
+}
+
+func hasVerb(verbs []string, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
 }
 
 // List calls ListNamespace(...) with the empty string as the namespace, which
@@ -246,37 +708,324 @@ func (c *Client) ListNamespace(namespace, resource string) ([]Resource, error) {
 }
 
 func (c *Client) SelectiveList(namespace, resource, fieldSelector, labelSelector string) ([]Resource, error) {
-	ri := c.ResolveResourceType(resource)
+	filtered, _, err := c.resourceInterface(resource, namespace)
+	if err != nil {
+		return nil, err
+	}
 
-	dyn, err := dynamic.NewForConfig(c.config)
+	uns, err := listPages(filtered, v1.ListOptions{
+		FieldSelector: fieldSelector,
+		LabelSelector: labelSelector,
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create dynamic context")
+		return nil, err
 	}
 
-	cli := dyn.Resource(schema.GroupVersionResource{
+	result := make([]Resource, len(uns.Items))
+	for idx, un := range uns.Items {
+		result[idx] = un.UnstructuredContent()
+	}
+	return result, nil
+}
+
+// resourceInterface returns the dynamic client for the resource type
+// in the given namespace. The namespace is ignored for resource types
+// that aren't namespaced, and the empty namespace means all
+// namespaces.
+func (c *Client) resourceInterface(resource, namespace string) (dynamic.ResourceInterface, ResourceType, error) {
+	ri, err := c.LookupResourceType(resource)
+	if err != nil {
+		return nil, ri, err
+	}
+
+	cli, err := c.dynamicResource(ri)
+	if err != nil {
+		return nil, ri, errors.Wrap(err, "failed to create dynamic context")
+	}
+
+	if namespace != "" && ri.Namespaced {
+		return cli.Namespace(namespace), ri, nil
+	}
+	return cli, ri, nil
+}
+
+// dynamicResource returns the dynamic client for the resource type,
+// which records or replays if the client does.
+func (c *Client) dynamicResource(ri ResourceType) (dynamic.NamespaceableResourceInterface, error) {
+	if c.replay != nil {
+		return c.replay.resource(ri), nil
+	}
+	dyn := c.dynamic
+	if dyn == nil {
+		var err error
+		dyn, err = dynamic.NewForConfig(c.config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resource := dyn.Resource(schema.GroupVersionResource{
 		Group:    ri.Group,
 		Version:  ri.Version,
 		Resource: ri.Name,
 	})
+	if c.recording != nil {
+		return c.recording.resource(c.context, ri, resource), nil
+	}
+	return resource, nil
+}
 
-	var filtered dynamic.ResourceInterface
-	if namespace != "" {
-		filtered = cli.Namespace(namespace)
-	} else {
-		filtered = cli
+// UpdateSubresource updates the given subresource, e.g. "status", of
+// the resource and returns what the server returned.
+func (c *Client) UpdateSubresource(resource Resource, subresource string) (Resource, error) {
+	cli, _, err := c.resourceInterface(qualifiedKind(resource), resource.Namespace())
+	if err != nil {
+		return nil, err
+	}
+	var uns unstructured.Unstructured
+	uns.SetUnstructuredContent(resource)
+	result, err := cli.Update(&uns, v1.UpdateOptions{}, subresource)
+	if err != nil {
+		return nil, err
+	}
+	return result.UnstructuredContent(), nil
+}
+
+// GetScale returns the scale subresource, an autoscaling/v1 Scale, of
+// the named resource, e.g. a deployment.
+func (c *Client) GetScale(kind, namespace, name string) (Resource, error) {
+	cli, _, err := c.resourceInterface(kind, namespace)
+	if err != nil {
+		return nil, err
+	}
+	result, err := cli.Get(name, v1.GetOptions{}, "scale")
+	if err != nil {
+		return nil, err
 	}
+	return result.UnstructuredContent(), nil
+}
+
+// UpdateScale sets the desired number of replicas of the named
+// resource through its scale subresource and returns the updated
+// Scale.
+func (c *Client) UpdateScale(kind, namespace, name string, replicas int64) (Resource, error) {
+	cli, _, err := c.resourceInterface(kind, namespace)
+	if err != nil {
+		return nil, err
+	}
+	scale, err := cli.Get(name, v1.GetOptions{}, "scale")
+	if err != nil {
+		return nil, err
+	}
+	spec, ok := scale.Object["spec"].(map[string]interface{})
+	if !ok {
+		spec = make(map[string]interface{})
+		scale.Object["spec"] = spec
+	}
+	spec["replicas"] = replicas
+	result, err := cli.Update(scale, v1.UpdateOptions{}, "scale")
+	if err != nil {
+		return nil, err
+	}
+	return result.UnstructuredContent(), nil
+}
 
-	uns, err := filtered.List(v1.ListOptions{
+// Create creates the resource and returns what the server returned.
+// Resources without a namespace are created in the client's namespace
+// if they are namespaced.
+func (c *Client) Create(resource Resource) (Resource, error) {
+	namespace := resource.Namespace()
+	if namespace == "" {
+		namespace = c.Namespace()
+	}
+	cli, _, err := c.resourceInterface(qualifiedKind(resource), namespace)
+	if err != nil {
+		return nil, err
+	}
+	var uns unstructured.Unstructured
+	uns.SetUnstructuredContent(resource)
+	result, err := cli.Create(&uns, v1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return result.UnstructuredContent(), nil
+}
+
+// Propagation decides what happens to the dependents of a deleted
+// resource, e.g. the pods of a deployment.
+type Propagation string
+
+const (
+	// DEFAULT_PROPAGATION leaves it to the server, which usually
+	// deletes the dependents in the background.
+	DEFAULT_PROPAGATION Propagation = ""
+	// ORPHAN leaves the dependents alone.
+	ORPHAN Propagation = "Orphan"
+	// BACKGROUND deletes the resource right away and the dependents
+	// after it.
+	BACKGROUND Propagation = "Background"
+	// FOREGROUND deletes the dependents before the resource.
+	FOREGROUND Propagation = "Foreground"
+)
+
+func (p Propagation) deleteOptions() *v1.DeleteOptions {
+	options := &v1.DeleteOptions{}
+	if p != DEFAULT_PROPAGATION {
+		policy := v1.DeletionPropagation(p)
+		options.PropagationPolicy = &policy
+	}
+	return options
+}
+
+// Delete deletes the resource, which only needs to have its kind, name
+// and, if it is namespaced, namespace set.
+func (c *Client) Delete(resource Resource, propagation Propagation) error {
+	cli, _, err := c.resourceInterface(qualifiedKind(resource), resource.Namespace())
+	if err != nil {
+		return err
+	}
+	return cli.Delete(resource.Name(), propagation.deleteOptions())
+}
+
+// DeleteCollection deletes all resources of the given type that match
+// the selectors, see SelectiveList, in one request. The empty
+// namespace means all namespaces.
+func (c *Client) DeleteCollection(namespace, resource, fieldSelector, labelSelector string,
+	propagation Propagation) error {
+	cli, _, err := c.resourceInterface(resource, namespace)
+	if err != nil {
+		return err
+	}
+	return cli.DeleteCollection(propagation.deleteOptions(), v1.ListOptions{
 		FieldSelector: fieldSelector,
 		LabelSelector: labelSelector,
 	})
+}
+
+// PatchType is the kind of patch passed to Patch.
+type PatchType string
+
+const (
+	// JSON_PATCH is a JSON Patch (RFC 6902), see also Diff.
+	JSON_PATCH PatchType = "application/json-patch+json"
+	// MERGE_PATCH is a JSON Merge Patch (RFC 7386), lists are
+	// replaced as a whole.
+	MERGE_PATCH PatchType = "application/merge-patch+json"
+	// STRATEGIC_MERGE_PATCH is like MERGE_PATCH but merges lists
+	// of the built in kinds according to their keys, e.g. the
+	// containers of a pod by name. It doesn't work for custom
+	// resources.
+	STRATEGIC_MERGE_PATCH PatchType = "application/strategic-merge-patch+json"
+)
+
+// Patch applies the patch to the resource, which only needs to have
+// its kind, name and, if it is namespaced, namespace set. Unlike
+// updating, patching doesn't fail if the resource changed since it was
+// read, so there is no need to retry on conflicts.
+func (c *Client) Patch(resource Resource, patchType PatchType, data []byte) (Resource, error) {
+	cli, _, err := c.resourceInterface(qualifiedKind(resource), resource.Namespace())
+	if err != nil {
+		return nil, err
+	}
+	result, err := cli.Patch(resource.Name(), types.PatchType(patchType), data, v1.UpdateOptions{})
 	if err != nil {
 		return nil, err
 	}
+	return result.UnstructuredContent(), nil
+}
 
-	result := make([]Resource, len(uns.Items))
-	for idx, un := range uns.Items {
-		result[idx] = un.UnstructuredContent()
+// APPLY_PATCH is the content type of server side apply requests.
+const APPLY_PATCH = "application/apply-patch+yaml"
+
+// Apply applies the resource, a complete manifest including apiVersion
+// and kind, with server side apply on behalf of the field manager. The
+// server keeps track of the fields each manager applied, so only the
+// fields in the manifest are changed, fields the manifest no longer
+// sets are removed if this manager set them, and fields set by other
+// controllers are left alone. Applying a field that another manager
+// owns fails with a conflict unless force is set, in which case the
+// field is taken over. The cluster has to support server side apply.
+func (c *Client) Apply(resource Resource, fieldManager string, force bool) (Resource, error) {
+	ri, err := c.LookupResourceTypeOf(resource)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.disco == nil {
+		return nil, errors.New("no discovery client")
+	}
+	req := c.disco.RESTClient().Patch(types.PatchType(APPLY_PATCH)).
+		AbsPath(c.resourcePath(ri, resource)).
+		Param("fieldManager", fieldManager)
+	if force {
+		req = req.Param("force", "true")
+	}
+	body, err := req.Body(data).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	var result Resource
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// resourcePath returns the api path of the resource, which is in the
+// namespace of the client unless it names one, just like with Create.
+func (c *Client) resourcePath(rt ResourceType, resource Resource) string {
+	namespace := resource.Namespace()
+	if namespace == "" {
+		namespace = c.Namespace()
+	}
+	return rt.path(namespace, resource.Name())
+}
+
+// path returns the api path of the named resource of this type.
+func (rt ResourceType) path(namespace, name string) string {
+	var parts []string
+	if rt.Group == "" {
+		parts = append(parts, "/api", rt.Version)
+	} else {
+		parts = append(parts, "/apis", rt.Group, rt.Version)
+	}
+	if rt.Namespaced {
+		parts = append(parts, "namespaces", namespace)
+	}
+	parts = append(parts, rt.Name, name)
+	return strings.Join(parts, "/")
+}
+
+// LIST_PAGE_SIZE is the number of resources requested at a time when
+// listing, so that listing lots of resources doesn't time out.
+var LIST_PAGE_SIZE int64 = 500
+
+// listPages lists the resources a page at a time and returns all of
+// them in one list. The pages are a consistent snapshot, the server
+// fails the listing if it can't provide that.
+func listPages(resource dynamic.ResourceInterface, options v1.ListOptions) (*unstructured.UnstructuredList, error) {
+	options.Limit = LIST_PAGE_SIZE
+	var result *unstructured.UnstructuredList
+	for {
+		page, err := resource.List(options)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = page
+		} else {
+			result.Items = append(result.Items, page.Items...)
+		}
+		if page.GetContinue() == "" {
+			break
+		}
+		options.Continue = page.GetContinue()
 	}
+	result.SetContinue("")
 	return result, nil
 }
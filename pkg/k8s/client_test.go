@@ -1,7 +1,15 @@
 package k8s
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
 	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 func TestList(t *testing.T) {
@@ -35,3 +43,299 @@ func TestList(t *testing.T) {
 		t.Errorf("did not find xmas")
 	}
 }
+
+func TestLookupResourceType(t *testing.T) {
+	deployments := v1.APIResource{Name: "deployments", SingularName: "deployment", Kind: "Deployment",
+		Namespaced: true, ShortNames: []string{"deploy"}}
+	c := &Client{resources: []*v1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []v1.APIResource{
+			{Name: "events", SingularName: "event", Kind: "Event", Namespaced: true, ShortNames: []string{"ev"}},
+			{Name: "pods", SingularName: "pod", Kind: "Pod", Namespaced: true, ShortNames: []string{"po"}},
+			{Name: "pods/status", Kind: "Pod", Namespaced: true},
+		}},
+		{GroupVersion: "extensions/v1beta1", APIResources: []v1.APIResource{deployments}},
+		{GroupVersion: "apps/v1", APIResources: []v1.APIResource{deployments}},
+		{GroupVersion: "apps/v1beta2", APIResources: []v1.APIResource{deployments}},
+		{GroupVersion: "events.k8s.io/v1beta1", APIResources: []v1.APIResource{
+			{Name: "events", SingularName: "event", Kind: "Event", Namespaced: true, ShortNames: []string{"ev"}},
+		}},
+		{GroupVersion: "a.example.com/v1", APIResources: []v1.APIResource{
+			{Name: "mappings", SingularName: "mapping", Kind: "Mapping", ShortNames: []string{"mp", "po"}},
+		}},
+		{GroupVersion: "b.example.com/v1", APIResources: []v1.APIResource{
+			{Name: "maps", SingularName: "map", Kind: "Map", ShortNames: []string{"mp"}},
+		}},
+	}}
+
+	for input, expected := range map[string]string{
+		"pod":                       "pods.v1",
+		"Pod":                       "pods.v1",
+		"po":                        "pods.v1",
+		"pods.v1":                   "pods.v1",
+		"deployments":               "deployments.v1beta1.extensions",
+		"deploy":                    "deployments.v1beta1.extensions",
+		"deployments.apps":          "deployments.v1.apps",
+		"deployments.v1beta2.apps":  "deployments.v1beta2.apps",
+		"events":                    "events.v1",
+		"ev":                        "events.v1",
+		"events.events.k8s.io":      "events.v1beta1.events.k8s.io",
+		"mapping":                   "mappings.v1.a.example.com",
+		"mp.b.example.com":          "maps.v1.b.example.com",
+		"mappings.v1.a.example.com": "mappings.v1.a.example.com",
+	} {
+		rt, err := c.LookupResourceType(input)
+		if err != nil {
+			t.Errorf("%s: %v", input, err)
+			continue
+		}
+		if rt.String() != expected {
+			t.Errorf("%s: got %s, expected %s", input, rt, expected)
+		}
+	}
+
+	for input, expected := range map[string]string{
+		"":                    "empty resource string",
+		"foos":                "unrecognized resource: foos",
+		"deployments.v2.apps": "unrecognized resource: deployments.v2.apps",
+		"mp":                  "ambiguous resource mp, candidates are: mappings.v1.a.example.com, maps.v1.b.example.com",
+	} {
+		_, err := c.LookupResourceType(input)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: got %v, expected %s", input, err, expected)
+		}
+		if IsUnrecognized(err) != strings.HasPrefix(expected, "unrecognized") {
+			t.Errorf("%s: IsUnrecognized(%v) is wrong", input, err)
+		}
+	}
+}
+
+func TestListAmbiguities(t *testing.T) {
+	c := &Client{resources: []*v1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []v1.APIResource{
+			{Name: "endpoints", SingularName: "endpoints", Kind: "Endpoints", Namespaced: true,
+				ShortNames: []string{"ep"}},
+		}},
+		{GroupVersion: "x.example.com/v1", APIResources: []v1.APIResource{
+			{Name: "endpoints", SingularName: "endpoint", Kind: "Endpoint", Namespaced: true},
+		}},
+		{GroupVersion: "a.example.com/v1", APIResources: []v1.APIResource{
+			{Name: "mappings", SingularName: "mapping", Kind: "Mapping", ShortNames: []string{"mp"}},
+		}},
+		{GroupVersion: "b.example.com/v1", APIResources: []v1.APIResource{
+			{Name: "maps", SingularName: "map", Kind: "Map", ShortNames: []string{"mp"}},
+		}},
+	}}
+
+	var names []string
+	for _, r := range c.ResolveNames() {
+		names = append(names, r.Name)
+	}
+	expected := "endpoint endpoints ep map mapping mappings maps mp"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("got names %v, expected %s", names, expected)
+	}
+
+	var out bytes.Buffer
+	err := PrintNameResolutions(&out, c.ListAmbiguities())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `NAME       RESOURCE      KIND       NAMESPACED  CANDIDATES
+endpoints  endpoints.v1  Endpoints  true        endpoints.v1, endpoints.v1.x.example.com
+mp         <ambiguous>                          mappings.v1.a.example.com, maps.v1.b.example.com
+`
+	if out.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestResourceTypePath(t *testing.T) {
+	for _, tt := range []struct {
+		rt       ResourceType
+		expected string
+	}{
+		{ResourceType{Version: "v1", Name: "pods", Namespaced: true}, "/api/v1/namespaces/ns/pods/foo"},
+		{ResourceType{Version: "v1", Name: "nodes"}, "/api/v1/nodes/foo"},
+		{ResourceType{Group: "apps", Version: "v1", Name: "deployments", Namespaced: true},
+			"/apis/apps/v1/namespaces/ns/deployments/foo"},
+	} {
+		if path := tt.rt.path("ns", "foo"); path != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, path)
+		}
+	}
+}
+
+func TestResourcePath(t *testing.T) {
+	pods := ResourceType{Version: "v1", Name: "pods", Namespaced: true}
+	nodes := ResourceType{Version: "v1", Name: "nodes"}
+	c := &Client{namespace: "staging"}
+	for _, tt := range []struct {
+		rt       ResourceType
+		resource Resource
+		expected string
+	}{
+		{pods, Resource{"metadata": map[string]interface{}{"name": "foo"}}, "/api/v1/namespaces/staging/pods/foo"},
+		{pods, Resource{"metadata": map[string]interface{}{"name": "foo", "namespace": "ns"}},
+			"/api/v1/namespaces/ns/pods/foo"},
+		{nodes, Resource{"metadata": map[string]interface{}{"name": "foo"}}, "/api/v1/nodes/foo"},
+	} {
+		if path := c.resourcePath(tt.rt, tt.resource); path != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, path)
+		}
+	}
+}
+
+func TestQualifiedKind(t *testing.T) {
+	for _, tt := range []struct {
+		resource Resource
+		expected string
+	}{
+		{Resource{"apiVersion": "apps/v1", "kind": "Deployment"}, "Deployment.v1.apps"},
+		{Resource{"apiVersion": "v1", "kind": "Service"}, "Service.v1"},
+		{Resource{"kind": "Service"}, "Service"},
+	} {
+		if kind := qualifiedKind(tt.resource); kind != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, kind)
+		}
+	}
+}
+
+func TestKubectlImpersonation(t *testing.T) {
+	info := &KubeInfo{Context: "ctx", Namespace: "ns", ImpersonateUser: "alice",
+		ImpersonateGroups: []string{"devs", "ops"}}
+	expected := "--context ctx --namespace ns --as alice --as-group devs --as-group ops get pods"
+	if args := strings.Join(info.GetKubectlArray("get", "pods"), " "); args != expected {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+}
+
+func TestExecToken(t *testing.T) {
+	token, err := execToken(&clientcmdapi.ExecConfig{
+		Command:    "sh",
+		Args:       []string{"-c", `echo "{\"kind\": \"ExecCredential\", \"status\": {\"token\": \"$TOKEN\"}}"`},
+		Env:        []clientcmdapi.ExecEnvVar{{Name: "TOKEN", Value: "secret"}},
+		APIVersion: "client.authentication.k8s.io/v1beta1",
+	})
+	if err != nil || token != "secret" {
+		t.Errorf("expected secret, got %q, %v", token, err)
+	}
+	if _, err := execToken(&clientcmdapi.ExecConfig{Command: "false"}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestPatch(t *testing.T) {
+	c := NewClient(nil)
+	svc := Resource{
+		"kind":     "Service",
+		"metadata": map[string]interface{}{"name": "kubernetes", "namespace": "default"},
+	}
+	result, err := c.Patch(svc, MERGE_PATCH, []byte(`{"metadata": {"labels": {"patched": "yes"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Labels()["patched"] != "yes" {
+		t.Errorf("expected the patched label, got %v", result.Labels())
+	}
+
+	result, err = c.Patch(svc, JSON_PATCH, []byte(`[{"op": "remove", "path": "/metadata/labels/patched"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Labels()["patched"]; ok {
+		t.Errorf("expected the patched label to be gone, got %v", result.Labels())
+	}
+}
+
+func TestCreateDelete(t *testing.T) {
+	c := NewClient(nil)
+	for _, name := range []string{"crud-one", "crud-two"} {
+		cm := Resource{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":   name,
+				"labels": map[string]interface{}{"test": "crud"},
+			},
+		}
+		result, err := c.Create(cm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Namespace() != "default" {
+			t.Errorf("expected the default namespace, got %q", result.Namespace())
+		}
+	}
+
+	err := c.Delete(Resource{"kind": "ConfigMap", "metadata": map[string]interface{}{
+		"name": "crud-one", "namespace": "default"}}, FOREGROUND)
+	if err != nil {
+		t.Error(err)
+	}
+	err = c.DeleteCollection("default", "configmaps", "", "test=crud", DEFAULT_PROPAGATION)
+	if err != nil {
+		t.Error(err)
+	}
+
+	left, err := c.SelectiveList("default", "configmaps", "", "test=crud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cm := range left {
+		if cm.Metadata()["deletionTimestamp"] == nil {
+			t.Errorf("expected %s to be deleted", cm.QName())
+		}
+	}
+}
+
+// pagedResource serves a fixed number of items a page at a time.
+type pagedResource struct {
+	dynamic.ResourceInterface
+	items int
+	calls int
+}
+
+func (r *pagedResource) List(options v1.ListOptions) (*unstructured.UnstructuredList, error) {
+	r.calls++
+	start := 0
+	if options.Continue != "" {
+		fmt.Sscanf(options.Continue, "%d", &start)
+	}
+	end := start + int(options.Limit)
+	if end > r.items {
+		end = r.items
+	}
+	result := &unstructured.UnstructuredList{}
+	for i := start; i < end; i++ {
+		result.Items = append(result.Items, unstructured.Unstructured{Object: map[string]interface{}{"index": i}})
+	}
+	if end < r.items {
+		result.SetContinue(fmt.Sprintf("%d", end))
+	}
+	return result, nil
+}
+
+func TestListPages(t *testing.T) {
+	defer func(size int64) { LIST_PAGE_SIZE = size }(LIST_PAGE_SIZE)
+	LIST_PAGE_SIZE = 3
+
+	resource := &pagedResource{items: 8}
+	list, err := listPages(resource, v1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resource.calls != 3 {
+		t.Errorf("expected 3 pages, got %d", resource.calls)
+	}
+	if len(list.Items) != 8 {
+		t.Fatalf("expected 8 items, got %d", len(list.Items))
+	}
+	for i, item := range list.Items {
+		if item.Object["index"] != i {
+			t.Errorf("item %d out of order: %v", i, item.Object["index"])
+		}
+	}
+	if list.GetContinue() != "" {
+		t.Errorf("expected no continue token, got %q", list.GetContinue())
+	}
+}
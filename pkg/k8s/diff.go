@@ -0,0 +1,143 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operation is a single JSON Patch (RFC 6902) operation, one of
+// "add", "remove" or "replace".
+type Operation struct {
+	Op    string
+	Path  string
+	Value interface{}
+	// Old is the value that is removed or replaced, it is not
+	// part of the JSON Patch.
+	Old interface{}
+}
+
+// MarshalJSON renders the operation as JSON Patch, remove operations
+// have no value.
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(map[string]interface{}{"op": o.Op, "path": o.Path})
+	}
+	return json.Marshal(map[string]interface{}{"op": o.Op, "path": o.Path, "value": o.Value})
+}
+
+// String renders the operation for humans, additions are prefixed
+// with "+", removals with "-" and replacements with "~", e.g.
+// `~ /spec/replicas: 1 -> 3`.
+func (o Operation) String() string {
+	switch o.Op {
+	case "add":
+		return fmt.Sprintf("+ %s: %s", o.Path, formatValue(o.Value))
+	case "remove":
+		return fmt.Sprintf("- %s: %s", o.Path, formatValue(o.Old))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", o.Path, formatValue(o.Old), formatValue(o.Value))
+	}
+}
+
+// Patch is a list of operations, see Diff.
+type Patch []Operation
+
+// String renders the patch for humans, one operation per line.
+func (p Patch) String() string {
+	var result strings.Builder
+	for _, op := range p {
+		result.WriteString(op.String())
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+// Diff returns the JSON Patch that turns from into to. Maps are
+// compared key by key in sorted order, lists element by element with
+// elements added or removed at the end, and numbers compare equal
+// regardless of whether they were decoded from json or yaml. An empty
+// patch means the resources are the same.
+func Diff(from, to Resource) Patch {
+	var patch Patch
+	diffValue("", map[string]interface{}(from), map[string]interface{}(to), &patch)
+	return patch
+}
+
+func diffValue(path string, from, to interface{}, patch *Patch) {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		if t, ok := to.(map[string]interface{}); ok {
+			diffMaps(path, f, t, patch)
+			return
+		}
+	case []interface{}:
+		if t, ok := to.([]interface{}); ok {
+			diffLists(path, f, t, patch)
+			return
+		}
+	}
+	if !equal(from, to) {
+		*patch = append(*patch, Operation{Op: "replace", Path: path, Value: to, Old: from})
+	}
+}
+
+func diffMaps(path string, from, to map[string]interface{}, patch *Patch) {
+	keys := make([]string, 0, len(from)+len(to))
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		fv, fok := from[k]
+		tv, tok := to[k]
+		switch {
+		case !tok:
+			*patch = append(*patch, Operation{Op: "remove", Path: p, Old: fv})
+		case !fok:
+			*patch = append(*patch, Operation{Op: "add", Path: p, Value: tv})
+		default:
+			diffValue(p, fv, tv, patch)
+		}
+	}
+}
+
+func diffLists(path string, from, to []interface{}, patch *Patch) {
+	common := len(from)
+	if len(to) < common {
+		common = len(to)
+	}
+	for i := 0; i < common; i++ {
+		diffValue(path+"/"+strconv.Itoa(i), from[i], to[i], patch)
+	}
+	for i := common; i < len(to); i++ {
+		*patch = append(*patch, Operation{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: to[i]})
+	}
+	// removed from the end so that the remaining indexes stay valid
+	for i := len(from) - 1; i >= common; i-- {
+		*patch = append(*patch, Operation{Op: "remove", Path: path + "/" + strconv.Itoa(i), Old: from[i]})
+	}
+}
+
+// escapePointer escapes a key for use in a JSON Pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// formatValue renders a value as json.
+func formatValue(v interface{}) string {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(bytes)
+}
@@ -0,0 +1,66 @@
+package k8s
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	from := Resource{
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{
+			"name":   "foo",
+			"labels": map[string]interface{}{"a/b": "x", "old": "y"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 1,
+			"args":     []interface{}{"a", "b", "c"},
+		},
+	}
+	to := Resource{
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{
+			"name":   "foo",
+			"labels": map[string]interface{}{"a/b": "x", "new": "z"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"args":     []interface{}{"a"},
+			"paused":   nil,
+		},
+	}
+
+	patch := Diff(from, to)
+	expected := `[{"op":"add","path":"/metadata/labels/new","value":"z"},` +
+		`{"op":"remove","path":"/metadata/labels/old"},` +
+		`{"op":"remove","path":"/spec/args/2"},` +
+		`{"op":"remove","path":"/spec/args/1"},` +
+		`{"op":"add","path":"/spec/paused","value":null},` +
+		`{"op":"replace","path":"/spec/replicas","value":3}]`
+	bytes, err := json.Marshal(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bytes) != expected {
+		t.Errorf("expected %s, got %s", expected, bytes)
+	}
+
+	human := "+ /metadata/labels/new: \"z\"\n" +
+		"- /metadata/labels/old: \"y\"\n" +
+		"- /spec/args/2: \"c\"\n" +
+		"- /spec/args/1: \"b\"\n" +
+		"+ /spec/paused: null\n" +
+		"~ /spec/replicas: 1 -> 3\n"
+	if patch.String() != human {
+		t.Errorf("expected:\n%s\ngot:\n%s", human, patch.String())
+	}
+
+	if len(Diff(to, to)) != 0 {
+		t.Errorf("expected no difference, got %v", Diff(to, to))
+	}
+
+	escaped := Diff(Resource{}, Resource{"a~b/c": 1})
+	if len(escaped) != 1 || escaped[0].Path != "/a~0b~1c" {
+		t.Errorf("unexpected patch: %v", escaped)
+	}
+}
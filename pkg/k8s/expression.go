@@ -0,0 +1,410 @@
+package k8s
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a condition evaluated against a resource, e.g.
+// "status.readyReplicas == spec.replicas". An expression is made of
+//
+//   - paths into the resource: status.phase, spec.containers[0].name,
+//     metadata.labels["app.kubernetes.io/name"]
+//   - literals: 3, 1.5, "Running", 'Running', true, false, null
+//   - comparisons: ==, !=, <, <=, >, >=
+//   - the boolean operators !, && and ||, and parentheses
+//
+// Paths that don't exist evaluate to null. Numbers compare
+// numerically regardless of how they were decoded. A value that isn't
+// a comparison is true unless it is null, false, 0, "" or empty.
+type Expression struct {
+	source string
+	root   node
+}
+
+// ParseExpression parses an Expression.
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against a resource.
+func (e *Expression) Eval(r Resource) bool {
+	return truthy(e.root.eval(map[string]interface{}(r)))
+}
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", "."}
+
+func tokenize(s string) (tokens []token, err error) {
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexRune(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{tokString, s[i+1 : i+1+end]})
+			i += end + 2
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1]))):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, s[i:j]})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, s[i:j]})
+			i = j
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{tokOp, op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+		}
+	}
+	return
+}
+
+type node interface {
+	eval(r map[string]interface{}) interface{}
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n literal) eval(map[string]interface{}) interface{} { return n.value }
+
+type path []interface{}
+
+func (n path) eval(r map[string]interface{}) interface{} {
+	var value interface{} = r
+	for _, step := range n {
+		switch step := step.(type) {
+		case string:
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value = m[step]
+		case int:
+			l, ok := value.([]interface{})
+			if !ok || step < 0 || step >= len(l) {
+				return nil
+			}
+			value = l[step]
+		}
+	}
+	return value
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) eval(r map[string]interface{}) interface{} { return !truthy(n.operand.eval(r)) }
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n binary) eval(r map[string]interface{}) interface{} {
+	switch n.op {
+	case "&&":
+		return truthy(n.left.eval(r)) && truthy(n.right.eval(r))
+	case "||":
+		return truthy(n.left.eval(r)) || truthy(n.right.eval(r))
+	}
+
+	left, right := n.left.eval(r), n.right.eval(r)
+	switch n.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+
+	// ordering only makes sense for two numbers or two strings
+	var cmp int
+	lf, lok := number(left)
+	rf, rok := number(right)
+	ls, lsok := left.(string)
+	rs, rsok := right.(string)
+	switch {
+	case lok && rok:
+		cmp = compareFloats(lf, rf)
+	case lsok && rsok:
+		cmp = strings.Compare(ls, rs)
+	default:
+		return false
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// number converts the numeric types that show up in resources decoded
+// from either json or yaml.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func equal(a, b interface{}) bool {
+	af, aok := number(a)
+	bf, bok := number(b)
+	if aok && bok {
+		return af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	if f, ok := number(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek(text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokOp && p.tokens[p.pos].text == text
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.peek(text) {
+		return p.unexpected()
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) unexpected() error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+}
+
+func (p *exprParser) or() (node, error) {
+	left, err := p.and()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var right node
+		right, err = p.and()
+		left = binary{"||", left, right}
+	}
+	return left, err
+}
+
+func (p *exprParser) and() (node, error) {
+	left, err := p.comparison()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var right node
+		right, err = p.comparison()
+		left = binary{"&&", left, right}
+	}
+	return left, err
+}
+
+func (p *exprParser) comparison() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.peek(op) {
+			p.pos++
+			right, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return binary{op, left, right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) unary() (node, error) {
+	if p.peek("!") {
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (node, error) {
+	if p.peek("(") {
+		p.pos++
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, p.unexpected()
+	}
+	tok := p.tokens[p.pos]
+	switch tok.kind {
+	case tokNumber:
+		p.pos++
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", tok.text)
+		}
+		return literal{f}, nil
+	case tokString:
+		p.pos++
+		return literal{tok.text}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			p.pos++
+			return literal{true}, nil
+		case "false":
+			p.pos++
+			return literal{false}, nil
+		case "null":
+			p.pos++
+			return literal{nil}, nil
+		}
+		return p.path()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *exprParser) path() (node, error) {
+	result := path{p.tokens[p.pos].text}
+	p.pos++
+	for {
+		switch {
+		case p.peek("."):
+			p.pos++
+			if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokIdent {
+				return nil, p.unexpected()
+			}
+			result = append(result, p.tokens[p.pos].text)
+			p.pos++
+		case p.peek("["):
+			p.pos++
+			if p.pos >= len(p.tokens) {
+				return nil, p.unexpected()
+			}
+			tok := p.tokens[p.pos]
+			switch tok.kind {
+			case tokString:
+				result = append(result, tok.text)
+			case tokNumber:
+				idx, err := strconv.Atoi(tok.text)
+				if err != nil {
+					return nil, fmt.Errorf("bad index %q", tok.text)
+				}
+				result = append(result, idx)
+			default:
+				return nil, p.unexpected()
+			}
+			p.pos++
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			return result, nil
+		}
+	}
+}
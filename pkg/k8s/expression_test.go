@@ -0,0 +1,69 @@
+package k8s
+
+import (
+	"testing"
+)
+
+func TestExpression(t *testing.T) {
+	deployment := Resource{
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{
+			"name":   "foo",
+			"labels": map[string]interface{}{"app.kubernetes.io/name": "foo"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 3,
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app"},
+					},
+				},
+			},
+		},
+		"status": map[string]interface{}{
+			"readyReplicas": float64(3),
+			"phase":         "Running",
+		},
+	}
+
+	for source, expected := range map[string]bool{
+		"status.readyReplicas == spec.replicas":                            true,
+		"status.readyReplicas != spec.replicas":                            false,
+		"status.readyReplicas >= 2 && status.readyReplicas < 4":            true,
+		"status.readyReplicas > 3 || status.phase == 'Running'":            true,
+		"!(status.phase == \"Running\")":                                   false,
+		"status.phase":                                                     true,
+		"status.missing":                                                   false,
+		"status.missing == null":                                           true,
+		"status.missing > 1":                                               false,
+		"spec.template.spec.containers[0].name == 'app'":                   true,
+		"spec.template.spec.containers[1].name == 'app'":                   false,
+		"metadata.labels[\"app.kubernetes.io/name\"] == metadata.name":     true,
+		"status.phase < 'Succeeded' && -1 < 0 && true && !false":           true,
+		"(status.readyReplicas == 1 || status.readyReplicas == 3) && true": true,
+	} {
+		expr, err := ParseExpression(source)
+		if err != nil {
+			t.Errorf("%s: %v", source, err)
+			continue
+		}
+		if result := expr.Eval(deployment); result != expected {
+			t.Errorf("%s: got %v, expected %v", source, result, expected)
+		}
+	}
+
+	for _, source := range []string{
+		"",
+		"status.phase ==",
+		"status.phase == 'Running",
+		"(status.phase",
+		"status.",
+		"status.phase $ 3",
+		"spec.containers[name]",
+	} {
+		if _, err := ParseExpression(source); err == nil {
+			t.Errorf("%s: expected an error", source)
+		}
+	}
+}
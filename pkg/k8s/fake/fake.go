@@ -0,0 +1,102 @@
+// Package fake provides a k8s.Client that keeps its resources in
+// memory, backed by client-go's fake dynamic client, so that code
+// that lists and watches resources, e.g. watt's listeners and
+// aggregator, can be unit tested without a cluster.
+package fake
+
+import (
+	"github.com/datawire/teleproxy/pkg/k8s"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// NAMESPACE is the namespace of a fake client, see k8s.Client.Namespace.
+const NAMESPACE = "default"
+
+// TYPES are the resource types every fake client serves.
+var TYPES = []k8s.ResourceType{
+	{Version: "v1", Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+	{Version: "v1", Name: "endpoints", Kind: "Endpoints", Namespaced: true},
+	{Version: "v1", Name: "events", Kind: "Event", Namespaced: true},
+	{Version: "v1", Name: "namespaces", Kind: "Namespace"},
+	{Version: "v1", Name: "nodes", Kind: "Node"},
+	{Version: "v1", Name: "pods", Kind: "Pod", Namespaced: true},
+	{Version: "v1", Name: "secrets", Kind: "Secret", Namespaced: true},
+	{Version: "v1", Name: "services", Kind: "Service", Namespaced: true},
+	{Group: "apps", Version: "v1", Name: "deployments", Kind: "Deployment", Namespaced: true},
+	{Group: "extensions", Version: "v1beta1", Name: "ingresses", Kind: "Ingress", Namespaced: true},
+}
+
+// NewClient returns a client of an in-memory cluster that serves TYPES
+// and the given types, e.g. of custom resources, and holds the given
+// resources. Everything a watcher of the client, see
+// k8s.Client.Watcher, watches is in sync as soon as it is started,
+// and changes made through the client are delivered to its listeners
+// like those made to a real cluster.
+//
+// The fake dynamic client only knows label selectors, field selectors
+// are ignored. It doesn't assign resource versions or uids either.
+func NewClient(types []k8s.ResourceType, resources ...k8s.Resource) (*k8s.Client, error) {
+	dyn := fakeDynamic{dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())}
+	c := k8s.NewClientForDynamic(dyn, NAMESPACE, append(append([]k8s.ResourceType{}, TYPES...), types...)...)
+	for _, resource := range resources {
+		_, err := c.Create(resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// fakeDynamic fills in the namespace of the resources that are created
+// or updated without one, which the fake dynamic client insists on
+// unlike the api server.
+type fakeDynamic struct {
+	dynamic.Interface
+}
+
+func (d fakeDynamic) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return fakeResource{d.Interface.Resource(gvr)}
+}
+
+type fakeResource struct {
+	dynamic.NamespaceableResourceInterface
+}
+
+func (r fakeResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return namespacedResource{r.NamespaceableResourceInterface.Namespace(namespace), namespace}
+}
+
+type namespacedResource struct {
+	dynamic.ResourceInterface
+	namespace string
+}
+
+func (r namespacedResource) withNamespace(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if obj.GetNamespace() != "" {
+		return obj
+	}
+	obj = obj.DeepCopy()
+	obj.SetNamespace(r.namespace)
+	return obj
+}
+
+func (r namespacedResource) Create(obj *unstructured.Unstructured, options v1.CreateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	return r.ResourceInterface.Create(r.withNamespace(obj), options, subresources...)
+}
+
+func (r namespacedResource) Update(obj *unstructured.Unstructured, options v1.UpdateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	return r.ResourceInterface.Update(r.withNamespace(obj), options, subresources...)
+}
+
+func (r namespacedResource) UpdateStatus(obj *unstructured.Unstructured,
+	options v1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return r.ResourceInterface.UpdateStatus(r.withNamespace(obj), options)
+}
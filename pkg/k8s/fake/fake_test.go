@@ -0,0 +1,68 @@
+package fake
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+	"github.com/stretchr/testify/require"
+)
+
+func service(name string) k8s.Resource {
+	return k8s.Resource{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": name},
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c, err := NewClient(nil, service("foo"))
+	require.NoError(t, err)
+
+	w := c.Watcher()
+	var seen [][]string
+	err = w.Watch("services", func(w *k8s.Watcher) {
+		var names []string
+		for _, r := range w.List("services") {
+			names = append(names, r.QName())
+		}
+		sort.Strings(names)
+		seen = append(seen, names)
+		if len(seen) == 1 {
+			_, err := c.Create(service("bar"))
+			require.NoError(t, err)
+		} else {
+			w.Stop()
+		}
+	})
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(10 * time.Second)
+		w.Stop()
+	}()
+	w.Wait()
+	require.Equal(t, [][]string{{"foo.default"}, {"bar.default", "foo.default"}}, seen)
+}
+
+func TestCustomTypes(t *testing.T) {
+	mapping := k8s.Resource{
+		"apiVersion": "getambassador.io/v1",
+		"kind":       "Mapping",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "ambassador"},
+	}
+
+	_, err := NewClient(nil, mapping)
+	require.True(t, k8s.IsUnrecognized(err))
+
+	c, err := NewClient([]k8s.ResourceType{
+		{Group: "getambassador.io", Version: "v1", Name: "mappings", Kind: "Mapping", Namespaced: true},
+	}, mapping)
+	require.NoError(t, err)
+	mappings, err := c.ListNamespace("ambassador", "mappings")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(mappings))
+	require.Equal(t, "foo.ambassador", mappings[0].QName())
+}
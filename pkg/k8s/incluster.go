@@ -0,0 +1,97 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// SERVICE_ACCOUNT_TOKEN is where the token of the service account of a
+// pod is mounted.
+var SERVICE_ACCOUNT_TOKEN = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// TOKEN_REFRESH is how often the service account token is reread.
+var TOKEN_REFRESH = time.Minute
+
+// refreshInClusterToken makes the config reread the service account
+// token if that is what it authenticates with. Kubernetes rotates
+// bound service account tokens, so a client that keeps using the
+// token it started with is eventually locked out. Since the token is
+// picked up request by request, running watches carry on with the new
+// token when they reconnect.
+func refreshInClusterToken(config *rest.Config) {
+	if config.BearerToken == "" {
+		return
+	}
+	token, err := ioutil.ReadFile(SERVICE_ACCOUNT_TOKEN)
+	if err != nil || strings.TrimSpace(string(token)) != strings.TrimSpace(config.BearerToken) {
+		return
+	}
+
+	source := &tokenFile{path: SERVICE_ACCOUNT_TOKEN, token: strings.TrimSpace(config.BearerToken), read: time.Now()}
+	config.BearerToken = ""
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &tokenRoundTripper{source, rt}
+	}
+}
+
+// tokenFile is a token that is reread from its file every
+// TOKEN_REFRESH.
+type tokenFile struct {
+	path  string
+	mutex sync.Mutex
+	token string
+	read  time.Time
+}
+
+func (tf *tokenFile) get() string {
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+	if time.Since(tf.read) >= TOKEN_REFRESH {
+		// if the file can't be read, the old token is the
+		// best bet
+		token, err := ioutil.ReadFile(tf.path)
+		if err == nil {
+			tf.token = strings.TrimSpace(string(token))
+			tf.read = time.Now()
+		}
+	}
+	return tf.token
+}
+
+// expire makes the next get reread the token.
+func (tf *tokenFile) expire() {
+	tf.mutex.Lock()
+	defer tf.mutex.Unlock()
+	tf.read = time.Time{}
+}
+
+type tokenRoundTripper struct {
+	source *tokenFile
+	rt     http.RoundTripper
+}
+
+func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a round tripper must not modify the request
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string(nil), v...)
+	}
+	clone.Header.Set("Authorization", "Bearer "+t.source.get())
+
+	resp, err := t.rt.RoundTrip(clone)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.source.expire()
+	}
+	return resp, err
+}
@@ -0,0 +1,75 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+type recorder struct {
+	auth string
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.auth = req.Header.Get("Authorization")
+	return &http.Response{StatusCode: http.StatusOK}, nil
+}
+
+func TestRefreshInClusterToken(t *testing.T) {
+	file, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("one\n")
+	file.Close()
+
+	defer func(path string, refresh time.Duration) {
+		SERVICE_ACCOUNT_TOKEN = path
+		TOKEN_REFRESH = refresh
+	}(SERVICE_ACCOUNT_TOKEN, TOKEN_REFRESH)
+	SERVICE_ACCOUNT_TOKEN = file.Name()
+	TOKEN_REFRESH = time.Hour
+
+	// a token from somewhere else is left alone
+	config := &rest.Config{BearerToken: "other"}
+	refreshInClusterToken(config)
+	if config.BearerToken != "other" || config.WrapTransport != nil {
+		t.Errorf("expected the config to be left alone")
+	}
+
+	config = &rest.Config{BearerToken: "one\n"}
+	refreshInClusterToken(config)
+	if config.BearerToken != "" {
+		t.Errorf("expected the static token to be cleared")
+	}
+	r := &recorder{}
+	rt := config.WrapTransport(r)
+	req, _ := http.NewRequest("GET", "https://kubernetes/api", nil)
+
+	rt.RoundTrip(req)
+	if r.auth != "Bearer one" {
+		t.Errorf("unexpected authorization %q", r.auth)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Errorf("the request was modified")
+	}
+
+	err = ioutil.WriteFile(file.Name(), []byte("two"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.RoundTrip(req)
+	if r.auth != "Bearer one" {
+		t.Errorf("expected the token to be cached, got %q", r.auth)
+	}
+	TOKEN_REFRESH = 0
+	rt.RoundTrip(req)
+	if r.auth != "Bearer two" {
+		t.Errorf("expected the rotated token, got %q", r.auth)
+	}
+}
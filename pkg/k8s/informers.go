@@ -0,0 +1,176 @@
+package k8s
+
+import (
+	"sync"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// informer watches a single namespace, or all namespaces if namespace
+// is empty. The informers of a client are shared by the watches of
+// all its watchers that watch the same resources, see
+// informerRegistry, so that e.g. watt doesn't list and watch the same
+// kind once for every consumer. Only WatchWithEvents gets informers of
+// its own, so that its listener sees each change exactly once.
+type informer struct {
+	namespace  string
+	adapter    *listWatchAdapter
+	store      cache.Indexer
+	controller cache.Controller
+
+	// key identifies the informer in the registry, it is empty
+	// for an informer that isn't shared
+	key      string
+	registry *informerRegistry
+
+	// mutex protects the rest of the state, it is held while the
+	// store is first populated so that is only done once
+	mutex   sync.Mutex
+	synced  bool
+	refs    int
+	running bool
+	stop    chan struct{}
+	done    chan struct{}
+
+	// handlersMu is held while the handlers are invoked, so no
+	// handler is invoked any more once it is detached
+	handlersMu sync.Mutex
+	handlers   map[int]cache.ResourceEventHandler
+	nextID     int
+}
+
+// informerKey identifies the resources an informer watches.
+func informerKey(rt ResourceType, namespace string, query Query) string {
+	key := rt.String() + "|" + namespace + "|" + query.fieldSelector() + "|" + query.LabelSelector
+	if query.MetadataOnly {
+		key += "|metadata"
+	}
+	return key
+}
+
+// informerRegistry keeps track of the shared informers of a client.
+type informerRegistry struct {
+	mutex     sync.Mutex
+	informers map[string]*informer
+}
+
+// acquire returns the informer with the given key, and creates it with
+// the create function if there is none, the handler the function gets
+// passes the changes on to the handlers attached to the informer. An
+// empty key always creates a new informer that isn't shared. Every
+// acquire must be matched by a release.
+func (r *informerRegistry) acquire(key, namespace string,
+	create func(cache.ResourceEventHandler) (*listWatchAdapter, cache.Indexer, cache.Controller)) *informer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	inf, ok := r.informers[key]
+	if !ok || key == "" {
+		inf = &informer{namespace: namespace, key: key, registry: r,
+			handlers: make(map[int]cache.ResourceEventHandler)}
+		inf.adapter, inf.store, inf.controller = create(inf)
+		if key != "" {
+			if r.informers == nil {
+				r.informers = make(map[string]*informer)
+			}
+			r.informers[key] = inf
+		}
+	}
+	inf.mutex.Lock()
+	inf.refs++
+	inf.mutex.Unlock()
+	return inf
+}
+
+// release drops a reference to the informer. Once the last one is
+// gone, the informer is stopped and forgotten, so the next acquire
+// starts from scratch.
+func (inf *informer) release() {
+	r := inf.registry
+	r.mutex.Lock()
+	inf.mutex.Lock()
+	inf.refs--
+	last := inf.refs == 0
+	if last && inf.key != "" && r.informers[inf.key] == inf {
+		delete(r.informers, inf.key)
+	}
+	running := inf.running
+	inf.mutex.Unlock()
+	r.mutex.Unlock()
+
+	if last && running {
+		close(inf.stop)
+		<-inf.done
+	}
+}
+
+// populate fills the store with the given function, unless that has
+// been done already.
+func (inf *informer) populate(fill func(cache.Indexer) error) error {
+	inf.mutex.Lock()
+	defer inf.mutex.Unlock()
+	if inf.synced {
+		return nil
+	}
+	err := fill(inf.store)
+	if err == nil {
+		inf.synced = true
+	}
+	return err
+}
+
+// run starts the informer unless it is running already.
+func (inf *informer) run() {
+	inf.mutex.Lock()
+	defer inf.mutex.Unlock()
+	if inf.running || inf.refs == 0 {
+		return
+	}
+	inf.running = true
+	inf.stop = make(chan struct{})
+	inf.done = make(chan struct{})
+	go func() {
+		defer close(inf.done)
+		inf.controller.Run(inf.stop)
+	}()
+}
+
+// attach adds a handler for the changes to the informer's resources,
+// it returns an id for detach.
+func (inf *informer) attach(handler cache.ResourceEventHandler) int {
+	inf.handlersMu.Lock()
+	defer inf.handlersMu.Unlock()
+	id := inf.nextID
+	inf.nextID++
+	inf.handlers[id] = handler
+	return id
+}
+
+func (inf *informer) detach(id int) {
+	inf.handlersMu.Lock()
+	defer inf.handlersMu.Unlock()
+	delete(inf.handlers, id)
+}
+
+func (inf *informer) OnAdd(obj interface{}) {
+	inf.handlersMu.Lock()
+	defer inf.handlersMu.Unlock()
+	for _, h := range inf.handlers {
+		h.OnAdd(obj)
+	}
+}
+
+func (inf *informer) OnUpdate(oldObj, newObj interface{}) {
+	inf.handlersMu.Lock()
+	defer inf.handlersMu.Unlock()
+	for _, h := range inf.handlers {
+		h.OnUpdate(oldObj, newObj)
+	}
+}
+
+func (inf *informer) OnDelete(obj interface{}) {
+	inf.handlersMu.Lock()
+	defer inf.handlersMu.Unlock()
+	for _, h := range inf.handlers {
+		h.OnDelete(obj)
+	}
+}
@@ -0,0 +1,78 @@
+package k8s
+
+import (
+	"testing"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+// idleController runs until it is stopped.
+type idleController struct {
+	runs int
+}
+
+func (c *idleController) Run(stop <-chan struct{}) {
+	c.runs++
+	<-stop
+}
+
+func (c *idleController) HasSynced() bool                 { return true }
+func (c *idleController) LastSyncResourceVersion() string { return "" }
+
+type countingHandler struct {
+	adds int
+}
+
+func (h *countingHandler) OnAdd(obj interface{})               { h.adds++ }
+func (h *countingHandler) OnUpdate(oldObj, newObj interface{}) {}
+func (h *countingHandler) OnDelete(obj interface{})            {}
+
+func TestInformerRegistry(t *testing.T) {
+	var r informerRegistry
+	created := 0
+	create := func(cache.ResourceEventHandler) (*listWatchAdapter, cache.Indexer, cache.Controller) {
+		created++
+		return nil, nil, &idleController{}
+	}
+
+	one := r.acquire("pods.v1|||", "", create)
+	two := r.acquire("pods.v1|||", "", create)
+	private := r.acquire("", "", create)
+	if one != two || one == private || created != 2 {
+		t.Fatalf("expected a shared and a private informer, created %d", created)
+	}
+
+	h1, h2 := &countingHandler{}, &countingHandler{}
+	id := one.attach(h1)
+	one.attach(h2)
+	one.OnAdd(nil)
+	one.detach(id)
+	one.OnAdd(nil)
+	if h1.adds != 1 || h2.adds != 2 {
+		t.Errorf("expected 1 and 2 adds, got %d and %d", h1.adds, h2.adds)
+	}
+
+	one.run()
+	two.run()
+	one.release()
+	select {
+	case <-one.done:
+		t.Errorf("expected the informer to keep running")
+	default:
+	}
+	two.release()
+	<-one.done
+	if runs := one.controller.(*idleController).runs; runs != 1 {
+		t.Errorf("expected the informer to run once, it ran %d times", runs)
+	}
+	if len(r.informers) != 0 {
+		t.Errorf("expected the informer to be forgotten")
+	}
+
+	three := r.acquire("pods.v1|||", "", create)
+	if three == one {
+		t.Errorf("expected a new informer")
+	}
+	three.release()
+	private.release()
+}
@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Lister is a read-only view of the resources a watcher keeps of one
+// resource type, see Watcher.Lister. It reads the watcher's stores
+// directly, so it can be handed to code that only needs to look
+// things up without handing it the whole Watcher.
+type Lister interface {
+	// List returns the resources that match the label selector,
+	// or all of them if it is empty.
+	List(selector string) ([]Resource, error)
+	// Get returns the named resource, or an empty Resource if
+	// there is none. The name of a namespaced resource is
+	// qualified as in "namespace/name" if the lister is for all
+	// namespaces.
+	Get(name string) Resource
+	// ByIndex returns the resources with the given value in the
+	// index, UID_INDEX or LABEL_INDEX.
+	ByIndex(index, value string) ([]Resource, error)
+}
+
+// Lister returns a Lister of the resources of the given type in the
+// given namespace, or in all watched namespaces if it is empty. The
+// resource type has to be watched already. The lister keeps reading
+// the stores of the watch as it is when the lister is created, so it
+// sees no more changes once the watch is replaced or unwatched, or
+// the watcher stops.
+func (w *Watcher) Lister(gvr schema.GroupVersionResource, namespace string) (Lister, error) {
+	w.watchesMu.RLock()
+	watch, ok := w.watches[ResourceType{Group: gvr.Group, Version: gvr.Version, Name: gvr.Resource}.String()]
+	w.watchesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no watch: %s", gvr.String())
+	}
+	rt := watch.resourceType
+	if !rt.Namespaced {
+		namespace = ""
+	}
+
+	l := &lister{namespaced: rt.Namespaced, namespace: namespace}
+	for _, inf := range watch.informers {
+		if namespace == "" || inf.namespace == "" || inf.namespace == namespace {
+			l.stores = append(l.stores, inf.store)
+		}
+	}
+	if len(l.stores) == 0 {
+		return nil, fmt.Errorf("namespace not watched: %s", namespace)
+	}
+	return l, nil
+}
+
+type lister struct {
+	stores     []cache.Indexer
+	namespaced bool
+	// namespace, if set, filters out the resources of the other
+	// namespaces, which are in the store if it is for all of them
+	namespace string
+}
+
+// filter returns the objects of the lister's namespace that match the
+// selector.
+func (l *lister) filter(objs []interface{}, selector labels.Selector) []Resource {
+	var result []Resource
+	for _, obj := range objs {
+		un, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if l.namespace != "" && un.GetNamespace() != l.namespace {
+			continue
+		}
+		if selector != nil && !selector.Matches(labels.Set(un.GetLabels())) {
+			continue
+		}
+		result = append(result, un.UnstructuredContent())
+	}
+	return result
+}
+
+func (l *lister) List(selector string) ([]Resource, error) {
+	var sel labels.Selector
+	if selector != "" {
+		var err error
+		sel, err = labels.Parse(selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var result []Resource
+	for _, store := range l.stores {
+		result = append(result, l.filter(store.List(), sel)...)
+	}
+	return result, nil
+}
+
+func (l *lister) Get(name string) Resource {
+	key := name
+	if l.namespaced && l.namespace != "" {
+		key = l.namespace + "/" + name
+	}
+	for _, store := range l.stores {
+		obj, exists, err := store.GetByKey(key)
+		if err == nil && exists {
+			return toResource(obj)
+		}
+	}
+	return Resource{}
+}
+
+func (l *lister) ByIndex(index, value string) ([]Resource, error) {
+	var result []Resource
+	for _, store := range l.stores {
+		objs, err := store.ByIndex(index, value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, l.filter(objs, nil)...)
+	}
+	return result, nil
+}
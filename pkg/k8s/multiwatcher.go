@@ -0,0 +1,194 @@
+package k8s
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CLUSTER_ANNOTATION is the annotation that a MultiWatcher tags the
+// resources it lists with, its value is the name of the cluster the
+// resource comes from.
+const CLUSTER_ANNOTATION = "teleproxy.datawire.io/cluster"
+
+// Cluster returns the name of the cluster a resource listed by a
+// MultiWatcher comes from, or "" if it isn't tagged.
+func (r Resource) Cluster() string {
+	cluster, _ := r.Metadata().Annotations()[CLUSTER_ANNOTATION].(string)
+	return cluster
+}
+
+// MultiWatcher watches the same resources in several clusters, e.g.
+// the clusters of several kubeconfig contexts, as if they were one.
+type MultiWatcher struct {
+	clusters []string
+	watchers map[string]*Watcher
+
+	// mutex serializes the listeners, they are only invoked once
+	// every cluster has been started
+	mutex     sync.Mutex
+	started   bool
+	listeners []func()
+}
+
+// NewMultiWatcher returns a MultiWatcher for the clusters of the given
+// clients, keyed by the name of the cluster. The resources of a
+// cluster with an empty name aren't tagged, so that a MultiWatcher
+// with just that cluster lists the same resources as a Watcher.
+func NewMultiWatcher(clients map[string]*Client) *MultiWatcher {
+	m := &MultiWatcher{watchers: make(map[string]*Watcher, len(clients))}
+	for name, client := range clients {
+		m.clusters = append(m.clusters, name)
+		m.watchers[name] = client.Watcher()
+	}
+	sort.Strings(m.clusters)
+	return m
+}
+
+// Clusters returns the names of the clusters in order.
+func (m *MultiWatcher) Clusters() []string {
+	return m.clusters
+}
+
+// Watcher returns the watcher of the named cluster, or nil.
+func (m *MultiWatcher) Watcher(cluster string) *Watcher {
+	return m.watchers[cluster]
+}
+
+// Canonical returns the canonical form of a name, see
+// Watcher.Canonical, as the first cluster sees it.
+func (m *MultiWatcher) Canonical(name string) string {
+	if len(m.clusters) == 0 {
+		return ""
+	}
+	return m.watchers[m.clusters[0]].Canonical(name)
+}
+
+func (m *MultiWatcher) Watch(resources string, listener func(*MultiWatcher)) error {
+	return m.WatchQuery(Query{Kind: resources}, listener)
+}
+
+// WatchQuery watches the resources the query selects in every
+// cluster. The listener is invoked when resources change in any of
+// them.
+func (m *MultiWatcher) WatchQuery(query Query, listener func(*MultiWatcher)) error {
+	return m.watch(listener, func(w *Watcher, l func(*Watcher)) error {
+		return w.WatchQuery(query, l)
+	})
+}
+
+// WatchWhenAvailable is WatchQuery, except that the kind doesn't need
+// to be served yet, see Watcher.WatchWhenAvailable. Each cluster
+// starts watching as soon as it serves the kind.
+func (m *MultiWatcher) WatchWhenAvailable(query Query, listener func(*MultiWatcher)) error {
+	return m.watch(listener, func(w *Watcher, l func(*Watcher)) error {
+		return w.WatchWhenAvailable(query, l)
+	})
+}
+
+// watch adds the listener to every cluster with the given watch
+// function.
+func (m *MultiWatcher) watch(listener func(*MultiWatcher), watch func(*Watcher, func(*Watcher)) error) error {
+	invoke := func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if m.started {
+			listener(m)
+		}
+	}
+	m.mutex.Lock()
+	m.listeners = append(m.listeners, func() { listener(m) })
+	m.mutex.Unlock()
+
+	for _, name := range m.clusters {
+		err := watch(m.watchers[name], func(*Watcher) { invoke() })
+		if err != nil {
+			return m.clusterError(name, err)
+		}
+	}
+	return nil
+}
+
+func (m *MultiWatcher) clusterError(cluster string, err error) error {
+	if cluster == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %v", cluster, err)
+}
+
+// Start starts watching every cluster, and once all of them are
+// listed invokes the listeners. If any cluster fails to start, the
+// ones that were started are stopped again.
+func (m *MultiWatcher) Start() error {
+	for _, name := range m.clusters {
+		err := m.watchers[name].Start()
+		if err != nil {
+			m.Stop()
+			return m.clusterError(name, err)
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.started {
+		m.started = true
+		for _, listener := range m.listeners {
+			listener()
+		}
+	}
+	return nil
+}
+
+// List lists the resources of the given kind in every cluster, tagged
+// with the cluster they come from, see CLUSTER_ANNOTATION.
+func (m *MultiWatcher) List(kind string) []Resource {
+	var result []Resource
+	for _, name := range m.clusters {
+		for _, r := range m.watchers[name].List(kind) {
+			result = append(result, tagCluster(r, name))
+		}
+	}
+	return result
+}
+
+// tagCluster returns a copy of the resource annotated with the
+// cluster, the resource itself belongs to a store and must not be
+// modified.
+func tagCluster(r Resource, cluster string) Resource {
+	if cluster == "" {
+		return r
+	}
+	return r.WithAnnotation(CLUSTER_ANNOTATION, cluster)
+}
+
+// Status returns the status of the watches of every cluster.
+func (m *MultiWatcher) Status() []WatchStatus {
+	var result []WatchStatus
+	for _, name := range m.clusters {
+		for _, status := range m.watchers[name].Status() {
+			status.Cluster = name
+			result = append(result, status)
+		}
+	}
+	return result
+}
+
+// Stop stops watching every cluster.
+func (m *MultiWatcher) Stop() {
+	for _, name := range m.clusters {
+		m.watchers[name].Stop()
+	}
+}
+
+// Wait waits for the watchers of every cluster, and returns the first
+// error.
+func (m *MultiWatcher) Wait() error {
+	var result error
+	for _, name := range m.clusters {
+		err := m.watchers[name].Wait()
+		if err != nil && result == nil {
+			result = m.clusterError(name, err)
+		}
+	}
+	return result
+}
@@ -0,0 +1,45 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagCluster(t *testing.T) {
+	r := Resource{
+		"kind": "Service",
+		"metadata": map[string]interface{}{
+			"name":        "foo",
+			"annotations": map[string]interface{}{"a": "b"},
+		},
+	}
+	tagged := tagCluster(r, "east")
+	require.Equal(t, "east", tagged.Cluster())
+	require.Equal(t, "b", tagged.Annotations()["a"])
+	require.Equal(t, "foo", tagged.Name())
+	// the original stays as it was
+	require.Equal(t, "", r.Cluster())
+	require.Equal(t, 1, len(r.Annotations()))
+
+	require.Equal(t, "", tagCluster(r, "").Cluster())
+}
+
+func TestMultiWatcher(t *testing.T) {
+	m := NewMultiWatcher(map[string]*Client{"a": NewClient(nil), "b": NewClient(nil)})
+	var clusters []string
+	err := m.Watch("services", func(m *MultiWatcher) {
+		clusters = nil
+		for _, svc := range m.List("services") {
+			if svc.QName() == "kubernetes.default" {
+				clusters = append(clusters, svc.Cluster())
+			}
+		}
+		m.Stop()
+	})
+	require.NoError(t, err)
+	require.NoError(t, m.Start())
+	require.NoError(t, m.Wait())
+	require.Equal(t, []string{"a", "b"}, clusters)
+	require.Equal(t, []string{"a", "b"}, m.Clusters())
+}
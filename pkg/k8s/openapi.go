@@ -0,0 +1,248 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// OpenAPISchema is the OpenAPI (v2) schema of the resources a cluster
+// serves, see Client.OpenAPISchema.
+type OpenAPISchema struct {
+	definitions map[string]interface{}
+	// kinds maps every kind to the name of its definition
+	kinds map[schema.GroupVersionKind]string
+}
+
+// ParseOpenAPISchema parses an OpenAPI (v2) document as served by
+// the api server at /openapi/v2.
+func ParseOpenAPISchema(data []byte) (*OpenAPISchema, error) {
+	var doc struct {
+		Definitions map[string]interface{} `json:"definitions"`
+	}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &OpenAPISchema{definitions: doc.Definitions, kinds: make(map[schema.GroupVersionKind]string)}
+	for name, def := range doc.Definitions {
+		def, _ := def.(map[string]interface{})
+		gvks, _ := def["x-kubernetes-group-version-kind"].([]interface{})
+		for _, gvk := range gvks {
+			gvk, _ := gvk.(map[string]interface{})
+			group, _ := gvk["group"].(string)
+			version, _ := gvk["version"].(string)
+			kind, _ := gvk["kind"].(string)
+			s.kinds[schema.GroupVersionKind{Group: group, Version: version, Kind: kind}] = name
+		}
+	}
+	return s, nil
+}
+
+// OpenAPISchema fetches the cluster's OpenAPI schema. The schema is
+// fetched once and then kept until the resource types are refreshed,
+// see RefreshResourceTypes.
+func (c *Client) OpenAPISchema() (*OpenAPISchema, error) {
+	c.resourcesMu.RLock()
+	s := c.schema
+	c.resourcesMu.RUnlock()
+	if s != nil {
+		return s, nil
+	}
+	if c.disco == nil {
+		return nil, fmt.Errorf("no discovery client")
+	}
+
+	data, err := c.disco.RESTClient().Get().AbsPath("/openapi/v2").DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	s, err = ParseOpenAPISchema(data)
+	if err != nil {
+		return nil, err
+	}
+
+	c.resourcesMu.Lock()
+	c.schema = s
+	c.resourcesMu.Unlock()
+	return s, nil
+}
+
+// ValidateAgainstSchema checks the resource against the cluster's
+// OpenAPI schema, see OpenAPISchema.Validate.
+func (c *Client) ValidateAgainstSchema(resource Resource) error {
+	s, err := c.OpenAPISchema()
+	if err != nil {
+		return err
+	}
+	return s.Validate(resource)
+}
+
+// ValidationError lists everything that is wrong with a resource, one
+// problem per entry, e.g. "spec.replicas: expected integer, got
+// string".
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// Validate checks the resource against the schema of its kind: the
+// types of the fields, that required fields are there, and that there
+// are no unknown fields. A resource whose kind the schema doesn't
+// describe, e.g. a custom resource without a schema, is accepted as
+// is. The problems found are returned as a ValidationError.
+func (s *OpenAPISchema) Validate(resource Resource) error {
+	apiVersion, _ := resource["apiVersion"].(string)
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return ValidationError{fmt.Sprintf("apiVersion: %v", err)}
+	}
+	name, ok := s.kinds[gv.WithKind(resource.Kind())]
+	if !ok {
+		return nil
+	}
+
+	var problems ValidationError
+	s.validate("", map[string]interface{}(resource), s.definitions[name], &problems)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+const refPrefix = "#/definitions/"
+
+func (s *OpenAPISchema) validate(path string, value, node interface{}, problems *ValidationError) {
+	n, _ := node.(map[string]interface{})
+	if ref, ok := n["$ref"].(string); ok {
+		s.validate(path, value, s.definitions[strings.TrimPrefix(ref, refPrefix)], problems)
+		return
+	}
+	// optional fields may be null
+	if value == nil {
+		return
+	}
+	problem := func(format string, args ...interface{}) {
+		field := strings.TrimPrefix(path, ".")
+		if field == "" {
+			field = "<root>"
+		}
+		*problems = append(*problems, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	if n["format"] == "int-or-string" || n["x-kubernetes-int-or-string"] == true {
+		if _, ok := value.(string); !ok && !isInteger(value) {
+			problem("expected integer or string, got %s", typeName(value))
+		}
+		return
+	}
+
+	switch n["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			problem("expected object, got %s", typeName(value))
+			return
+		}
+		properties, _ := n["properties"].(map[string]interface{})
+		additional, hasAdditional := n["additionalProperties"]
+		preserve := n["x-kubernetes-preserve-unknown-fields"] == true
+		required, _ := n["required"].([]interface{})
+		for _, field := range required {
+			if name, ok := field.(string); ok {
+				if _, ok := obj[name]; !ok {
+					problem("missing required field %q", name)
+				}
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if prop, ok := properties[key]; ok {
+				s.validate(path+"."+key, obj[key], prop, problems)
+			} else if hasAdditional && additional != false {
+				s.validate(path+"."+key, obj[key], additional, problems)
+			} else if !preserve && (properties != nil || additional == false) {
+				problem("unknown field %q", key)
+			}
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			problem("expected array, got %s", typeName(value))
+			return
+		}
+		for i, item := range list {
+			s.validate(fmt.Sprintf("%s[%d]", path, i), item, n["items"], problems)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			problem("expected string, got %s", typeName(value))
+		}
+	case "integer":
+		if !isInteger(value) {
+			problem("expected integer, got %s", typeName(value))
+		}
+	case "number":
+		if _, ok := toFloat(value); !ok {
+			problem("expected number, got %s", typeName(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problem("expected boolean, got %s", typeName(value))
+		}
+	}
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func isInteger(value interface{}) bool {
+	f, ok := toFloat(value)
+	return ok && f == math.Trunc(f)
+}
+
+// typeName names the json type of a value.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	if _, ok := toFloat(value); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
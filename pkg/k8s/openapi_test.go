@@ -0,0 +1,88 @@
+package k8s
+
+import (
+	"testing"
+)
+
+const testSchema = `{
+  "definitions": {
+    "io.k8s.api.apps.v1.Deployment": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.DeploymentSpec"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "Deployment", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.DeploymentSpec": {
+      "type": "object",
+      "required": ["template"],
+      "properties": {
+        "replicas": {"type": "integer", "format": "int32"},
+        "paused": {"type": "boolean"},
+        "maxSurge": {"type": "string", "format": "int-or-string"},
+        "template": {"type": "object"},
+        "args": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"},
+        "labels": {"type": "object", "additionalProperties": {"type": "string"}}
+      }
+    }
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := ParseOpenAPISchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deployment := func(spec map[string]interface{}) Resource {
+		return Resource{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":   "foo",
+				"labels": map[string]interface{}{"app": "foo"},
+			},
+			"spec": spec,
+		}
+	}
+
+	for _, tt := range []struct {
+		resource Resource
+		expected string
+	}{
+		{deployment(map[string]interface{}{"replicas": 3, "maxSurge": "25%", "template": map[string]interface{}{}}),
+			""},
+		{deployment(map[string]interface{}{"replicas": float64(3), "maxSurge": 1, "paused": nil,
+			"template": map[string]interface{}{"anything": "goes"}}), ""},
+		{deployment(map[string]interface{}{"replicas": "3", "paused": "no", "maxSurge": true,
+			"args": []interface{}{"a", 1}, "replics": 3}),
+			`spec: missing required field "template"; spec.args[1]: expected string, got number; ` +
+				`spec.maxSurge: expected integer or string, got boolean; spec.paused: expected boolean, got string; ` +
+				`spec.replicas: expected integer, got string; spec: unknown field "replics"`},
+		{deployment(map[string]interface{}{"replicas": 1.5, "template": map[string]interface{}{}}),
+			"spec.replicas: expected integer, got number"},
+		{Resource{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": map[string]interface{}{
+			"labels": map[string]interface{}{"app": 1}}, "spec": "nope"},
+			"metadata.labels.app: expected string, got number; spec: expected object, got string"},
+		// kinds the schema doesn't know are accepted
+		{Resource{"apiVersion": "getambassador.io/v1", "kind": "Mapping", "spec": "anything"}, ""},
+	} {
+		err := s.Validate(tt.resource)
+		actual := ""
+		if err != nil {
+			actual = err.Error()
+		}
+		if actual != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// NORMAL_EVENT is the type of events about things going as
+	// expected.
+	NORMAL_EVENT = "Normal"
+	// WARNING_EVENT is the type of events about something that
+	// needs a look.
+	WARNING_EVENT = "Warning"
+)
+
+// Recorder posts Kubernetes Events about resources, so that what a
+// tool did to a resource shows up in `kubectl describe` and `kubectl
+// get events`.
+type Recorder struct {
+	client    *Client
+	component string
+	host      string
+}
+
+// Recorder returns a Recorder that posts events as coming from the
+// named component, e.g. "watt".
+func (c *Client) Recorder(component string) *Recorder {
+	host, _ := os.Hostname()
+	return &Recorder{client: c, component: component, host: host}
+}
+
+// Event posts an event of the given type, NORMAL_EVENT or
+// WARNING_EVENT, about the resource. The reason is a short CamelCase
+// word such as "Applied" and the message says what happened. The
+// event goes in the namespace of the resource, or in the client's
+// namespace for resources that aren't namespaced.
+func (r *Recorder) Event(resource Resource, eventType, reason, message string) error {
+	_, err := r.client.Create(r.event(resource, eventType, reason, message, time.Now().UTC()))
+	return err
+}
+
+// Eventf is Event with a formatted message.
+func (r *Recorder) Eventf(resource Resource, eventType, reason, format string, args ...interface{}) error {
+	return r.Event(resource, eventType, reason, fmt.Sprintf(format, args...))
+}
+
+// event returns the Event resource that Event posts.
+func (r *Recorder) event(resource Resource, eventType, reason, message string, now time.Time) Resource {
+	namespace := resource.Namespace()
+	if namespace == "" {
+		namespace = r.client.Namespace()
+	}
+	timestamp := now.Format(time.RFC3339)
+
+	involved := map[string]interface{}{
+		"kind": resource.Kind(),
+		"name": resource.Name(),
+	}
+	for key, value := range map[string]interface{}{
+		"apiVersion":      resource["apiVersion"],
+		"namespace":       resource.Namespace(),
+		"uid":             resource.Metadata()["uid"],
+		"resourceVersion": resource.ResourceVersion(),
+	} {
+		if value != nil && value != "" {
+			involved[key] = value
+		}
+	}
+
+	source := map[string]interface{}{"component": r.component}
+	if r.host != "" {
+		source["host"] = r.host
+	}
+
+	return Resource{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			// the same naming scheme as client-go's recorder
+			"name":      resource.Name() + "." + strconv.FormatInt(now.UnixNano(), 16),
+			"namespace": namespace,
+		},
+		"involvedObject": involved,
+		"type":           eventType,
+		"reason":         reason,
+		"message":        message,
+		"source":         source,
+		"firstTimestamp": timestamp,
+		"lastTimestamp":  timestamp,
+		"count":          int64(1),
+	}
+}
@@ -0,0 +1,42 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecorderEvent(t *testing.T) {
+	r := &Recorder{client: &Client{namespace: "ambassador"}, component: "watt", host: "box"}
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mapping := Resource{
+		"apiVersion": "getambassador.io/v1",
+		"kind":       "Mapping",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "apps", "uid": "1234"},
+	}
+	event := r.event(mapping, WARNING_EVENT, "Invalid", "snapshot excluded invalid Mapping", now)
+	if event.Namespace() != "apps" || event.Name() != "foo.1587d3ea51c38000" {
+		t.Errorf("unexpected event %s in %s", event.Name(), event.Namespace())
+	}
+	involved := event["involvedObject"].(map[string]interface{})
+	if involved["kind"] != "Mapping" || involved["uid"] != "1234" || involved["namespace"] != "apps" {
+		t.Errorf("unexpected involved object %v", involved)
+	}
+	if _, ok := involved["resourceVersion"]; ok {
+		t.Errorf("expected no resourceVersion, got %v", involved["resourceVersion"])
+	}
+	if event["type"] != "Warning" || event["lastTimestamp"] != "2019-03-01T12:00:00Z" {
+		t.Errorf("unexpected event %v", event)
+	}
+
+	// events about resources that aren't namespaced go in the
+	// client's namespace
+	node := Resource{"kind": "Node", "metadata": map[string]interface{}{"name": "n1"}}
+	event = r.event(node, NORMAL_EVENT, "Seen", "", now)
+	if event.Namespace() != "ambassador" {
+		t.Errorf("expected the client's namespace, got %q", event.Namespace())
+	}
+	if _, ok := event["involvedObject"].(map[string]interface{})["namespace"]; ok {
+		t.Errorf("expected no namespace for the node")
+	}
+}
@@ -0,0 +1,356 @@
+package k8s
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	pwatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// Recording records what the clients that use it list and watch to a
+// file, one json record per line, so that it can be fed back into a
+// Watcher later on, see NewReplayClient. This makes it possible to
+// reproduce what a watcher saw in a cluster somewhere else.
+type Recording struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// record is a single line of a recording, either the result of a
+// list or a watch event.
+type record struct {
+	Time          time.Time    `json:"time"`
+	Context       string       `json:"context,omitempty"`
+	Resource      ResourceType `json:"resource"`
+	Namespace     string       `json:"namespace,omitempty"`
+	FieldSelector string       `json:"fieldSelector,omitempty"`
+	LabelSelector string       `json:"labelSelector,omitempty"`
+	// Type is "LIST" for a list, or the type of the watch event
+	Type            string                   `json:"type"`
+	ResourceVersion string                   `json:"resourceVersion,omitempty"`
+	Objects         []map[string]interface{} `json:"objects"`
+}
+
+const listRecord = "LIST"
+
+// NewRecording creates the file the recording is written to, see
+// ClientOptions.Recording.
+func NewRecording(path string) (*Recording, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recording{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Close closes the file of the recording. Nothing is recorded any
+// more afterwards.
+func (r *Recording) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.encoder = nil
+	return r.file.Close()
+}
+
+func (r *Recording) write(rec record) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.encoder != nil {
+		// a recording is a debugging aid, so failing to write
+		// it must not break the watch
+		r.encoder.Encode(rec)
+	}
+}
+
+// recordingResource records the lists and watch events of a resource
+// type in a namespace.
+type recordingResource struct {
+	dynamic.ResourceInterface
+	recording *Recording
+	context   string
+	rt        ResourceType
+	namespace string
+}
+
+func (r recordingResource) record(typ string, options v1.ListOptions, rv string, objs ...map[string]interface{}) {
+	r.recording.write(record{
+		Time:            time.Now(),
+		Context:         r.context,
+		Resource:        r.rt,
+		Namespace:       r.namespace,
+		FieldSelector:   options.FieldSelector,
+		LabelSelector:   options.LabelSelector,
+		Type:            typ,
+		ResourceVersion: rv,
+		Objects:         objs,
+	})
+}
+
+func (r recordingResource) List(options v1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := r.ResourceInterface.List(options)
+	if err == nil {
+		objs := make([]map[string]interface{}, len(list.Items))
+		for i := range list.Items {
+			objs[i] = list.Items[i].UnstructuredContent()
+		}
+		r.record(listRecord, options, list.GetResourceVersion(), objs...)
+	}
+	return list, err
+}
+
+func (r recordingResource) Watch(options v1.ListOptions) (pwatch.Interface, error) {
+	result, err := r.ResourceInterface.Watch(options)
+	if err != nil {
+		return result, err
+	}
+	return pwatch.Filter(result, func(event pwatch.Event) (pwatch.Event, bool) {
+		if un, ok := event.Object.(*unstructured.Unstructured); ok {
+			r.record(string(event.Type), options, un.GetResourceVersion(), un.UnstructuredContent())
+		}
+		return event, true
+	}), nil
+}
+
+type recordingNamespaceableResource struct {
+	recordingResource
+	resource dynamic.NamespaceableResourceInterface
+}
+
+func (r recordingNamespaceableResource) Namespace(namespace string) dynamic.ResourceInterface {
+	result := r.recordingResource
+	result.ResourceInterface = r.resource.Namespace(namespace)
+	result.namespace = namespace
+	return result
+}
+
+func (r *Recording) resource(context string, rt ResourceType,
+	resource dynamic.NamespaceableResourceInterface) dynamic.NamespaceableResourceInterface {
+	return recordingNamespaceableResource{recordingResource{resource, r, context, rt, ""}, resource}
+}
+
+// replay serves the lists and watch events of a recording.
+type replay struct {
+	records []record
+	// start is when replaying started, the events are delivered
+	// as long after it as they were recorded after the first
+	// record, divided by speed
+	start time.Time
+	speed float64
+}
+
+// NewReplayClient returns a client that serves what was recorded by
+// the clients of the given context, or of all of them if context is
+// empty, see Recording. Listing returns the first list recorded, and
+// watching delivers the recorded watch events at the pace they were
+// recorded at, multiplied by speed, or as fast as possible if speed
+// is zero. Only the resource types in the recording are known to the
+// client, and it can only list and watch.
+func NewReplayClient(path, context string, speed float64) (*Client, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rp := &replay{start: time.Now(), speed: speed}
+	var resources []*v1.APIResourceList
+	seen := make(map[ResourceType]bool)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec record
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if context != "" && rec.Context != context {
+			continue
+		}
+		rp.records = append(rp.records, rec)
+
+		if !seen[rec.Resource] {
+			seen[rec.Resource] = true
+			resources = addResourceType(resources, rec.Resource, "list", "watch")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &Client{namespace: "default", resources: resources, replay: rp}, nil
+}
+
+// addResourceType adds the resource type, with the given verbs, to
+// the discovery data.
+func addResourceType(resources []*v1.APIResourceList, rt ResourceType, verbs ...string) []*v1.APIResourceList {
+	gv := rt.Version
+	if rt.Group != "" {
+		gv = rt.Group + "/" + rt.Version
+	}
+	resource := v1.APIResource{
+		Name:         rt.Name,
+		SingularName: strings.ToLower(rt.Kind),
+		Kind:         rt.Kind,
+		Namespaced:   rt.Namespaced,
+		Verbs:        verbs,
+	}
+	for _, rl := range resources {
+		if rl.GroupVersion == gv {
+			rl.APIResources = append(rl.APIResources, resource)
+			return resources
+		}
+	}
+	return append(resources, &v1.APIResourceList{GroupVersion: gv, APIResources: []v1.APIResource{resource}})
+}
+
+func (rp *replay) resource(rt ResourceType) dynamic.NamespaceableResourceInterface {
+	return replayResource{rp, rt, ""}
+}
+
+// replayResource serves the records of a resource type in a
+// namespace. A record of all namespaces serves every namespace.
+type replayResource struct {
+	replay    *replay
+	rt        ResourceType
+	namespace string
+}
+
+func (r replayResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return replayResource{r.replay, r.rt, namespace}
+}
+
+// matches returns the objects of the record that the options select,
+// and whether the record is of the right list or watch at all.
+func (r replayResource) matches(rec record, options v1.ListOptions) ([]map[string]interface{}, bool) {
+	if rec.Resource != r.rt || rec.FieldSelector != options.FieldSelector ||
+		rec.LabelSelector != options.LabelSelector {
+		return nil, false
+	}
+	if rec.Namespace == r.namespace {
+		return rec.Objects, true
+	}
+	if rec.Namespace != "" {
+		return nil, false
+	}
+	var result []map[string]interface{}
+	for _, obj := range rec.Objects {
+		if Resource(obj).Namespace() == r.namespace {
+			result = append(result, obj)
+		}
+	}
+	return result, true
+}
+
+func (r replayResource) List(options v1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list := &unstructured.UnstructuredList{}
+	for _, rec := range r.replay.records {
+		if rec.Type != listRecord {
+			continue
+		}
+		if objs, ok := r.matches(rec, options); ok {
+			for _, obj := range objs {
+				list.Items = append(list.Items, unstructured.Unstructured{Object: obj})
+			}
+			list.SetResourceVersion(rec.ResourceVersion)
+			break
+		}
+	}
+	return list, nil
+}
+
+// Watch delivers the recorded watch events. The watch stays open once
+// they are delivered, so the watcher doesn't list again.
+func (r replayResource) Watch(options v1.ListOptions) (pwatch.Interface, error) {
+	w := &replayWatch{result: make(chan pwatch.Event), stop: make(chan struct{})}
+	go func() {
+		var first time.Time
+		if len(r.replay.records) > 0 {
+			first = r.replay.records[0].Time
+		}
+		for _, rec := range r.replay.records {
+			if rec.Type == listRecord {
+				continue
+			}
+			objs, ok := r.matches(rec, options)
+			if !ok {
+				continue
+			}
+			if r.replay.speed > 0 {
+				offset := time.Duration(float64(rec.Time.Sub(first)) / r.replay.speed)
+				select {
+				case <-time.After(time.Until(r.replay.start.Add(offset))):
+				case <-w.stop:
+					return
+				}
+			}
+			for _, obj := range objs {
+				event := pwatch.Event{Type: pwatch.EventType(rec.Type), Object: &unstructured.Unstructured{Object: obj}}
+				select {
+				case w.result <- event:
+				case <-w.stop:
+					return
+				}
+			}
+		}
+	}()
+	return w, nil
+}
+
+var errReplay = fmt.Errorf("a replay client can only list and watch")
+
+func (r replayResource) Create(obj *unstructured.Unstructured, options v1.CreateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, errReplay
+}
+
+func (r replayResource) Update(obj *unstructured.Unstructured, options v1.UpdateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, errReplay
+}
+
+func (r replayResource) UpdateStatus(obj *unstructured.Unstructured,
+	options v1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return nil, errReplay
+}
+
+func (r replayResource) Delete(name string, options *v1.DeleteOptions, subresources ...string) error {
+	return errReplay
+}
+
+func (r replayResource) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+	return errReplay
+}
+
+func (r replayResource) Get(name string, options v1.GetOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, errReplay
+}
+
+func (r replayResource) Patch(name string, pt types.PatchType, data []byte, options v1.UpdateOptions,
+	subresources ...string) (*unstructured.Unstructured, error) {
+	return nil, errReplay
+}
+
+type replayWatch struct {
+	result chan pwatch.Event
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func (w *replayWatch) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+func (w *replayWatch) ResultChan() <-chan pwatch.Event {
+	return w.result
+}
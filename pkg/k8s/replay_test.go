@@ -0,0 +1,75 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	pwatch "k8s.io/apimachinery/pkg/watch"
+)
+
+func TestRecordReplay(t *testing.T) {
+	file, err := ioutil.TempFile("", "recording")
+	require.NoError(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	recording, err := NewRecording(file.Name())
+	require.NoError(t, err)
+	pods := ResourceType{Version: "v1", Name: "pods", Kind: "Pod", Namespaced: true}
+	options := v1.ListOptions{Limit: 10, LabelSelector: "app=foo"}
+
+	for _, context := range []string{"one", "two"} {
+		resource := &resumableResource{pagedResource{items: 2}, make(chan pwatch.Event)}
+		recorded := recordingResource{resource, recording, context, pods, "apps"}
+		_, err = recorded.List(options)
+		require.NoError(t, err)
+
+		watch, err := recorded.Watch(options)
+		require.NoError(t, err)
+		for _, rv := range []string{"11", "12"} {
+			resource.events <- pwatch.Event{Type: pwatch.Modified, Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"metadata": map[string]interface{}{"name": context, "resourceVersion": rv},
+				},
+			}}
+			<-watch.ResultChan()
+		}
+	}
+	require.NoError(t, recording.Close())
+
+	c, err := NewReplayClient(file.Name(), "two", 0)
+	require.NoError(t, err)
+	rt, err := c.LookupResourceType("pod")
+	require.NoError(t, err)
+	require.Equal(t, pods, rt)
+
+	resource, err := c.dynamicResource(rt)
+	require.NoError(t, err)
+	list, err := resource.Namespace("apps").List(options)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list.Items))
+	require.Equal(t, "10", list.GetResourceVersion())
+
+	// nothing was recorded for other selectors
+	list, err = resource.Namespace("apps").List(v1.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(list.Items))
+
+	watch, err := resource.Namespace("apps").Watch(options)
+	require.NoError(t, err)
+	defer watch.Stop()
+	for _, rv := range []string{"11", "12"} {
+		event := <-watch.ResultChan()
+		require.Equal(t, pwatch.Modified, event.Type)
+		un := event.Object.(*unstructured.Unstructured)
+		require.Equal(t, "two", un.GetName())
+		require.Equal(t, rv, un.GetResourceVersion())
+	}
+
+	_, err = resource.Namespace("apps").Create(&unstructured.Unstructured{}, v1.CreateOptions{})
+	require.Error(t, err)
+}
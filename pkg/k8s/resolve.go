@@ -0,0 +1,100 @@
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// NameResolution says what a plain resource type name, i.e. a name,
+// kind, singular name or short name without a version or group,
+// resolves to, see LookupResourceType.
+type NameResolution struct {
+	// Name is the lowercase name.
+	Name string
+	// Candidates are the resource types the name may refer to, in
+	// discovery order.
+	Candidates []ResourceType
+	// Resolved is the resource type LookupResourceType picks,
+	// unless Err says why it can't pick one.
+	Resolved ResourceType
+	Err      error
+}
+
+// Ambiguous returns whether the name may refer to more than one
+// resource type, whether or not LookupResourceType can pick one.
+func (r NameResolution) Ambiguous() bool {
+	return len(r.Candidates) > 1
+}
+
+// ResolveNames returns what every plain name of every resource type
+// the cluster serves resolves to, sorted by name.
+func (c *Client) ResolveNames() []NameResolution {
+	seen := make(map[string]bool)
+	var names []string
+	for _, rl := range c.resourceLists() {
+		for _, r := range rl.APIResources {
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			for _, name := range append([]string{r.Name, r.Kind, r.SingularName}, r.ShortNames...) {
+				name = strings.ToLower(name)
+				if name != "" && !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+
+	result := make([]NameResolution, 0, len(names))
+	for _, name := range names {
+		matches := c.matchResourceTypes(name, func(schema.GroupVersion) bool { return true })
+		rt, err := pickResourceType(name, matches)
+		result = append(result, NameResolution{Name: name, Candidates: matches, Resolved: rt, Err: err})
+	}
+	return result
+}
+
+// ListAmbiguities returns the names of ResolveNames that may refer to
+// more than one resource type, e.g. "endpoints" if a CRD in another
+// group uses that name as well, or "deployments" on clusters that
+// serve them in more than one group.
+func (c *Client) ListAmbiguities() []NameResolution {
+	var result []NameResolution
+	for _, r := range c.ResolveNames() {
+		if r.Ambiguous() {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// PrintNameResolutions writes a table of the name resolutions, one
+// line per name with what it resolves to and, if it is ambiguous, all
+// of the candidates.
+func PrintNameResolutions(w io.Writer, resolutions []NameResolution) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tRESOURCE\tKIND\tNAMESPACED\tCANDIDATES")
+	for _, r := range resolutions {
+		resource, kind, namespaced := "<ambiguous>", "", ""
+		if r.Err == nil {
+			resource = r.Resolved.String()
+			kind = r.Resolved.Kind
+			namespaced = fmt.Sprint(r.Resolved.Namespaced)
+		}
+		var candidates []string
+		if r.Ambiguous() {
+			for _, rt := range r.Candidates {
+				candidates = append(candidates, rt.String())
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Name, resource, kind, namespaced, strings.Join(candidates, ", "))
+	}
+	return tw.Flush()
+}
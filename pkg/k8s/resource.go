@@ -9,17 +9,52 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/Masterminds/sprig"
-	ms "github.com/mitchellh/mapstructure"
 	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
 )
 
+// READY maps a kind to the function that decides whether a resource
+// of that kind is ready. Resources of kinds that aren't listed are
+// considered ready as soon as they exist. Entries may be added or
+// replaced to customize readiness, see also ReadyCondition.
 var READY = map[string]func(Resource) bool{
 	"": func(Resource) bool { return false },
 	"Deployment": func(r Resource) bool {
-		return r.Status().getInt64("readyReplicas") > 0
+		// this follows `kubectl rollout status`
+		status := r.Status()
+		if !r.observed() {
+			return false
+		}
+		updated := status.getInt64("updatedReplicas")
+		return updated >= r.replicas() &&
+			status.getInt64("replicas") <= updated &&
+			status.getInt64("availableReplicas") >= updated
+	},
+	"StatefulSet": func(r Resource) bool {
+		status := r.Status()
+		if !r.observed() || status.getInt64("readyReplicas") < r.replicas() {
+			return false
+		}
+		strategy := r.Spec().getMap("updateStrategy")
+		if Map(strategy).getString("type") == "OnDelete" {
+			return true
+		}
+		return status.getString("currentRevision") == status.getString("updateRevision")
+	},
+	"DaemonSet": func(r Resource) bool {
+		status := r.Status()
+		desired := status.getInt64("desiredNumberScheduled")
+		return r.observed() &&
+			status.getInt64("updatedNumberScheduled") >= desired &&
+			status.getInt64("numberAvailable") >= desired
+	},
+	"Job": func(r Resource) bool {
+		return r.condition("Complete") == "True"
 	},
 	"Service": func(r Resource) bool {
 		return true
@@ -48,26 +83,92 @@ var READY = map[string]func(Resource) bool{
 		return true
 	},
 	"CustomResourceDefinition": func(r Resource) bool {
-		conditions := r.Status().getMaps("conditions")
-		if len(conditions) > 0 {
-			last := conditions[len(conditions)-1]
-			if last["status"] == "True" {
-				return true
-			} else {
-				return false
-			}
-		} else {
+		return r.condition("Established") == "True"
+	},
+}
+
+// READY_WHEN is the annotation that overrides the readiness of a
+// single resource with a ReadyCondition.
+const READY_WHEN = "kubeapply.datawire.io/ready-when"
+
+// ReadyCondition parses a readiness condition. A condition is either
+// of the form JSONPATH[=VALUE], e.g. "{.status.phase}=Running", or an
+// Expression, e.g. "status.readyReplicas == spec.replicas". A JSONPath
+// condition is met when the JSONPath evaluates to VALUE, or, if no
+// VALUE is given, to anything other than "", "false", or "0".
+func ReadyCondition(condition string) (func(Resource) bool, error) {
+	if !strings.HasPrefix(strings.TrimSpace(condition), "{") {
+		expr, err := ParseExpression(condition)
+		if err != nil {
+			return nil, err
+		}
+		return expr.Eval, nil
+	}
+
+	expr, value, hasValue := condition, "", false
+	if idx := strings.LastIndex(condition, "}"); idx >= 0 && strings.HasPrefix(condition[idx+1:], "=") {
+		expr, value, hasValue = condition[:idx+1], condition[idx+2:], true
+	}
+
+	jp := jsonpath.New("ready").AllowMissingKeys(true)
+	err := jp.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", condition, err)
+	}
+
+	// a JSONPath can't be executed concurrently
+	var mutex sync.Mutex
+	return func(r Resource) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		buf := bytes.NewBuffer(nil)
+		err := jp.Execute(buf, map[string]interface{}(r))
+		if err != nil {
 			return false
 		}
-	},
+		result := buf.String()
+		if hasValue {
+			return result == value
+		}
+		return result != "" && result != "false" && result != "0"
+	}, nil
+}
+
+// observed returns true if the controller of the resource has caught
+// up with the latest change to its spec.
+func (r Resource) observed() bool {
+	return r.Status().getInt64("observedGeneration") >= Map(r.Metadata()).getInt64("generation")
+}
+
+// replicas returns the desired number of replicas, which defaults to 1.
+func (r Resource) replicas() int64 {
+	spec := r.Spec()
+	if _, ok := spec["replicas"]; !ok {
+		return 1
+	}
+	return spec.getInt64("replicas")
+}
+
+// condition returns the status of the condition of the given type, or
+// "" if there is no such condition.
+func (r Resource) condition(kind string) string {
+	for _, c := range r.Status().getMaps("conditions") {
+		if c["type"] == kind {
+			status, _ := c["status"].(string)
+			return status
+		}
+	}
+	return ""
 }
 
 type Map map[string]interface{}
 
 func (m Map) getMap(name string) map[string]interface{} {
-	v, ok := m[name]
+	// a field that is null or not a map at all is treated as
+	// missing
+	v, ok := m[name].(map[string]interface{})
 	if ok {
-		return v.(map[string]interface{})
+		return v
 	} else {
 		return Map{}
 	}
@@ -98,10 +199,15 @@ func (m Map) getString(key string) string {
 }
 
 func (m Map) getInt64(key string) int64 {
-	v, ok := m[key]
-	if ok {
-		return v.(int64)
-	} else {
+	// json from the api server decodes to int64, yaml to int
+	switch v := m[key].(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	default:
 		return 0
 	}
 }
@@ -149,6 +255,9 @@ func (r Resource) ReadyImplemented() bool {
 	if r.Empty() {
 		return false
 	}
+	if _, ok := r.Metadata().Annotations()[READY_WHEN]; ok {
+		return true
+	}
 	kind := r.Kind()
 	_, ok := READY[kind]
 	return ok
@@ -158,6 +267,13 @@ func (r Resource) Ready() bool {
 	if r.Empty() {
 		return false
 	}
+	if when, ok := r.Metadata().Annotations()[READY_WHEN].(string); ok {
+		f, err := ReadyCondition(when)
+		if err != nil {
+			return false
+		}
+		return f(r)
+	}
 	kind := r.Kind()
 	f, ok := READY[kind]
 	if ok {
@@ -185,6 +301,35 @@ func (r Resource) ResourceVersion() string { return r.Metadata().ResourceVersion
 func (m Metadata) Annotations() map[string]interface{} {
 	return Map(m).getMap("annotations")
 }
+func (r Resource) Annotations() map[string]interface{} { return r.Metadata().Annotations() }
+
+// WithAnnotation returns a copy of the resource with the annotation
+// added. Only the maps on the way to the annotation are copied, so the
+// resource itself may be one that must not be modified, e.g. one from
+// the store of a Watcher.
+func (r Resource) WithAnnotation(key, value string) Resource {
+	annotations := make(map[string]interface{})
+	for k, v := range r.Annotations() {
+		annotations[k] = v
+	}
+	annotations[key] = value
+	metadata := make(map[string]interface{})
+	for k, v := range r.Metadata() {
+		metadata[k] = v
+	}
+	metadata["annotations"] = annotations
+	result := make(Resource, len(r))
+	for k, v := range r {
+		result[k] = v
+	}
+	result["metadata"] = metadata
+	return result
+}
+
+func (m Metadata) Labels() map[string]interface{} {
+	return Map(m).getMap("labels")
+}
+func (r Resource) Labels() map[string]interface{} { return r.Metadata().Labels() }
 
 func (m Metadata) QName() string {
 	ns := m.Namespace()
@@ -197,8 +342,12 @@ func (m Metadata) QName() string {
 
 func (r Resource) QName() string { return r.Metadata().QName() }
 
+// Decode decodes the resource into output the way the api machinery
+// does, i.e. according to the json tags of output, so that it works
+// for the typed api objects, e.g. a *corev1.Service, as well as for
+// structs that only declare the fields of interest.
 func (r Resource) Decode(output interface{}) error {
-	return ms.Decode(r, output)
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(r, output)
 }
 
 // This fixes objects parsed by yaml to objects that are compatible
@@ -234,19 +383,29 @@ func isTemplate(input []byte) bool {
 }
 
 func ExpandResource(path string) (result []byte, err error) {
+	return ExpandResourceWithData(path, nil, false)
+}
+
+// ExpandResourceWithData is like ExpandResource, but templates are
+// executed against the given data. If strict, referring to a key that
+// is missing from the data is an error.
+func ExpandResourceWithData(path string, data interface{}, strict bool) (result []byte, err error) {
 	input, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %v", path, err)
 	}
 	if isTemplate(input) {
 		tmpl := template.New(filepath.Base(path)).Funcs(sprig.TxtFuncMap())
+		if strict {
+			tmpl = tmpl.Option("missingkey=error")
+		}
 		_, err := tmpl.Parse(string(input))
 		if err != nil {
 			return nil, fmt.Errorf("%s: %v", path, err)
 		}
 
 		buf := bytes.NewBuffer(nil)
-		err = tmpl.ExecuteTemplate(buf, filepath.Base(path), nil)
+		err = tmpl.ExecuteTemplate(buf, filepath.Base(path), data)
 		if err != nil {
 			return nil, fmt.Errorf("%s: %v", path, err)
 		}
@@ -278,8 +437,14 @@ func ParseResources(name, input string) (result []Resource, err error) {
 }
 
 func LoadResources(path string) (result []Resource, err error) {
+	return LoadResourcesWithData(path, nil, false)
+}
+
+// LoadResourcesWithData is like LoadResources, but templates are
+// expanded with ExpandResourceWithData.
+func LoadResourcesWithData(path string, data interface{}, strict bool) (result []Resource, err error) {
 	var input []byte
-	input, err = ExpandResource(path)
+	input, err = ExpandResourceWithData(path, data, strict)
 	if err != nil {
 		return
 	}
@@ -287,6 +452,41 @@ func LoadResources(path string) (result []Resource, err error) {
 	return
 }
 
+// LoadValues reads the given yaml files and merges them into a single
+// map suitable for use as template data. Values from later files take
+// precedence over those from earlier files, with maps being merged
+// key by key.
+func LoadValues(paths ...string) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	for _, path := range paths {
+		input, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		var values map[interface{}]interface{}
+		err = yaml.Unmarshal(input, &values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if values != nil {
+			mergeValues(result, fixup(values).(map[string]interface{}))
+		}
+	}
+	return result, nil
+}
+
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		dstMap, dstOk := dst[k].(map[string]interface{})
+		srcMap, srcOk := v.(map[string]interface{})
+		if dstOk && srcOk {
+			mergeValues(dstMap, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
 func SaveResources(path string, resources []Resource) error {
 	output, err := MarshalResources(resources)
 	if err != nil {
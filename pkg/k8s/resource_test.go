@@ -0,0 +1,177 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var readiness = []struct {
+	name     string
+	resource Resource
+	ready    bool
+}{
+	{"deployment rolled out", Resource{
+		"kind":     "Deployment",
+		"metadata": map[string]interface{}{"generation": int64(2)},
+		"spec":     map[string]interface{}{"replicas": int64(2)},
+		"status": map[string]interface{}{"observedGeneration": int64(2), "replicas": int64(2),
+			"updatedReplicas": int64(2), "availableReplicas": int64(2)},
+	}, true},
+	{"deployment not observed", Resource{
+		"kind":     "Deployment",
+		"metadata": map[string]interface{}{"generation": int64(3)},
+		"spec":     map[string]interface{}{"replicas": int64(2)},
+		"status": map[string]interface{}{"observedGeneration": int64(2), "replicas": int64(2),
+			"updatedReplicas": int64(2), "availableReplicas": int64(2)},
+	}, false},
+	{"deployment with old replicas", Resource{
+		"kind":     "Deployment",
+		"metadata": map[string]interface{}{"generation": int64(2)},
+		"spec":     map[string]interface{}{"replicas": int64(2)},
+		"status": map[string]interface{}{"observedGeneration": int64(2), "replicas": int64(3),
+			"updatedReplicas": int64(2), "availableReplicas": int64(2)},
+	}, false},
+	{"job complete", Resource{
+		"kind": "Job",
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Complete", "status": "True"},
+		}},
+	}, true},
+	{"job running", Resource{
+		"kind":   "Job",
+		"status": map[string]interface{}{"active": int64(1)},
+	}, false},
+	{"crd established", Resource{
+		"kind": "CustomResourceDefinition",
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Established", "status": "True"},
+			map[string]interface{}{"type": "NamesAccepted", "status": "True"},
+		}},
+	}, true},
+	{"crd not established", Resource{
+		"kind": "CustomResourceDefinition",
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "NamesAccepted", "status": "True"},
+		}},
+	}, false},
+}
+
+func TestAccessors(t *testing.T) {
+	r := Resource{
+		"kind": "Service",
+		"metadata": map[string]interface{}{
+			"name":        "foo",
+			"labels":      map[string]interface{}{"app": "foo"},
+			"annotations": nil,
+		},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{map[string]interface{}{"name": "http", "port": int64(80)}},
+		},
+	}
+	if r.Labels()["app"] != "foo" {
+		t.Errorf("unexpected labels: %v", r.Labels())
+	}
+	if len(r.Annotations()) != 0 {
+		t.Errorf("unexpected annotations: %v", r.Annotations())
+	}
+	if len(r.Status()) != 0 {
+		t.Errorf("unexpected status: %v", r.Status())
+	}
+
+	var svc struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			Ports []struct {
+				Name string `json:"name"`
+				Port int    `json:"port"`
+			} `json:"ports"`
+		} `json:"spec"`
+	}
+	if err := r.Decode(&svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Metadata.Name != "foo" || len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 80 {
+		t.Errorf("unexpected decoding: %+v", svc)
+	}
+}
+
+func TestReady(t *testing.T) {
+	for _, tt := range readiness {
+		if ready := tt.resource.Ready(); ready != tt.ready {
+			t.Errorf("%s: got %v, expected %v", tt.name, ready, tt.ready)
+		}
+	}
+}
+
+func TestReadyCondition(t *testing.T) {
+	pod := Resource{
+		"kind":   "Pod",
+		"status": map[string]interface{}{"phase": "Running"},
+	}
+
+	for condition, ready := range map[string]bool{
+		"{.status.phase}=Running": true,
+		"{.status.phase}=Pending": false,
+		"{.status.phase}":         true,
+		"{.status.podIP}":         false,
+	} {
+		f, err := ReadyCondition(condition)
+		if err != nil {
+			t.Errorf("%s: %v", condition, err)
+			continue
+		}
+		if f(pod) != ready {
+			t.Errorf("%s: got %v, expected %v", condition, !ready, ready)
+		}
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	values := map[string]interface{}{
+		"image":    map[string]interface{}{"repo": "datawire/app", "tag": "1.0"},
+		"replicas": 1,
+	}
+	mergeValues(values, map[string]interface{}{
+		"image":    map[string]interface{}{"tag": "1.1"},
+		"replicas": 3,
+		"debug":    true,
+	})
+
+	image := values["image"].(map[string]interface{})
+	if image["repo"] != "datawire/app" || image["tag"] != "1.1" {
+		t.Errorf("unexpected image: %v", image)
+	}
+	if values["replicas"] != 3 || values["debug"] != true {
+		t.Errorf("unexpected values: %v", values)
+	}
+}
+
+func TestExpandResourceWithData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "manifest.yaml")
+	err = ioutil.WriteFile(path, []byte("# @TEMPLATE@\nname: {{.Env.NAME}}{{.Values.suffix}}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"Env": map[string]string{"NAME": "foo"}}
+
+	result, err := ExpandResourceWithData(path, data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "# @TEMPLATE@\nname: foo<no value>\n"; string(result) != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+
+	if _, err := ExpandResourceWithData(path, data, true); err == nil {
+		t.Errorf("expected an error for the missing values")
+	}
+}
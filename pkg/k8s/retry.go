@@ -0,0 +1,122 @@
+package k8s
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// RetryPolicy says how requests that fail because the api server is
+// overloaded or unavailable are retried. The zero value doesn't retry.
+type RetryPolicy struct {
+	// Retries is how often a request is retried at most.
+	Retries int
+	// Backoff is the delay before the first retry, by default one
+	// second. It doubles with every retry, up to MaxBackoff, by
+	// default 30 seconds. A Retry-After from the server takes
+	// precedence, so a throttled client waits as long as it is
+	// told to.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func (p RetryPolicy) delay(retry int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	backoff := p.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	for i := 0; i < retry && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff
+}
+
+// retry makes the config retry requests according to the policy.
+func (p RetryPolicy) retry(config *rest.Config) {
+	if p.Retries <= 0 {
+		return
+	}
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &retryRoundTripper{p, rt}
+	}
+}
+
+type retryRoundTripper struct {
+	policy RetryPolicy
+	rt     http.RoundTripper
+}
+
+// RoundTrip retries requests that were throttled (429) or that the
+// server was unavailable for (503), which are safe to retry since the
+// server didn't act on them. Reads are also retried when the
+// connection fails or on other server errors.
+func (r *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		attempt := req
+		if retry > 0 && req.Body != nil {
+			// the body was consumed by the previous attempt
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt = new(http.Request)
+			*attempt = *req
+			attempt.Body = body
+		}
+
+		resp, err := r.rt.RoundTrip(attempt)
+		if retry >= r.policy.Retries || !retriable(req, resp, err) {
+			return resp, err
+		}
+		delay := r.policy.delay(retry, resp)
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retriable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+	read := req.Method == http.MethodGet || req.Method == http.MethodHead
+	if err != nil {
+		return read
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return read
+	}
+	return false
+}
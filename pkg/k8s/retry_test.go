@@ -0,0 +1,89 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestRetry(t *testing.T) {
+	var requests []string
+	failures := map[string]int{"GET": 2, "POST": 1}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+string(body))
+		if failures[r.Method] > 0 {
+			failures[r.Method]--
+			if r.Method == "GET" {
+				w.WriteHeader(http.StatusBadGateway)
+			} else {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := &rest.Config{}
+	RetryPolicy{Retries: 3, Backoff: time.Millisecond}.retry(config)
+	client := &http.Client{Transport: config.WrapTransport(http.DefaultTransport)}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the get to succeed, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the post to succeed, got %d", resp.StatusCode)
+	}
+
+	expected := "GET ,GET ,GET ,POST body,POST body"
+	if actual := strings.Join(requests, ","); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	// writes aren't retried on other errors
+	requests = nil
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	resp, err = client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(requests) != 1 {
+		t.Errorf("expected a single post, got %d", len(requests))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Retries: 10, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second,
+		5 * time.Second} {
+		if delay := p.delay(retry, nil); delay != expected {
+			t.Errorf("retry %d: expected %v, got %v", retry, expected, delay)
+		}
+	}
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"7"}}}
+	if delay := p.delay(0, resp); delay != 7*time.Second {
+		t.Errorf("expected the Retry-After delay, got %v", delay)
+	}
+}
@@ -47,6 +47,10 @@ func (w *Waiter) Add(resource string) error {
 func (w *Waiter) Scan(path string) (err error) {
 	resources, err := LoadResources(path)
 	for _, res := range resources {
+		err = checkReadyWhen(res)
+		if err != nil {
+			return
+		}
 		err = w.Add(fmt.Sprintf("%s/%s", res.Kind(), res.QName()))
 		if err != nil {
 			return
@@ -55,6 +59,21 @@ func (w *Waiter) Scan(path string) (err error) {
 	return
 }
 
+// checkReadyWhen makes sure a typo in a readiness condition is
+// reported up front rather than showing up as a timeout.
+func checkReadyWhen(res Resource) error {
+	when, ok := res.Metadata().Annotations()[READY_WHEN]
+	if !ok {
+		return nil
+	}
+	condition, ok := when.(string)
+	if !ok {
+		return fmt.Errorf("%s/%s: %s must be a string", res.Kind(), res.QName(), READY_WHEN)
+	}
+	_, err := ReadyCondition(condition)
+	return err
+}
+
 func (w *Waiter) ScanPaths(files []string) (err error) {
 	resources, err := WalkResources(tpu.IsYaml, files...)
 	for _, res := range resources {
@@ -145,7 +164,11 @@ func (w *Waiter) Wait(timeout time.Duration) bool {
 		}
 	}
 
-	w.watcher.Start()
+	err := w.watcher.Start()
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
 
 	go func() {
 		time.Sleep(timeout)
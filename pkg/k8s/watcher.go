@@ -2,14 +2,20 @@ package k8s
 
 import (
 	"fmt"
+	"log"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	pwatch "k8s.io/apimachinery/pkg/watch"
 
 	"k8s.io/client-go/dynamic"
@@ -21,41 +27,240 @@ type listWatchAdapter struct {
 	resource      dynamic.ResourceInterface
 	fieldSelector string
 	labelSelector string
+	// transform, if set, is applied to every object before it is
+	// stored
+	transform func(map[string]interface{}) map[string]interface{}
+	// cached returns the objects in the informer's store
+	cached func() []interface{}
+
+	mutex sync.Mutex
+	// resourceVersion is the last resourceVersion seen in a list
+	// or a watch event, it is empty until the first list and
+	// after the server has told us that it expired
+	resourceVersion string
+	// watches counts the attempts to watch, lastEvent is when a
+	// change was last seen and broken when the watch last broke,
+	// it is zero while the watch is up
+	watches   int
+	lastEvent time.Time
+	broken    time.Time
 }
 
-func (lw listWatchAdapter) List(options v1.ListOptions) (runtime.Object, error) {
+// List lists the resources from the server the first time around.
+// When the watch breaks the reflector lists again before watching
+// again, in that case the objects already in the store are returned
+// as of the last seen resourceVersion, so that the new watch resumes
+// where the old one left off instead of every resource being listed
+// again. If that resourceVersion has expired, the watch fails and the
+// next List goes to the server.
+//
+// The apimachinery version we use predates watch bookmarks, so the
+// last seen resourceVersion is only advanced by actual changes.
+func (lw *listWatchAdapter) List(options v1.ListOptions) (runtime.Object, error) {
+	if rv := lw.lastResourceVersion(); rv != "" && lw.cached != nil {
+		objs := lw.cached()
+		list := &unstructured.UnstructuredList{Items: make([]unstructured.Unstructured, 0, len(objs))}
+		for _, obj := range objs {
+			list.Items = append(list.Items, *obj.(*unstructured.Unstructured))
+		}
+		list.SetResourceVersion(rv)
+		return list, nil
+	}
+
 	options.FieldSelector = lw.fieldSelector
 	options.LabelSelector = lw.labelSelector
-	// silently coerce the returned *unstructured.UnstructuredList
-	// struct to a runtime.Object interface.
-	return lw.resource.List(options)
+	list, err := listPages(lw.resource, options)
+	if err != nil {
+		lw.setBroken()
+		// silently coerce the returned *unstructured.UnstructuredList
+		// struct to a runtime.Object interface.
+		return list, err
+	}
+	if lw.transform != nil {
+		for i := range list.Items {
+			list.Items[i].Object = lw.transform(list.Items[i].Object)
+		}
+	}
+	lw.setResourceVersion(list.GetResourceVersion())
+	return list, nil
 }
 
-func (lw listWatchAdapter) Watch(options v1.ListOptions) (pwatch.Interface, error) {
+func (lw *listWatchAdapter) Watch(options v1.ListOptions) (pwatch.Interface, error) {
 	options.FieldSelector = lw.fieldSelector
 	options.LabelSelector = lw.labelSelector
-	return lw.resource.Watch(options)
+	result, err := lw.resource.Watch(options)
+	lw.mutex.Lock()
+	lw.watches++
+	if err == nil {
+		lw.broken = time.Time{}
+	}
+	lw.mutex.Unlock()
+	if err != nil {
+		lw.setBroken()
+		if errors.IsGone(err) {
+			lw.setResourceVersion("")
+		}
+		return result, err
+	}
+	return pwatch.Filter(result, func(event pwatch.Event) (pwatch.Event, bool) {
+		if event.Type == pwatch.Error {
+			// most likely the resourceVersion we watch from
+			// is too old
+			lw.setBroken()
+			lw.setResourceVersion("")
+			return event, true
+		}
+		if un, ok := event.Object.(*unstructured.Unstructured); ok {
+			lw.mutex.Lock()
+			lw.resourceVersion = un.GetResourceVersion()
+			lw.lastEvent = time.Now()
+			lw.mutex.Unlock()
+			if lw.transform != nil {
+				un.Object = lw.transform(un.Object)
+			}
+		}
+		return event, true
+	}), nil
+}
+
+func (lw *listWatchAdapter) lastResourceVersion() string {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	return lw.resourceVersion
+}
+
+func (lw *listWatchAdapter) setResourceVersion(rv string) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	lw.resourceVersion = rv
+}
+
+// setBroken records when the watch broke, unless it is already
+// broken.
+func (lw *listWatchAdapter) setBroken() {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	if lw.broken.IsZero() {
+		lw.broken = time.Now()
+	}
+}
+
+// status adds what the adapter knows about the watch to the status.
+func (lw *listWatchAdapter) status(status *WatchStatus) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	if lw.watches > 1 {
+		status.Reconnects += lw.watches - 1
+	}
+	if lw.lastEvent.After(status.LastEvent) {
+		status.LastEvent = lw.lastEvent
+	}
+	if !lw.broken.IsZero() {
+		if lag := time.Since(lw.broken); lag > status.Lag {
+			status.Lag = lag
+		}
+	}
+}
+
+// metadataOnly strips a resource down to what identifies it.
+func metadataOnly(obj map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, 3)
+	for _, key := range []string{"apiVersion", "kind", "metadata"} {
+		if value, ok := obj[key]; ok {
+			result[key] = value
+		}
+	}
+	return result
 }
 
 type Watcher struct {
+	// Coalesce, if set, batches the changes that arrive within
+	// the window into a single invocation of the listener, e.g.
+	// when lots of pods are rescheduled at once. It applies to
+	// the listeners of the watches added after it is set, but not
+	// to those of WatchWithEvents, which get every event.
+	Coalesce time.Duration
+
 	client  *Client
 	watches map[string]watch
 	stop    chan struct{}
 	wg      sync.WaitGroup
 	mutex   sync.Mutex
 	stopMu  sync.Mutex
-	started bool
-	stopped bool
+	// watchesMu protects watches and started, it is separate from
+	// mutex so that watches can be added and removed from a
+	// listener.
+	watchesMu sync.RWMutex
+	started   bool
+	stopped   bool
 }
 
 type watch struct {
-	namespace     string
+	resourceType  ResourceType
 	resource      dynamic.NamespaceableResourceInterface
 	fieldSelector string
 	labelSelector string
-	store         cache.Store
+	transform     func(map[string]interface{}) map[string]interface{}
+	informers     []*informer
 	invoke        func()
 	runner        func()
+	cancel        chan struct{}
+}
+
+const (
+	// UID_INDEX indexes the resources of a watch by uid, see
+	// Lister.ByIndex.
+	UID_INDEX = "uid"
+	// LABEL_INDEX indexes the resources of a watch by every label,
+	// as in "key=value", see Lister.ByIndex.
+	LABEL_INDEX = "label"
+)
+
+// indexers index the stores by uid and by every label, as in
+// "key=value", see GetByUID and ListByLabel.
+var indexers = cache.Indexers{
+	UID_INDEX: func(obj interface{}) ([]string, error) {
+		un, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, nil
+		}
+		return []string{string(un.GetUID())}, nil
+	},
+	LABEL_INDEX: func(obj interface{}) ([]string, error) {
+		un, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return nil, nil
+		}
+		labels := un.GetLabels()
+		result := make([]string, 0, len(labels))
+		for key, value := range labels {
+			result = append(result, key+"="+value)
+		}
+		return result, nil
+	},
+}
+
+// list returns the resources in all of the watch's stores.
+func (wt watch) list() []interface{} {
+	if len(wt.informers) == 1 {
+		return wt.informers[0].store.List()
+	}
+	var result []interface{}
+	for _, inf := range wt.informers {
+		result = append(result, inf.store.List()...)
+	}
+	return result
+}
+
+// store returns the store for resources in the given namespace, or
+// nil if the namespace isn't watched.
+func (wt watch) store(namespace string) cache.Store {
+	for _, inf := range wt.informers {
+		if inf.namespace == "" || inf.namespace == namespace {
+			return inf.store
+		}
+	}
+	return nil
 }
 
 // NewWatcher returns a Kubernetes Watcher for the specified cluster
@@ -75,9 +280,11 @@ func (c *Client) Watcher() *Watcher {
 //   ResourceName: TYPE/NAME[.NAMESPACE]
 //   ResourceType: TYPE
 //
-// BUG(lukeshu): Canonical's TYPE is just the resource type
-// name/kind/shortname; it does NOT include the version or API group.
-// This is because of limitations in Client.ResolveResourceType.
+// The TYPE may be qualified with a version and API group, see
+// Client.LookupResourceType. The canonical TYPE is always fully
+// qualified, see ResourceType.String, so that e.g. the deployments of
+// apps/v1 and extensions/v1beta1 are told apart. The empty string is
+// returned if the name is malformed or the TYPE can't be resolved.
 func (w *Watcher) Canonical(name string) string {
 	parts := strings.Split(name, "/")
 
@@ -93,9 +300,11 @@ func (w *Watcher) Canonical(name string) string {
 		return ""
 	}
 
-	ri := w.client.ResolveResourceType(kind)
-	//kind = ri.Name + "." + ri.Version + "." + ri.Group
-	kind = ri.Name
+	ri, err := w.client.LookupResourceType(kind)
+	if err != nil {
+		return ""
+	}
+	kind = ri.String()
 
 	if name == "" {
 		return kind
@@ -129,133 +338,572 @@ func (w *Watcher) WatchNamespace(namespace, resources string, listener func(*Wat
 	return w.SelectiveWatch(namespace, resources, "", "", listener)
 }
 
-func (w *Watcher) SelectiveWatch(namespace, resources, fieldSelector, labelSelector string,
-	listener func(*Watcher)) error {
-	ri := w.client.ResolveResourceType(resources)
-	dyn, err := dynamic.NewForConfig(w.client.config)
+// WatchNamespaces is WatchNamespace for several namespaces. There is
+// an informer for each of the namespaces, so resources in the other
+// namespaces are never listed or watched, not even by the server. The
+// resources in all of the namespaces are listed together by kind.
+func (w *Watcher) WatchNamespaces(namespaces []string, resources string, listener func(*Watcher)) error {
+	if len(namespaces) == 0 {
+		return fmt.Errorf("%s: no namespaces to watch", resources)
+	}
+	return w.WatchQuery(Query{Kind: resources, Namespaces: namespaces}, listener)
+}
+
+// WatchNamespaceFields is WatchNamespace, except that only the
+// resources matching the field selector are watched, see also Query.
+func (w *Watcher) WatchNamespaceFields(namespace, resources, fieldSelector string, listener func(*Watcher)) error {
+	return w.WatchQuery(Query{Kind: resources, Namespace: namespace, FieldSelector: fieldSelector}, listener)
+}
+
+// Query describes the resources a watch is interested in. Only Kind
+// is required, the rest narrows the resources down on the server side,
+// so resources that don't match never make it into the watcher.
+type Query struct {
+	Kind      string
+	Namespace string
+	// Namespaces, if set, is watched instead of Namespace, with an
+	// informer for each of the namespaces like WatchNamespaces does.
+	Namespaces []string
+	// LabelSelector uses the same syntax as `kubectl get -l`,
+	// e.g. "app=foo,tier!=frontend".
+	LabelSelector string
+	// FieldSelector uses the same syntax as `kubectl get
+	// --field-selector`, e.g. "metadata.name=foo" or
+	// "status.phase=Running". Which fields are supported depends
+	// on the kind.
+	FieldSelector string
+	// ExcludeNamespaces lists namespaces whose resources are left
+	// out. They are filtered out on the server side, so changes in
+	// them never invoke the listener.
+	ExcludeNamespaces []string
+	// MetadataOnly keeps just the apiVersion, kind and metadata of
+	// the resources, which cuts down on memory a lot for kinds like
+	// pods. The resources are still transferred in full, they just
+	// aren't kept around.
+	MetadataOnly bool
+}
+
+// WatchQuery watches the resources that match the query. The
+// resources are listed by kind as usual.
+func (w *Watcher) WatchQuery(query Query, listener func(*Watcher)) error {
+	err := query.validate()
 	if err != nil {
 		return err
 	}
+	return w.watchListener(query, query.namespaces(), listener)
+}
 
-	resource := dyn.Resource(schema.GroupVersionResource{
-		Group:    ri.Group,
-		Version:  ri.Version,
-		Resource: ri.Name,
-	})
+// WatchWhenAvailable is WatchQuery, except that the kind doesn't need
+// to be served by the cluster yet, e.g. because its CRD is installed
+// later on. Until it is, the kind is looked up again every
+// DISCOVERY_REFRESH, and once it is found the watch is added as if
+// WatchQuery were called then. Any other error is returned right
+// away.
+func (w *Watcher) WatchWhenAvailable(query Query, listener func(*Watcher)) error {
+	err := query.validate()
+	if err != nil {
+		return err
+	}
+	_, err = w.client.LookupResourceType(query.Kind)
+	if !IsUnrecognized(err) {
+		return w.WatchQuery(query, listener)
+	}
 
-	var watched dynamic.ResourceInterface
-	if namespace != "" {
-		watched = resource.Namespace(namespace)
-	} else {
-		watched = resource
+	go func() {
+		ticker := time.NewTicker(DISCOVERY_REFRESH)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+			_, err := w.client.LookupResourceType(query.Kind)
+			if IsUnrecognized(err) {
+				continue
+			}
+			err = w.WatchQuery(query, listener)
+			if err != nil {
+				log.Printf("watching %s: %v", query.Kind, err)
+			}
+			return
+		}
+	}()
+	return nil
+}
+
+func (q Query) validate() error {
+	if q.Namespaces != nil && len(q.namespaces()) == 0 {
+		return fmt.Errorf("%s: no namespaces to watch", q.Kind)
+	}
+	if _, err := labels.Parse(q.LabelSelector); err != nil {
+		return fmt.Errorf("%s: %v", q.Kind, err)
+	}
+	if _, err := fields.ParseSelector(q.fieldSelector()); err != nil {
+		return fmt.Errorf("%s: %v", q.Kind, err)
+	}
+	return nil
+}
+
+// namespaces returns the namespaces to watch, without duplicates and
+// excluded namespaces. Watching all namespaces includes every other
+// one.
+func (q Query) namespaces() []string {
+	if q.Namespaces == nil {
+		return []string{q.Namespace}
+	}
+	excluded := make(map[string]bool)
+	for _, ns := range q.ExcludeNamespaces {
+		excluded[ns] = true
+	}
+	var result []string
+	for _, ns := range q.Namespaces {
+		if ns == "" {
+			return []string{""}
+		}
+		if !excluded[ns] {
+			excluded[ns] = true
+			result = append(result, ns)
+		}
+	}
+	return result
+}
+
+// fieldSelector returns the field selector that implements both
+// FieldSelector and ExcludeNamespaces, every kind supports selecting
+// on metadata.namespace.
+func (q Query) fieldSelector() string {
+	selectors := []string{}
+	if q.FieldSelector != "" {
+		selectors = append(selectors, q.FieldSelector)
+	}
+	for _, ns := range q.ExcludeNamespaces {
+		selectors = append(selectors, "metadata.namespace!="+ns)
+	}
+	return strings.Join(selectors, ",")
+}
+
+type EventType string
+
+const (
+	ADDED    EventType = "ADDED"
+	MODIFIED EventType = "MODIFIED"
+	DELETED  EventType = "DELETED"
+)
+
+// Event describes a single change to a watched resource. Old is nil
+// for ADDED events and New is nil for DELETED events.
+type Event struct {
+	Type EventType
+	Old  Resource
+	New  Resource
+	// FinalStateUnknown is set for DELETED events of resources
+	// whose deletion was missed while the watch was broken and
+	// only noticed when listing again. Old is then the last state
+	// the watcher saw, which may be out of date.
+	FinalStateUnknown bool
+}
+
+// WatchWithEvents watches the resources that match the query like
+// WatchQuery does, except that the listener is told what changed, so
+// it doesn't need to List all the resources every time. The resources
+// that already exist when the watcher starts are delivered as ADDED
+// events.
+func (w *Watcher) WatchWithEvents(query Query, listener func(*Watcher, Event)) error {
+	err := query.validate()
+	if err != nil {
+		return err
+	}
+
+	notify := func(event Event) {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		listener(w, event)
 	}
 
+	initial := func(objs []interface{}) {
+		for _, obj := range objs {
+			notify(Event{Type: ADDED, New: toResource(obj)})
+		}
+	}
+
+	return w.addWatch(query, query.namespaces(), false, notify, initial)
+}
+
+// toResource returns the resource of an object from a store or an
+// event handler, which is the last known state for a deletion that
+// was missed, see Event.FinalStateUnknown.
+func toResource(obj interface{}) Resource {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if un, ok := obj.(*unstructured.Unstructured); ok {
+		return un.UnstructuredContent()
+	}
+	return nil
+}
+
+func (w *Watcher) SelectiveWatch(namespace, resources, fieldSelector, labelSelector string,
+	listener func(*Watcher)) error {
+	query := Query{
+		Kind:          resources,
+		Namespace:     namespace,
+		FieldSelector: fieldSelector,
+		LabelSelector: labelSelector,
+	}
+	return w.watchListener(query, []string{namespace}, listener)
+}
+
+// watchListener adds a watch that invokes the listener whenever
+// anything changes.
+func (w *Watcher) watchListener(query Query, namespaces []string, listener func(*Watcher)) error {
 	invoke := func() {
 		w.mutex.Lock()
 		defer w.mutex.Unlock()
 		listener(w)
 	}
+	changed := invoke
+	if w.Coalesce > 0 {
+		changed = coalesce(w.Coalesce, w.stop, invoke)
+	}
+	return w.addWatch(query, namespaces, true, func(Event) { changed() }, func([]interface{}) { invoke() })
+}
+
+// coalesce returns a function that calls fn once the window has
+// passed since the first of a burst of calls, unless stop is closed
+// by then. Calls made while fn runs start a new burst.
+func coalesce(window time.Duration, stop <-chan struct{}, fn func()) func() {
+	var mutex sync.Mutex
+	pending := false
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if pending {
+			return
+		}
+		pending = true
+		time.AfterFunc(window, func() {
+			mutex.Lock()
+			pending = false
+			mutex.Unlock()
+			select {
+			case <-stop:
+			default:
+				fn()
+			}
+		})
+	}
+}
+
+// addWatch sets up an informer for the query in each of the
+// namespaces, or reuses the client's informers for them if shared is
+// set. The notify function is called for every change, the initial
+// function once the watcher is started and the stores are populated.
+func (w *Watcher) addWatch(query Query, namespaces []string, shared bool, notify func(Event),
+	initial func([]interface{})) error {
+	ri, err := w.client.LookupResourceType(query.Kind)
+	if err != nil {
+		return err
+	}
+	resource, err := w.client.dynamicResource(ri)
+	if err != nil {
+		return err
+	}
 
-	store, controller := cache.NewInformer(
-		listWatchAdapter{watched, fieldSelector, labelSelector},
-		nil,
-		5*time.Minute,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				invoke()
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldUn := oldObj.(*unstructured.Unstructured)
-				newUn := newObj.(*unstructured.Unstructured)
-				// we ignore updates for objects
-				// already in our store because we
-				// assume this means we made the
-				// change to them
-				if oldUn.GetResourceVersion() != newUn.GetResourceVersion() {
-					// kube-scheduler and kube-controller-manager endpoints are
-					// updated almost every second, leading to terrible noise,
-					// and hence constant listener invokation. So, here we
-					// ignore endpoint updates from kube-system namespace. More:
-					// https://github.com/kubernetes/kubernetes/issues/41635
-					// https://github.com/kubernetes/kubernetes/issues/34627
-					if oldUn.GetKind() == "Endpoints" &&
-						newUn.GetKind() == "Endpoints" &&
-						oldUn.GetNamespace() == "kube-system" &&
-						newUn.GetNamespace() == "kube-system" {
-						return
-					}
-					invoke()
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			notify(Event{Type: ADDED, New: toResource(obj)})
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldUn := oldObj.(*unstructured.Unstructured)
+			newUn := newObj.(*unstructured.Unstructured)
+			// we ignore updates for objects
+			// already in our store because we
+			// assume this means we made the
+			// change to them
+			if oldUn.GetResourceVersion() != newUn.GetResourceVersion() {
+				// kube-scheduler and kube-controller-manager endpoints are
+				// updated almost every second, leading to terrible noise,
+				// and hence constant listener invokation. So, here we
+				// ignore endpoint updates from kube-system namespace. More:
+				// https://github.com/kubernetes/kubernetes/issues/41635
+				// https://github.com/kubernetes/kubernetes/issues/34627
+				if oldUn.GetKind() == "Endpoints" &&
+					newUn.GetKind() == "Endpoints" &&
+					oldUn.GetNamespace() == "kube-system" &&
+					newUn.GetNamespace() == "kube-system" {
+					return
 				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				invoke()
-			},
+				notify(Event{Type: MODIFIED, Old: oldUn.UnstructuredContent(), New: newUn.UnstructuredContent()})
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			_, unknown := obj.(cache.DeletedFinalStateUnknown)
+			notify(Event{Type: DELETED, Old: toResource(obj), FinalStateUnknown: unknown})
 		},
-	)
+	}
+
+	var transform func(map[string]interface{}) map[string]interface{}
+	if query.MetadataOnly {
+		transform = metadataOnly
+	}
+
+	var informers []*informer
+	for _, namespace := range namespaces {
+		var watched dynamic.ResourceInterface
+		if namespace != "" {
+			watched = resource.Namespace(namespace)
+		} else {
+			watched = resource
+		}
+
+		key := ""
+		if shared {
+			key = informerKey(ri, namespace, query)
+		}
+		inf := w.client.informers.acquire(key, namespace,
+			func(handler cache.ResourceEventHandler) (*listWatchAdapter, cache.Indexer, cache.Controller) {
+				adapter := &listWatchAdapter{
+					resource:      watched,
+					fieldSelector: query.fieldSelector(),
+					labelSelector: query.LabelSelector,
+					transform:     transform,
+				}
+				store, controller := cache.NewIndexerInformer(adapter, nil, 5*time.Minute, handler, indexers)
+				adapter.cached = store.List
+				return adapter, store, controller
+			})
+		informers = append(informers, inf)
+	}
+
+	// the handler is attached to the informers just before the
+	// listener is first invoked, and the informers are released
+	// once either the whole watcher stops or just this watch is
+	// cancelled, whether or not it ever started
+	cancel := make(chan struct{})
+	released := make(chan struct{})
+	var attachMu sync.Mutex
+	var ids []int
+	detached := false
+	attach := func() {
+		attachMu.Lock()
+		defer attachMu.Unlock()
+		if !detached {
+			for _, inf := range informers {
+				ids = append(ids, inf.attach(handler))
+			}
+		}
+	}
+	go func() {
+		select {
+		case <-w.stop:
+		case <-cancel:
+		}
+		attachMu.Lock()
+		detached = true
+		for i, id := range ids {
+			informers[i].detach(id)
+		}
+		attachMu.Unlock()
+		for _, inf := range informers {
+			inf.release()
+		}
+		close(released)
+	}()
 
 	runner := func() {
-		controller.Run(w.stop)
-		w.wg.Done()
+		defer w.wg.Done()
+		for _, inf := range informers {
+			inf.run()
+		}
+		<-released
 	}
 
-	kind := w.Canonical(ri.Kind)
-	w.watches[kind] = watch{
-		namespace:     namespace,
+	kind := ri.String()
+	watch := watch{
+		resourceType:  ri,
 		resource:      resource,
-		fieldSelector: fieldSelector,
-		labelSelector: labelSelector,
-		store:         store,
-		invoke:        invoke,
+		fieldSelector: query.fieldSelector(),
+		labelSelector: query.LabelSelector,
+		transform:     transform,
+		informers:     informers,
 		runner:        runner,
+		cancel:        cancel,
+	}
+	watch.invoke = func() {
+		attach()
+		initial(watch.list())
 	}
 
+	w.watchesMu.Lock()
+	if old, ok := w.watches[kind]; ok {
+		close(old.cancel)
+	}
+	w.watches[kind] = watch
+	started := w.started
+	if started {
+		w.wg.Add(1)
+	}
+	w.watchesMu.Unlock()
+
+	// watches added after the watcher started are started right
+	// away, the listener is invoked in the background so that this
+	// works from a listener
+	if started {
+		err := w.sync(kind, watch)
+		if err != nil {
+			w.watchesMu.Lock()
+			// unless it was replaced already, which
+			// cancelled it
+			if w.watches[kind].cancel == cancel {
+				delete(w.watches, kind)
+				close(cancel)
+			}
+			w.watchesMu.Unlock()
+			w.wg.Done()
+			return err
+		}
+		go func() {
+			watch.invoke()
+			watch.runner()
+		}()
+	}
+
+	return nil
+}
+
+// Unwatch stops watching the given kind. The other watches are not
+// affected, and once the last watch is gone Wait returns.
+func (w *Watcher) Unwatch(kind string) error {
+	kind = w.Canonical(kind)
+	w.watchesMu.Lock()
+	defer w.watchesMu.Unlock()
+	watch, ok := w.watches[kind]
+	if !ok {
+		return fmt.Errorf("no watch: %s", kind)
+	}
+	delete(w.watches, kind)
+	close(watch.cancel)
 	return nil
 }
 
-func (w *Watcher) Start() {
-	w.mutex.Lock()
+// WatchStatus describes the health of a watch.
+type WatchStatus struct {
+	// Cluster is only set by MultiWatcher.
+	Cluster string `json:"cluster,omitempty"`
+	Kind    string `json:"kind"`
+	// Synced is true once the informers have done their initial
+	// list.
+	Synced bool `json:"synced"`
+	// LastEvent is when a change was last seen, it is zero if
+	// nothing changed since the watch started.
+	LastEvent time.Time `json:"lastEvent"`
+	// Reconnects counts how often the watch had to be
+	// reestablished.
+	Reconnects int `json:"reconnects"`
+	// Lag estimates how far behind the watched resources may be,
+	// i.e. how long ago the watch broke. It is zero while the
+	// watch is up.
+	Lag time.Duration `json:"lag"`
+}
+
+// Status returns the status of every watch, ordered by kind. A watch
+// watching several namespaces is only synced when all of them are,
+// and reports the worst lag of all of them.
+func (w *Watcher) Status() []WatchStatus {
+	w.watchesMu.RLock()
+	defer w.watchesMu.RUnlock()
+	result := make([]WatchStatus, 0, len(w.watches))
+	for kind, watch := range w.watches {
+		status := WatchStatus{Kind: kind, Synced: true}
+		for _, inf := range watch.informers {
+			if !inf.controller.HasSynced() {
+				status.Synced = false
+			}
+			inf.adapter.status(&status)
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Kind < result[j].Kind })
+	return result
+}
+
+// Start lists the watched resources, invokes the listeners once the
+// lists are in, and then starts watching for changes in the
+// background. Calling Start more than once is harmless. If listing
+// fails, Start returns the error and the watcher is stopped, so the
+// caller can give up or retry with a new Watcher.
+func (w *Watcher) Start() error {
+	w.watchesMu.Lock()
 	if w.started {
-		w.mutex.Unlock()
-		return
-	} else {
-		w.started = true
-		w.mutex.Unlock()
+		w.watchesMu.Unlock()
+		return nil
 	}
-	for kind := range w.watches {
-		w.sync(kind)
+	w.started = true
+	watches := make(map[string]watch, len(w.watches))
+	for kind, watch := range w.watches {
+		watches[kind] = watch
 	}
+	w.watchesMu.Unlock()
 
-	for _, watch := range w.watches {
+	for kind, watch := range watches {
+		err := w.sync(kind, watch)
+		if err != nil {
+			// releases the informers
+			w.Stop()
+			return err
+		}
+	}
+
+	for _, watch := range watches {
 		watch.invoke()
 	}
 
-	w.wg.Add(len(w.watches))
-	for _, watch := range w.watches {
+	w.wg.Add(len(watches))
+	for _, watch := range watches {
 		go watch.runner()
 	}
+
+	return nil
 }
 
-func (w *Watcher) sync(kind string) {
-	watch := w.watches[kind]
-	resources, err := w.client.SelectiveList(watch.namespace, kind, watch.fieldSelector, watch.labelSelector)
-	if err != nil {
-		panic(err)
-	}
-	for _, rsrc := range resources {
-		var uns unstructured.Unstructured
-		uns.SetUnstructuredContent(rsrc)
-		err = watch.store.Update(&uns)
+// sync populates the stores of the watch, unless they are shared and
+// populated already.
+func (w *Watcher) sync(kind string, watch watch) error {
+	for _, inf := range watch.informers {
+		err := inf.populate(func(store cache.Indexer) error {
+			resources, err := w.client.SelectiveList(inf.namespace, watch.resourceType.String(),
+				watch.fieldSelector, watch.labelSelector)
+			if err != nil {
+				return fmt.Errorf("listing %s: %v", kind, err)
+			}
+			for _, rsrc := range resources {
+				if watch.transform != nil {
+					rsrc = watch.transform(rsrc)
+				}
+				var uns unstructured.Unstructured
+				uns.SetUnstructuredContent(rsrc)
+				err = store.Update(&uns)
+				if err != nil {
+					return fmt.Errorf("storing %s: %v", kind, err)
+				}
+			}
+			return nil
+		})
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
+	return nil
 }
 
 func (w *Watcher) List(kind string) []Resource {
 	kind = w.Canonical(kind)
+	w.watchesMu.RLock()
 	watch, ok := w.watches[kind]
+	w.watchesMu.RUnlock()
 	if ok {
-		objs := watch.store.List()
+		objs := watch.list()
 		result := make([]Resource, len(objs))
 		for idx, obj := range objs {
 			result[idx] = obj.(*unstructured.Unstructured).UnstructuredContent()
@@ -267,26 +915,99 @@ func (w *Watcher) List(kind string) []Resource {
 }
 
 func (w *Watcher) UpdateStatus(resource Resource) (Resource, error) {
-	kind := w.Canonical(resource.Kind())
-	if kind == "" {
-		return nil, fmt.Errorf("unknown resource: %v", resource.Kind())
-	}
-	watch, ok := w.watches[kind]
-	if !ok {
-		return nil, fmt.Errorf("no watch: %s", kind)
+	return w.UpdateSubresource(resource, "status")
+}
+
+// UpdateSubresource updates the given subresource of a watched
+// resource. If the server returns the whole resource, as it does for
+// "status", the watcher's copy is updated right away.
+func (w *Watcher) UpdateSubresource(resource Resource, subresource string) (Resource, error) {
+	watch, err := w.watchOf(resource)
+	if err != nil {
+		return nil, err
 	}
 
 	var uns unstructured.Unstructured
 	uns.SetUnstructuredContent(resource)
 
 	// XXX: should we have an if Namespaced here?
-	result, err := watch.resource.Namespace(uns.GetNamespace()).UpdateStatus(&uns, v1.UpdateOptions{})
+	result, err := watch.resource.Namespace(uns.GetNamespace()).Update(&uns, v1.UpdateOptions{}, subresource)
 	if err != nil {
 		return nil, err
-	} else {
-		watch.store.Update(result)
-		return result.UnstructuredContent(), nil
 	}
+	return w.updated(watch, uns.GetKind(), result), nil
+}
+
+// Patch patches a watched resource, see Client.Patch, and updates the
+// watcher's copy right away.
+func (w *Watcher) Patch(resource Resource, patchType PatchType, data []byte) (Resource, error) {
+	watch, err := w.watchOf(resource)
+	if err != nil {
+		return nil, err
+	}
+	result, err := watch.resource.Namespace(resource.Namespace()).Patch(resource.Name(), types.PatchType(patchType),
+		data, v1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return w.updated(watch, resource.Kind(), result), nil
+}
+
+// Create creates a resource of a watched kind, see Client.Create, and
+// adds it to the watcher right away.
+func (w *Watcher) Create(resource Resource) (Resource, error) {
+	watch, err := w.watchOf(resource)
+	if err != nil {
+		return nil, err
+	}
+	result, err := w.client.Create(resource)
+	if err != nil {
+		return nil, err
+	}
+	var uns unstructured.Unstructured
+	uns.SetUnstructuredContent(result)
+	if store := watch.store(uns.GetNamespace()); store != nil {
+		store.Add(&uns)
+	}
+	return result, nil
+}
+
+// Delete deletes a watched resource, see Client.Delete. The resource
+// stays in the watcher until the server reports that it is gone, e.g.
+// once its finalizers have run.
+func (w *Watcher) Delete(resource Resource, propagation Propagation) error {
+	_, err := w.watchOf(resource)
+	if err != nil {
+		return err
+	}
+	return w.client.Delete(resource, propagation)
+}
+
+// watchOf returns the watch of the resource's kind in the API group
+// and version of the resource, if it has an apiVersion.
+func (w *Watcher) watchOf(resource Resource) (watch, error) {
+	kind := w.Canonical(qualifiedKind(resource))
+	if kind == "" {
+		return watch{}, fmt.Errorf("unknown resource: %v", resource.Kind())
+	}
+	w.watchesMu.RLock()
+	watch, ok := w.watches[kind]
+	w.watchesMu.RUnlock()
+	if !ok {
+		return watch, fmt.Errorf("no watch: %s", kind)
+	}
+	return watch, nil
+}
+
+// updated stores what the server returned for a change to a resource
+// of the given kind, unless it is something else, e.g. a Scale.
+func (w *Watcher) updated(watch watch, kind string, result *unstructured.Unstructured) Resource {
+	if result.GetKind() == kind {
+		if store := watch.store(result.GetNamespace()); store != nil {
+			store.Update(result)
+		}
+	}
+	return result.UnstructuredContent()
 }
 
 func (w *Watcher) Get(kind, qname string) Resource {
@@ -303,6 +1024,108 @@ func (w *Watcher) Exists(kind, qname string) bool {
 	return w.Get(kind, qname).Name() != ""
 }
 
+// GetByName returns the resource of the given kind with the given
+// name in the given namespace, the namespace is ignored for kinds
+// that aren't namespaced. Unlike Get, the lookup doesn't scan all the
+// resources of the kind, and names are case sensitive. An empty
+// Resource is returned if there is no such resource or the kind isn't
+// watched.
+func (w *Watcher) GetByName(kind, namespace, name string) Resource {
+	kind = w.Canonical(kind)
+	w.watchesMu.RLock()
+	watch, ok := w.watches[kind]
+	w.watchesMu.RUnlock()
+	if !ok {
+		return Resource{}
+	}
+	key := name
+	if watch.resourceType.Namespaced {
+		key = namespace + "/" + name
+	}
+	store := watch.store(namespace)
+	if store == nil {
+		return Resource{}
+	}
+	obj, exists, err := store.GetByKey(key)
+	if err != nil || !exists {
+		return Resource{}
+	}
+	return toResource(obj)
+}
+
+// GetByUID returns the resource with the given uid, whatever its kind,
+// or an empty Resource if no watched resource has that uid.
+func (w *Watcher) GetByUID(uid string) Resource {
+	w.watchesMu.RLock()
+	defer w.watchesMu.RUnlock()
+	for _, watch := range w.watches {
+		for _, inf := range watch.informers {
+			objs, err := inf.store.ByIndex(UID_INDEX, uid)
+			if err == nil && len(objs) > 0 {
+				return toResource(objs[0])
+			}
+		}
+	}
+	return Resource{}
+}
+
+// ListByLabel returns the resources of the given kind that match the
+// label selector. If the selector requires a label to have a
+// particular value, only the resources with that label value are
+// looked at.
+func (w *Watcher) ListByLabel(kind, selector string) ([]Resource, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	kind = w.Canonical(kind)
+	w.watchesMu.RLock()
+	watch, ok := w.watches[kind]
+	w.watchesMu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	key := requiredLabel(sel)
+	var result []Resource
+	for _, inf := range watch.informers {
+		var objs []interface{}
+		if key != "" {
+			objs, err = inf.store.ByIndex(LABEL_INDEX, key)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			objs = inf.store.List()
+		}
+		for _, obj := range objs {
+			un := obj.(*unstructured.Unstructured)
+			if sel.Matches(labels.Set(un.GetLabels())) {
+				result = append(result, un.UnstructuredContent())
+			}
+		}
+	}
+	return result, nil
+}
+
+// requiredLabel returns "key=value" for the first label the selector
+// requires to have a particular value, or "" if there is none.
+func requiredLabel(sel labels.Selector) string {
+	requirements, selectable := sel.Requirements()
+	if !selectable {
+		return ""
+	}
+	for _, req := range requirements {
+		switch req.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+			if req.Values().Len() == 1 {
+				return req.Key() + "=" + req.Values().List()[0]
+			}
+		}
+	}
+	return ""
+}
+
 // Stop stops a watch. It is safe to call Stop from multiple
 // goroutines and call it multiple times. This is useful, e.g. for
 // implementing a timed wait pattern. You can have your watch callback
@@ -320,7 +1143,12 @@ func (w *Watcher) Stop() {
 	}
 }
 
-func (w *Watcher) Wait() {
-	w.Start()
+// Wait starts the watcher if necessary and waits until it is stopped.
+func (w *Watcher) Wait() error {
+	err := w.Start()
+	if err != nil {
+		return err
+	}
 	w.wg.Wait()
+	return nil
 }
@@ -5,6 +5,11 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	pwatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
@@ -92,3 +97,397 @@ func TestSelectiveWatch(t *testing.T) {
 	w.Wait()
 	require.Equal(t, services, []string{"kubernetes.default"})
 }
+
+func TestWatchQuery(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	services := []string{}
+	err := w.WatchQuery(Query{
+		Kind:          "services",
+		Namespace:     "default",
+		LabelSelector: "component=apiserver,provider=kubernetes",
+	}, func(w *Watcher) {
+		for _, r := range w.List("services") {
+			services = append(services, r.QName())
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	time.AfterFunc(1*time.Second, func() {
+		w.Stop()
+	})
+	w.Wait()
+	require.Equal(t, services, []string{"kubernetes.default"})
+
+	err = NewClient(nil).Watcher().WatchQuery(Query{Kind: "services", LabelSelector: "component in apiserver"},
+		func(w *Watcher) {})
+	require.Error(t, err)
+}
+
+func TestWatchNamespaceFields(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	services := []string{}
+	err := w.WatchNamespaceFields("default", "services", "metadata.name=kubernetes", func(w *Watcher) {
+		for _, r := range w.List("services") {
+			services = append(services, r.QName())
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	time.AfterFunc(1*time.Second, func() {
+		w.Stop()
+	})
+	w.Wait()
+	require.Equal(t, services, []string{"kubernetes.default"})
+
+	err = NewClient(nil).Watcher().WatchNamespaceFields("default", "services", "metadata.name", func(w *Watcher) {})
+	require.Error(t, err)
+}
+
+func TestWatchWithEvents(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	events := []string{}
+	err := w.WatchWithEvents(Query{Kind: "services", FieldSelector: "metadata.name=kubernetes"},
+		func(w *Watcher, event Event) {
+			events = append(events, string(event.Type)+" "+event.New.QName())
+		})
+	if err != nil {
+		panic(err)
+	}
+	time.AfterFunc(1*time.Second, func() {
+		w.Stop()
+	})
+	w.Wait()
+	require.Equal(t, events, []string{"ADDED kubernetes.default"})
+}
+
+func TestDynamicWatch(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	timer := time.AfterFunc(delay, func() {
+		w.Stop()
+	})
+	defer timer.Stop()
+
+	added, found := false, false
+	err := w.Watch("services", func(w *Watcher) {
+		if added || !w.Exists("services", "kubernetes.default") {
+			return
+		}
+		added = true
+		// watches can be added from a running watcher...
+		err := w.Watch("namespaces", func(w *Watcher) {
+			if !found && w.Exists("namespaces", "default") {
+				found = true
+				// ...and removed again, once the last
+				// one is gone the watcher is done
+				require.NoError(t, w.Unwatch("namespaces"))
+			}
+		})
+		require.NoError(t, err)
+		require.NoError(t, w.Unwatch("services"))
+	})
+	if err != nil {
+		panic(err)
+	}
+	w.Wait()
+	require.True(t, found)
+	require.Error(t, w.Unwatch("services"))
+}
+
+func TestWatchNamespaces(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	services := map[string]bool{}
+	err := w.WatchNamespaces([]string{"default", "kube-system", "default"}, "services", func(w *Watcher) {
+		for _, r := range w.List("services") {
+			services[r.Namespace()] = true
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	time.AfterFunc(1*time.Second, func() {
+		w.Stop()
+	})
+	w.Wait()
+	require.Equal(t, services, map[string]bool{"default": true, "kube-system": true})
+
+	require.Error(t, NewClient(nil).Watcher().WatchNamespaces(nil, "services", func(w *Watcher) {}))
+}
+
+func TestExcludeNamespaces(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	services := map[string]bool{}
+	err := w.WatchQuery(Query{Kind: "services", ExcludeNamespaces: []string{"kube-system"}}, func(w *Watcher) {
+		for _, r := range w.List("services") {
+			services[r.Namespace()] = true
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	time.AfterFunc(1*time.Second, func() {
+		w.Stop()
+	})
+	w.Wait()
+	require.True(t, services["default"])
+	require.False(t, services["kube-system"])
+}
+
+func TestQueryNamespaces(t *testing.T) {
+	require.Equal(t, []string{"default"}, Query{Namespace: "default"}.namespaces())
+	require.Equal(t, []string{"default", "ambassador"}, Query{
+		Namespaces:        []string{"default", "kube-system", "ambassador", "default"},
+		ExcludeNamespaces: []string{"kube-system"},
+	}.namespaces())
+	require.Equal(t, []string{""}, Query{Namespaces: []string{"default", ""}}.namespaces())
+	require.Error(t, Query{Kind: "services", Namespaces: []string{"kube-system"},
+		ExcludeNamespaces: []string{"kube-system"}}.validate())
+}
+
+func TestMetadataOnly(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	var svc Resource
+	err := w.WatchQuery(Query{Kind: "services", Namespace: "default", MetadataOnly: true}, func(w *Watcher) {
+		svc = w.Get("services", "kubernetes.default")
+	})
+	if err != nil {
+		panic(err)
+	}
+	time.AfterFunc(1*time.Second, func() {
+		w.Stop()
+	})
+	w.Wait()
+	require.Equal(t, "kubernetes", svc.Name())
+	require.Equal(t, "Service", svc.Kind())
+	require.Nil(t, svc["spec"])
+}
+
+func TestWatcherStatus(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	err := w.Watch("services", func(w *Watcher) {})
+	if err != nil {
+		panic(err)
+	}
+	err = w.Start()
+	require.NoError(t, err)
+	defer w.Stop()
+	deadline := time.Now().Add(delay)
+	for !w.Status()[0].Synced && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	status := w.Status()
+	require.Equal(t, 1, len(status))
+	require.Equal(t, "services.v1", status[0].Kind)
+	require.True(t, status[0].Synced)
+	require.Equal(t, time.Duration(0), status[0].Lag)
+}
+
+func TestCanonical(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	require.Equal(t, "services.v1/kubernetes.default", w.Canonical("service/kubernetes"))
+	require.Equal(t, "namespaces.v1/default", w.Canonical("ns/default"))
+	require.Equal(t, "deployments.v1.apps", w.Canonical("deployments.v1.apps"))
+	require.Equal(t, "", w.Canonical("bogus/foo"))
+}
+
+func TestIndexedLookups(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	err := w.Watch("services", func(w *Watcher) {})
+	if err != nil {
+		panic(err)
+	}
+	err = w.Start()
+	require.NoError(t, err)
+	defer w.Stop()
+
+	svc := w.GetByName("services", "default", "kubernetes")
+	require.Equal(t, "kubernetes", svc.Name())
+	require.Equal(t, svc.QName(), w.GetByUID(svc.Metadata()["uid"].(string)).QName())
+	require.True(t, w.GetByName("services", "kube-system", "kubernetes").Empty())
+
+	svcs, err := w.ListByLabel("services", "component=apiserver,provider")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(svcs))
+	require.Equal(t, "kubernetes", svcs[0].Name())
+
+	_, err = w.ListByLabel("services", "component in (")
+	require.Error(t, err)
+}
+
+func TestToResource(t *testing.T) {
+	un := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "foo", "namespace": "default"},
+	}}
+	require.Equal(t, "foo.default", toResource(un).QName())
+	tombstone := cache.DeletedFinalStateUnknown{Key: "default/foo", Obj: un}
+	require.Equal(t, "foo.default", toResource(tombstone).QName())
+	require.Nil(t, toResource("bogus"))
+}
+
+func TestLister(t *testing.T) {
+	w := NewClient(nil).Watcher()
+	err := w.Watch("services", func(w *Watcher) {})
+	require.NoError(t, err)
+	err = w.Start()
+	require.NoError(t, err)
+	defer w.Stop()
+
+	services := schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	l, err := w.Lister(services, "default")
+	require.NoError(t, err)
+	svc := l.Get("kubernetes")
+	require.Equal(t, "kubernetes", svc.Name())
+
+	svcs, err := l.List("component=apiserver")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(svcs))
+	svcs, err = l.ByIndex(UID_INDEX, svc.Metadata()["uid"].(string))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(svcs))
+
+	l, err = w.Lister(services, "kube-system")
+	require.NoError(t, err)
+	require.True(t, l.Get("kubernetes").Empty())
+
+	l, err = w.Lister(services, "")
+	require.NoError(t, err)
+	require.Equal(t, "kubernetes", l.Get("default/kubernetes").Name())
+
+	_, err = w.Lister(schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "services"}, "")
+	require.Error(t, err)
+}
+
+func TestSharedInformers(t *testing.T) {
+	c := NewClient(nil)
+	w1 := c.Watcher()
+	w2 := c.Watcher()
+	for _, w := range []*Watcher{w1, w2} {
+		err := w.Watch("services", func(w *Watcher) {})
+		require.NoError(t, err)
+		err = w.Start()
+		require.NoError(t, err)
+	}
+	require.True(t, w1.watches["services"].informers[0] == w2.watches["services"].informers[0])
+	require.Equal(t, 1, len(c.informers.informers))
+
+	w1.Stop()
+	w1.Wait()
+	require.NotEmpty(t, w2.List("services"))
+	w2.Stop()
+	w2.Wait()
+	require.Equal(t, 0, len(c.informers.informers))
+}
+
+// resumableResource is a pagedResource at resourceVersion 10 whose
+// watch delivers whatever is sent on events.
+type resumableResource struct {
+	pagedResource
+	events chan pwatch.Event
+}
+
+func (r *resumableResource) List(options v1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := r.pagedResource.List(options)
+	if err == nil {
+		list.SetResourceVersion("10")
+	}
+	return list, err
+}
+
+func (r *resumableResource) Watch(options v1.ListOptions) (pwatch.Interface, error) {
+	return r, nil
+}
+
+func (r *resumableResource) Stop() {}
+
+func (r *resumableResource) ResultChan() <-chan pwatch.Event {
+	return r.events
+}
+
+func TestListWatchResume(t *testing.T) {
+	resource := &resumableResource{pagedResource{items: 2}, make(chan pwatch.Event)}
+	stored := []interface{}{&unstructured.Unstructured{Object: map[string]interface{}{"index": 0}}}
+	lw := &listWatchAdapter{resource: resource, cached: func() []interface{} { return stored }}
+
+	list, err := lw.List(v1.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list.(*unstructured.UnstructuredList).Items))
+	require.Equal(t, "10", lw.lastResourceVersion())
+
+	// relisting resumes from the store
+	list, err = lw.List(v1.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 1, resource.calls)
+	require.Equal(t, 1, len(list.(*unstructured.UnstructuredList).Items))
+	require.Equal(t, "10", list.(*unstructured.UnstructuredList).GetResourceVersion())
+
+	watch, err := lw.Watch(v1.ListOptions{ResourceVersion: "10"})
+	require.NoError(t, err)
+	modified := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": "11"},
+	}}
+	resource.events <- pwatch.Event{Type: pwatch.Modified, Object: modified}
+	<-watch.ResultChan()
+	require.Equal(t, "11", lw.lastResourceVersion())
+
+	// once the resourceVersion expires, everything is listed again
+	resource.events <- pwatch.Event{Type: pwatch.Error}
+	<-watch.ResultChan()
+	_, err = lw.List(v1.ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2, resource.calls)
+}
+
+func TestListWatchStatus(t *testing.T) {
+	resource := &resumableResource{pagedResource{items: 1}, make(chan pwatch.Event)}
+	lw := &listWatchAdapter{resource: resource}
+
+	var status WatchStatus
+	lw.status(&status)
+	require.Equal(t, WatchStatus{}, status)
+
+	watch, err := lw.Watch(v1.ListOptions{})
+	require.NoError(t, err)
+	resource.events <- pwatch.Event{Type: pwatch.Added, Object: &unstructured.Unstructured{Object: map[string]interface{}{}}}
+	<-watch.ResultChan()
+	resource.events <- pwatch.Event{Type: pwatch.Error}
+	<-watch.ResultChan()
+
+	lw.status(&status)
+	require.Equal(t, 0, status.Reconnects)
+	require.False(t, status.LastEvent.IsZero())
+	require.True(t, status.Lag > 0)
+
+	_, err = lw.Watch(v1.ListOptions{})
+	require.NoError(t, err)
+	status = WatchStatus{}
+	lw.status(&status)
+	require.Equal(t, 1, status.Reconnects)
+	require.Equal(t, time.Duration(0), status.Lag)
+}
+
+func TestCoalesce(t *testing.T) {
+	stop := make(chan struct{})
+	calls := make(chan struct{}, 10)
+	changed := coalesce(50*time.Millisecond, stop, func() { calls <- struct{}{} })
+
+	for i := 0; i < 100; i++ {
+		changed()
+	}
+	<-calls
+	changed()
+	<-calls
+	select {
+	case <-calls:
+		t.Errorf("expected one call per burst")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	changed()
+	close(stop)
+	select {
+	case <-calls:
+		t.Errorf("expected no call after stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
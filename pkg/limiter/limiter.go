@@ -1,6 +1,9 @@
 package limiter
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 // A limiter can be used to rate limit and/or coalesce a series of
 // time-based events. This interface captures the logic of deciding
@@ -19,9 +22,10 @@ type Limiter interface {
 	// expected that this is invoked with a monotonically
 	// increasing set of timestamps. Typically this will be
 	// invoked when an event is generated and will be the result
-	// of time.Now(), but for testing or other cases, this could
-	// be invoked with any set of historic or future timestamps so
-	// long as they are invoked in monotonically increasing order.
+	// of time.Now(), or of the Now() of a clock.Clock, but for
+	// testing or other cases, this could be invoked with any set
+	// of historic or future timestamps so long as they are
+	// invoked in monotonically increasing order.
 	//
 	// The result of this (when positive) is always relative to
 	// the passed in time. In other words to compute a deadline
@@ -98,3 +102,186 @@ func NewUnlimited() Limiter {
 }
 
 func (u *unlimited) Limit(now time.Time) time.Duration { return 0 }
+
+type tokenBucket struct {
+	interval time.Duration
+	burst    time.Duration
+	credit   time.Duration
+	last     time.Time
+	deadline time.Time
+}
+
+// Constructs a new limiter that acts upon bursts of up to the
+// specified number of events right away, and upon one event per
+// interval on average after that. The bucket holds up to burst
+// tokens, one is added every interval and every action takes one.
+// Events that occur while the bucket is empty are coalesced into one
+// that is acted upon as soon as the next token is added, so a single
+// event after a quiet spell is never delayed.
+func NewTokenBucket(interval time.Duration, burst int) Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		interval: interval,
+		burst:    time.Duration(burst) * interval,
+	}
+}
+
+func (b *tokenBucket) Limit(now time.Time) time.Duration {
+	// the bucket holds credit, a token is worth an interval of it
+	if b.last.IsZero() {
+		b.credit = b.burst
+	} else {
+		b.credit += now.Sub(b.last)
+		if b.credit > b.burst {
+			b.credit = b.burst
+		}
+	}
+	b.last = now
+
+	switch {
+	case b.credit >= b.interval:
+		b.credit -= b.interval
+		return 0
+	case b.deadline.After(now):
+		return -1
+	default:
+		delay := b.interval - b.credit
+		b.deadline = now.Add(delay)
+		return delay
+	}
+}
+
+// Adaptive is a limiter that coalesces events like the one returned by
+// NewInterval, but with an interval that follows the observed cost of
+// acting upon an event. The interval is twice the cost, smoothed over
+// the last few observations, so that whatever is rate limited is
+// kept busy at most about half of the time, within the minimum and
+// maximum interval.
+type Adaptive struct {
+	min, max   time.Duration
+	mutex      sync.Mutex
+	cost       time.Duration
+	observed   bool
+	lastAction time.Time
+	deadline   time.Time
+}
+
+// Constructs a new adaptive limiter with an interval of at least min
+// and at most max. Until a cost is observed the interval is min.
+func NewAdaptive(min, max time.Duration) *Adaptive {
+	if max < min {
+		max = min
+	}
+	return &Adaptive{min: min, max: max}
+}
+
+// Observe records how long it took to act upon an event. It may be
+// called concurrently with Limit, e.g. once whatever the event was
+// handed off to is done with it.
+func (a *Adaptive) Observe(cost time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.observed {
+		a.cost = (3*a.cost + cost) / 4
+	} else {
+		a.cost = cost
+		a.observed = true
+	}
+}
+
+// Interval returns the current interval of the limiter.
+func (a *Adaptive) Interval() time.Duration {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.interval()
+}
+
+// this assumes that a.mutex is already held
+func (a *Adaptive) interval() time.Duration {
+	interval := 2 * a.cost
+	switch {
+	case interval < a.min:
+		return a.min
+	case interval > a.max:
+		return a.max
+	default:
+		return interval
+	}
+}
+
+func (a *Adaptive) Limit(now time.Time) time.Duration {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	interval := a.interval()
+	since := now.Sub(a.lastAction)
+	switch {
+	case since >= interval:
+		a.lastAction = now
+		return 0
+	case a.deadline.After(now):
+		return -1
+	default:
+		delay := interval - since
+		a.deadline = now.Add(delay)
+		return delay
+	}
+}
+
+type debounce struct {
+	quiet    time.Duration
+	max      time.Duration
+	pending  bool
+	first    time.Time
+	last     time.Time
+	deadline time.Time
+}
+
+// Constructs a new limiter that coalesces a burst of events into one
+// that is acted upon once no event occurred for the quiet period, or
+// at the latest the max delay after the first event of the burst. As
+// opposed to the other limiters, which act upon the first event of a
+// burst right away, this waits for the burst to be over and only acts
+// upon its last event. This relies on being checked back with after
+// the returned delay, the events it is checked back with are
+// considered part of the burst.
+func NewDebounce(quiet, max time.Duration) Limiter {
+	if max < quiet {
+		max = quiet
+	}
+	return &debounce{
+		quiet: quiet,
+		max:   max,
+	}
+}
+
+func (d *debounce) Limit(now time.Time) time.Duration {
+	if !d.pending {
+		d.pending = true
+		d.first = now
+		d.last = now
+		d.deadline = now.Add(d.quiet)
+		if d.quiet <= 0 {
+			d.pending = false
+			return 0
+		}
+		return d.quiet
+	}
+
+	if d.deadline.After(now) {
+		d.last = now
+		return -1
+	}
+
+	due := d.last.Add(d.quiet)
+	if latest := d.first.Add(d.max); latest.Before(due) {
+		due = latest
+	}
+	if due.After(now) {
+		d.deadline = due
+		return due.Sub(now)
+	}
+	d.pending = false
+	return 0
+}
@@ -34,3 +34,90 @@ func TestIntervalLimiter(fool *testing.T) {
 	t.expect(-1, l.Limit(start.Add(2999*time.Millisecond)))
 	t.expect(0, l.Limit(start.Add(3000*time.Millisecond)))
 }
+
+func TestTokenBucketLimiter(fool *testing.T) {
+	t := pity(fool)
+	l := NewTokenBucket(1*time.Second, 3)
+	start := time.Now()
+	// a burst of three goes through right away
+	t.expect(0, l.Limit(start))
+	t.expect(0, l.Limit(start.Add(1*time.Millisecond)))
+	t.expect(0, l.Limit(start.Add(2*time.Millisecond)))
+	// the rest is coalesced until the next token is added
+	t.expect(997*time.Millisecond, l.Limit(start.Add(3*time.Millisecond)))
+	t.expect(-1, l.Limit(start.Add(500*time.Millisecond)))
+	// checking back takes that token
+	t.expect(0, l.Limit(start.Add(1000*time.Millisecond)))
+	t.expect(500*time.Millisecond, l.Limit(start.Add(1500*time.Millisecond)))
+	// after a quiet spell the bucket is full again
+	t.expect(0, l.Limit(start.Add(10*time.Second)))
+	t.expect(0, l.Limit(start.Add(10*time.Second)))
+	t.expect(0, l.Limit(start.Add(10*time.Second)))
+	t.expect(1*time.Second, l.Limit(start.Add(10*time.Second)))
+}
+
+func TestTokenBucketSingleEvents(fool *testing.T) {
+	t := pity(fool)
+	l := NewTokenBucket(1*time.Second, 1)
+	start := time.Now()
+	t.expect(0, l.Limit(start))
+	t.expect(0, l.Limit(start.Add(1000*time.Millisecond)))
+	t.expect(500*time.Millisecond, l.Limit(start.Add(1500*time.Millisecond)))
+	t.expect(0, l.Limit(start.Add(3000*time.Millisecond)))
+}
+
+func TestAdaptiveLimiter(fool *testing.T) {
+	t := pity(fool)
+	l := NewAdaptive(100*time.Millisecond, 1*time.Second)
+	start := time.Now()
+	t.expect(100*time.Millisecond, l.Interval())
+	t.expect(0, l.Limit(start))
+	t.expect(50*time.Millisecond, l.Limit(start.Add(50*time.Millisecond)))
+
+	// a slow action backs off to twice its cost
+	l.Observe(200 * time.Millisecond)
+	t.expect(400*time.Millisecond, l.Interval())
+	t.expect(200*time.Millisecond, l.Limit(start.Add(200*time.Millisecond)))
+	t.expect(-1, l.Limit(start.Add(300*time.Millisecond)))
+	t.expect(0, l.Limit(start.Add(400*time.Millisecond)))
+
+	// up to the maximum
+	l.Observe(10 * time.Second)
+	t.expect(1*time.Second, l.Interval())
+
+	// and recovers as the action gets cheap again
+	for i := 0; i < 20; i++ {
+		l.Observe(0)
+	}
+	t.expect(100*time.Millisecond, l.Interval())
+}
+
+func TestDebounceLimiter(fool *testing.T) {
+	t := pity(fool)
+	l := NewDebounce(100*time.Millisecond, 1*time.Second)
+	start := time.Now()
+	// a single event is acted upon after the quiet period
+	t.expect(100*time.Millisecond, l.Limit(start))
+	t.expect(0, l.Limit(start.Add(100*time.Millisecond)))
+
+	// a burst is acted upon once it is over
+	start = start.Add(1 * time.Second)
+	t.expect(100*time.Millisecond, l.Limit(start))
+	t.expect(-1, l.Limit(start.Add(50*time.Millisecond)))
+	t.expect(-1, l.Limit(start.Add(90*time.Millisecond)))
+	t.expect(90*time.Millisecond, l.Limit(start.Add(100*time.Millisecond)))
+	t.expect(0, l.Limit(start.Add(190*time.Millisecond)))
+}
+
+func TestDebounceMaxDelay(fool *testing.T) {
+	t := pity(fool)
+	l := NewDebounce(100*time.Millisecond, 250*time.Millisecond)
+	start := time.Now()
+	t.expect(100*time.Millisecond, l.Limit(start))
+	t.expect(-1, l.Limit(start.Add(90*time.Millisecond)))
+	t.expect(90*time.Millisecond, l.Limit(start.Add(100*time.Millisecond)))
+	t.expect(-1, l.Limit(start.Add(180*time.Millisecond)))
+	// a burst that never quiets down is acted upon after the max delay
+	t.expect(60*time.Millisecond, l.Limit(start.Add(190*time.Millisecond)))
+	t.expect(0, l.Limit(start.Add(250*time.Millisecond)))
+}
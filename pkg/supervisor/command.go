@@ -0,0 +1,91 @@
+package supervisor
+
+import (
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CommandWorker describes a worker that runs an external command, see
+// Worker. The output of the command is written to the supervisor's
+// Logger line by line, as with Process.Command. When the worker is
+// shut down the command is sent SIGTERM, and SIGKILL if it is still
+// running after KillAfter. The signals are sent to the command's whole
+// process group, so that commands run through a shell are stopped
+// along with the shell.
+//
+// A command that exits with an error fails the worker, which is then
+// restarted or not according to Restart. A command that exits because
+// it was shut down does not.
+type CommandWorker struct {
+	Name     string   // the name of the worker
+	Command  string   // the command to run
+	Args     []string // the arguments of the command
+	Dir      string   // the working directory, the current one if empty
+	Env      []string // the environment, the current one if nil
+	Input    string   // what the command reads from its stdin
+	Requires []string
+	Restart  RestartPolicy
+	Backoff  Backoff
+	// KillAfter is how long the command has to exit after SIGTERM
+	// before it is sent SIGKILL, 10 seconds unless set. It should
+	// be shorter than the worker's shutdown grace period, if any.
+	KillAfter     time.Duration
+	ShutdownGrace time.Duration
+}
+
+// Worker returns the worker that runs the command.
+func (c *CommandWorker) Worker() *Worker {
+	return &Worker{
+		Name:          c.Name,
+		Work:          c.run,
+		Requires:      c.Requires,
+		Restart:       c.Restart,
+		Backoff:       c.Backoff,
+		ShutdownGrace: c.ShutdownGrace,
+	}
+}
+
+func (c *CommandWorker) killAfter() time.Duration {
+	if c.KillAfter > 0 {
+		return c.KillAfter
+	}
+	return 10 * time.Second
+}
+
+func (c *CommandWorker) run(p *Process) error {
+	cmd := p.Command(c.Command, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	if c.Input != "" {
+		cmd.Stdin = strings.NewReader(c.Input)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.Ready()
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		return err
+	case <-p.Shutdown():
+	}
+
+	pgid := -cmd.Process.Pid
+	p.Logf("sending SIGTERM to %s", c.Command)
+	_ = syscall.Kill(pgid, syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-p.Supervisor().Clock.After(c.killAfter()):
+		p.LogWith(WarnLevel, "did not exit after SIGTERM, sending SIGKILL", Fields{"command": c.Command})
+		_ = syscall.Kill(pgid, syscall.SIGKILL)
+		<-exited
+	}
+	return nil
+}
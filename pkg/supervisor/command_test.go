@@ -0,0 +1,99 @@
+package supervisor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runCommand(t *testing.T, c *CommandWorker, shutdown bool) ([]error, *recordingLogger) {
+	logger := &recordingLogger{}
+	s := WithContext(context.Background())
+	s.Logger = logger
+	w := c.Worker()
+	if shutdown {
+		w.Work = func(p *Process) error {
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				s.Shutdown()
+			}()
+			return c.run(p)
+		}
+	}
+	s.Supervise(w)
+	done := make(chan []error)
+	go func() {
+		done <- s.Run()
+	}()
+	select {
+	case errs := <-done:
+		return errs, logger
+	case <-time.After(10 * time.Second):
+		t.Fatal("command did not exit")
+		return nil, nil
+	}
+}
+
+func TestCommandWorkerOutput(t *testing.T) {
+	errs, logger := runCommand(t, &CommandWorker{
+		Name:    "cat",
+		Command: "cat",
+		Input:   "hello",
+	}, false)
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	found := false
+	for _, e := range logger.entries {
+		if strings.Contains(e.msg, "hello") && e.fields[WORKER_FIELD] == "cat" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("output not logged: %v", logger.entries)
+	}
+}
+
+func TestCommandWorkerFailure(t *testing.T) {
+	errs, _ := runCommand(t, &CommandWorker{
+		Name:    "fail",
+		Command: "sh",
+		Args:    []string{"-c", "exit 3"},
+	}, false)
+	if len(errs) != 1 || errs[0].Error() != "fail: exit status 3" {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestCommandWorkerTerminate(t *testing.T) {
+	errs, _ := runCommand(t, &CommandWorker{
+		Name:    "sleep",
+		Command: "sleep",
+		Args:    []string{"60"},
+	}, true)
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestCommandWorkerKill(t *testing.T) {
+	errs, logger := runCommand(t, &CommandWorker{
+		Name:      "stubborn",
+		Command:   "sh",
+		Args:      []string{"-c", "trap '' TERM; sleep 60"},
+		KillAfter: 100 * time.Millisecond,
+	}, true)
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	killed := false
+	for _, e := range logger.entries {
+		if e.level == WarnLevel && strings.Contains(e.msg, "SIGKILL") {
+			killed = true
+		}
+	}
+	if !killed {
+		t.Errorf("command not killed: %v", logger.entries)
+	}
+}
@@ -0,0 +1,36 @@
+package supervisor
+
+import (
+	"fmt"
+)
+
+// WorkerError is the error Run returns for each worker that failed.
+// It says which worker failed, how often it had been restarted before,
+// and why, e.g. the error its Work returned, a *PanicError, a
+// *StuckError or a *SilentError. The cause can be inspected with
+// errors.Is and errors.As, or pkg/errors' Cause, so that callers can
+// tell e.g. bad configuration from transient failures.
+type WorkerError struct {
+	Worker   string
+	Restarts int
+	Err      error
+}
+
+func (e *WorkerError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Worker, e.Err)
+}
+
+// Unwrap returns the cause, for errors.Is and errors.As.
+func (e *WorkerError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the cause, for pkg/errors.
+func (e *WorkerError) Cause() error {
+	return e.Err
+}
+
+// this assumes that s.mutex is already held
+func (s *Supervisor) failed(worker *Worker, err error) {
+	s.errors = append(s.errors, &WorkerError{Worker: worker.Name, Restarts: worker.restarts, Err: err})
+}
@@ -0,0 +1,61 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+var errBadConfig = errors.New("bad config")
+
+func TestWorkerError(t *testing.T) {
+	s := WithContext(context.Background())
+	tree := WithContext(context.Background())
+	tree.Supervise(&Worker{
+		Name: "config",
+		Work: func(p *Process) error {
+			return fmt.Errorf("loading: %w", errBadConfig)
+		},
+	})
+	s.Supervise(tree.AsWorker("tree"))
+
+	errs := s.Run()
+	if len(errs) != 1 || errs[0].Error() != "tree: config: loading: bad config" {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	var workerErr *WorkerError
+	if !errors.As(errs[0], &workerErr) || workerErr.Worker != "tree" {
+		t.Errorf("unexpected error: %v", errs[0])
+	}
+	if !errors.Is(errs[0], errBadConfig) {
+		t.Errorf("expected the bad config cause: %v", errs[0])
+	}
+	var subtreeErr *SubtreeError
+	if !errors.As(errs[0], &subtreeErr) || !errors.As(subtreeErr.Errors[0], &workerErr) || workerErr.Worker != "config" {
+		t.Errorf("unexpected subtree error: %v", errs[0])
+	}
+}
+
+func TestWorkerErrorRestarts(t *testing.T) {
+	s := WithContext(context.Background())
+	runs := 0
+	s.Supervise(&Worker{
+		Name:    "worker",
+		Restart: Always,
+		Backoff: Backoff{Initial: 1},
+		Work: func(p *Process) error {
+			runs++
+			if runs == 2 {
+				p.Do(func() { panic("oops") })
+				<-p.Shutdown()
+			}
+			return nil
+		},
+	})
+	errs := s.Run()
+	var panicErr *PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &panicErr) || errs[0].(*WorkerError).Restarts != 1 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
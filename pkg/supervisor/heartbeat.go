@@ -0,0 +1,53 @@
+package supervisor
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// SilentError is the error of a worker that didn't call Process.Alive
+// within its Heartbeat, e.g. because it is wedged in a call that never
+// returns. The supervisor gives up on the process as it does on one
+// that is stuck, see StuckError, and tells it to shut down in case it
+// comes back. The worker is then restarted, or fails, according to its
+// restart policy, just as if Work had returned the error.
+type SilentError struct {
+	Worker    string
+	Heartbeat time.Duration
+}
+
+func (e *SilentError) Error() string {
+	return fmt.Sprintf("no heartbeat within %s", e.Heartbeat)
+}
+
+// Alive tells the supervisor that the worker is making progress. A
+// worker with a Heartbeat has to call it at least that often, counting
+// from when Work is invoked.
+func (p *Process) Alive() {
+	atomic.StoreInt64(&p.alive, p.supervisor.Clock.Now().UnixNano())
+}
+
+// checkHeartbeat gives up on the process if it hasn't called Alive
+// within its worker's heartbeat, or checks again once it may have
+// been. This assumes that s.mutex is already held.
+func (s *Supervisor) checkHeartbeat(process *Process) {
+	worker := process.worker
+	if worker.process != process || process.shutdownClosed {
+		// the process exited or is shutting down anyways
+		return
+	}
+	deadline := time.Unix(0, atomic.LoadInt64(&process.alive)).Add(worker.Heartbeat)
+	if wait := deadline.Sub(s.Clock.Now()); wait > 0 {
+		s.Clock.AfterFunc(wait, func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			s.checkHeartbeat(process)
+		})
+		return
+	}
+	process.abandoned = true
+	close(process.shutdown)
+	process.shutdownClosed = true
+	s.exited(process, &SilentError{Worker: worker.Name, Heartbeat: worker.Heartbeat})
+}
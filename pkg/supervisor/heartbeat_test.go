@@ -0,0 +1,74 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	s := WithContext(context.Background())
+	s.Supervise(&Worker{
+		Name:      "lively",
+		Heartbeat: 50 * time.Millisecond,
+		Work: func(p *Process) error {
+			for i := 0; i < 20; i++ {
+				p.Alive()
+				time.Sleep(10 * time.Millisecond)
+			}
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestHeartbeatRestart(t *testing.T) {
+	s := WithContext(context.Background())
+	attempts := make(chan int, 10)
+	s.Supervise(&Worker{
+		Name:      "wedged",
+		Heartbeat: 20 * time.Millisecond,
+		Restart:   OnFailure,
+		Backoff:   Backoff{Initial: 1},
+		Work: func(p *Process) error {
+			attempts <- 1
+			if len(attempts) == 1 {
+				// wedged until the supervisor gives up on it
+				<-p.Shutdown()
+				return nil
+			}
+			s.Shutdown()
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if len(attempts) != 2 {
+		t.Errorf("expected 2 attempts, got %d", len(attempts))
+	}
+	status := s.Status()
+	if len(status) != 1 || status[0].Restarts != 1 || status[0].LastError != "no heartbeat within 20ms" {
+		t.Errorf("unexpected status: %v", status)
+	}
+}
+
+func TestHeartbeatFailure(t *testing.T) {
+	s := WithContext(context.Background())
+	s.Supervise(&Worker{
+		Name:      "wedged",
+		Heartbeat: 20 * time.Millisecond,
+		Work: func(p *Process) error {
+			<-p.Shutdown()
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 1 || errors[0].Error() != "wedged: no heartbeat within 20ms" {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
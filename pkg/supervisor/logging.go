@@ -0,0 +1,104 @@
+package supervisor
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// Fields are the key/value pairs of a log entry.
+type Fields map[string]interface{}
+
+// WORKER_FIELD is the field that holds the name of the worker a log
+// entry is about. It is set on every entry the supervisor and its
+// processes write about a worker.
+const WORKER_FIELD = "worker"
+
+// Logger is where a supervisor writes its log entries, and those of
+// its workers, see Process.Log. Implement it to route them to zap,
+// logrus, JSON lines or the like. Log may be called from any goroutine
+// and must not call back into the supervisor.
+type Logger interface {
+	Log(level Level, msg string, fields Fields)
+}
+
+// DefaultLogger writes log entries with the standard log package, see
+// PrintfLogger.
+type DefaultLogger struct{}
+
+func (d *DefaultLogger) Log(level Level, msg string, fields Fields) {
+	log.Print(format(msg, fields))
+}
+
+type printfLogger func(format string, v ...interface{})
+
+// PrintfLogger returns a Logger that writes log entries with the given
+// printf style function, e.g. log.Printf or testing.T.Logf. Entries
+// are written the way the supervisor always has, as "worker: msg",
+// followed by the other fields sorted by key. The level is dropped.
+func PrintfLogger(printf func(format string, v ...interface{})) Logger {
+	return printfLogger(printf)
+}
+
+func (p printfLogger) Log(level Level, msg string, fields Fields) {
+	p("%s", format(msg, fields))
+}
+
+func format(msg string, fields Fields) string {
+	var b strings.Builder
+	if worker, ok := fields[WORKER_FIELD]; ok {
+		fmt.Fprintf(&b, "%v: ", worker)
+	}
+	b.WriteString(msg)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if k != WORKER_FIELD {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	return b.String()
+}
+
+// log writes a log entry about the worker, or about the supervisor
+// itself if the worker is nil.
+func (s *Supervisor) log(level Level, worker *Worker, msg string, fields Fields) {
+	if worker != nil {
+		withWorker := make(Fields, len(fields)+1)
+		for k, v := range fields {
+			withWorker[k] = v
+		}
+		withWorker[WORKER_FIELD] = worker.Name
+		fields = withWorker
+	}
+	s.Logger.Log(level, msg, fields)
+}
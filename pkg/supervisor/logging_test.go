@@ -0,0 +1,68 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type entry struct {
+	level  Level
+	msg    string
+	fields Fields
+}
+
+type recordingLogger struct {
+	mutex   sync.Mutex
+	entries []entry
+}
+
+func (l *recordingLogger) Log(level Level, msg string, fields Fields) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries = append(l.entries, entry{level, msg, fields})
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	s := WithContext(context.Background())
+	s.Logger = logger
+	s.Supervise(&Worker{
+		Name: "worker",
+		Work: func(p *Process) error {
+			p.LogWith(WarnLevel, "slow", Fields{"url": "http://example.com"})
+			return fmt.Errorf("oops")
+		},
+	})
+	s.Run()
+
+	expected := []string{
+		"info starting map[worker:worker]",
+		"warn slow map[url:http://example.com worker:worker]",
+		"error oops map[worker:worker]",
+	}
+	if len(logger.entries) != len(expected) {
+		t.Fatalf("unexpected entries: %v", logger.entries)
+	}
+	for i, e := range logger.entries {
+		if actual := fmt.Sprintf("%s %s %v", e.level, e.msg, e.fields); actual != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], actual)
+		}
+	}
+}
+
+func TestPrintfLogger(t *testing.T) {
+	var lines []string
+	logger := PrintfLogger(func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	})
+	logger.Log(InfoLevel, "starting", Fields{WORKER_FIELD: "worker"})
+	logger.Log(ErrorLevel, "failed", Fields{WORKER_FIELD: "worker", "b": 2, "a": 1})
+	logger.Log(DebugLevel, "plain", nil)
+
+	expected := []string{"worker: starting", "worker: failed a=1 b=2", "plain"}
+	if fmt.Sprint(lines) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, lines)
+	}
+}
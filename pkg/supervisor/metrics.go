@@ -0,0 +1,150 @@
+package supervisor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The upper bounds (in seconds) of the Work duration histogram
+// buckets. Workers range from quick one-shot jobs to ones that run
+// for as long as the process does.
+var workBuckets = []float64{.01, .1, 1, 10, 60, 600, 3600, 21600, 86400}
+
+// metrics are the metrics of all the workers with a name, which
+// outlive the workers themselves.
+type metrics struct {
+	restarts *prometheus.CounterVec
+	work     *prometheus.HistogramVec
+	// the states and uptimes are set from the workers when
+	// they're collected, collecting serializes that
+	collecting sync.Mutex
+	state      *prometheus.GaugeVec
+	uptime     *prometheus.GaugeVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "supervisor_worker_restarts_total",
+			Help: "Worker restarts, by worker.",
+		}, []string{"worker"}),
+		work: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "supervisor_work_duration_seconds",
+			Help:    "Time taken by the runs of each worker's Work.",
+			Buckets: workBuckets,
+		}, []string{"worker"}),
+		state: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "supervisor_worker_state",
+			Help: "Worker states, 1 for the state each worker is in.",
+		}, []string{"worker", "state"}),
+		uptime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "supervisor_worker_uptime_seconds",
+			Help: "Time since each running worker was last started.",
+		}, []string{"worker"}),
+	}
+}
+
+// added makes the restarts of a worker show up before the first one.
+func (m *metrics) added(worker *Worker) {
+	m.restarts.WithLabelValues(worker.Name)
+}
+
+// restarted counts a restart of the worker. This assumes that s.mutex
+// is already held.
+func (s *Supervisor) restarted(worker *Worker) {
+	worker.restarts++
+	s.metrics.restarts.WithLabelValues(worker.Name).Inc()
+}
+
+// observeWork records how long a run of the worker's Work took. This
+// assumes that s.mutex is already held.
+func (s *Supervisor) observeWork(worker *Worker, elapsed time.Duration) {
+	s.metrics.work.WithLabelValues(worker.Name).Observe(elapsed.Seconds())
+}
+
+// Register registers metrics about the supervisor's workers with the
+// registerer:
+//
+//   - supervisor_worker_restarts_total, the restarts of each worker
+//   - supervisor_worker_state, a gauge that is 1 for the state of
+//     each worker, see Status
+//   - supervisor_worker_uptime_seconds, how long each running worker
+//     has been running since it was last started
+//   - supervisor_work_duration_seconds, a histogram of how long the
+//     runs of each worker's Work took
+//
+// The restarts and durations of workers that were removed are kept,
+// and added to if a worker with the same name is added again. Wrap
+// the registerer to give the names a prefix, e.g. with
+// prometheus.WrapRegistererWithPrefix("watt_", registerer) for
+// watt_supervisor_worker_restarts_total.
+func (s *Supervisor) Register(registerer prometheus.Registerer) error {
+	return registerer.Register(collector{s})
+}
+
+// collector is the prometheus.Collector of the metrics of a
+// supervisor.
+type collector struct {
+	supervisor *Supervisor
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	m := c.supervisor.metrics
+	m.restarts.Describe(ch)
+	m.work.Describe(ch)
+	m.state.Describe(ch)
+	m.uptime.Describe(ch)
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	m := c.supervisor.metrics
+	m.collecting.Lock()
+	defer m.collecting.Unlock()
+	c.update()
+	m.restarts.Collect(ch)
+	m.work.Collect(ch)
+	m.state.Collect(ch)
+	m.uptime.Collect(ch)
+}
+
+// update sets the state and uptime gauges from the workers. This
+// assumes that s.mutex is not held.
+func (c collector) update() {
+	s := c.supervisor
+	status := s.Status()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m := s.metrics
+	m.state.Reset()
+	m.uptime.Reset()
+
+	// a worker that was done may have been added again since, the
+	// state of the live one, or else of the latest one, counts
+	states := make(map[string]WorkerState)
+	for _, st := range status {
+		if _, live := s.workers[st.Name]; live {
+			if _, seen := states[st.Name]; seen {
+				continue
+			}
+		}
+		states[st.Name] = st.State
+	}
+	all := []WorkerState{Waiting, Running, Restarting, ShuttingDown, Failed, Done}
+	now := s.Clock.Now()
+	for worker, current := range states {
+		for _, state := range all {
+			value := 0.0
+			if state == current {
+				value = 1
+			}
+			m.state.WithLabelValues(worker, string(state)).Set(value)
+		}
+		w, ok := s.workers[worker]
+		if ok && w.process != nil && w.process.running {
+			m.uptime.WithLabelValues(worker).Set(now.Sub(w.process.started).Seconds())
+		}
+	}
+}
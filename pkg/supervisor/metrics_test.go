@@ -0,0 +1,61 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestRegister(t *testing.T) {
+	s := WithContext(context.Background())
+	attempts := 0
+	s.Supervise(&Worker{
+		Name:    "flaky",
+		Restart: OnFailure,
+		Backoff: Backoff{Initial: 1},
+		Work: func(p *Process) error {
+			attempts++
+			if attempts < 3 {
+				return fmt.Errorf("oops")
+			}
+			return nil
+		},
+	})
+	if errs := s.Run(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := s.Register(prometheus.WrapRegistererWithPrefix("test_", registry)); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metrics := buf.String()
+	for _, line := range []string{
+		"# TYPE test_supervisor_worker_restarts_total counter",
+		`test_supervisor_worker_restarts_total{worker="flaky"} 2`,
+		`test_supervisor_worker_state{state="done",worker="flaky"} 1`,
+		`test_supervisor_worker_state{state="running",worker="flaky"} 0`,
+		"# TYPE test_supervisor_work_duration_seconds histogram",
+		`test_supervisor_work_duration_seconds_bucket{worker="flaky",le="+Inf"} 3`,
+		`test_supervisor_work_duration_seconds_count{worker="flaky"} 3`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, metrics)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package supervisor
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNoResult is the error of a result whose worker exited before its
+// function returned, e.g. because it panicked.
+var ErrNoResult = errors.New("worker exited without a result")
+
+// ErrShutdown is returned by Result.WaitFor when the waiting process
+// is shut down before the result is available.
+var ErrShutdown = errors.New("shut down while waiting for a result")
+
+// Result is what the function of a one-shot worker returns, see
+// OneShot. Other workers wait for it rather than receive it on a
+// channel that has to be shared with them up front.
+type Result struct {
+	once  sync.Once
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+func (r *Result) complete(value interface{}, err error) {
+	r.once.Do(func() {
+		r.value = value
+		r.err = err
+		close(r.done)
+	})
+}
+
+// Done returns a channel that is closed once the result is available.
+func (r *Result) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait blocks until the result is available and returns it.
+func (r *Result) Wait() (interface{}, error) {
+	<-r.done
+	return r.value, r.err
+}
+
+// WaitFor is like Wait, but returns ErrShutdown if the given process
+// is shut down first.
+func (r *Result) WaitFor(p *Process) (interface{}, error) {
+	select {
+	case <-r.done:
+		return r.value, r.err
+	case <-p.Shutdown():
+		return nil, ErrShutdown
+	}
+}
+
+// OneShot returns a worker that calls fn once and the Result that
+// holds what fn returns. Once fn succeeds the worker signals it is
+// ready and stays around, without calling fn again, until it is shut
+// down, so workers that require it can start and find the result
+// available. If fn fails the worker fails. Its error becomes the
+// result, unless the worker is restarted according to its restart
+// policy, in which case fn is called again and the result is what it
+// returns then.
+func OneShot(name string, fn func(*Process) (interface{}, error)) (*Worker, *Result) {
+	result := &Result{done: make(chan struct{})}
+	worker := &Worker{
+		Name: name,
+		Work: func(p *Process) error {
+			final := p.Worker().restartPolicy() == Never
+			returned := false
+			defer func() {
+				if !returned && final {
+					result.complete(nil, ErrNoResult)
+				}
+			}()
+			value, err := fn(p)
+			returned = true
+			if err != nil {
+				if final {
+					result.complete(nil, err)
+				}
+				return err
+			}
+			result.complete(value, nil)
+			p.Ready()
+			<-p.Shutdown()
+			return nil
+		},
+	}
+	return worker, result
+}
@@ -0,0 +1,91 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestOneShot(t *testing.T) {
+	s := WithContext(context.Background())
+	producer, result := OneShot("producer", func(p *Process) (interface{}, error) {
+		return 42, nil
+	})
+	var value interface{}
+	s.Supervise(&Worker{
+		Name:     "consumer",
+		Requires: []string{"producer"},
+		Work: func(p *Process) error {
+			select {
+			case <-result.Done():
+			default:
+				t.Errorf("result not available")
+			}
+			var err error
+			value, err = result.WaitFor(p)
+			s.Shutdown()
+			return err
+		},
+	})
+	s.Supervise(producer)
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if value != 42 {
+		t.Errorf("unexpected value: %v", value)
+	}
+}
+
+func TestOneShotError(t *testing.T) {
+	s := WithContext(context.Background())
+	producer, result := OneShot("producer", func(p *Process) (interface{}, error) {
+		return nil, fmt.Errorf("oops")
+	})
+	s.Supervise(producer)
+	errors := s.Run()
+	if len(errors) != 1 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if _, err := result.Wait(); err == nil || err.Error() != "oops" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestOneShotRetry(t *testing.T) {
+	s := WithContext(context.Background())
+	attempts := 0
+	producer, result := OneShot("producer", func(p *Process) (interface{}, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, fmt.Errorf("oops")
+		}
+		return attempts, nil
+	})
+	producer.Restart = OnFailure
+	producer.Backoff = Backoff{Initial: 1}
+	s.Supervise(producer)
+	go func() {
+		<-result.Done()
+		s.Shutdown()
+	}()
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if value, err := result.Wait(); value != 3 || err != nil {
+		t.Errorf("unexpected result: %v, %v", value, err)
+	}
+}
+
+func TestOneShotPanic(t *testing.T) {
+	s := WithContext(context.Background())
+	producer, result := OneShot("producer", func(p *Process) (interface{}, error) {
+		panic("oops")
+	})
+	s.Supervise(producer)
+	s.Run()
+	if _, err := result.Wait(); err != ErrNoResult {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
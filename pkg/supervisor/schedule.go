@@ -0,0 +1,219 @@
+package supervisor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when a scheduled worker runs, see ScheduledWorker.
+type Schedule interface {
+	// Next returns the first time after the given one the work is
+	// due, or the zero time if it never is again.
+	Next(after time.Time) time.Time
+}
+
+type every time.Duration
+
+// Every returns a schedule that is due every interval, counting from
+// the end of the previous run.
+func Every(interval time.Duration) Schedule {
+	return every(interval)
+}
+
+func (e every) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// cron is a parsed cron expression, each field is a bit set of the
+// values that match.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// as in cron, if both the day of month and the day of week are
+	// restricted, i.e. don't start with "*", a day matches if either
+	// of them does
+	anyDom, anyDow bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses a cron expression with the five fields "minute hour
+// day-of-month month day-of-week", e.g. "*/15 * * * *" for every
+// quarter of an hour or "0 3 * * 1-5" for 3am on weekdays. Each field
+// is a "*" or a comma separated list of values and ranges, which may
+// have a step, e.g. "1-10/2". Sunday is 0 or 7. Names of months and
+// days aren't supported. Times are matched in the location of the time
+// Next is given.
+func ParseCron(expr string) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q: expected %d fields, got %d", expr, len(cronFields), len(fields))
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %s: %v", expr, cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	// sunday is 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &cron{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDom: strings.HasPrefix(fields[2], "*"),
+		anyDow: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step: %s", part)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			lo, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("bad value: %s", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				hi, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("bad value: %s", part)
+				}
+			} else if step > 1 {
+				// "5/10" means from 5 on
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("out of range %d-%d: %s", min, max, part)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func inSet(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := inSet(c.dom, t.Day())
+	dow := inSet(c.dow, int(t.Weekday()))
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// every possible match comes around within a few years, e.g.
+	// the 29th of february
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !inSet(c.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !inSet(c.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !inSet(c.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ScheduledWorker describes a worker that calls Work whenever it is
+// due according to Schedule, see Worker. Runs never overlap: the next
+// run is scheduled once the previous one has returned, so runs that
+// fall due while the previous one is still running are skipped.
+//
+// Work is called with the same Process every time, the worker is
+// ready once it waits for its first run. If Work returns an error the
+// worker fails, and is restarted or not according to Restart.
+type ScheduledWorker struct {
+	Name     string
+	Work     func(*Process) error
+	Schedule Schedule
+	// RunAtStart calls Work once as soon as the worker starts,
+	// before it waits for the schedule.
+	RunAtStart bool
+	Requires   []string
+	Restart    RestartPolicy
+	Backoff    Backoff
+}
+
+// Worker returns the worker that runs the work on schedule.
+func (sw *ScheduledWorker) Worker() *Worker {
+	return &Worker{
+		Name:     sw.Name,
+		Work:     sw.run,
+		Requires: sw.Requires,
+		Restart:  sw.Restart,
+		Backoff:  sw.Backoff,
+	}
+}
+
+func (sw *ScheduledWorker) run(p *Process) error {
+	p.Ready()
+	if sw.RunAtStart {
+		if err := sw.Work(p); err != nil {
+			return err
+		}
+	}
+	for {
+		now := p.Supervisor().Clock.Now()
+		next := sw.Schedule.Next(now)
+		if next.IsZero() {
+			p.Logf("schedule is never due again")
+			<-p.Shutdown()
+			return nil
+		}
+		due := make(chan struct{})
+		timer := p.Supervisor().Clock.AfterFunc(next.Sub(now), func() { close(due) })
+		select {
+		case <-due:
+		case <-p.Shutdown():
+			timer.Stop()
+			return nil
+		}
+		if err := sw.Work(p); err != nil {
+			return err
+		}
+	}
+}
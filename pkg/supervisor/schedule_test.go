@@ -0,0 +1,84 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	// a wednesday
+	start := time.Date(2019, 5, 15, 10, 7, 30, 0, time.UTC)
+	for _, c := range []struct {
+		expr     string
+		expected string
+	}{
+		{"* * * * *", "2019-05-15 10:08"},
+		{"*/15 * * * *", "2019-05-15 10:15"},
+		{"5/10 * * * *", "2019-05-15 10:15"},
+		{"0 3 * * *", "2019-05-16 03:00"},
+		{"0 3 * * 1-5", "2019-05-16 03:00"},
+		{"0 3 * * 0", "2019-05-19 03:00"},
+		{"0 3 * * 7", "2019-05-19 03:00"},
+		{"30 9,18 * * *", "2019-05-15 18:30"},
+		{"0 0 1 * *", "2019-06-01 00:00"},
+		{"0 0 29 2 *", "2020-02-29 00:00"},
+		// either the day of month or the day of week
+		{"0 0 1 * 5", "2019-05-17 00:00"},
+		{"0 0 31 2 *", "0001-01-01 00:00"},
+	} {
+		schedule, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if actual := schedule.Next(start).Format("2006-01-02 15:04"); actual != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.expr, c.expected, actual)
+		}
+	}
+}
+
+func TestCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"60 * * * *",
+		"* 5-1 * * *",
+		"*/0 * * * *",
+		"* * 0 * *",
+		"* * * jan *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestScheduledWorker(t *testing.T) {
+	s := WithContext(context.Background())
+	var runs, running int32
+	s.Supervise((&ScheduledWorker{
+		Name:       "periodic",
+		Schedule:   Every(time.Millisecond),
+		RunAtStart: true,
+		Work: func(p *Process) error {
+			if atomic.AddInt32(&running, 1) != 1 {
+				return fmt.Errorf("runs overlap")
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			if atomic.AddInt32(&runs, 1) == 3 {
+				s.Shutdown()
+			}
+			return nil
+		},
+	}).Worker())
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if runs < 3 {
+		t.Errorf("expected at least 3 runs, got %d", runs)
+	}
+}
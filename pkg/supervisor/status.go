@@ -0,0 +1,100 @@
+package supervisor
+
+// WorkerState is what a worker is up to, see Supervisor.Status.
+type WorkerState string
+
+const (
+	// Waiting workers wait for the workers they require to be
+	// ready.
+	Waiting WorkerState = "waiting"
+	// Running workers are running, whether or not they are ready.
+	Running WorkerState = "running"
+	// Restarting workers wait for their backoff delay to pass
+	// before they are started again.
+	Restarting WorkerState = "restarting"
+	// ShuttingDown workers were told to shut down but haven't
+	// exited yet.
+	ShuttingDown WorkerState = "shutting down"
+	// Failed workers exited with an error and aren't restarted,
+	// or didn't shut down in time.
+	Failed WorkerState = "failed"
+	// Done workers exited normally.
+	Done WorkerState = "done"
+)
+
+// finishedHistory is how many of the workers that are done Status
+// remembers.
+const finishedHistory = 100
+
+// WorkerStatus is the status of a worker, see Supervisor.Status.
+type WorkerStatus struct {
+	Name     string      `json:"name"`
+	State    WorkerState `json:"state"`
+	Requires []string    `json:"requires,omitempty"`
+	Ready    bool        `json:"ready"`
+	Restarts int         `json:"restarts"`
+	// LastError is the error the worker last exited with, if any,
+	// even if it was restarted since.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Status returns the status of the supervisor's workers, in the order
+// they were added, followed by the most recent of the workers that are
+// done.
+func (s *Supervisor) Status() []WorkerStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]WorkerStatus, 0, len(s.names)+len(s.finished))
+	for _, name := range s.names {
+		result = append(result, s.workers[name].status())
+	}
+	for _, w := range s.finished {
+		result = append(result, w.status())
+	}
+	return result
+}
+
+// finish marks the worker as done and remembers it for Status. This
+// assumes that s.mutex is already held.
+func (s *Supervisor) finish(worker *Worker) {
+	worker.done = true
+	s.finished = append(s.finished, worker)
+	if len(s.finished) > finishedHistory {
+		s.finished = s.finished[len(s.finished)-finishedHistory:]
+	}
+}
+
+// this assumes that s.mutex is already held
+func (w *Worker) status() WorkerStatus {
+	status := WorkerStatus{
+		Name:     w.Name,
+		Requires: w.Requires,
+		Restarts: w.restarts,
+	}
+	if w.lastError != nil {
+		status.LastError = w.lastError.Error()
+	}
+	p := w.process
+	switch {
+	case w.done && (w.error != nil || isStuck(w.lastError)):
+		status.State = Failed
+	case w.done:
+		status.State = Done
+	case p == nil:
+		status.State = Waiting
+	case p.shutdownClosed:
+		status.State = ShuttingDown
+		status.Ready = p.ready
+	case !p.running && w.restarts > 0:
+		status.State = Restarting
+	default:
+		status.State = Running
+		status.Ready = p.ready
+	}
+	return status
+}
+
+func isStuck(err error) bool {
+	_, ok := err.(*StuckError)
+	return ok
+}
@@ -0,0 +1,70 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	s := WithContext(context.Background())
+	s.Supervise(&Worker{
+		Name: "steady",
+		Work: func(p *Process) error {
+			p.Ready()
+			<-p.Shutdown()
+			return nil
+		},
+	})
+	s.Supervise(&Worker{
+		Name:     "flaky",
+		Requires: []string{"steady"},
+		Restart:  OnFailure,
+		Backoff:  Backoff{Initial: time.Hour},
+		Work: func(p *Process) error {
+			return fmt.Errorf("oops")
+		},
+	})
+	s.Supervise(&Worker{
+		Name: "broken",
+		Work: func(p *Process) error {
+			<-p.Shutdown()
+			return fmt.Errorf("broken")
+		},
+	})
+
+	done := make(chan []error)
+	go func() {
+		done <- s.Run()
+	}()
+
+	expected := "[{steady running [] true 0 } {flaky restarting [steady] false 1 oops} {broken running [] false 0 }]"
+	deadline := time.Now().Add(10 * time.Second)
+	for fmt.Sprint(s.Status()) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s, got %v", expected, s.Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.Shutdown()
+	<-done
+	// the finished workers are listed in the order they exited in
+	states := make(map[string]bool)
+	for _, status := range s.Status() {
+		states[fmt.Sprint(status)] = true
+	}
+	for _, want := range []string{
+		"{flaky done [steady] false 1 oops}",
+		"{steady done [] false 0 }",
+		"{broken failed [] false 0 broken}",
+	} {
+		if !states[want] {
+			t.Errorf("expected %s in %v", want, s.Status())
+		}
+	}
+	if len(states) != 3 {
+		t.Errorf("expected 3 workers, got %v", s.Status())
+	}
+}
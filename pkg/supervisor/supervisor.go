@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
 	"os/exec"
 	"runtime/debug"
 	"strings"
@@ -12,19 +12,9 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/pkg/errors"
+	"github.com/datawire/teleproxy/pkg/clock"
 )
 
-type Logger interface {
-	Printf(format string, v ...interface{})
-}
-
-type DefaultLogger struct{}
-
-func (d *DefaultLogger) Printf(format string, v ...interface{}) {
-	log.Printf(format, v...)
-}
-
 // A supervisor provides an abstraction for managing a group of
 // related goroutines, and provides:
 //
@@ -41,8 +31,75 @@ type Supervisor struct {
 	wantsShutdown bool               // signals we are in shutdown mode
 	names         []string           // list of worker names in order added
 	workers       map[string]*Worker // keyed by worker name
+	finished      []*Worker          // the workers that are done, most recent last, see Status
+	metrics       *metrics           // see Register
 	errors        []error
-	Logger        Logger
+	// Logger receives the log entries of the supervisor and its
+	// workers, it is a DefaultLogger unless set.
+	Logger Logger
+	// ShutdownGrace, if set, is how long workers have to exit once
+	// they are told to shut down, unless they set their own. A
+	// worker that takes longer is abandoned and reported by Run
+	// with a StuckError.
+	ShutdownGrace time.Duration
+	// OnCrash, if set, is called with the details of every panic
+	// of a worker or of a function run with Process.Do, e.g. to
+	// write a crash report. The worker is treated as failed
+	// regardless. It is called from the panicking worker's
+	// goroutine before the supervisor learns about the failure.
+	OnCrash func(*PanicError)
+	// Strategy says whether a worker that is restarted is
+	// restarted alone, or along with all the other workers, see
+	// AsWorker.
+	Strategy Strategy
+	// Clock is what the supervisor and its workers tell the time
+	// and wait with, for restart backoffs, shutdown grace periods,
+	// heartbeats and schedules. It is clock.Real unless set, tests
+	// can set a clock.Fake to control time.
+	Clock clock.Clock
+}
+
+// PanicError is the error of a worker whose Work panicked, or of a
+// function run with Process.Do that panicked. It carries what the
+// panic was called with and the stack of the panicking goroutine.
+type PanicError struct {
+	Time   time.Time   `json:"time"`
+	Worker string      `json:"worker"`
+	Value  interface{} `json:"value"`
+	Stack  string      `json:"stack"`
+	// what panicked, "WORKER" or "FUNCTION"
+	what string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s PANICKED: %v\n%s", e.what, e.Value, e.Stack)
+}
+
+// recovered returns the PanicError of a recovered panic and reports
+// it to the OnCrash hook, if any.
+func (s *Supervisor) recovered(worker *Worker, what string, r interface{}) *PanicError {
+	err := &PanicError{
+		Time:   s.Clock.Now(),
+		Worker: worker.Name,
+		Value:  r,
+		Stack:  string(debug.Stack()),
+		what:   what,
+	}
+	if s.OnCrash != nil {
+		s.OnCrash(err)
+	}
+	return err
+}
+
+// StuckError is the cause of the WorkerError Run reports for each
+// worker that didn't exit within its shutdown grace period.
+type StuckError struct {
+	Worker string
+	Grace  time.Duration
+}
+
+func (e *StuckError) Error() string {
+	return fmt.Sprintf("did not shut down within %s", e.Grace)
 }
 
 func Run(name string, f func(*Process) error) []error {
@@ -65,7 +122,9 @@ func WithContext(ctx context.Context) *Supervisor {
 		changed: sync.NewCond(mu),
 		context: ctx,
 		workers: make(map[string]*Worker),
+		metrics: newMetrics(),
 		Logger:  &DefaultLogger{},
+		Clock:   clock.Real,
 	}
 }
 
@@ -73,14 +132,93 @@ type Worker struct {
 	Name          string               // the name of the worker
 	Work          func(*Process) error // the function to perform the work
 	Requires      []string             // a list of required worker names
-	Retry         bool                 // whether or not to retry on error
+	Retry         bool                 // whether or not to retry on error, the same as Restart: OnFailure
+	Restart       RestartPolicy        // when to restart the worker once Work returns
+	Backoff       Backoff              // how long to wait before restarting the worker
+	ShutdownGrace time.Duration        // how long the worker has to exit once told to shut down, see Supervisor.ShutdownGrace
+	Heartbeat     time.Duration        // if set, how often the worker has to call Process.Alive, see SilentError
 	wantsShutdown bool                 // true if the worker wants to shut down
 	done          bool
 	supervisor    *Supervisor //
 	children      int64       // atomic counter for naming children
 	process       *Process    // nil if the worker is not currently running
 	error         error
-	retryDelay    time.Duration // how long to wait to retry
+	retryDelay    time.Duration // how long to wait to retry, before jitter
+	restarts      int           // how often the worker was restarted
+	lastError     error         // the error the worker last exited with, see Status
+	restartAll    bool          // true while the worker is shut down to be restarted with the others, see RestartAll
+}
+
+// RestartPolicy says whether a worker is started again once its Work
+// returns.
+type RestartPolicy int
+
+const (
+	// Never restarts the worker, if it fails the supervisor shuts
+	// down. This is the default unless Retry is set.
+	Never RestartPolicy = iota
+	// OnFailure restarts the worker when Work returns an error or
+	// panics, but not when it returns normally.
+	OnFailure
+	// Always restarts the worker whenever Work returns, until the
+	// worker or the supervisor is shut down.
+	Always
+)
+
+func (w *Worker) restartPolicy() RestartPolicy {
+	if w.Restart == Never && w.Retry {
+		return OnFailure
+	}
+	return w.Restart
+}
+
+// Backoff is how long a worker waits before it is restarted. The
+// first restart waits Initial, every further one Factor times as long
+// as the one before, up to Max. Each wait is varied randomly by up to
+// Jitter times itself, e.g. 0.2 for 20%, so that workers that fail
+// together don't all retry at the same time. Once a worker has run for
+// longer than Max, the next restart waits Initial again. The zero
+// value of each field means the default: 100ms, 3s, a factor of 2 and
+// no jitter.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	Jitter  float64
+}
+
+func (b Backoff) max() time.Duration {
+	if b.Max > 0 {
+		return b.Max
+	}
+	return 3 * time.Second
+}
+
+// next returns the delay after the given one, before jitter.
+func (b Backoff) next(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		if b.Initial > 0 {
+			return b.Initial
+		}
+		return 100 * time.Millisecond
+	}
+	factor := b.Factor
+	if factor <= 0 {
+		factor = 2
+	}
+	delay = time.Duration(float64(delay) * factor)
+	if delay > b.max() {
+		return b.max()
+	}
+	return delay
+}
+
+// jitter varies the delay randomly by up to Jitter times itself.
+func (b Backoff) jitter(delay time.Duration) time.Duration {
+	if b.Jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	return delay + time.Duration(float64(delay)*b.Jitter*(2*rand.Float64()-1))
 }
 
 func (w *Worker) Error() string {
@@ -101,6 +239,13 @@ func (w *Worker) Wait() {
 	}
 }
 
+// Supervise adds a worker to the supervisor. A worker is started once
+// all the workers it requires are running and have signaled that they
+// are ready, see Process.Ready, and it is shut down before any of
+// them. The required workers may be added later on, but requirements
+// may not form a cycle. Workers may be added before or while Run runs,
+// and removed again with Remove, so a worker can supervise a changing
+// set of child workers, e.g. one per watch.
 func (s *Supervisor) Supervise(worker *Worker) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -108,12 +253,36 @@ func (s *Supervisor) Supervise(worker *Worker) {
 	if exists {
 		panic(fmt.Sprintf("worker already exists: %s", worker.Name))
 	}
+	if cycle := s.cycle(worker, worker.Name, nil); cycle != nil {
+		panic(fmt.Sprintf("worker requirements form a cycle: %s", strings.Join(cycle, " -> ")))
+	}
 	s.workers[worker.Name] = worker
+	s.metrics.added(worker)
 	worker.supervisor = s
 	s.names = append(s.names, worker.Name)
 	s.changed.Broadcast()
 }
 
+// cycle returns the chain of requirements that leads from the worker
+// back to the named one, if any. This assumes that s.mutex is already
+// held.
+func (s *Supervisor) cycle(worker *Worker, name string, path []string) []string {
+	path = append(path, worker.Name)
+	for _, r := range worker.Requires {
+		if r == name {
+			return append(path, r)
+		}
+		required := s.workers[r]
+		if required == nil {
+			continue
+		}
+		if cycle := s.cycle(required, name, path); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
 // this assumes that s.mutex is already held
 func (s *Supervisor) remove(worker *Worker) {
 	delete(s.workers, worker.Name)
@@ -139,16 +308,24 @@ func (s *Supervisor) remove(worker *Worker) {
 // A normal exit does not trigger any special action other than
 // causing Run to return if it is the last worker.
 //
-// If a worker exits with an error, the behavior depends on the value
-// of the Retry flag on the worker. If Retry is true, the worker will
-// be restarted. If not the supervisor shutdown sequence is triggerd.
+// If a worker exits with an error, the behavior depends on the
+// restart policy of the worker. If it is OnFailure or Always, or the
+// Retry flag is set, the worker will be restarted after a backoff
+// delay, see Backoff. If not the supervisor shutdown sequence is
+// triggerd. Workers whose policy is Always are restarted after a
+// normal exit as well.
 //
 // The supervisor shutdown sequence can be deliberately triggered by
 // invoking supervisor.Shutdown(). This can be done from any goroutine
 // including workers.
 //
 // The graceful shutdown sequence shuts down workers in an order that
-// respects worker dependencies.
+// respects worker dependencies. Workers that don't exit within their
+// shutdown grace period, if there is one, are abandoned and reported
+// with a StuckError, see Supervisor.ShutdownGrace.
+//
+// Run returns a *WorkerError for every worker that failed, in the
+// order they failed in.
 func (s *Supervisor) Run() []error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -186,6 +363,19 @@ func (s *Supervisor) Get(name string) *Worker {
 	return s.workers[name]
 }
 
+// Remove shuts down the named worker, see Worker.Shutdown, and waits
+// for it to exit. It returns false if there is no such worker. Since
+// only Run shuts workers down, Remove blocks until Run is invoked.
+func (s *Supervisor) Remove(name string) bool {
+	worker := s.Get(name)
+	if worker == nil {
+		return false
+	}
+	worker.Shutdown()
+	worker.Wait()
+	return true
+}
+
 // Shuts down the worker. Note that if the worker has other workers
 // that depend on it, the shutdown won't actually be initiated until
 // those dependent workers exit.
@@ -217,7 +407,15 @@ func (s *Supervisor) reconcile() {
 	// XXX: added this for debugging shutdown stalls, need a better way to
 	// log this that doesn't add so much noise normally
 	//
-	//s.Logger.Printf("WORKERS: %v", s.names)
+	//s.log(DebugLevel, nil, fmt.Sprintf("WORKERS: %v", s.names), nil)
+
+	// workers that are restarted together are started again once
+	// all of them have exited
+	if s.restartedAll() {
+		for _, w := range s.workers {
+			w.restartAll = false
+		}
+	}
 
 	var cleanup []string
 	for _, n := range s.names {
@@ -241,20 +439,28 @@ func (w *Worker) shuttingDown() bool {
 // returns true if the worker is done and should be removed from the supervisor
 func (w *Worker) reconcile() bool {
 	s := w.supervisor
-	if w.shuttingDown() {
+	if w.shuttingDown() || w.restartAll {
 		if w.process != nil && !w.process.shutdownClosed {
 			for _, d := range s.dependents(w) {
 				if s.workers[d.Name].process != nil {
 					return false
 				}
 			}
-			s.Logger.Printf("%s: signaling shutdown", w.Name)
+			s.log(InfoLevel, w, "signaling shutdown", nil)
 			close(w.process.shutdown)
 			w.process.shutdownClosed = true
+			if grace := w.shutdownGrace(); grace > 0 {
+				process := w.process
+				s.Clock.AfterFunc(grace, func() {
+					s.mutex.Lock()
+					defer s.mutex.Unlock()
+					s.abandon(process, grace)
+				})
+			}
 		}
-		if w.process == nil {
+		if w.process == nil && w.shuttingDown() {
 			if !w.done {
-				w.done = true
+				s.finish(w)
 				s.changed.Broadcast()
 			}
 			return true
@@ -271,7 +477,7 @@ func (w *Worker) reconcile() bool {
 					return false
 				}
 			}
-			s.Logger.Printf("%s: starting", w.Name)
+			s.log(InfoLevel, w, "starting", nil)
 			s.launch(w)
 		}
 
@@ -279,15 +485,29 @@ func (w *Worker) reconcile() bool {
 	return false
 }
 
-func nextDelay(delay time.Duration) time.Duration {
-	switch {
-	case delay <= 0:
-		return 100 * time.Millisecond
-	case delay < 3*time.Second:
-		return delay * 2
-	default:
-		return 3 * time.Second
+func (w *Worker) shutdownGrace() time.Duration {
+	if w.ShutdownGrace > 0 {
+		return w.ShutdownGrace
 	}
+	return w.supervisor.ShutdownGrace
+}
+
+// abandon gives up on a process that is still running after its
+// shutdown grace period, so that the workers it requires can shut
+// down and Run can return. This assumes that s.mutex is already held.
+func (s *Supervisor) abandon(process *Process, grace time.Duration) {
+	worker := process.worker
+	if worker.process != process {
+		return
+	}
+	s.log(ErrorLevel, worker, fmt.Sprintf("did not shut down within %s, abandoning it", grace), nil)
+	process.abandoned = true
+	worker.process = nil
+	s.remove(worker)
+	worker.lastError = &StuckError{Worker: worker.Name, Grace: grace}
+	s.failed(worker, worker.lastError)
+	s.finish(worker)
+	s.changed.Broadcast()
 }
 
 func (s *Supervisor) launch(worker *Worker) {
@@ -297,53 +517,119 @@ func (s *Supervisor) launch(worker *Worker) {
 		shutdown:   make(chan struct{}),
 	}
 	worker.process = process
+	delay := worker.Backoff.jitter(worker.retryDelay)
 	go func() {
 		var err error
+		var started time.Time
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
-					stack := string(debug.Stack())
-					err = errors.Errorf("WORKER PANICKED: %v\n%s", r, stack)
+					err = s.recovered(worker, "WORKER", r)
 				}
 			}()
-			time.Sleep(worker.retryDelay)
+			if delay > 0 {
+				select {
+				case <-s.Clock.After(delay):
+				case <-process.shutdown:
+					// shut down while waiting to restart
+					return
+				}
+			}
+			started = s.Clock.Now()
+			s.mutex.Lock()
+			process.running = true
+			process.started = started
+			if worker.Heartbeat > 0 {
+				process.Alive()
+				s.Clock.AfterFunc(worker.Heartbeat, func() {
+					s.mutex.Lock()
+					defer s.mutex.Unlock()
+					s.checkHeartbeat(process)
+				})
+			}
+			s.mutex.Unlock()
 			err = worker.Work(process)
 		}()
 		s.mutex.Lock()
 		defer s.mutex.Unlock()
-		worker.process = nil
-		if err != nil {
-			process.Log(err)
-			if worker.Retry {
-				if worker.shuttingDown() {
-					s.remove(worker)
-					worker.done = true
-				} else {
-					worker.retryDelay = nextDelay(worker.retryDelay)
-					process.Logf("retrying after %s...", worker.retryDelay.String())
-				}
+		if !started.IsZero() {
+			s.observeWork(worker, s.Clock.Now().Sub(started))
+		}
+		if process.abandoned {
+			s.log(WarnLevel, worker, fmt.Sprintf("exited after it was abandoned: %v", err), nil)
+			return
+		}
+		s.exited(process, err)
+	}()
+}
+
+// exited restarts or removes the worker once its process has exited
+// with the given error, or was given up on. This assumes that s.mutex
+// is already held.
+func (s *Supervisor) exited(process *Process, err error) {
+	worker := process.worker
+	started := process.started
+	worker.process = nil
+	if err != nil {
+		s.log(ErrorLevel, worker, err.Error(), nil)
+		worker.lastError = err
+	}
+	if worker.restartAll && !worker.shuttingDown() {
+		// shut down to be restarted along with the others
+		s.restarted(worker)
+		s.changed.Broadcast()
+		return
+	}
+	policy := worker.restartPolicy()
+	if policy == Always || (err != nil && policy == OnFailure) {
+		if worker.shuttingDown() {
+			s.remove(worker)
+			s.finish(worker)
+		} else {
+			if s.Strategy == OneForAll {
+				s.restartAllWorkers()
+			}
+			s.restarted(worker)
+			if !started.IsZero() && s.Clock.Now().Sub(started) > worker.Backoff.max() {
+				worker.retryDelay = 0
+			}
+			worker.retryDelay = worker.Backoff.next(worker.retryDelay)
+			if err != nil {
+				process.Logf("retrying after %s...", worker.retryDelay.String())
 			} else {
-				s.remove(worker)
-				worker.error = err
-				s.errors = append(s.errors, worker)
-				s.wantsShutdown = true
-				worker.done = true
+				process.Logf("restarting after %s...", worker.retryDelay.String())
 			}
-		} else {
-			s.remove(worker)
-			worker.done = true
 		}
-		s.changed.Broadcast()
-	}()
+	} else if err != nil {
+		s.remove(worker)
+		worker.error = err
+		s.failed(worker, err)
+		s.wantsShutdown = true
+		s.finish(worker)
+	} else {
+		s.remove(worker)
+		s.finish(worker)
+	}
+	s.changed.Broadcast()
 }
 
 type Process struct {
+	// alive is when the worker last called Alive, in unix nanos,
+	// it comes first so that it is aligned for atomic access
+	alive      int64
 	supervisor *Supervisor
 	worker     *Worker
 	// Used to signal graceful shutdown.
 	shutdown       chan struct{}
 	ready          bool
 	shutdownClosed bool
+	// running is set once Work is invoked, until then the process
+	// waits to be restarted
+	running bool
+	started time.Time
+	// abandoned is set once the process is given up on, see
+	// Supervisor.ShutdownGrace
+	abandoned bool
 }
 
 func (p *Process) Supervisor() *Supervisor {
@@ -371,13 +657,20 @@ func (p *Process) Shutdown() <-chan struct{} {
 	return p.shutdown
 }
 
-// Used for logging...
+// Used for logging... Log and Logf write info level entries about
+// the worker, see Logger.
 func (p *Process) Log(obj interface{}) {
-	p.supervisor.Logger.Printf("%s: %v", p.Worker().Name, obj)
+	p.supervisor.log(InfoLevel, p.Worker(), fmt.Sprint(obj), nil)
 }
 
 func (p *Process) Logf(format string, args ...interface{}) {
-	p.supervisor.Logger.Printf("%s: %v", p.Worker().Name, fmt.Sprintf(format, args...))
+	p.supervisor.log(InfoLevel, p.Worker(), fmt.Sprintf(format, args...), nil)
+}
+
+// LogWith writes a log entry about the worker with the given level and
+// fields, e.g. p.LogWith(WarnLevel, "slow response", Fields{"url": url}).
+func (p *Process) LogWith(level Level, msg string, fields Fields) {
+	p.supervisor.log(level, p.Worker(), msg, fields)
 }
 
 func (p *Process) allocateId() int64 {
@@ -405,11 +698,11 @@ func (p *Process) Do(fn func()) bool {
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				stack := string(debug.Stack())
-				err := errors.Errorf("FUNCTION PANICKED: %v\n%s", r, stack)
+				err := sup.recovered(p.Worker(), "FUNCTION", r)
 				sup.mutex.Lock()
-				sup.errors = append(sup.errors, err)
+				sup.failed(p.Worker(), err)
 				sup.wantsShutdown = true
+				sup.changed.Broadcast()
 				sup.mutex.Unlock()
 			}
 			close(done)
@@ -6,6 +6,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/datawire/teleproxy/pkg/clock"
 )
 
 const (
@@ -162,8 +164,8 @@ func TestErrorExit(t *testing.T) {
 		t.Fail()
 	}
 	for _, err := range errors {
-		wrk := err.(*Worker)
-		if !strings.HasPrefix(err.Error(), fmt.Sprintf("%s: boo-", wrk.Name)) {
+		wrk := err.(*WorkerError)
+		if !strings.HasPrefix(err.Error(), fmt.Sprintf("%s: boo-", wrk.Worker)) {
 			t.Fail()
 		}
 	}
@@ -194,8 +196,8 @@ func TestPanicExit(t *testing.T) {
 		t.Fail()
 	}
 	for _, err := range errors {
-		wrk := err.(*Worker)
-		if !strings.HasPrefix(err.Error(), fmt.Sprintf("%s: WORKER PANICKED: boo-", wrk.Name)) {
+		wrk := err.(*WorkerError)
+		if !strings.HasPrefix(err.Error(), fmt.Sprintf("%s: WORKER PANICKED: boo-", wrk.Worker)) {
 			t.Errorf("unexpected error: %v", err)
 		}
 	}
@@ -252,6 +254,21 @@ func TestDependencyPanic(t *testing.T) {
 	}
 }
 
+func TestDependencyCycle(t *testing.T) {
+	s := WithContext(context.Background())
+	work := func(p *Process) error { return nil }
+	s.Supervise(&Worker{Name: "a", Requires: []string{"b"}, Work: work})
+	s.Supervise(&Worker{Name: "b", Requires: []string{"c"}, Work: work})
+
+	defer func() {
+		r := recover()
+		if r != "worker requirements form a cycle: c -> a -> b -> c" {
+			t.Errorf("unexpected panic: %v", r)
+		}
+	}()
+	s.Supervise(&Worker{Name: "c", Requires: []string{"a"}, Work: work})
+}
+
 func TestShutdownOnError(t *testing.T) {
 	r := newRoot()
 	s := WithContext(context.Background())
@@ -286,6 +303,35 @@ func TestShutdownOnPanic(t *testing.T) {
 	}
 }
 
+func TestOnCrash(t *testing.T) {
+	s := WithContext(context.Background())
+	var crashes []*PanicError
+	s.OnCrash = func(err *PanicError) {
+		crashes = append(crashes, err)
+	}
+	s.Supervise(&Worker{
+		Name: "buggy",
+		Work: func(p *Process) error {
+			p.Do(func() { panic("in do") })
+			panic("in work")
+		},
+	})
+	errors := s.Run()
+	if len(crashes) != 2 {
+		t.Fatalf("expected 2 crashes, got %d", len(crashes))
+	}
+	for i, value := range []string{"in do", "in work"} {
+		crash := crashes[i]
+		if crash.Worker != "buggy" || crash.Value != value || !strings.Contains(crash.Stack, "TestOnCrash") {
+			t.Errorf("unexpected crash: %v", crash)
+		}
+	}
+	if len(errors) != 2 || !strings.HasPrefix(errors[0].Error(), "buggy: FUNCTION PANICKED: in do") ||
+		errors[0].(*WorkerError).Err != crashes[0] || errors[1].(*WorkerError).Err != crashes[1] {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
 func TestShutdown(t *testing.T) {
 	r := newRoot()
 	s := WithContext(context.Background())
@@ -310,6 +356,46 @@ func TestShutdown(t *testing.T) {
 // necessarily needs to be... it's actually checking that we run the
 // worker and then cancel, whereas if cancel happens prior to Run()
 // being called, I'm guessing we might want to turn Run into a noop.
+func TestShutdownGrace(t *testing.T) {
+	s := WithContext(context.Background())
+	s.ShutdownGrace = 10 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	s.Supervise(&Worker{
+		Name: "stuck",
+		Work: func(p *Process) error {
+			p.Ready()
+			<-p.Shutdown()
+			<-release
+			return nil
+		},
+	})
+	s.Supervise(&Worker{
+		Name:          "slow",
+		ShutdownGrace: time.Second,
+		Work: func(p *Process) error {
+			<-p.Shutdown()
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		},
+	})
+	s.Supervise(&Worker{
+		Name:     "dependent",
+		Requires: []string{"stuck"},
+		Work: func(p *Process) error {
+			p.Supervisor().Shutdown()
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 1 || errors[0].Error() != "stuck: did not shut down within 10ms" {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if stuck, ok := errors[0].(*WorkerError).Err.(*StuckError); !ok || stuck.Worker != "stuck" {
+		t.Errorf("expected a StuckError, got %v", errors[0])
+	}
+}
+
 func TestCancelPreRun(t *testing.T) {
 	r := newRoot()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -415,6 +501,121 @@ func TestRetry(t *testing.T) {
 	}
 }
 
+func TestRestartAlways(t *testing.T) {
+	s := WithContext(context.Background())
+	N := 3
+	count := 0
+	s.Supervise(&Worker{
+		Name:    "restarted",
+		Restart: Always,
+		Backoff: Backoff{Initial: time.Millisecond},
+		Work: func(p *Process) error {
+			count++
+			if count == N {
+				p.Supervisor().Shutdown()
+			}
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if count != N {
+		t.Errorf("unexpected count: %d", count)
+	}
+}
+
+func TestRestartOnFailure(t *testing.T) {
+	s := WithContext(context.Background())
+	count := 0
+	s.Supervise(&Worker{
+		Name:    "flaky",
+		Restart: OnFailure,
+		Backoff: Backoff{Initial: time.Millisecond},
+		Work: func(p *Process) error {
+			count++
+			if count < 3 {
+				return fmt.Errorf("oops")
+			}
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if count != 3 {
+		t.Errorf("unexpected count: %d", count)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second, Factor: 3}
+	var delays []time.Duration
+	delay := time.Duration(0)
+	for i := 0; i < 4; i++ {
+		delay = b.next(delay)
+		delays = append(delays, delay)
+	}
+	expected := []time.Duration{time.Second, 3 * time.Second, 5 * time.Second, 5 * time.Second}
+	if fmt.Sprint(delays) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, delays)
+	}
+
+	var defaults Backoff
+	if d := defaults.next(0); d != 100*time.Millisecond {
+		t.Errorf("unexpected default initial delay: %v", d)
+	}
+	if d := defaults.next(2 * time.Second); d != 3*time.Second {
+		t.Errorf("unexpected default max delay: %v", d)
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.jitter(time.Second)
+		if d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("jittered delay out of range: %v", d)
+		}
+	}
+}
+
+func TestBackoffClock(t *testing.T) {
+	s := WithContext(context.Background())
+	fake := clock.NewFake(time.Unix(0, 0))
+	s.Clock = fake
+	attempts := 0
+	s.Supervise(&Worker{
+		Name:    "flaky",
+		Restart: OnFailure,
+		Backoff: Backoff{Initial: time.Minute, Max: time.Hour, Factor: 2},
+		Work: func(p *Process) error {
+			attempts++
+			if attempts < 3 {
+				return fmt.Errorf("oops")
+			}
+			return nil
+		},
+	})
+	done := make(chan []error)
+	go func() {
+		done <- s.Run()
+	}()
+	for _, delay := range []time.Duration{time.Minute, 2 * time.Minute} {
+		fake.BlockUntil(1)
+		fake.Advance(delay)
+	}
+	if errs := <-done; len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if attempts != 3 {
+		t.Errorf("unexpected attempts: %d", attempts)
+	}
+	if now := fake.Now(); !now.Equal(time.Unix(0, 0).Add(3 * time.Minute)) {
+		t.Errorf("unexpected time: %v", now)
+	}
+}
+
 func TestGo(t *testing.T) {
 	r := newRoot()
 	s := WithContext(context.Background())
@@ -545,6 +746,42 @@ func TestSuperviseAfterRun(t *testing.T) {
 	}
 }
 
+func TestRemove(t *testing.T) {
+	s := WithContext(context.Background())
+	var removed, kept *Worker
+	s.Supervise(&Worker{
+		Name: "parent",
+		Work: func(p *Process) error {
+			for _, name := range []string{"child:a", "child:b"} {
+				p.Supervisor().Supervise(&Worker{
+					Name: name,
+					Work: func(p *Process) error {
+						<-p.Shutdown()
+						return nil
+					},
+				})
+			}
+			if !p.Supervisor().Remove("child:a") {
+				t.Errorf("child:a not removed")
+			}
+			if p.Supervisor().Remove("child:c") {
+				t.Errorf("child:c removed")
+			}
+			removed = p.Supervisor().Get("child:a")
+			kept = p.Supervisor().Get("child:b")
+			p.Supervisor().Shutdown()
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if removed != nil || kept == nil {
+		t.Errorf("unexpected workers: %v, %v", removed, kept)
+	}
+}
+
 func TestWorkerWait(t *testing.T) {
 	s := WithContext(context.Background())
 	exit := make(chan struct{})
@@ -0,0 +1,107 @@
+package supervisor
+
+import (
+	"strings"
+)
+
+// Strategy says which workers are restarted when one of them is
+// restarted, see RestartPolicy.
+type Strategy int
+
+const (
+	// OneForOne restarts just the worker. This is the default.
+	OneForOne Strategy = iota
+	// OneForAll shuts down all the workers, in an order that
+	// respects their requirements, and starts all of them again
+	// once they have exited, e.g. for workers that share a
+	// connection and have to start over together.
+	OneForAll
+)
+
+// RestartAll shuts down all the workers and starts them again once
+// they have exited, as if one of them was restarted by a supervisor
+// whose Strategy is OneForAll. Workers added in the meantime are left
+// alone.
+func (s *Supervisor) RestartAll() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.restartAllWorkers()
+}
+
+// this assumes that s.mutex is already held
+func (s *Supervisor) restartAllWorkers() {
+	s.log(InfoLevel, nil, "restarting all workers", nil)
+	for _, w := range s.workers {
+		w.restartAll = true
+	}
+	s.changed.Broadcast()
+}
+
+// restartedAll returns whether all of the workers that are restarted
+// together have exited. This assumes that s.mutex is already held.
+func (s *Supervisor) restartedAll() bool {
+	restarting := false
+	for _, w := range s.workers {
+		if w.restartAll {
+			if w.process != nil {
+				return false
+			}
+			restarting = true
+		}
+	}
+	return restarting
+}
+
+// SubtreeError is the error of a worker that runs a supervisor, see
+// AsWorker. It holds the errors the supervisor's Run returned.
+type SubtreeError struct {
+	Errors []error
+}
+
+// Unwrap returns the errors of the subtree, for errors.Is and
+// errors.As.
+func (e *SubtreeError) Unwrap() []error {
+	return e.Errors
+}
+
+func (e *SubtreeError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// AsWorker returns a worker that runs the supervisor as a subtree of
+// the one it is added to. The subtree restarts its workers according
+// to its own Strategy, shuts down when the worker is shut down and
+// makes the worker fail with a SubtreeError if it fails itself.
+//
+// The subtree keeps running while it has no workers, so that workers
+// can be added to it at any time, until the worker is shut down.
+// Since a supervisor only runs once, the worker must not be
+// restarted.
+func (s *Supervisor) AsWorker(name string) *Worker {
+	return &Worker{
+		Name: name,
+		Work: func(p *Process) error {
+			s.Supervise(&Worker{
+				Name: name,
+				Work: func(sp *Process) error {
+					sp.Ready()
+					select {
+					case <-sp.Shutdown():
+					case <-p.Shutdown():
+						s.Shutdown()
+					}
+					return nil
+				},
+			})
+			p.Ready()
+			if errs := s.Run(); len(errs) > 0 {
+				return &SubtreeError{Errors: errs}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOneForAll(t *testing.T) {
+	s := WithContext(context.Background())
+	s.Strategy = OneForAll
+	var started, failed int32
+	s.Supervise(&Worker{
+		Name: "steady",
+		Work: func(p *Process) error {
+			if atomic.AddInt32(&started, 1) == 2 {
+				s.Shutdown()
+			}
+			p.Ready()
+			<-p.Shutdown()
+			return nil
+		},
+	})
+	s.Supervise(&Worker{
+		Name:     "flaky",
+		Requires: []string{"steady"},
+		Restart:  OnFailure,
+		Backoff:  Backoff{Initial: 1},
+		Work: func(p *Process) error {
+			if atomic.AddInt32(&failed, 1) == 1 {
+				return fmt.Errorf("oops")
+			}
+			<-p.Shutdown()
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if started != 2 {
+		t.Errorf("steady started %d times", started)
+	}
+}
+
+func TestAsWorker(t *testing.T) {
+	s := WithContext(context.Background())
+	tree := WithContext(context.Background())
+	s.Supervise(tree.AsWorker("tree"))
+	s.Supervise(&Worker{
+		Name:     "parent",
+		Requires: []string{"tree"},
+		Work: func(p *Process) error {
+			done := make(chan struct{})
+			tree.Supervise(&Worker{
+				Name: "child",
+				Work: func(p *Process) error {
+					close(done)
+					<-p.Shutdown()
+					return nil
+				},
+			})
+			<-done
+			s.Shutdown()
+			return nil
+		},
+	})
+	errors := s.Run()
+	if len(errors) != 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+	if tree.Get("child") != nil {
+		t.Errorf("child not shut down")
+	}
+}
+
+func TestAsWorkerError(t *testing.T) {
+	s := WithContext(context.Background())
+	tree := WithContext(context.Background())
+	tree.Supervise(&Worker{
+		Name: "child",
+		Work: func(p *Process) error {
+			return fmt.Errorf("oops")
+		},
+	})
+	s.Supervise(tree.AsWorker("tree"))
+	errors := s.Run()
+	if len(errors) != 1 || errors[0].Error() != "tree: child: oops" {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
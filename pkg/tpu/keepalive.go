@@ -2,8 +2,12 @@ package tpu
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os/exec"
 	"strings"
 	"syscall"
@@ -16,16 +20,31 @@ type Keeper struct {
 	Input   string
 	Inspect string
 	Limit   int
-	stop    chan empty
-	done    chan empty
+	// HealthCheck is an http://, https:// or tcp:// url that is
+	// checked every HealthInterval while the command runs. The
+	// command is restarted after HealthFailures checks in a row
+	// fail, just as if it had exited.
+	HealthCheck    string
+	HealthInterval time.Duration
+	HealthFailures int
+	// Timestamps prefixes every line of output with the time it was
+	// read.
+	Timestamps bool
+	// Err is the error the command last exited with, if any. It is
+	// safe to read once Wait returns, unless the keeper was stopped.
+	Err  error
+	stop chan empty
+	done chan empty
 }
 
 func NewKeeper(prefix, command string) (k *Keeper) {
 	return &Keeper{
-		Prefix:  prefix,
-		Command: command,
-		stop:    make(chan empty),
-		done:    make(chan empty),
+		Prefix:         prefix,
+		Command:        command,
+		HealthInterval: 10 * time.Second,
+		HealthFailures: 3,
+		stop:           make(chan empty),
+		done:           make(chan empty),
 	}
 }
 
@@ -64,39 +83,113 @@ func (k *Keeper) Start() {
 				panic(err)
 			}
 
-			died := make(chan empty)
+			died := make(chan empty, 1)
 			go func() {
 				err = cmd.Wait()
+				k.Err = err
 				if err != nil {
 					k.log("%s", err.Error())
 				}
 				died <- nil
 			}()
 
+			healthy := make(chan empty)
+			unhealthy := k.checkHealth(healthy)
+
 			count += 1
 
 			select {
 			case <-died:
-				l.Wait()
-				if count < k.Limit || k.Limit == 0 {
-					k.log("%s restarting...", strings.Fields(k.Command)[0])
-					ShellLog(k.Inspect, func(line string) {
-						k.log("%s", line)
-					})
-					time.Sleep(time.Second)
-				} else {
-					return
-				}
+				close(healthy)
+			case <-unhealthy:
+				k.log("%s failed health check", strings.Fields(k.Command)[0])
+				// kill the whole process group, not just the shell
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+				<-died
 			case <-k.stop:
+				close(healthy)
 				cmd.Process.Kill()
 				l.Wait()
 				return
 			}
 
+			l.Wait()
+			if count < k.Limit || k.Limit == 0 {
+				k.log("%s restarting...", strings.Fields(k.Command)[0])
+				ShellLog(k.Inspect, func(line string) {
+					k.log("%s", line)
+				})
+				time.Sleep(time.Second)
+			} else {
+				return
+			}
 		}
 	}()
 }
 
+// checkHealth checks the health of the command until done is closed.
+// The returned channel is closed once HealthFailures checks in a row
+// have failed. Without a HealthCheck nothing is checked and the
+// returned channel is never closed.
+func (k *Keeper) checkHealth(done chan empty) chan empty {
+	unhealthy := make(chan empty)
+	if k.HealthCheck == "" {
+		return unhealthy
+	}
+	go func() {
+		failures := 0
+		ticker := time.NewTicker(k.HealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := k.check()
+				if err == nil {
+					failures = 0
+					continue
+				}
+				failures += 1
+				k.log("health check %d/%d failed: %v", failures, k.HealthFailures, err)
+				if failures >= k.HealthFailures {
+					close(unhealthy)
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return unhealthy
+}
+
+func (k *Keeper) check() error {
+	u, err := url.Parse(k.HealthCheck)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		client := http.Client{Timeout: k.HealthInterval}
+		resp, err := client.Get(k.HealthCheck)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%s: %s", k.HealthCheck, resp.Status)
+		}
+		return nil
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", u.Host, k.HealthInterval)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		return fmt.Errorf("%s: unsupported health check", k.HealthCheck)
+	}
+}
+
 func (k *Keeper) forwardOutput(cmd *exec.Cmd) Latch {
 	pipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -132,7 +225,7 @@ func (k *Keeper) reader(pipe io.ReadCloser, l Latch) {
 		line, err := buf.ReadString('\n')
 		if err != nil {
 			if strings.TrimSpace(line) != "" {
-				k.log("%s", line)
+				k.output(line)
 			}
 			if err != io.EOF {
 				k.log("%s", err.Error())
@@ -140,7 +233,15 @@ func (k *Keeper) reader(pipe io.ReadCloser, l Latch) {
 			l.Notify()
 			return
 		} else {
-			k.log("%s", line)
+			k.output(line)
 		}
 	}
 }
+
+func (k *Keeper) output(line string) {
+	if k.Timestamps {
+		k.log("%s %s", time.Now().Format("15:04:05.000"), line)
+	} else {
+		k.log("%s", line)
+	}
+}
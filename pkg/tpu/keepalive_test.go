@@ -3,6 +3,8 @@ package tpu
 import (
 	"bytes"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -24,3 +26,52 @@ func TestKeepalive(t *testing.T) {
 		t.Errorf("incorrect number of lines: %v", 4)
 	}
 }
+
+func TestHealthCheck(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	k := NewKeeper("TST", "true")
+	for _, check := range []string{srv.URL, "tcp://" + srv.Listener.Addr().String()} {
+		k.HealthCheck = check
+		if err := k.check(); err != nil {
+			t.Errorf("%s: %v", check, err)
+		}
+	}
+
+	status = http.StatusInternalServerError
+	k.HealthCheck = srv.URL
+	if err := k.check(); err == nil {
+		t.Errorf("%s: expected failure", k.HealthCheck)
+	}
+
+	srv.Close()
+	k.HealthCheck = "tcp://" + srv.Listener.Addr().String()
+	if err := k.check(); err == nil {
+		t.Errorf("%s: expected failure", k.HealthCheck)
+	}
+}
+
+func TestHealthCheckRestart(t *testing.T) {
+	os.Remove("/tmp/health-lines")
+	k := NewKeeper("TST", "echo hi >> /tmp/health-lines; sleep 100")
+	k.HealthCheck = "tcp://127.0.0.1:1"
+	k.HealthInterval = 100 * time.Millisecond
+	k.HealthFailures = 2
+	k.Start()
+	time.Sleep(2500 * time.Millisecond)
+	k.Stop()
+	dat, err := ioutil.ReadFile("/tmp/health-lines")
+	if err != nil {
+		panic(err)
+	}
+	lines := bytes.Count(dat, []byte("\n"))
+	// the command never exits, so it must have been restarted
+	// because of the failing health check
+	if lines < 2 {
+		t.Errorf("incorrect number of lines: %v", lines)
+	}
+}
@@ -0,0 +1,151 @@
+package watt
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/datawire/teleproxy/pkg/k8s"
+)
+
+const SCHEMA_DRAFT = "http://json-schema.org/draft-07/schema#"
+
+// Schema returns a JSON Schema describing the snapshots that watt
+// delivers to its receivers. Every named type is described once under
+// "definitions" and referred to from there, which keeps the schema
+// usable for generating typed clients.
+func Schema() map[string]interface{} {
+	g := &schemaGenerator{definitions: make(map[string]interface{})}
+	root := g.object(reflect.TypeOf(Snapshot{}))
+	result := map[string]interface{}{
+		"$schema":     SCHEMA_DRAFT,
+		"title":       "Snapshot",
+		"definitions": g.definitions,
+	}
+	for k, v := range root {
+		result[k] = v
+	}
+	return result
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	resourceType = reflect.TypeOf(k8s.Resource{})
+)
+
+// resourceSchema describes a kubernetes resource. Only the fields all
+// resources have in common are spelled out, the rest depends on the
+// kind.
+var resourceSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"apiVersion": map[string]interface{}{"type": "string"},
+		"kind":       map[string]interface{}{"type": "string"},
+		"metadata": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name":            map[string]interface{}{"type": "string"},
+				"namespace":       map[string]interface{}{"type": "string"},
+				"resourceVersion": map[string]interface{}{"type": "string"},
+				"labels": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "string"},
+				},
+				"annotations": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "string"},
+				},
+			},
+		},
+	},
+	"required": []string{"apiVersion", "kind", "metadata"},
+}
+
+func (g *schemaGenerator) ref(name string, define func() map[string]interface{}) map[string]interface{} {
+	if _, ok := g.definitions[name]; !ok {
+		// reserve the name first so that recursive types terminate
+		g.definitions[name] = nil
+		g.definitions[name] = define()
+	}
+	return map[string]interface{}{"$ref": "#/definitions/" + name}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == resourceType:
+		return g.ref("Resource", func() map[string]interface{} { return resourceSchema })
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		return g.ref(t.Name(), func() map[string]interface{} { return g.object(t) })
+	default:
+		// interface{} and friends can be anything
+		return map[string]interface{}{}
+	}
+}
+
+// object describes a struct the way encoding/json marshals it.
+func (g *schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		omitempty := false
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitempty = true
+				}
+			}
+		}
+		properties[name] = g.schema(f.Type)
+		// encoding/json never omits a struct
+		if !omitempty || f.Type.Kind() == reflect.Struct {
+			required = append(required, name)
+		}
+	}
+	result := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		result["required"] = required
+	}
+	return result
+}
@@ -0,0 +1,36 @@
+package watt
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	definitions := schema["definitions"].(map[string]interface{})
+	for _, name := range []string{"ConsulSnapshot", "Endpoints", "Endpoint", "Resource"} {
+		if definitions[name] == nil {
+			t.Errorf("missing definition for %s", name)
+		}
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	kubernetes := properties["Kubernetes"].(map[string]interface{})
+	if kubernetes["type"] != "object" {
+		t.Errorf("unexpected schema for Kubernetes: %v", kubernetes)
+	}
+	items := kubernetes["additionalProperties"].(map[string]interface{})["items"].(map[string]interface{})
+	if items["$ref"] != "#/definitions/Resource" {
+		t.Errorf("unexpected schema for Kubernetes resources: %v", items)
+	}
+
+	endpoint := definitions["Endpoint"].(map[string]interface{})["properties"].(map[string]interface{})
+	if endpoint["Port"].(map[string]interface{})["type"] != "integer" {
+		t.Errorf("unexpected schema for Endpoint: %v", endpoint)
+	}
+}
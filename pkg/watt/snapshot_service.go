@@ -0,0 +1,138 @@
+package watt
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+// This is the Go side of the SnapshotService in
+// snapshot_service.proto. It is written by hand rather than generated,
+// the messages are encoded according to their protobuf struct tags,
+// which is all that the protobuf codec of grpc needs, so clients in
+// other languages can be generated from the .proto file.
+
+// WatchRequest is what a subscriber asks SnapshotService.Watch for.
+type WatchRequest struct {
+	// Delta asks for all but the first snapshot to be sent as JSON
+	// Patches (RFC 6902) against the snapshot sent before, like
+	// /snapshots/<id>/delta.
+	Delta bool `protobuf:"varint,1,opt,name=delta,proto3" json:"delta,omitempty"`
+}
+
+func (m *WatchRequest) Reset()         { *m = WatchRequest{} }
+func (m *WatchRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (*WatchRequest) ProtoMessage()    {}
+
+// SnapshotUpdate is a snapshot, or a patch to the snapshot sent
+// before, streamed by SnapshotService.Watch.
+type SnapshotUpdate struct {
+	// Id is the id the snapshot is served under at /snapshots/<id>.
+	Id int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Delta says whether Json is a JSON Patch rather than the
+	// snapshot.
+	Delta bool   `protobuf:"varint,2,opt,name=delta,proto3" json:"delta,omitempty"`
+	Json  []byte `protobuf:"bytes,3,opt,name=json,proto3" json:"json,omitempty"`
+}
+
+func (m *SnapshotUpdate) Reset()         { *m = SnapshotUpdate{} }
+func (m *SnapshotUpdate) String() string { return fmt.Sprintf("%+v", *m) }
+func (*SnapshotUpdate) ProtoMessage()    {}
+
+// SnapshotServiceServer is the server side of the SnapshotService.
+type SnapshotServiceServer interface {
+	Watch(*WatchRequest, WatchServer) error
+}
+
+// WatchServer is the stream a SnapshotServiceServer sends the
+// snapshots of a Watch on.
+type WatchServer interface {
+	Send(*SnapshotUpdate) error
+	grpc.ServerStream
+}
+
+type watchServer struct {
+	grpc.ServerStream
+}
+
+func (x *watchServer) Send(m *SnapshotUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// RegisterSnapshotServiceServer registers the implementation of the
+// SnapshotService with the grpc server.
+func RegisterSnapshotServiceServer(s *grpc.Server, srv SnapshotServiceServer) {
+	s.RegisterService(&snapshotServiceDesc, srv)
+}
+
+func watchHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SnapshotServiceServer).Watch(m, &watchServer{stream})
+}
+
+var snapshotServiceDesc = grpc.ServiceDesc{
+	ServiceName: "watt.SnapshotService",
+	HandlerType: (*SnapshotServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       watchHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "snapshot_service.proto",
+}
+
+// SnapshotServiceClient is the client side of the SnapshotService.
+type SnapshotServiceClient interface {
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (WatchClient, error)
+}
+
+// WatchClient is the stream a SnapshotServiceClient receives the
+// snapshots of a Watch on.
+type WatchClient interface {
+	Recv() (*SnapshotUpdate, error)
+	grpc.ClientStream
+}
+
+type snapshotServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewSnapshotServiceClient returns a client of the SnapshotService on
+// the other end of the connection.
+func NewSnapshotServiceClient(cc *grpc.ClientConn) SnapshotServiceClient {
+	return &snapshotServiceClient{cc}
+}
+
+func (c *snapshotServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &snapshotServiceDesc.Streams[0], "/watt.SnapshotService/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type watchClient struct {
+	grpc.ClientStream
+}
+
+func (x *watchClient) Recv() (*SnapshotUpdate, error) {
+	m := new(SnapshotUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
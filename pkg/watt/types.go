@@ -24,6 +24,11 @@ func (s *ConsulSnapshot) DeepCopy() (*ConsulSnapshot, error) {
 	return res, err
 }
 
+// INVALID_ANNOTATION is the annotation that watt, when run with
+// --validate, adds to the resources in a snapshot that don't match the
+// schema of their kind, its value says what is wrong with them.
+const INVALID_ANNOTATION = "teleproxy.datawire.io/invalid"
+
 type Snapshot struct {
 	Consul     ConsulSnapshot            `json:",omitempty"`
 	Kubernetes map[string][]k8s.Resource `json:",omitempty"`